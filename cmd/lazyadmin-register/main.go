@@ -2,49 +2,107 @@ package main
 
 import (
 	"context"
-	"crypto/rand"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"os"
 	"os/user"
+	"strings"
 	"time"
 
 	"github.com/you/lazyadmin/internal/auth"
+	"github.com/you/lazyadmin/internal/config"
+	"github.com/you/lazyadmin/internal/logging"
+	"github.com/you/lazyadmin/internal/users"
 )
 
 func main() {
 	var (
-		rpID     = flag.String("rp-id", "lazyadmin.local", "Relying Party ID")
-		rpName   = flag.String("rp-name", "lazyadmin", "Relying Party Name")
-		userName = flag.String("user-name", "", "User name (defaults to current user)")
-		userID   = flag.String("user-id", "", "User ID (defaults to current username)")
-		output   = flag.String("output", "yaml", "Output format: yaml or json")
+		rpID       = flag.String("rp-id", "lazyadmin.local", "Relying Party ID")
+		rpName     = flag.String("rp-name", "lazyadmin", "Relying Party Name")
+		userName   = flag.String("user-name", "", "User name (defaults to current user)")
+		userID     = flag.String("user-id", "", "User ID (defaults to current username)")
+		name       = flag.String("name", "", `Credential name, e.g. "blue backup key"`)
+		output     = flag.String("output", "yaml", "Output format: yaml or json")
+		storePath  = flag.String("store", "", "Add the credential to the user in this SQLite user store")
+		configPath = flag.String("config", "", "Add the credential to the user in this YAML config, in place")
+		create     = flag.Bool("create", false, "With --store, create the user if it does not exist")
+		sshUsers   = flag.String("ssh-users", "", "With --create, comma-separated SSH usernames (defaults to user ID)")
+		roles      = flag.String("roles", "", "With --create, comma-separated roles")
 	)
 	flag.Parse()
 
+	if *storePath != "" && *configPath != "" {
+		log.Fatal("--store and --config are exclusive")
+	}
+	if *create && *storePath == "" {
+		log.Fatal("--create requires --store")
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
 	defer cancel()
 
 	// Determine user name and ID
+	current, err := user.Current()
+	if err != nil {
+		log.Fatalf("get current user: %v", err)
+	}
 	if *userName == "" {
-		u, err := user.Current()
-		if err != nil {
-			log.Fatalf("get current user: %v", err)
-		}
-		*userName = u.Username
+		*userName = current.Username
 	}
 	if *userID == "" {
-		u, err := user.Current()
+		*userID = current.Username
+	}
+
+	// Refuse duplicates before asking for a touch, and reuse the user handle
+	// of the user's existing credentials.
+	var (
+		store   *users.Store
+		newUser *users.User
+		handle  string
+	)
+	switch {
+	case *storePath != "":
+		store, err = users.NewStore(*storePath)
+		if err != nil {
+			log.Fatalf("open user store: %v", err)
+		}
+		defer store.Close()
+
+		_, err := store.GetUser(ctx, *userID)
+		switch {
+		case errors.Is(err, users.ErrUserNotFound) && *create:
+			newUser = &users.User{ID: *userID, SSHUsers: splitList(*sshUsers), Roles: splitList(*roles)}
+			if len(newUser.SSHUsers) == 0 {
+				newUser.SSHUsers = []string{*userID}
+			}
+			if len(newUser.Roles) == 0 {
+				log.Fatal("--create requires --roles")
+			}
+		case errors.Is(err, users.ErrUserNotFound):
+			log.Fatalf("user %s not in store (use --create to add it)", *userID)
+		case err != nil:
+			log.Fatalf("get user: %v", err)
+		default:
+			if err := store.CheckCredential(ctx, *userID, &users.Credential{RPID: *rpID, Name: *name}); err != nil {
+				log.Fatal(err)
+			}
+			if handle, err = store.UserHandle(ctx, *userID); err != nil {
+				log.Fatal(err)
+			}
+		}
+	case *configPath != "":
+		u, err := config.CheckYubiKeyCredential(*configPath, *userID, config.YubiKeyCredential{RPID: *rpID, Name: *name})
 		if err != nil {
-			log.Fatalf("get current user: %v", err)
+			log.Fatal(err)
 		}
-		*userID = u.Username
+		handle = u.UserHandle()
 	}
 
-	// Generate user ID bytes (8 bytes)
-	userIDBytes := make([]byte, 8)
-	if _, err := rand.Read(userIDBytes); err != nil {
-		log.Fatalf("generate user ID: %v", err)
+	handleBytes, handle, err := auth.UserHandle(handle)
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	fmt.Printf("FIDO2 YubiKey Registration\n")
@@ -53,16 +111,52 @@ func main() {
 	fmt.Printf("RP Name: %s\n", *rpName)
 	fmt.Printf("User Name: %s\n", *userName)
 	fmt.Printf("User ID: %s\n", *userID)
+	if *name != "" {
+		fmt.Printf("Credential Name: %s\n", *name)
+	}
 	fmt.Printf("\nPlease touch your YubiKey...\n")
 
 	// Register credential
-	result, err := auth.RegisterFIDO2Credential(ctx, *rpID, *rpName, *userName, userIDBytes)
+	result, err := auth.RegisterFIDO2Credential(ctx, *rpID, *rpName, *userName, handleBytes)
 	if err != nil {
 		log.Fatalf("registration failed: %v", err)
 	}
 
 	fmt.Printf("\n✓ Registration successful!\n\n")
 
+	switch {
+	case store != nil:
+		cred := &users.Credential{
+			RPID:         *rpID,
+			CredentialID: result.CredentialID,
+			PublicKey:    result.PublicKey,
+			Name:         *name,
+			UserHandle:   handle,
+		}
+		if err := addToStore(ctx, store, *storePath, current.Username, newUser, *userID, cred); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Credential added to user %s in %s\n", *userID, *storePath)
+		return
+	case *configPath != "":
+		cred := config.YubiKeyCredential{
+			RPID:         *rpID,
+			CredentialID: result.CredentialID,
+			PublicKey:    result.PublicKey,
+			Name:         *name,
+			UserHandle:   handle,
+		}
+		if err := config.AddYubiKeyCredential(*configPath, *userID, cred); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Credential added to user %s in %s\n", *userID, *configPath)
+		// A signed configuration no longer verifies after the edit.
+		if _, err := os.Stat(config.TrustFile); err == nil {
+			fmt.Fprintf(os.Stderr, "warning: %s exists: sign the configuration again with `lazyadmin config sign`, or it will not load\n", config.TrustFile)
+		}
+		return
+	}
+
 	// Output credentials
 	switch *output {
 	case "yaml":
@@ -71,14 +165,80 @@ func main() {
 		fmt.Printf("  - rp_id: %q\n", *rpID)
 		fmt.Printf("    credential_id: %q\n", result.CredentialID)
 		fmt.Printf("    public_key: %q\n", result.PublicKey)
+		if *name != "" {
+			fmt.Printf("    name: %q\n", *name)
+		}
+		fmt.Printf("    user_handle: %q\n", handle)
 	case "json":
 		fmt.Printf("{\n")
 		fmt.Printf("  \"rp_id\": %q,\n", *rpID)
 		fmt.Printf("  \"credential_id\": %q,\n", result.CredentialID)
-		fmt.Printf("  \"public_key\": %q\n", result.PublicKey)
+		fmt.Printf("  \"public_key\": %q,\n", result.PublicKey)
+		if *name != "" {
+			fmt.Printf("  \"name\": %q,\n", *name)
+		}
+		fmt.Printf("  \"user_handle\": %q\n", handle)
 		fmt.Printf("}\n")
 	default:
 		fmt.Printf("Credential ID: %s\n", result.CredentialID)
 		fmt.Printf("Public Key: %s\n", result.PublicKey)
+		if *name != "" {
+			fmt.Printf("Name: %s\n", *name)
+		}
+		fmt.Printf("User Handle: %s\n", handle)
+	}
+}
+
+// addToStore stores cred for userID, first creating newUser if set, and
+// records both in the audit log of the same database. osUser is the local
+// account running the tool.
+func addToStore(ctx context.Context, store *users.Store, path, osUser string, newUser *users.User, userID string, cred *users.Credential) error {
+	logger, err := logging.NewAuditLogger(path)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	defer logger.Close()
+
+	audit := func(opID string, err error) {
+		entry := logging.AuditEntry{
+			Time:        time.Now(),
+			UserID:      osUser,
+			SSHUser:     osUser,
+			OperationID: opID,
+			Success:     err == nil,
+		}
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		_ = logger.Log(ctx, entry)
+	}
+
+	if newUser != nil {
+		err := store.CreateUser(ctx, newUser)
+		audit(fmt.Sprintf("user:create %s ssh:%v roles:%v", newUser.ID, newUser.SSHUsers, newUser.Roles), err)
+		if err != nil {
+			return fmt.Errorf("create user: %w", err)
+		}
+	}
+
+	err = store.AddCredential(ctx, userID, cred)
+	audit(fmt.Sprintf("user:credential-add %s", userID), err)
+	if err != nil {
+		if newUser != nil {
+			_ = store.DeleteUser(ctx, userID)
+		}
+		return fmt.Errorf("add credential: %w", err)
+	}
+	return nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
 	}
+	return out
 }
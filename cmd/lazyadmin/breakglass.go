@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/x/term"
+
+	"github.com/you/lazyadmin/internal/auth"
+	"github.com/you/lazyadmin/internal/users"
+)
+
+// cmdBreakGlass opens an emergency TUI session authenticated by a one-time
+// recovery code instead of FIDO2, or issues new recovery codes.
+func cmdBreakGlass(a *app, args []string) int {
+	if len(args) > 0 && args[0] == "codes" {
+		return issueRecoveryCodes(a, args[1:])
+	}
+
+	fs := flag.NewFlagSet("break-glass", flag.ContinueOnError)
+	reason := fs.String("reason", "", "why emergency access is needed (required)")
+	if err := fs.Parse(args); err != nil || fs.NArg() > 0 {
+		return exitUsage
+	}
+	if strings.TrimSpace(*reason) == "" {
+		fmt.Fprintln(os.Stderr, "break-glass: --reason is required")
+		return exitUsage
+	}
+	if !a.cfg.Auth.BreakGlass.Enabled() {
+		fmt.Fprintf(os.Stderr, "break-glass: %v\n", auth.ErrBreakGlassDisabled)
+		return exitUsage
+	}
+
+	principal, err := a.resolve()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitAuth
+	}
+	code, err := readRecoveryCode()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "break-glass: %v\n", err)
+		return exitAuth
+	}
+
+	g, err := a.breakGlass.Activate(context.Background(), principal, code, *reason)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "break-glass: %v\n", err)
+		if errors.Is(err, users.ErrRecoveryCodeInvalid) || errors.Is(err, users.ErrLockedOut) {
+			return exitAuth
+		}
+		return exitFailed
+	}
+	fmt.Fprintf(os.Stderr, "break-glass: %s granted until %s; everything in this session is audited and notifications were sent\n",
+		g.Role, g.ExpiresAt.Local().Format(time.DateTime))
+
+	a.principal = principal
+	runTUI(a)
+	return exitOK
+}
+
+// issueRecoveryCodes replaces a user's recovery codes and prints the new
+// ones. They are shown only once.
+func issueRecoveryCodes(a *app, args []string) int {
+	fs := flag.NewFlagSet("break-glass codes", flag.ContinueOnError)
+	userID := fs.String("user", "", "user to issue codes for (default: yourself)")
+	count := fs.Int("count", auth.DefaultRecoveryCodes, "number of codes")
+	if err := fs.Parse(args); err != nil || fs.NArg() > 0 {
+		return exitUsage
+	}
+	if *count < 1 {
+		fmt.Fprintln(os.Stderr, "break-glass: --count must be at least 1")
+		return exitUsage
+	}
+	if err := a.authenticate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitAuth
+	}
+	if *userID == "" {
+		*userID = a.principal.ConfigUser.ID
+	}
+
+	codes, err := a.breakGlass.IssueCodes(context.Background(), a.principal, *userID, *count)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "break-glass: %v\n", err)
+		switch {
+		case errors.Is(err, auth.ErrCodesNotAllowed):
+			return exitDenied
+		case errors.Is(err, users.ErrUserNotFound):
+			return exitUsage
+		}
+		return exitFailed
+	}
+	fmt.Printf("Recovery codes for %s (each works once; previous codes are void).\n", *userID)
+	fmt.Println("Store them offline; they are not shown again.")
+	for _, c := range codes {
+		fmt.Println(c)
+	}
+	return exitOK
+}
+
+// readRecoveryCode prompts for the code without echo on a terminal, or reads
+// one line from stdin otherwise. Codes are never taken from arguments, which
+// end up in shell history and process listings.
+func readRecoveryCode() (string, error) {
+	if term.IsTerminal(os.Stdin.Fd()) {
+		fmt.Fprint(os.Stderr, "Recovery code: ")
+		b, err := term.ReadPassword(os.Stdin.Fd())
+		fmt.Fprintln(os.Stderr)
+		return string(b), err
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("read recovery code: %w", err)
+	}
+	return strings.TrimSpace(line), nil
+}
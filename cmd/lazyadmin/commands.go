@@ -0,0 +1,579 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/you/lazyadmin/internal/auth"
+	"github.com/you/lazyadmin/internal/scheduler"
+	"github.com/you/lazyadmin/internal/tasks"
+)
+
+const usage = `usage:
+  lazyadmin                                   start the TUI
+  lazyadmin run op <id> [--param k=v]... [--output text|json]
+  lazyadmin run task <id> [--input k=v]... [--output text|json]
+  lazyadmin list ops|tasks [--output text|json]
+  lazyadmin plan task <id> [--input k=v]... [--preview]
+  lazyadmin elevate request <role> --for <duration> --reason <text>
+  lazyadmin elevate list [--output text|json]
+  lazyadmin elevate approve|deny|revoke <grant-id>
+  lazyadmin break-glass --reason <text>       emergency TUI session with a recovery
+                                              code instead of FIDO2 (read from stdin)
+  lazyadmin break-glass codes [--user <id>] [--count <n>]
+                                              issue new recovery codes
+  lazyadmin users sync [--import | --export]  report conflicts between config and store
+                                              users, or copy users from one to the other
+  lazyadmin users unlock [<user>]             lift a sign-in lockout, or list users
+                                              with failed sign-ins
+  lazyadmin db status|migrate                 show or upgrade the database schema version
+  lazyadmin config render                     print the configuration merged from its includes
+                                              and LAZYADMIN_CONFIG_OVERLAYS
+  lazyadmin config sign --key <file> | --manifest
+                                              sign the configuration and its includes,
+                                              or print the manifest for ssh-keygen -Y sign
+  lazyadmin config verify                     check the signature against the trust file
+  lazyadmin scheduler                         run configured schedules until interrupted
+  lazyadmin bastion [--user <id> | --key <fingerprint>]
+                                              SSH ForceCommand entry point; runs
+                                              SSH_ORIGINAL_COMMAND or the TUI
+`
+
+// Exit codes of command-line mode.
+const (
+	exitOK     = 0 // the command succeeded
+	exitFailed = 1 // the operation, task or plan failed
+	exitUsage  = 2 // bad arguments or unknown operation or task
+	exitDenied = 3 // the caller's roles do not allow the operation or task
+	exitAuth   = 4 // the caller could not be authenticated (user lookup or FIDO2)
+)
+
+// runCommand dispatches command-line mode and returns the process exit code.
+func runCommand(a *app, args []string) int {
+	switch args[0] {
+	case "run":
+		return cmdRun(a, args[1:])
+	case "list":
+		return cmdList(a, args[1:])
+	case "plan":
+		return cmdPlan(a, args[1:])
+	case "elevate":
+		return cmdElevate(a, args[1:])
+	case "break-glass":
+		return cmdBreakGlass(a, args[1:])
+	case "users":
+		return cmdUsers(a, args[1:])
+	case "scheduler":
+		return cmdScheduler(a, args[1:])
+	case "bastion":
+		return cmdBastion(a, args[1:])
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return exitOK
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n%s", args[0], usage)
+		return exitUsage
+	}
+}
+
+// cmdPlan prints the plan for a task without executing mutating steps.
+func cmdPlan(a *app, args []string) int {
+	if len(args) < 2 || args[0] != "task" {
+		fmt.Fprint(os.Stderr, usage)
+		return exitUsage
+	}
+	taskID := args[1]
+
+	fs := flag.NewFlagSet("plan", flag.ContinueOnError)
+	inputs := keyValueFlag{}
+	fs.Var(inputs, "input", "task input as key=value (repeatable)")
+	preview := fs.Bool("preview", false, "execute read-only steps to preview their output")
+	if err := fs.Parse(args[2:]); err != nil {
+		return exitUsage
+	}
+
+	task, ok := a.cfg.FindTask(taskID)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown task %q\n", taskID)
+		return exitUsage
+	}
+
+	if err := a.authenticate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitAuth
+	}
+	if !a.principal.CanRun(task.AllowedRoles, task.Permissions, task.Tags) {
+		fmt.Fprintf(os.Stderr, "task %s: %v\n", task.ID, tasks.ErrNotAllowed)
+		return exitDenied
+	}
+
+	plan := a.runner.Plan(context.Background(), a.principal, *task, inputs, tasks.PlanOptions{ExecuteReadOnly: *preview})
+	fmt.Print(plan.String())
+
+	if !plan.OK() {
+		return exitFailed
+	}
+	return exitOK
+}
+
+// cmdScheduler runs the schedule daemon until SIGINT or SIGTERM. Runs in
+// progress are allowed to finish before it exits.
+func cmdScheduler(a *app, args []string) int {
+	if len(args) > 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return exitUsage
+	}
+
+	s, err := scheduler.New(a.cfg, a.runner, a.logger, a.schedStore, a.lockStore)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "scheduler: %v\n", err)
+		return exitFailed
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("scheduler: running %d schedules", len(a.cfg.Schedules))
+	if err := s.Run(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "scheduler: %v\n", err)
+		return exitFailed
+	}
+	log.Printf("scheduler: stopped")
+	return exitOK
+}
+
+// cmdRun executes an operation or a task with the same RBAC, FIDO2 step-up,
+// audit logging and summary rendering as the TUI.
+func cmdRun(a *app, args []string) int {
+	if len(args) < 2 || (args[0] != "op" && args[0] != "task") {
+		fmt.Fprint(os.Stderr, usage)
+		return exitUsage
+	}
+	kind, id := args[0], args[1]
+
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	values := keyValueFlag{}
+	if kind == "op" {
+		fs.Var(values, "param", "operation path parameter as key=value (repeatable)")
+	} else {
+		fs.Var(values, "input", "task input as key=value (repeatable)")
+	}
+	output := fs.String("output", "text", "output format: text or json")
+	if err := fs.Parse(args[2:]); err != nil {
+		return exitUsage
+	}
+	if *output != "text" && *output != "json" {
+		fmt.Fprintf(os.Stderr, "unknown output format %q\n", *output)
+		return exitUsage
+	}
+
+	if kind == "op" {
+		if _, ok := a.cfg.FindOperation(id); !ok {
+			fmt.Fprintf(os.Stderr, "unknown operation %q\n", id)
+			return exitUsage
+		}
+	} else if _, ok := a.cfg.FindTask(id); !ok {
+		fmt.Fprintf(os.Stderr, "unknown task %q\n", id)
+		return exitUsage
+	}
+
+	if err := a.authenticate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitAuth
+	}
+
+	if kind == "op" {
+		return runOp(a, id, values, *output)
+	}
+	return runTask(a, id, values, *output)
+}
+
+type opResultJSON struct {
+	Operation string `json:"operation"`
+	Success   bool   `json:"success"`
+	Output    string `json:"output,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+func runOp(a *app, id string, params map[string]string, output string) int {
+	op, _ := a.cfg.FindOperation(id)
+	out, err := a.runner.RunOperation(context.Background(), a.principal, *op, params)
+
+	if output == "json" {
+		res := opResultJSON{Operation: op.ID, Success: err == nil, Output: out}
+		if err != nil {
+			res.Error = err.Error()
+		}
+		writeJSON(res)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "operation %s failed: %v\n", op.ID, err)
+	} else {
+		fmt.Println(out)
+	}
+
+	return exitCode(err)
+}
+
+type stepResultJSON struct {
+	ID       string `json:"id"`
+	Success  bool   `json:"success"`
+	Output   string `json:"output,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Attempts int    `json:"attempts,omitempty"`
+}
+
+type taskResultJSON struct {
+	Task     string            `json:"task"`
+	RunID    string            `json:"run_id"`
+	Success  bool              `json:"success"`
+	Error    string            `json:"error,omitempty"`
+	Inputs   map[string]string `json:"inputs,omitempty"`
+	Steps    []stepResultJSON  `json:"steps"`
+	Rollback []stepResultJSON  `json:"rollback,omitempty"`
+	Skipped  string            `json:"rollback_skipped,omitempty"`
+	Summary  string            `json:"summary,omitempty"`
+}
+
+func runTask(a *app, id string, inputs map[string]string, output string) int {
+	task, _ := a.cfg.FindTask(id)
+
+	// Refuse callers who may not run the task before asking for a touch.
+	if !a.principal.CanRun(task.AllowedRoles, task.Permissions, task.Tags) {
+		fmt.Fprintf(os.Stderr, "task %s: %v\n", task.ID, tasks.ErrNotAllowed)
+		return exitDenied
+	}
+	if err := auth.RequireTaskYubiKey(a.principal, *task); err != nil {
+		fmt.Fprintf(os.Stderr, "step-up authentication failed: %v\n", err)
+		return exitAuth
+	}
+
+	tr := a.runner.Run(context.Background(), a.principal, *task, inputs)
+
+	summary, err := tasks.RenderSummary(*task, tr)
+	if err != nil {
+		summary = fmt.Sprintf("error rendering summary: %v", err)
+	}
+
+	if output == "json" {
+		res := taskResultJSON{
+			Task:    task.ID,
+			RunID:   tr.RunID,
+			Success: tr.Success,
+			Inputs:  tr.Inputs,
+			Steps:   stepsJSON(tr.StepOrder, tr.Steps),
+			Summary: summary,
+		}
+		if tr.Err != nil {
+			res.Error = tr.Err.Error()
+		}
+		if tr.Rollback != nil {
+			res.Rollback = stepsJSON(tr.Rollback.StepOrder, tr.Rollback.Steps)
+			if tr.Rollback.Skipped != nil {
+				res.Skipped = tr.Rollback.Skipped.Error()
+			}
+		}
+		writeJSON(res)
+	} else {
+		printTaskResult(tr, summary)
+	}
+
+	if !tr.Success {
+		if code := exitCode(tr.Err); code != exitOK {
+			return code
+		}
+		return exitFailed
+	}
+	return exitOK
+}
+
+func stepsJSON(order []string, steps map[string]tasks.StepResult) []stepResultJSON {
+	out := make([]stepResultJSON, 0, len(order))
+	for _, id := range order {
+		sr := steps[id]
+		s := stepResultJSON{ID: id, Success: sr.OK, Output: sr.Output, Attempts: len(sr.Attempts)}
+		if sr.Err != nil {
+			s.Error = sr.Err.Error()
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func printTaskResult(tr tasks.TaskResult, summary string) {
+	status := "succeeded"
+	if !tr.Success {
+		status = "failed"
+	}
+	fmt.Printf("task %s %s (run %s)\n", tr.Task.ID, status, tr.RunID)
+	if tr.Err != nil {
+		fmt.Printf("  error: %v\n", tr.Err)
+	}
+
+	printSteps := func(order []string, steps map[string]tasks.StepResult) {
+		for _, id := range order {
+			sr := steps[id]
+			mark := "✓"
+			if !sr.OK {
+				mark = "✗"
+			}
+			line := fmt.Sprintf("  %s %s", mark, id)
+			if sr.Err != nil {
+				line += ": " + sr.Err.Error()
+			} else if sr.Output != "" {
+				line += ": " + sr.Output
+			}
+			fmt.Println(line)
+		}
+	}
+	printSteps(tr.StepOrder, tr.Steps)
+
+	if rb := tr.Rollback; rb != nil && rb.Skipped != nil {
+		fmt.Printf("%v\n", rb.Skipped)
+	} else if rb != nil {
+		fmt.Printf("rollback success: %v\n", rb.Success)
+		printSteps(rb.StepOrder, rb.Steps)
+	}
+
+	if summary != "" {
+		fmt.Println()
+		fmt.Print(summary)
+		if !strings.HasSuffix(summary, "\n") {
+			fmt.Println()
+		}
+	}
+}
+
+// exitCode maps an operation or task error to a process exit code.
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, tasks.ErrNotAllowed):
+		return exitDenied
+	case errors.Is(err, tasks.ErrInvalidParams):
+		return exitUsage
+	default:
+		return exitFailed
+	}
+}
+
+type opListJSON struct {
+	ID     string   `json:"id"`
+	Label  string   `json:"label"`
+	Type   string   `json:"type"`
+	Target string   `json:"target"`
+	Params []string `json:"params,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+}
+
+type taskListJSON struct {
+	ID     string   `json:"id"`
+	Label  string   `json:"label"`
+	Risk   string   `json:"risk_level,omitempty"`
+	Inputs []string `json:"inputs,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+}
+
+// cmdList prints the operations or tasks the caller's roles allow.
+func cmdList(a *app, args []string) int {
+	if len(args) < 1 || (args[0] != "ops" && args[0] != "tasks") {
+		fmt.Fprint(os.Stderr, usage)
+		return exitUsage
+	}
+
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	output := fs.String("output", "text", "output format: text or json")
+	if err := fs.Parse(args[1:]); err != nil {
+		return exitUsage
+	}
+	if *output != "text" && *output != "json" {
+		fmt.Fprintf(os.Stderr, "unknown output format %q\n", *output)
+		return exitUsage
+	}
+
+	if err := a.authenticate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitAuth
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+
+	if args[0] == "ops" {
+		list := []opListJSON{}
+		for _, op := range a.cfg.Operations {
+			if a.principal.CanRun(op.AllowedRoles, op.Permissions, op.Tags) {
+				list = append(list, opListJSON{ID: op.ID, Label: op.Label, Type: op.Type, Target: op.Target, Params: tasks.PathParams(op), Tags: op.Tags})
+			}
+		}
+		if *output == "json" {
+			writeJSON(list)
+			return exitOK
+		}
+		fmt.Fprintln(w, "ID\tTYPE\tTARGET\tPARAMS\tLABEL")
+		for _, op := range list {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", op.ID, op.Type, op.Target, strings.Join(op.Params, ","), op.Label)
+		}
+		return exitOK
+	}
+
+	list := []taskListJSON{}
+	for _, t := range a.cfg.Tasks {
+		if !a.principal.CanRun(t.AllowedRoles, t.Permissions, t.Tags) {
+			continue
+		}
+		item := taskListJSON{ID: t.ID, Label: t.Label, Risk: string(t.RiskLevel), Tags: t.Tags}
+		for _, in := range t.Inputs {
+			item.Inputs = append(item.Inputs, in.Name)
+		}
+		list = append(list, item)
+	}
+	if *output == "json" {
+		writeJSON(list)
+		return exitOK
+	}
+	fmt.Fprintln(w, "ID\tRISK\tINPUTS\tLABEL")
+	for _, t := range list {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", t.ID, t.Risk, strings.Join(t.Inputs, ","), t.Label)
+	}
+	return exitOK
+}
+
+func writeJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+// bastionCommands are the commands an SSH client may request through
+// SSH_ORIGINAL_COMMAND in bastion mode.
+var bastionCommands = map[string]bool{"run": true, "list": true, "plan": true, "elevate": true, "break-glass": true, "users": true, "help": true}
+
+// cmdBastion is the forced command on a bastion (sshd ForceCommand or an
+// authorized_keys command= option). Identity comes from --user/--key, which
+// only the administrator can set in authorized_keys, or from the key listed
+// in the ExposeAuthInfo file; SSH_USER and USER are ignored. The client's
+// requested command, if any, is dispatched to the headless CLI.
+func cmdBastion(a *app, args []string) int {
+	fs := flag.NewFlagSet("bastion", flag.ContinueOnError)
+	userID := fs.String("user", "", "lazyadmin user ID (set in authorized_keys command=)")
+	key := fs.String("key", "", "SSH key fingerprint, SHA256:... (set in authorized_keys command=)")
+	if err := fs.Parse(args); err != nil || fs.NArg() > 0 {
+		return exitUsage
+	}
+
+	id := auth.BastionIdentity{
+		UserID:       *userID,
+		Fingerprint:  *key,
+		AuthInfoPath: os.Getenv("SSH_USER_AUTH"),
+	}
+	a.identity = func() (*auth.Principal, error) {
+		return auth.ResolveBastionPrincipal(a.cfg, a.userStore, id)
+	}
+	a.caller = id.Presented
+
+	original := strings.TrimSpace(os.Getenv("SSH_ORIGINAL_COMMAND"))
+	if original == "" {
+		if err := a.authenticate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitAuth
+		}
+		runTUI(a)
+		return exitOK
+	}
+
+	cmdArgs, err := splitCommand(original)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "SSH_ORIGINAL_COMMAND: %v\n", err)
+		return exitUsage
+	}
+	if len(cmdArgs) > 0 && cmdArgs[0] == "lazyadmin" {
+		cmdArgs = cmdArgs[1:]
+	}
+	if len(cmdArgs) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return exitUsage
+	}
+	if !bastionCommands[cmdArgs[0]] {
+		fmt.Fprintf(os.Stderr, "command %q is not available over SSH\n", cmdArgs[0])
+		return exitUsage
+	}
+	return runCommand(a, cmdArgs)
+}
+
+// splitCommand splits an SSH command line into words, honouring single and
+// double quotes and backslash escapes outside single quotes. No other shell
+// syntax is interpreted.
+func splitCommand(s string) ([]string, error) {
+	var (
+		words   []string
+		cur     strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+	for _, r := range s {
+		switch {
+		case escaped:
+			cur.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		default:
+			cur.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape")
+	}
+	if inWord {
+		words = append(words, cur.String())
+	}
+	return words, nil
+}
+
+// keyValueFlag collects repeated key=value flags.
+type keyValueFlag map[string]string
+
+func (f keyValueFlag) String() string {
+	parts := make([]string, 0, len(f))
+	for k, v := range f {
+		parts = append(parts, k+"="+v)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f keyValueFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("expected key=value, got %q", s)
+	}
+	f[k] = v
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{name: "plain", in: "run op backend_health", want: []string{"run", "op", "backend_health"}},
+		{name: "extra spaces", in: "  list   tasks ", want: []string{"list", "tasks"}},
+		{name: "double quotes", in: `run task deploy --input "msg=hello world"`, want: []string{"run", "task", "deploy", "--input", "msg=hello world"}},
+		{name: "single quotes keep backslash", in: `run op get --param 'id=a\b'`, want: []string{"run", "op", "get", "--param", `id=a\b`}},
+		{name: "escaped space", in: `run op get --param id=a\ b`, want: []string{"run", "op", "get", "--param", "id=a b"}},
+		{name: "empty quotes", in: `run task t --input ""`, want: []string{"run", "task", "t", "--input", ""}},
+		{name: "no shell syntax", in: "list ops; rm -rf /", want: []string{"list", "ops;", "rm", "-rf", "/"}},
+		{name: "unterminated", in: `run op "x`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := splitCommand(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("splitCommand() error = nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("splitCommand() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitCommand() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/you/lazyadmin/internal/config"
+)
+
+// cmdConfig works on the configuration files themselves. Like cmdDB it runs
+// before setup, so it works on configurations that do not validate.
+func cmdConfig(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return exitUsage
+	}
+	switch args[0] {
+	case "render":
+		if len(args) != 1 {
+			break
+		}
+		out, err := config.Render(config.Path(), config.Overlays()...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "config: %v\n", err)
+			return exitFailed
+		}
+		os.Stdout.Write(out)
+		return exitOK
+	case "sign":
+		return cmdConfigSign(args[1:])
+	case "verify":
+		if len(args) != 1 {
+			break
+		}
+		return cmdConfigVerify()
+	}
+	fmt.Fprint(os.Stderr, usage)
+	return exitUsage
+}
+
+// cmdConfigSign writes the detached signature of the configuration and its
+// includes with an OpenSSH private key, or prints the manifest for signing
+// with ssh-keygen -Y sign when the key lives elsewhere.
+func cmdConfigSign(args []string) int {
+	fs := flag.NewFlagSet("config sign", flag.ContinueOnError)
+	keyPath := fs.String("key", "", "OpenSSH private key to sign with (unencrypted)")
+	manifest := fs.Bool("manifest", false, "print the text to sign instead of signing it")
+	if err := fs.Parse(args); err != nil || fs.NArg() > 0 || (*keyPath == "") == !*manifest {
+		fmt.Fprintln(os.Stderr, "config sign: give exactly one of --key and --manifest")
+		return exitUsage
+	}
+
+	cfg, err := config.LoadFiles(config.Path(), config.Overlays()...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config: %v\n", err)
+		return exitFailed
+	}
+	if *manifest {
+		os.Stdout.Write(cfg.Manifest())
+		return exitOK
+	}
+
+	pem, err := os.ReadFile(*keyPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config sign: %v\n", err)
+		return exitFailed
+	}
+	signer, err := ssh.ParsePrivateKey(pem)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		fmt.Fprintf(os.Stderr, "config sign: %s is encrypted; sign the --manifest output with ssh-keygen -Y sign -n %s instead\n", *keyPath, config.SignatureNamespace)
+		return exitFailed
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config sign: %s: %v\n", *keyPath, err)
+		return exitFailed
+	}
+	sig, err := config.Sign(cfg.Manifest(), signer)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config sign: %v\n", err)
+		return exitFailed
+	}
+	sigPath := config.SignaturePath(cfg.Files()[0])
+	if err := os.WriteFile(sigPath, sig, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "config sign: %v\n", err)
+		return exitFailed
+	}
+	fmt.Printf("signed %d file(s) with %s: %s\n", len(cfg.Files()), ssh.FingerprintSHA256(signer.PublicKey()), sigPath)
+	return exitOK
+}
+
+// cmdConfigVerify checks the configuration's signature as startup would.
+func cmdConfigVerify() int {
+	cfg, err := config.LoadFiles(config.Path(), config.Overlays()...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config: %v\n", err)
+		return exitFailed
+	}
+	if _, err := os.Stat(config.TrustFile); errors.Is(err, os.ErrNotExist) {
+		fmt.Printf("%s does not exist; signatures are not checked\n", config.TrustFile)
+		return exitOK
+	}
+	if err := cfg.Verify(config.TrustFile); err != nil {
+		fmt.Fprintf(os.Stderr, "config: %v\n", err)
+		return exitFailed
+	}
+	fmt.Printf("signature of %d file(s) verified against %s\n", len(cfg.Files()), config.TrustFile)
+	return exitOK
+}
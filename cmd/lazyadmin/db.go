@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/you/lazyadmin/internal/config"
+	"github.com/you/lazyadmin/internal/migrate"
+)
+
+// cmdDB reports or upgrades the schema version of the SQLite database. It
+// runs before setup, which would otherwise migrate the database itself, and
+// needs only logging.sqlite_path from the configuration.
+func cmdDB(args []string) int {
+	if len(args) != 1 || args[0] != "migrate" && args[0] != "status" {
+		fmt.Fprint(os.Stderr, usage)
+		return exitUsage
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load config: %v\n", err)
+		return exitFailed
+	}
+	db, err := migrate.Open(cfg.Logging.SQLitePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "db: %v\n", err)
+		return exitFailed
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if args[0] == "migrate" {
+		res, err := migrate.Up(ctx, db, cfg.Logging.SQLitePath)
+		if res != nil {
+			printMigrated(res)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "db: %v\n", err)
+			return exitFailed
+		}
+	}
+
+	st, err := migrate.Status(ctx, db)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "db: %v\n", err)
+		return exitFailed
+	}
+	fmt.Printf("%s: schema version %d, latest %d\n", cfg.Logging.SQLitePath, st.Current, st.Latest)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+	for _, a := range st.Applied {
+		fmt.Fprintf(w, "%04d\t%s\t%s\n", a.Version, a.Name, a.AppliedAt.Local().Format(time.DateTime))
+	}
+	for _, m := range st.Pending {
+		fmt.Fprintf(w, "%04d\t%s\tpending\n", m.Version, m.Name)
+	}
+	w.Flush()
+
+	if st.Current > st.Latest {
+		fmt.Fprintf(os.Stderr, "db: %v\n", migrate.ErrSchemaTooNew)
+		return exitFailed
+	}
+	return exitOK
+}
+
+func printMigrated(res *migrate.Result) {
+	if res.Backup != "" {
+		fmt.Printf("backup: %s\n", res.Backup)
+	}
+	for _, m := range res.Applied {
+		fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+	}
+}
+
+// migrateDB brings the database at path to the latest schema before the
+// stores open it, logging any backup and applied migrations.
+func migrateDB(ctx context.Context, path string) error {
+	db, err := migrate.Open(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	res, err := migrate.Up(ctx, db, path)
+	if res != nil {
+		if res.Backup != "" {
+			log.Printf("db: backed up %s to %s", path, res.Backup)
+		}
+		for _, m := range res.Applied {
+			log.Printf("db: applied migration %04d_%s", m.Version, m.Name)
+		}
+	}
+	if errors.Is(err, migrate.ErrSchemaTooNew) {
+		return fmt.Errorf("%w; upgrade lazyadmin or restore a backup", err)
+	}
+	return err
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/you/lazyadmin/internal/auth"
+	"github.com/you/lazyadmin/internal/users"
+)
+
+// grantListLimit bounds `lazyadmin elevate list`; pending and active grants
+// come first.
+const grantListLimit = 50
+
+type grantJSON struct {
+	ID          int64  `json:"id"`
+	User        string `json:"user"`
+	Role        string `json:"role"`
+	Status      string `json:"status"`
+	Duration    string `json:"duration"`
+	Reason      string `json:"reason"`
+	RequestedAt string `json:"requested_at"`
+	DecidedBy   string `json:"decided_by,omitempty"`
+	ExpiresAt   string `json:"expires_at,omitempty"`
+	RevokedBy   string `json:"revoked_by,omitempty"`
+}
+
+// cmdElevate runs the just-in-time role elevation flow: request a role for a
+// limited time, list grants, and approve, deny or revoke them.
+func cmdElevate(a *app, args []string) int {
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return exitUsage
+	}
+	sub := args[0]
+
+	switch sub {
+	case "request":
+		if len(args) < 2 {
+			fmt.Fprint(os.Stderr, usage)
+			return exitUsage
+		}
+		fs := flag.NewFlagSet("elevate request", flag.ContinueOnError)
+		duration := fs.Duration("for", time.Hour, "how long the role is needed")
+		reason := fs.String("reason", "", "why the role is needed (required)")
+		if err := fs.Parse(args[2:]); err != nil {
+			return exitUsage
+		}
+		if err := a.authenticate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitAuth
+		}
+
+		g, err := a.elevator.Request(context.Background(), a.principal, args[1], *duration, *reason)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "elevate: %v\n", err)
+			return elevationExitCode(err)
+		}
+		if g.Status == users.GrantActive {
+			fmt.Printf("grant #%d: %s active until %s\n", g.ID, g.Role, g.ExpiresAt.Local().Format(time.DateTime))
+		} else {
+			fmt.Printf("grant #%d: %s requested, waiting for approval (lazyadmin elevate approve %d)\n", g.ID, g.Role, g.ID)
+		}
+		return exitOK
+
+	case "list":
+		fs := flag.NewFlagSet("elevate list", flag.ContinueOnError)
+		output := fs.String("output", "text", "output format: text or json")
+		if err := fs.Parse(args[1:]); err != nil {
+			return exitUsage
+		}
+		if *output != "text" && *output != "json" {
+			fmt.Fprintf(os.Stderr, "unknown output format %q\n", *output)
+			return exitUsage
+		}
+		if err := a.authenticate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitAuth
+		}
+		return listGrants(a, *output)
+
+	case "approve", "deny", "revoke":
+		if len(args) != 2 {
+			fmt.Fprint(os.Stderr, usage)
+			return exitUsage
+		}
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid grant id %q\n", args[1])
+			return exitUsage
+		}
+		if err := a.authenticate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitAuth
+		}
+
+		ctx := context.Background()
+		var g *users.Grant
+		switch sub {
+		case "approve":
+			g, err = a.elevator.Approve(ctx, a.principal, id)
+		case "deny":
+			g, err = a.elevator.Deny(ctx, a.principal, id)
+		default:
+			g, err = a.elevator.Revoke(ctx, a.principal, id)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "elevate: %v\n", err)
+			return elevationExitCode(err)
+		}
+		fmt.Printf("grant #%d: %s for %s %s\n", g.ID, g.Role, g.UserID, g.Status)
+		return exitOK
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown elevate command %q\n%s", sub, usage)
+		return exitUsage
+	}
+}
+
+func listGrants(a *app, output string) int {
+	grants, err := a.userStore.ListGrants(context.Background(), grantListLimit)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "elevate: %v\n", err)
+		return exitFailed
+	}
+
+	list := []grantJSON{}
+	for _, g := range grants {
+		gj := grantJSON{
+			ID:          g.ID,
+			User:        g.UserID,
+			Role:        g.Role,
+			Status:      string(g.Status),
+			Duration:    g.Duration.String(),
+			Reason:      g.Reason,
+			RequestedAt: g.RequestedAt.Format(time.RFC3339),
+			DecidedBy:   g.DecidedBy,
+			RevokedBy:   g.RevokedBy,
+		}
+		if !g.ExpiresAt.IsZero() {
+			gj.ExpiresAt = g.ExpiresAt.Format(time.RFC3339)
+		}
+		list = append(list, gj)
+	}
+	if output == "json" {
+		writeJSON(list)
+		return exitOK
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "ID\tUSER\tROLE\tSTATUS\tDURATION\tEXPIRES\tREASON")
+	for _, g := range grants {
+		expires := "-"
+		if !g.ExpiresAt.IsZero() {
+			expires = g.ExpiresAt.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", g.ID, g.UserID, g.Role, g.Status, g.Duration, expires, g.Reason)
+	}
+	return exitOK
+}
+
+// elevationExitCode maps an elevation error to a process exit code.
+func elevationExitCode(err error) int {
+	switch {
+	case errors.Is(err, auth.ErrElevationNotAllowed),
+		errors.Is(err, auth.ErrElevationIneligible),
+		errors.Is(err, auth.ErrNotApprover),
+		errors.Is(err, auth.ErrSelfApproval):
+		return exitDenied
+	case errors.Is(err, auth.ErrElevationDuration),
+		errors.Is(err, auth.ErrElevationReason),
+		errors.Is(err, users.ErrGrantNotFound):
+		return exitUsage
+	default:
+		return exitFailed
+	}
+}
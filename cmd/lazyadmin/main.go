@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
@@ -12,80 +15,428 @@ import (
 	"github.com/you/lazyadmin/internal/auth"
 	"github.com/you/lazyadmin/internal/clients"
 	"github.com/you/lazyadmin/internal/config"
+	"github.com/you/lazyadmin/internal/locks"
 	"github.com/you/lazyadmin/internal/logging"
+	"github.com/you/lazyadmin/internal/notify"
 	"github.com/you/lazyadmin/internal/openapi"
+	"github.com/you/lazyadmin/internal/runs"
+	"github.com/you/lazyadmin/internal/scheduler"
+	"github.com/you/lazyadmin/internal/secrets"
 	"github.com/you/lazyadmin/internal/tasks"
 	"github.com/you/lazyadmin/internal/ui"
 	"github.com/you/lazyadmin/internal/users"
 )
 
+// app holds the components shared by the TUI and the command-line modes.
+type app struct {
+	cfg         *config.Config
+	logger      *logging.AuditLogger
+	userStore   *users.Store
+	runStore    *runs.Store
+	lockStore   *locks.Store
+	schedStore  *scheduler.Store
+	elevator    *auth.Elevator
+	breakGlass  *auth.BreakGlass
+	lockout     *auth.Lockout
+	principal   *auth.Principal
+	identity    func() (*auth.Principal, error) // resolves the caller; see authenticate
+	caller      func() string                   // names a caller identity could not resolve, for the audit log
+	secrets     *secrets.Resolver
+	httpClients map[string]*clients.HTTPClient
+	pgClients   map[string]*clients.PostgresClient
+	runner      *tasks.Runner
+
+	mu sync.Mutex // guards cfg once the TUI reloads it
+}
+
+func (a *app) Close() {
+	a.schedStore.Close()
+	a.lockStore.Close()
+	a.runStore.Close()
+	a.userStore.Close()
+	a.logger.Close()
+}
+
 func main() {
-	cfg, err := config.Load()
-	if err != nil {
-		log.Fatalf("load config: %v", err)
+	if len(os.Args) > 1 && os.Args[1] == "db" {
+		os.Exit(cmdDB(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(cmdConfig(os.Args[2:]))
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	a := setup()
+
+	if len(os.Args) > 1 {
+		code := runCommand(a, os.Args[1:])
+		a.Close()
+		os.Exit(code)
+	}
+	defer a.Close()
+
+	if err := a.authenticate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		a.Close()
+		os.Exit(exitAuth)
+	}
+	runTUI(a)
+}
+
+func runTUI(a *app) {
+	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	if len(cfg.OpenAPI.Backends) > 0 {
-		gen := openapi.NewGenerator()
-		autoOps, err := gen.GenerateOperations(ctx, cfg)
-		if err != nil {
-			log.Printf("openapi: %v", err)
-		} else {
-			log.Printf("openapi: generated %d operations", len(autoOps))
-			cfg.Operations = append(cfg.Operations, autoOps...)
+	// The configuration is reloaded on SIGHUP, when its files change, and
+	// from the TUI (see reloadEnvironment).
+	reloads := make(chan string)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				select {
+				case reloads <- ui.ReloadSIGHUP:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	go config.Watch(ctx, func() []string {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		return a.cfg.Files()
+	}, config.DefaultWatchInterval, func() {
+		select {
+		case reloads <- ui.ReloadWatch:
+		case <-ctx.Done():
 		}
+	})
+
+	m := ui.NewModel(a.cfg, a.principal, a.logger, a.userStore, a.httpClients, a.pgClients, a.runner, a.runStore, a.schedStore).
+		WithEnvironments(a.loadEnvironment).
+		WithReload(a.reloadEnvironment, reloads).
+		WithRedaction(a.secrets.Redact)
+
+	if err := tea.NewProgram(m).Start(); err != nil {
+		log.Fatalf("tui error: %v", err)
+	}
+}
+
+func setup() *app {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Start in LAZYADMIN_ENV, or env: from the config; the TUI can switch
+	// environments later (see loadEnvironment).
+	cfg, notes, err := loadConfig(ctx, os.Getenv("LAZYADMIN_ENV"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, n := range notes {
+		log.Print(n)
+	}
+
+	// Bring the shared SQLite database to the current schema before any
+	// store opens it.
+	if err := migrateDB(ctx, cfg.Logging.SQLitePath); err != nil {
+		log.Fatalf("database: %v", err)
 	}
 
 	logger, err := logging.NewAuditLogger(cfg.Logging.SQLitePath)
 	if err != nil {
 		log.Fatalf("audit logger: %v", err)
 	}
-	defer logger.Close()
+	logger.SetEnv(cfg.Env)
+
+	// Secret references are resolved on use; every value resolved is
+	// redacted from audit rows, task output and the TUI.
+	res := secrets.New(cfg)
+	logger.SetRedact(res.Redact)
 
 	// Initialize user store (uses same SQLite database)
 	userStore, err := users.NewStore(cfg.Logging.SQLitePath)
 	if err != nil {
 		log.Fatalf("user store: %v", err)
 	}
-	defer userStore.Close()
 
-	principal, err := auth.ResolvePrincipal(cfg, userStore)
+	// Task run checkpoints (same SQLite database). Runs left running by a
+	// process that no longer exists are marked interrupted so they can be
+	// resumed from the Runs view.
+	runStore, err := runs.NewStore(cfg.Logging.SQLitePath)
 	if err != nil {
-		log.Fatalf("auth: %v", err)
+		log.Fatalf("run store: %v", err)
+	}
+	if n, err := runStore.MarkInterrupted(ctx); err != nil {
+		log.Printf("runs: %v", err)
+	} else if n > 0 {
+		log.Printf("runs: marked %d interrupted task runs; resume them from the Runs view", n)
 	}
 
-	if err := auth.RequireYubiKeyIfConfigured(cfg, principal); err != nil {
-		log.Fatalf("yubikey: %v", err)
+	// Task and operation locks (same SQLite database, shared across bastions)
+	lockStore, err := locks.NewStore(cfg.Logging.SQLitePath)
+	if err != nil {
+		log.Fatalf("lock store: %v", err)
+	}
+
+	// Schedule state written by the scheduler daemon (same SQLite database)
+	schedStore, err := scheduler.NewStore(cfg.Logging.SQLitePath)
+	if err != nil {
+		log.Fatalf("schedule store: %v", err)
 	}
 
+	httpClients, pgClients, warnings := newClients(ctx, cfg, res, nil)
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+	}
+
+	runner := tasks.NewRunner(cfg, logger, httpClients, pgClients)
+	runner.SetRunStore(runStore)
+	runner.SetLockStore(lockStore)
+	runner.SetRedact(res.Redact)
+
+	a := &app{
+		cfg:         cfg,
+		logger:      logger,
+		userStore:   userStore,
+		runStore:    runStore,
+		lockStore:   lockStore,
+		schedStore:  schedStore,
+		elevator:    auth.NewElevator(cfg, userStore, logger),
+		breakGlass:  auth.NewBreakGlass(cfg, userStore, logger, notify.New(cfg, res)),
+		lockout:     auth.NewLockout(cfg, userStore, logger),
+		httpClients: httpClients,
+		pgClients:   pgClients,
+		runner:      runner,
+		secrets:     res,
+	}
+	a.identity = func() (*auth.Principal, error) {
+		return auth.ResolvePrincipal(cfg, userStore)
+	}
+	a.caller = auth.CurrentSSHUser
+
+	// The same person may be defined in users[] and in the store with
+	// different roles; only auth.user_source decides who signs in.
+	warnUserConflicts(ctx, a)
+	return a
+}
+
+// loadConfig loads the configuration, appends the operations generated from
+// its OpenAPI backends, and validates it before and after applying
+// environment env (env: from the config when empty). notes reports on
+// OpenAPI generation, which does not fail the load.
+func loadConfig(ctx context.Context, env string) (cfg *config.Config, notes []string, err error) {
+	cfg, err = config.Load()
+	if err != nil {
+		return nil, nil, fmt.Errorf("load config: %w", err)
+	}
+
+	if len(cfg.OpenAPI.Backends) > 0 {
+		gen := openapi.NewGenerator()
+		autoOps, err := gen.GenerateOperations(ctx, cfg)
+		if err != nil {
+			notes = append(notes, fmt.Sprintf("openapi: %v", err))
+		} else {
+			notes = append(notes, fmt.Sprintf("openapi: generated %d operations", len(autoOps)))
+			cfg.Operations = append(cfg.Operations, autoOps...)
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("config: %w", err)
+	}
+	cfg, err = cfg.ForEnvironment(env)
+	if err != nil {
+		return nil, nil, fmt.Errorf("config: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("config: env %s: %w", cfg.Env, err)
+	}
+	return cfg, notes, nil
+}
+
+// newClients creates the clients of cfg's resources, resolving their secret
+// references through sec. Postgres resources whose DSN is unset, cannot be
+// resolved or is invalid are skipped with a warning. HTTP tokens are
+// resolved per request, so a rotated token is picked up once the cached
+// value expires. Clients of prev, when set, are reused for resources
+// defined the same way in both.
+func newClients(ctx context.Context, cfg *config.Config, sec *secrets.Resolver, prev *ui.Environment) (map[string]*clients.HTTPClient, map[string]*clients.PostgresClient, []string) {
+	var warnings []string
+
 	httpClients := make(map[string]*clients.HTTPClient)
 	for name, res := range cfg.Resources.HTTP {
-		httpClients[name] = clients.NewHTTPClient(res.BaseURL)
+		if prev != nil && prev.HTTPClients[name] != nil && prev.Config.Resources.HTTP[name] == res {
+			httpClients[name] = prev.HTTPClients[name]
+			continue
+		}
+		client := clients.NewHTTPClient(res.BaseURL)
+		if token := res.Token; token != "" {
+			client.SetBearerToken(func(ctx context.Context) (string, error) {
+				return sec.Resolve(ctx, token)
+			})
+		}
+		httpClients[name] = client
 	}
 
 	pgClients := make(map[string]*clients.PostgresClient)
 	for name, res := range cfg.Resources.Postgres {
-		dsn := os.Getenv(res.DSNEnv)
+		if prev != nil && prev.PGClients[name] != nil && prev.Config.Resources.Postgres[name] == res {
+			pgClients[name] = prev.PGClients[name]
+			continue
+		}
+		dsn := res.DSN
 		if dsn == "" {
-			fmt.Fprintf(os.Stderr, "warning: env %s not set, skipping pg resource %s\n", res.DSNEnv, name)
+			dsn = os.Getenv(res.DSNEnv)
+			if dsn == "" {
+				warnings = append(warnings, fmt.Sprintf("env %s not set, skipping pg resource %s", res.DSNEnv, name))
+				continue
+			}
+		}
+		dsn, err := sec.Resolve(ctx, dsn)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("skipping pg resource %s: %v", name, err))
 			continue
 		}
 		client, err := clients.NewPostgresClient(dsn)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "warning: cannot init pg resource %s: %v\n", name, err)
+			warnings = append(warnings, sec.Redact(fmt.Sprintf("cannot init pg resource %s: %v", name, err)))
 			continue
 		}
 		pgClients[name] = client
 	}
+	return httpClients, pgClients, warnings
+}
+
+// loadEnvironment prepares the TUI's switch to environment name: its
+// configuration, the signed-in user as defined there, and clients, runner
+// and audit logger of its own. Task runs already started keep the
+// environment they started in.
+func (a *app) loadEnvironment(name string) (*ui.Environment, error) {
+	a.mu.Lock()
+	cfg, err := a.cfg.ForEnvironment(name)
+	a.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("config: env %s: %w", name, err)
+	}
+	principal, err := a.principal.ForEnvironment(cfg)
+	if err != nil {
+		return nil, err
+	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	logger := a.logger.WithEnv(cfg.Env)
+	httpClients, pgClients, warnings := newClients(ctx, cfg, a.secrets, nil)
+	return a.environment(cfg, principal, logger, httpClients, pgClients, warnings), nil
+}
+
+// reloadEnvironment reloads the configuration for the TUI's current
+// environment cur: it reads the files again, regenerates OpenAPI operations
+// and validates the result, then builds a runner over it. Clients of
+// resources that did not change are kept; runs already started keep the
+// runner, and configuration, they started with. On any error cur stays in
+// use.
+func (a *app) reloadEnvironment(cur *ui.Environment) (*ui.Environment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cfg, notes, err := loadConfig(ctx, cur.Config.Env)
+	if err != nil {
+		return nil, err
+	}
+	principal, err := cur.Principal.ForEnvironment(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// The resolver is shared by every environment, the audit logger's
+	// redaction and the notifier, so it is reconfigured in place rather
+	// than replaced; it keeps redacting values it resolved before.
+	a.mu.Lock()
+	if cfg.Secrets != a.cfg.Secrets {
+		a.secrets.Reconfigure(cfg)
+		notes = append(notes, "secrets: settings changed; secret references are resolved again")
+	}
+	a.mu.Unlock()
+
+	httpClients, pgClients, warnings := newClients(ctx, cfg, a.secrets, cur)
+	a.mu.Lock()
+	a.cfg = cfg
+	a.mu.Unlock()
+	return a.environment(cfg, principal, cur.Logger, httpClients, pgClients, append(notes, warnings...)), nil
+}
+
+// environment assembles a ui.Environment with a runner over cfg and the
+// given clients.
+func (a *app) environment(cfg *config.Config, principal *auth.Principal, logger *logging.AuditLogger, httpClients map[string]*clients.HTTPClient, pgClients map[string]*clients.PostgresClient, warnings []string) *ui.Environment {
 	runner := tasks.NewRunner(cfg, logger, httpClients, pgClients)
+	runner.SetRunStore(a.runStore)
+	runner.SetLockStore(a.lockStore)
+	runner.SetRedact(a.secrets.Redact)
+
+	return &ui.Environment{
+		Config:      cfg,
+		Principal:   principal,
+		Logger:      logger,
+		HTTPClients: httpClients,
+		PGClients:   pgClients,
+		Runner:      runner,
+		Warnings:    warnings,
+	}
+}
 
-	m := ui.NewModel(cfg, principal, logger, userStore, httpClients, pgClients, runner)
+// authenticate resolves the interactive user and enforces the YubiKey check.
+// The user comes from SSH_USER/USER, or from the SSH key in bastion mode.
+// Every attempt is audited, and locked-out users are refused before the
+// check (auth.lockout). The scheduler daemon runs as the service identities
+// of its schedules and does not call it.
+func (a *app) authenticate() error {
+	principal, err := a.resolve()
+	if err != nil {
+		return err
+	}
 
-	if err := tea.NewProgram(m).Start(); err != nil {
-		log.Fatalf("tui error: %v", err)
+	ctx := context.Background()
+	if err := a.lockout.Check(ctx, principal, auth.OpSignIn); err != nil {
+		return fmt.Errorf("auth: %w", err)
+	}
+	err = auth.RequireYubiKeyIfConfigured(a.cfg, principal)
+	if err := a.lockout.Record(ctx, principal, auth.OpSignIn, err); err != nil {
+		return fmt.Errorf("yubikey: %w", err)
+	}
+
+	a.principal = principal
+	return nil
+}
+
+// resolve identifies the caller and loads their role grants, without the
+// YubiKey check. Only break-glass uses it directly, substituting a recovery
+// code for FIDO2; it checks the lockout itself, so a locked-out user is
+// refused there too. Callers that match no user are audited.
+func (a *app) resolve() (*auth.Principal, error) {
+	principal, err := a.identity()
+	if err != nil {
+		a.lockout.Unresolved(a.caller(), err)
+		return nil, fmt.Errorf("auth: %w", err)
+	}
+
+	// Record grants that lapsed since anyone last looked, then pick up the
+	// user's grants still in force.
+	ctx := context.Background()
+	if err := a.elevator.Expire(ctx); err != nil {
+		log.Printf("elevation: %v", err)
+	}
+	if err := a.elevator.LoadGrants(ctx, principal); err != nil {
+		return nil, fmt.Errorf("auth: %w", err)
 	}
+	return principal, nil
 }
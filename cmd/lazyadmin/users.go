@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/you/lazyadmin/internal/auth"
+	"github.com/you/lazyadmin/internal/config"
+	"github.com/you/lazyadmin/internal/logging"
+)
+
+// cmdUsers dispatches the users subcommands.
+func cmdUsers(a *app, args []string) int {
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return exitUsage
+	}
+	switch args[0] {
+	case "sync":
+		return cmdUsersSync(a, args)
+	case "unlock":
+		return cmdUsersUnlock(a, args[1:])
+	}
+	fmt.Fprint(os.Stderr, usage)
+	return exitUsage
+}
+
+// cmdUsersSync reconciles users[] in the config with the SQLite user store:
+// it reports conflicts, imports config users into the store, or exports
+// store users as YAML for users[].
+func cmdUsersSync(a *app, args []string) int {
+	fs := flag.NewFlagSet("users sync", flag.ContinueOnError)
+	doImport := fs.Bool("import", false, "copy config users into the user store")
+	doExport := fs.Bool("export", false, "print store users as YAML for users[]")
+	if err := fs.Parse(args[1:]); err != nil || fs.NArg() > 0 {
+		return exitUsage
+	}
+	if *doImport && *doExport {
+		fmt.Fprintln(os.Stderr, "users sync: --import and --export are exclusive")
+		return exitUsage
+	}
+	if err := a.authenticate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitAuth
+	}
+	if !a.principal.HasPermission(config.PermUsersManage) {
+		fmt.Fprintf(os.Stderr, "users sync: %s permission required\n", config.PermUsersManage)
+		return exitDenied
+	}
+
+	ctx := context.Background()
+	switch {
+	case *doImport:
+		imported, skipped, err := auth.ImportConfigUsers(ctx, a.cfg, a.userStore)
+		for _, id := range imported {
+			a.auditUsers(ctx, "user:import "+id, nil)
+			fmt.Printf("imported %s\n", id)
+		}
+		for _, c := range skipped {
+			fmt.Fprintf(os.Stderr, "skipped %s: %s\n", c.ConfigUser, c)
+		}
+		if err != nil {
+			a.auditUsers(ctx, "user:import", err)
+			fmt.Fprintf(os.Stderr, "users sync: %v\n", err)
+			return exitFailed
+		}
+		if len(imported) > 0 {
+			fmt.Println("Remove the imported users from users[] and set auth.user_source: store.")
+		}
+		return exitOK
+
+	case *doExport:
+		exported, disabled, err := auth.ExportStoreUsers(ctx, a.cfg, a.userStore)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "users sync: %v\n", err)
+			return exitFailed
+		}
+		for _, id := range disabled {
+			fmt.Fprintf(os.Stderr, "skipped %s: disabled\n", id)
+		}
+		out, err := yaml.Marshal(struct {
+			Users []config.User `yaml:"users"`
+		}{exported})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "users sync: %v\n", err)
+			return exitFailed
+		}
+		os.Stdout.Write(out)
+		return exitOK
+	}
+
+	conflicts, err := auth.FindUserConflicts(ctx, a.cfg, a.userStore)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "users sync: %v\n", err)
+		return exitFailed
+	}
+	if len(conflicts) == 0 {
+		fmt.Println("config and store users do not conflict")
+		return exitOK
+	}
+	for _, c := range conflicts {
+		fmt.Printf("%s; %s\n", c, conflictWinner(a.cfg, c))
+	}
+	return exitFailed
+}
+
+// cmdUsersUnlock lifts a lockout (auth.lockout) and clears the user's
+// failed sign-ins. Without a user it lists users with failed sign-ins.
+func cmdUsersUnlock(a *app, args []string) int {
+	if len(args) > 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return exitUsage
+	}
+	if err := a.authenticate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitAuth
+	}
+	if !a.principal.HasPermission(config.PermUsersManage) {
+		fmt.Fprintf(os.Stderr, "users unlock: %s permission required\n", config.PermUsersManage)
+		return exitDenied
+	}
+
+	ctx := context.Background()
+	if len(args) == 0 {
+		list, err := a.userStore.ListAuthFailures(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "users unlock: %v\n", err)
+			return exitFailed
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "USER\tFAILURES\tLAST FAILURE\tLOCKED UNTIL")
+		for _, f := range list {
+			until := "-"
+			if f.Locked(time.Now()) {
+				until = f.LockedUntil.Local().Format(time.DateTime)
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", f.UserID, f.Failures, f.LastFailureAt.Local().Format(time.DateTime), until)
+		}
+		w.Flush()
+		return exitOK
+	}
+
+	userID := args[0]
+	cleared, err := a.userStore.ResetAuthFailures(ctx, userID)
+	a.auditUsers(ctx, "user:unlock "+userID, err)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "users unlock: %v\n", err)
+		return exitFailed
+	}
+	if !cleared {
+		fmt.Printf("%s has no failed sign-ins\n", userID)
+		return exitOK
+	}
+	fmt.Printf("unlocked %s\n", userID)
+	return exitOK
+}
+
+// conflictWinner describes which user of c may sign in.
+func conflictWinner(cfg *config.Config, c auth.UserConflict) string {
+	if cfg.Auth.StoreAuthoritative() {
+		return fmt.Sprintf("store user %s wins (auth.user_source: store)", c.StoreUser)
+	}
+	return fmt.Sprintf("config user %s wins (auth.user_source: config)", c.ConfigUser)
+}
+
+func (a *app) auditUsers(ctx context.Context, opID string, err error) {
+	entry := logging.AuditEntry{
+		Time:        time.Now(),
+		UserID:      a.principal.ConfigUser.ID,
+		SSHUser:     a.principal.SSHUser,
+		OperationID: opID,
+		Success:     err == nil,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	_ = a.logger.Log(ctx, entry)
+}
+
+// warnUserConflicts logs conflicts between config and store users at
+// startup; see lazyadmin users sync.
+func warnUserConflicts(ctx context.Context, a *app) {
+	conflicts, err := auth.FindUserConflicts(ctx, a.cfg, a.userStore)
+	if err != nil {
+		log.Printf("users: %v", err)
+		return
+	}
+	for _, c := range conflicts {
+		log.Printf("users: %s; %s", c, conflictWinner(a.cfg, c))
+	}
+	if len(conflicts) > 0 {
+		log.Printf("users: run `lazyadmin users sync` to reconcile")
+	}
+}
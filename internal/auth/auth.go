@@ -3,8 +3,10 @@ package auth
 import (
 	"context"
 	"errors"
+	"fmt"
 	"os"
 	"os/user"
+	"time"
 
 	"github.com/you/lazyadmin/internal/config"
 	"github.com/you/lazyadmin/internal/users"
@@ -14,10 +16,27 @@ type Principal struct {
 	ConfigUser *config.User
 	DBUser     *users.User
 	SSHUser    string
+	RBAC       *RBAC // role hierarchy; nil uses config.DefaultRoles
+
+	// Grants are the user's role elevations, loaded by Elevator.LoadGrants.
+	// Each confers its role only until it expires.
+	Grants []users.Grant
+
+	// BreakGlass is the emergency grant when the session was opened with a
+	// recovery code instead of FIDO2; see BreakGlass.Activate.
+	BreakGlass *users.Grant
+}
+
+// InBreakGlass reports whether p holds an emergency grant in force at t.
+// FIDO2 checks are waived while it does.
+func (p *Principal) InBreakGlass(t time.Time) bool {
+	return p.BreakGlass != nil && p.BreakGlass.InForce(t)
 }
 
 var (
-	ErrNoMatchingUser = errors.New("no matching lazyadmin user for current SSH user")
+	ErrNoMatchingUser     = errors.New("no matching lazyadmin user for current SSH user")
+	ErrUnknownServiceUser = errors.New("unknown service user")
+	ErrUserDisabled       = errors.New("user is disabled")
 )
 
 func CurrentSSHUser() string {
@@ -35,93 +54,139 @@ func CurrentSSHUser() string {
 }
 
 // ResolvePrincipal resolves the principal from config and optionally from SQLite user store.
-// Config users are checked first (for hardcoded admin), then SQLite users.
+// Config users are checked first (for hardcoded admin), then SQLite users,
+// unless auth.user_source makes the store authoritative. A user conflicting
+// with one of the authoritative source is refused.
+// With auth.bastion set, env-based identity is refused; use
+// ResolveBastionPrincipal.
 func ResolvePrincipal(cfg *config.Config, userStore *users.Store) (*Principal, error) {
+	if cfg.Auth.Bastion {
+		return nil, ErrBastionOnly
+	}
+	ctx := context.Background()
 	sshUser := CurrentSSHUser()
 
-	// First, check config users (for hardcoded admin)
-	for i := range cfg.Users {
-		u := &cfg.Users[i]
-		for _, su := range u.SSHUsers {
-			if su == sshUser {
-				return &Principal{
-					ConfigUser: u,
-					SSHUser:    sshUser,
-				}, nil
+	p, err := resolveInSourceOrder(ctx, cfg, userStore,
+		func() (*Principal, error) {
+			for i := range cfg.Users {
+				u := &cfg.Users[i]
+				for _, su := range u.SSHUsers {
+					if su == sshUser {
+						return &Principal{
+							ConfigUser: u,
+							SSHUser:    sshUser,
+							RBAC:       NewRBAC(cfg),
+						}, nil
+					}
+				}
 			}
-		}
+			return nil, nil
+		},
+		func() (*Principal, error) {
+			if userStore == nil {
+				return nil, nil
+			}
+			dbUser, err := userStore.FindUserBySSHUser(ctx, sshUser)
+			if err != nil {
+				return nil, nil
+			}
+			return storePrincipal(ctx, cfg, userStore, dbUser, sshUser)
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, ErrNoMatchingUser
 	}
+	return p, nil
+}
 
-	// Then check SQLite users if store is provided
-	if userStore != nil {
-		ctx := context.Background()
-		dbUser, err := userStore.FindUserBySSHUser(ctx, sshUser)
-		if err == nil {
-			// Convert DB user to config user format for compatibility
-			configUser := &config.User{
-				ID:           dbUser.ID,
-				SSHUsers:     dbUser.SSHUsers,
-				Roles:        dbUser.Roles,
-				YubiKeyCreds: []config.YubiKeyCredential{},
-			}
+// storePrincipal builds a principal for a user from the SQLite store,
+// converting it to the config user format and loading its credentials.
+// The current environment may override the user's roles. Disabled users
+// are refused.
+func storePrincipal(ctx context.Context, cfg *config.Config, userStore *users.Store, dbUser *users.User, sshUser string) (*Principal, error) {
+	if dbUser.Disabled {
+		return nil, fmt.Errorf("%w: %s", ErrUserDisabled, dbUser.ID)
+	}
 
-			// Load credentials from DB
-			creds, err := userStore.GetCredentials(ctx, dbUser.ID)
-			if err == nil {
-				for _, cred := range creds {
-					configUser.YubiKeyCreds = append(configUser.YubiKeyCreds, config.YubiKeyCredential{
-						RPID:         cred.RPID,
-						CredentialID: cred.CredentialID,
-						PublicKey:    cred.PublicKey,
-					})
-				}
-			}
+	// Convert DB user to config user format for compatibility
+	configUser := &config.User{
+		ID:           dbUser.ID,
+		SSHUsers:     dbUser.SSHUsers,
+		Roles:        dbUser.Roles,
+		YubiKeyCreds: []config.YubiKeyCredential{},
+	}
+	if roles, ok := cfg.EnvironmentRoles(dbUser.ID); ok {
+		configUser.Roles = roles
+	}
 
-			return &Principal{
-				DBUser:     dbUser,
-				SSHUser:    sshUser,
-				ConfigUser: configUser,
-			}, nil
+	// Load credentials from DB
+	creds, err := userStore.GetCredentials(ctx, dbUser.ID)
+	if err == nil {
+		for _, cred := range creds {
+			configUser.YubiKeyCreds = append(configUser.YubiKeyCreds, config.YubiKeyCredential{
+				RPID:         cred.RPID,
+				CredentialID: cred.CredentialID,
+				PublicKey:    cred.PublicKey,
+				Name:         cred.Name,
+				UserHandle:   cred.UserHandle,
+			})
 		}
 	}
 
-	return nil, ErrNoMatchingUser
+	return &Principal{
+		DBUser:     dbUser,
+		SSHUser:    sshUser,
+		ConfigUser: configUser,
+		RBAC:       NewRBAC(cfg),
+	}, nil
 }
 
-func (p *Principal) HasRole(role string) bool {
-	if p.ConfigUser != nil {
-		for _, r := range p.ConfigUser.Roles {
-			if r == role {
-				return true
-			}
-		}
-	}
-	if p.DBUser != nil {
-		for _, r := range p.DBUser.Roles {
-			if r == role {
-				return true
-			}
+// ForEnvironment returns p as it is in the environment of cfg, a
+// configuration from config.ForEnvironment: a config user as defined there,
+// or a store user with the roles the environment gives them. The SSH user,
+// role grants and break-glass grant carry over.
+func (p *Principal) ForEnvironment(cfg *config.Config) (*Principal, error) {
+	out := *p
+	out.RBAC = NewRBAC(cfg)
+	if p.DBUser == nil {
+		u, ok := cfg.FindUser(p.ConfigUser.ID)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s in env %s", ErrNoMatchingUser, p.ConfigUser.ID, cfg.Env)
 		}
+		out.ConfigUser = u
+		return &out, nil
 	}
-	return false
-}
 
-// IsAdmin returns true if the principal has admin or owner role.
-func (p *Principal) IsAdmin() bool {
-	return p.HasRole("admin") || p.HasRole("owner")
+	u := *p.ConfigUser
+	u.Roles = p.DBUser.Roles
+	if roles, ok := cfg.EnvironmentRoles(p.DBUser.ID); ok {
+		u.Roles = roles
+	}
+	out.ConfigUser = &u
+	return &out, nil
 }
 
-func (p *Principal) HasAnyRole(roles []string) bool {
-	for _, role := range roles {
-		if p.HasRole(role) {
-			return true
-		}
+// ServicePrincipal returns the principal for an unattended service identity,
+// such as a schedule's run_as user. There is no SSH session; SSHUser records
+// the service name (e.g. "scheduler") so audit entries show where the action
+// came from.
+func ServicePrincipal(cfg *config.Config, userID, service string) (*Principal, error) {
+	u, ok := cfg.FindUser(userID)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownServiceUser, userID)
 	}
-	return false
+	return &Principal{
+		ConfigUser: u,
+		SSHUser:    service,
+		RBAC:       NewRBAC(cfg),
+	}, nil
 }
 
 func RequireYubiKeyIfConfigured(cfg *config.Config, p *Principal) error {
-	if !cfg.Auth.RequireYubiKey {
+	if !cfg.Auth.RequireYubiKey || (p != nil && p.InBreakGlass(time.Now())) {
 		return nil
 	}
 
@@ -134,3 +199,27 @@ func RequireYubiKeyIfConfigured(cfg *config.Config, p *Principal) error {
 
 	return RequireFIDO2Assertion(ctx, p.ConfigUser)
 }
+
+// RequireTaskYubiKey asks for a fresh FIDO2 assertion (step-up) before a task
+// marked require_yubikey runs, even if one was given at login. Break-glass
+// sessions skip it while their grant is in force.
+func RequireTaskYubiKey(p *Principal, task config.Task) error {
+	if !task.RequireYubiKey || p.InBreakGlass(time.Now()) {
+		return nil
+	}
+
+	return Reauthenticate(p)
+}
+
+// Reauthenticate requires a fresh FIDO2 assertion from p, regardless of
+// auth.require_yubikey.
+func Reauthenticate(p *Principal) error {
+	if p == nil || p.ConfigUser == nil {
+		return ErrNoYubiCreds
+	}
+
+	ctx, cancel := ContextWithTimeout()
+	defer cancel()
+
+	return RequireFIDO2Assertion(ctx, p.ConfigUser)
+}
@@ -1,10 +1,13 @@
 package auth
 
 import (
+	"errors"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/you/lazyadmin/internal/config"
+	"github.com/you/lazyadmin/internal/users"
 )
 
 func TestCurrentSSHUser(t *testing.T) {
@@ -240,3 +243,153 @@ func TestPrincipal_HasAnyRole(t *testing.T) {
 		})
 	}
 }
+
+func TestServicePrincipal(t *testing.T) {
+	cfg := &config.Config{
+		Users: []config.User{{ID: "svc_backup", SSHUsers: []string{"backup"}, Roles: []string{"operator"}}},
+	}
+
+	p, err := ServicePrincipal(cfg, "svc_backup", "scheduler")
+	if err != nil {
+		t.Fatalf("ServicePrincipal() error = %v", err)
+	}
+	if p.ConfigUser.ID != "svc_backup" || p.SSHUser != "scheduler" || !p.HasRole("operator") {
+		t.Errorf("ServicePrincipal() = %+v", p)
+	}
+
+	if _, err := ServicePrincipal(cfg, "nobody", "scheduler"); !errors.Is(err, ErrUnknownServiceUser) {
+		t.Errorf("ServicePrincipal(nobody) error = %v, want ErrUnknownServiceUser", err)
+	}
+}
+
+func TestPrincipal_ForEnvironment(t *testing.T) {
+	base := &config.Config{
+		Env:   "dev",
+		Users: []config.User{{ID: "alice", SSHUsers: []string{"alice"}, Roles: []string{"admin"}}},
+		Environments: map[string]config.Environment{
+			"dev":  {},
+			"prod": {UserRoles: map[string][]string{"alice": {"read_only"}, "erin": {"operator"}}},
+		},
+	}
+	prod, err := base.ForEnvironment("prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := &Principal{ConfigUser: &base.Users[0], SSHUser: "alice", RBAC: NewRBAC(base)}
+	p, err := alice.ForEnvironment(prod)
+	if err != nil {
+		t.Fatalf("ForEnvironment(prod) error = %v", err)
+	}
+	if p.HasRole("admin") || !p.HasRole("read_only") || !alice.HasRole("admin") {
+		t.Errorf("alice in prod: admin %v, read_only %v", p.HasRole("admin"), p.HasRole("read_only"))
+	}
+
+	erin := &Principal{
+		DBUser:     &users.User{ID: "erin", Roles: []string{"read_only"}},
+		ConfigUser: &config.User{ID: "erin", Roles: []string{"read_only"}},
+		SSHUser:    "erin",
+	}
+	if p, err := erin.ForEnvironment(prod); err != nil || !p.HasRole("operator") {
+		t.Errorf("erin in prod: %v, operator %v", err, p != nil && p.HasRole("operator"))
+	}
+	if p, err := erin.ForEnvironment(base); err != nil || p.HasRole("operator") {
+		t.Errorf("erin in dev: %v, operator %v", err, p != nil && p.HasRole("operator"))
+	}
+
+	bob := &Principal{ConfigUser: &config.User{ID: "bob"}, SSHUser: "bob"}
+	if _, err := bob.ForEnvironment(prod); !errors.Is(err, ErrNoMatchingUser) {
+		t.Errorf("ForEnvironment() for unknown config user error = %v, want ErrNoMatchingUser", err)
+	}
+}
+
+func TestPrincipal_EnvironmentDemotesStoreUser(t *testing.T) {
+	store := newSyncStore(t)
+	base := syncConfig(config.UserSourceStore)
+	base.Env = "dev"
+	base.Environments = map[string]config.Environment{
+		"dev":  {},
+		"prod": {UserRoles: map[string][]string{"robert": {"read_only"}}},
+	}
+	prod, err := base.ForEnvironment("prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// robert is an owner in the store and only read_only in prod, whether
+	// resolved there or switched to it.
+	t.Setenv("SSH_USER", "bob")
+	p, err := ResolvePrincipal(prod, store)
+	if err != nil {
+		t.Fatalf("ResolvePrincipal() error = %v", err)
+	}
+	if p.DBUser == nil || p.HasRole("owner") || !p.HasRole("read_only") {
+		t.Errorf("robert resolved in prod: store %v, owner %v, read_only %v", p.DBUser != nil, p.HasRole("owner"), p.HasRole("read_only"))
+	}
+
+	dev, err := ResolvePrincipal(base, store)
+	if err != nil {
+		t.Fatalf("ResolvePrincipal() error = %v", err)
+	}
+	if !dev.HasRole("owner") {
+		t.Error("robert in dev is not an owner")
+	}
+	p, err = dev.ForEnvironment(prod)
+	if err != nil {
+		t.Fatalf("ForEnvironment(prod) error = %v", err)
+	}
+	if p.HasRole("owner") || !p.HasRole("read_only") {
+		t.Errorf("robert switched to prod: owner %v, read_only %v", p.HasRole("owner"), p.HasRole("read_only"))
+	}
+}
+
+func TestRequireTaskYubiKey(t *testing.T) {
+	p := &Principal{ConfigUser: &config.User{ID: "alice"}, SSHUser: "alice"}
+
+	if err := RequireTaskYubiKey(p, config.Task{ID: "restart"}); err != nil {
+		t.Errorf("RequireTaskYubiKey() without require_yubikey error = %v, want nil", err)
+	}
+	if err := RequireTaskYubiKey(p, config.Task{ID: "drop", RequireYubiKey: true}); !errors.Is(err, ErrNoYubiCreds) {
+		t.Errorf("RequireTaskYubiKey() for user without credentials error = %v, want ErrNoYubiCreds", err)
+	}
+}
+
+func TestSession_Expired(t *testing.T) {
+	t0 := time.Date(2025, 1, 15, 9, 0, 0, 0, time.UTC)
+	cfg := &config.Config{Auth: config.AuthConfig{SessionIdleTimeout: 10 * time.Minute, SessionMaxAge: time.Hour}}
+
+	tests := []struct {
+		name    string
+		prepare func(s *Session)
+		at      time.Duration
+		want    LockReason
+	}{
+		{name: "fresh", at: time.Minute, want: ""},
+		{name: "idle", at: 10 * time.Minute, want: LockIdle},
+		{name: "input keeps it alive", prepare: func(s *Session) { s.Touch(t0.Add(8 * time.Minute)) }, at: 15 * time.Minute, want: ""},
+		{name: "max age despite input", prepare: func(s *Session) { s.Touch(t0.Add(59 * time.Minute)) }, at: time.Hour, want: LockMaxAge},
+		{name: "renew resets max age", prepare: func(s *Session) { s.Renew(t0.Add(55 * time.Minute)) }, at: 61 * time.Minute, want: ""},
+		{name: "break-glass suspends timeouts", prepare: func(s *Session) { s.BreakGlassUntil = t0.Add(2 * time.Hour) }, at: 90 * time.Minute, want: ""},
+		{name: "break-glass window ends", prepare: func(s *Session) { s.BreakGlassUntil = t0.Add(30 * time.Minute) }, at: 30 * time.Minute, want: LockBreakGlass},
+		{name: "renew ends break-glass", prepare: func(s *Session) {
+			s.BreakGlassUntil = t0.Add(30 * time.Minute)
+			s.Renew(t0.Add(31 * time.Minute))
+		}, at: 35 * time.Minute, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSession(cfg, t0)
+			if tt.prepare != nil {
+				tt.prepare(&s)
+			}
+			if got := s.Expired(t0.Add(tt.at)); got != tt.want {
+				t.Errorf("Expired() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if disabled := NewSession(&config.Config{}, t0); disabled.Enabled() || disabled.Expired(t0.Add(1000*time.Hour)) != "" {
+		t.Errorf("session without timeouts expired")
+	}
+}
@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+
+	"github.com/you/lazyadmin/internal/config"
+	"github.com/you/lazyadmin/internal/users"
+)
+
+var (
+	ErrBastionOnly      = errors.New("auth.bastion is set: identity must come from `lazyadmin bastion`, not SSH_USER/USER")
+	ErrNoBastionKey     = errors.New("no SSH key identity: set --user or --key in authorized_keys, or enable ExposeAuthInfo")
+	ErrUnknownSSHKey    = errors.New("no lazyadmin user for SSH key")
+	ErrAmbiguousSSHKeys = errors.New("SSH keys map to different lazyadmin users")
+)
+
+// BastionIdentity is what sshd tells lazyadmin about the connecting user when
+// it runs as the forced command. UserID and Fingerprint come from the
+// authorized_keys command= option (trusted, since the user cannot change it);
+// AuthInfoPath is the ExposeAuthInfo file named by SSH_USER_AUTH.
+type BastionIdentity struct {
+	UserID       string
+	Fingerprint  string
+	AuthInfoPath string
+}
+
+// Presented describes what the client presented, for auditing a caller that
+// matches no user: the user ID or key fingerprint from authorized_keys, or
+// the keys listed in the ExposeAuthInfo file. The Unix account lazyadmin runs
+// as is shared by everyone on a bastion and says nothing about the caller.
+func (id BastionIdentity) Presented() string {
+	switch {
+	case id.UserID != "":
+		return "user:" + id.UserID
+	case id.Fingerprint != "":
+		return "key:" + id.Fingerprint
+	case id.AuthInfoPath != "":
+		if fps, err := ExposedKeyFingerprints(id.AuthInfoPath); err == nil && len(fps) > 0 {
+			return "key:" + strings.Join(fps, ",")
+		}
+	}
+	return "key:none"
+}
+
+// ResolveBastionPrincipal resolves the principal in bastion mode. The first
+// of UserID, Fingerprint and the public keys listed in AuthInfoPath that is
+// set is used; SSH_USER and USER are never consulted. Fingerprints are looked
+// up in users[].ssh_keys first, then in the SQLite user store (the other way
+// round when auth.user_source is store). The principal's
+// SSHUser is the Unix account lazyadmin runs as.
+func ResolveBastionPrincipal(cfg *config.Config, userStore *users.Store, id BastionIdentity) (*Principal, error) {
+	ctx := context.Background()
+	unixUser := currentUnixUser()
+
+	if id.UserID != "" {
+		p, err := resolveInSourceOrder(ctx, cfg, userStore,
+			func() (*Principal, error) {
+				if u, ok := cfg.FindUser(id.UserID); ok {
+					return &Principal{ConfigUser: u, SSHUser: unixUser, RBAC: NewRBAC(cfg)}, nil
+				}
+				return nil, nil
+			},
+			func() (*Principal, error) {
+				if userStore == nil {
+					return nil, nil
+				}
+				dbUser, err := userStore.GetUser(ctx, id.UserID)
+				if err != nil {
+					return nil, nil
+				}
+				return storePrincipal(ctx, cfg, userStore, dbUser, unixUser)
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+		if p == nil {
+			return nil, fmt.Errorf("%w: user %q", ErrNoMatchingUser, id.UserID)
+		}
+		return p, nil
+	}
+
+	var fingerprints []string
+	switch {
+	case id.Fingerprint != "":
+		fingerprints = []string{id.Fingerprint}
+	case id.AuthInfoPath != "":
+		fps, err := ExposedKeyFingerprints(id.AuthInfoPath)
+		if err != nil {
+			return nil, err
+		}
+		fingerprints = fps
+	}
+	if len(fingerprints) == 0 {
+		return nil, ErrNoBastionKey
+	}
+
+	var found *Principal
+	for _, fp := range fingerprints {
+		p, err := principalForKey(ctx, cfg, userStore, fp, unixUser)
+		if errors.Is(err, ErrUnknownSSHKey) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if found != nil && found.ConfigUser.ID != p.ConfigUser.ID {
+			return nil, fmt.Errorf("%w: %s and %s", ErrAmbiguousSSHKeys, found.ConfigUser.ID, p.ConfigUser.ID)
+		}
+		found = p
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSSHKey, strings.Join(fingerprints, ", "))
+	}
+	return found, nil
+}
+
+func principalForKey(ctx context.Context, cfg *config.Config, userStore *users.Store, fingerprint, unixUser string) (*Principal, error) {
+	p, err := resolveInSourceOrder(ctx, cfg, userStore,
+		func() (*Principal, error) {
+			for i := range cfg.Users {
+				u := &cfg.Users[i]
+				for _, k := range u.SSHKeys {
+					if k == fingerprint {
+						return &Principal{ConfigUser: u, SSHUser: unixUser, RBAC: NewRBAC(cfg)}, nil
+					}
+				}
+			}
+			return nil, nil
+		},
+		func() (*Principal, error) {
+			if userStore == nil {
+				return nil, nil
+			}
+			dbUser, err := userStore.FindUserBySSHKey(ctx, fingerprint)
+			if errors.Is(err, users.ErrUserNotFound) {
+				return nil, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			return storePrincipal(ctx, cfg, userStore, dbUser, unixUser)
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, ErrUnknownSSHKey
+	}
+	return p, nil
+}
+
+// ExposedKeyFingerprints reads the file sshd writes when ExposeAuthInfo is
+// enabled and returns the fingerprints of the public keys the user
+// authenticated with. Lines look like "publickey ssh-ed25519 AAAA...".
+func ExposedKeyFingerprints(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("read SSH_USER_AUTH: %w", err)
+	}
+	defer f.Close()
+
+	var fps []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 3 || fields[0] != "publickey" {
+			continue
+		}
+		fp, err := KeyFingerprint(fields[1] + " " + fields[2])
+		if err != nil {
+			return nil, err
+		}
+		fps = append(fps, fp)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read SSH_USER_AUTH: %w", err)
+	}
+	return fps, nil
+}
+
+// KeyFingerprint returns the SHA256 fingerprint of a public key in
+// authorized_keys format ("ssh-ed25519 AAAA... [comment]"), matching
+// `ssh-keygen -l`.
+func KeyFingerprint(authorizedKey string) (string, error) {
+	fields := strings.Fields(authorizedKey)
+	if len(fields) < 2 {
+		return "", fmt.Errorf("parse public key: expected \"<type> <base64>\"")
+	}
+	blob, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return "", fmt.Errorf("parse public key: %w", err)
+	}
+	sum := sha256.Sum256(blob)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:]), nil
+}
+
+// currentUnixUser returns the account lazyadmin runs as, from the OS rather
+// than the environment.
+func currentUnixUser() string {
+	u, err := user.Current()
+	if err == nil && u.Username != "" {
+		return u.Username
+	}
+	return "unknown"
+}
@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/you/lazyadmin/internal/config"
+	"github.com/you/lazyadmin/internal/users"
+)
+
+const (
+	testPublicKey   = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIB1xo/xJx8Ok3LIm5I646BmE1e4vYpbqbkE0QFV6w7cN test"
+	testFingerprint = "SHA256:lhQ3xekhxGKFtWOicH26MDIh1MrG8cK7b0b/bofhf8Y" // ssh-keygen -lf
+)
+
+func TestKeyFingerprint(t *testing.T) {
+	got, err := KeyFingerprint(testPublicKey)
+	if err != nil {
+		t.Fatalf("KeyFingerprint() error = %v", err)
+	}
+	if got != testFingerprint {
+		t.Errorf("KeyFingerprint() = %s, want %s", got, testFingerprint)
+	}
+
+	if _, err := KeyFingerprint("ssh-ed25519"); err == nil {
+		t.Errorf("KeyFingerprint() without key data error = nil")
+	}
+}
+
+func TestResolveBastionPrincipal(t *testing.T) {
+	dir := t.TempDir()
+	store, err := users.NewStore(filepath.Join(dir, "lazyadmin.db"))
+	if err != nil {
+		t.Fatalf("users.NewStore() error = %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	if err := store.CreateUser(ctx, &users.User{ID: "carol", SSHUsers: []string{"carol"}, Roles: []string{"operator"}}); err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+	if err := store.AddSSHKey(ctx, "carol", "SHA256:carolkey"); err != nil {
+		t.Fatalf("AddSSHKey() error = %v", err)
+	}
+	if err := store.CreateUser(ctx, &users.User{ID: "dave", SSHUsers: []string{"dave"}, Roles: []string{"operator"}}); err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+	if err := store.AddSSHKey(ctx, "dave", "SHA256:davekey"); err != nil {
+		t.Fatalf("AddSSHKey() error = %v", err)
+	}
+	if err := store.SetDisabled(ctx, "dave", true); err != nil {
+		t.Fatalf("SetDisabled() error = %v", err)
+	}
+
+	authInfo := filepath.Join(dir, "auth-info")
+	if err := os.WriteFile(authInfo, []byte("publickey "+testPublicKey+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	emptyInfo := filepath.Join(dir, "empty-info")
+	if err := os.WriteFile(emptyInfo, []byte("password\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{
+		Auth: config.AuthConfig{Bastion: true},
+		Users: []config.User{
+			{ID: "alice", SSHUsers: []string{"alice"}, Roles: []string{"admin"}, SSHKeys: []string{testFingerprint}},
+			{ID: "bob", SSHUsers: []string{"bob"}, Roles: []string{"operator"}, SSHKeys: []string{"SHA256:bobkey"}},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		id       BastionIdentity
+		wantUser string
+		wantErr  error
+	}{
+		{name: "user from command=", id: BastionIdentity{UserID: "bob"}, wantUser: "bob"},
+		{name: "store user from command=", id: BastionIdentity{UserID: "carol"}, wantUser: "carol"},
+		{name: "unknown user", id: BastionIdentity{UserID: "mallory"}, wantErr: ErrNoMatchingUser},
+		{name: "fingerprint from command=", id: BastionIdentity{Fingerprint: "SHA256:bobkey"}, wantUser: "bob"},
+		{name: "store fingerprint", id: BastionIdentity{Fingerprint: "SHA256:carolkey"}, wantUser: "carol"},
+		{name: "disabled store user", id: BastionIdentity{UserID: "dave"}, wantErr: ErrUserDisabled},
+		{name: "disabled store fingerprint", id: BastionIdentity{Fingerprint: "SHA256:davekey"}, wantErr: ErrUserDisabled},
+		{name: "unknown fingerprint", id: BastionIdentity{Fingerprint: "SHA256:nope"}, wantErr: ErrUnknownSSHKey},
+		{name: "ExposeAuthInfo", id: BastionIdentity{AuthInfoPath: authInfo}, wantUser: "alice"},
+		{name: "ExposeAuthInfo without keys", id: BastionIdentity{AuthInfoPath: emptyInfo}, wantErr: ErrNoBastionKey},
+		{name: "nothing", id: BastionIdentity{}, wantErr: ErrNoBastionKey},
+	}
+
+	// Env-based identity must not be trusted.
+	t.Setenv("SSH_USER", "alice")
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := ResolveBastionPrincipal(cfg, store, tt.id)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("ResolveBastionPrincipal() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveBastionPrincipal() error = %v", err)
+			}
+			if p.ConfigUser.ID != tt.wantUser {
+				t.Errorf("ResolveBastionPrincipal() user = %s, want %s", p.ConfigUser.ID, tt.wantUser)
+			}
+			if p.SSHUser == "alice" {
+				t.Errorf("ResolveBastionPrincipal() SSHUser taken from SSH_USER")
+			}
+		})
+	}
+
+	if _, err := ResolvePrincipal(cfg, store); !errors.Is(err, ErrBastionOnly) {
+		t.Errorf("ResolvePrincipal() with auth.bastion error = %v, want ErrBastionOnly", err)
+	}
+}
+
+func TestBastionIdentity_Presented(t *testing.T) {
+	authInfo := filepath.Join(t.TempDir(), "auth-info")
+	if err := os.WriteFile(authInfo, []byte("publickey "+testPublicKey+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		id   BastionIdentity
+		want string
+	}{
+		{BastionIdentity{UserID: "alice", Fingerprint: "SHA256:x"}, "user:alice"},
+		{BastionIdentity{Fingerprint: "SHA256:x", AuthInfoPath: authInfo}, "key:SHA256:x"},
+		{BastionIdentity{AuthInfoPath: authInfo}, "key:" + testFingerprint},
+		{BastionIdentity{}, "key:none"},
+	}
+	for _, tt := range tests {
+		if got := tt.id.Presented(); got != tt.want {
+			t.Errorf("%+v.Presented() = %q, want %q", tt.id, got, tt.want)
+		}
+	}
+}
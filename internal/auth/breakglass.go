@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/you/lazyadmin/internal/config"
+	"github.com/you/lazyadmin/internal/logging"
+	"github.com/you/lazyadmin/internal/notify"
+	"github.com/you/lazyadmin/internal/users"
+)
+
+var (
+	ErrBreakGlassDisabled = errors.New("break-glass is not configured (auth.break_glass.role)")
+	ErrBreakGlassReason   = errors.New("break-glass requires a reason")
+	ErrCodesNotAllowed    = errors.New("users.manage permission is required to issue recovery codes")
+)
+
+const (
+	// BreakGlassApprover is recorded as the approver of break-glass grants.
+	BreakGlassApprover = "break-glass"
+	// BreakGlassMark tags every audit entry of a break-glass session.
+	BreakGlassMark = "break-glass"
+	// DefaultRecoveryCodes is how many codes IssueCodes generates by default.
+	DefaultRecoveryCodes = 10
+)
+
+// recoveryAlphabet omits characters that are easily misread (0/O, 1/I/L).
+const recoveryAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+
+// BreakGlass grants the configured emergency role to a user who cannot
+// produce a FIDO2 assertion, in exchange for a one-time recovery code and a
+// reason. Every attempt is audited and sent to all notification sinks, and
+// wrong codes count toward auth.lockout like failed FIDO2 assertions.
+type BreakGlass struct {
+	cfg      *config.Config
+	store    *users.Store
+	logger   *logging.AuditLogger
+	notifier *notify.Notifier
+	lockout  *Lockout
+	now      func() time.Time
+}
+
+// NewBreakGlass returns a BreakGlass keeping recovery codes and grants in
+// store. logger may be nil.
+func NewBreakGlass(cfg *config.Config, store *users.Store, logger *logging.AuditLogger, notifier *notify.Notifier) *BreakGlass {
+	b := &BreakGlass{cfg: cfg, store: store, logger: logger, notifier: notifier, now: time.Now}
+	// Attempts are audited as break-glass:denied or break-glass:start, so
+	// the lockout only counts them.
+	b.lockout = NewLockout(cfg, store, nil)
+	b.lockout.now = func() time.Time { return b.now() }
+	return b
+}
+
+// GenerateRecoveryCodes returns n random codes of the form XXXX-XXXX-XXXX.
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	buf := make([]byte, 12)
+	for i := range codes {
+		if _, err := rand.Read(buf); err != nil {
+			return nil, fmt.Errorf("generate recovery codes: %w", err)
+		}
+		var b strings.Builder
+		for j, c := range buf {
+			if j > 0 && j%4 == 0 {
+				b.WriteByte('-')
+			}
+			b.WriteByte(recoveryAlphabet[int(c)%len(recoveryAlphabet)])
+		}
+		codes[i] = b.String()
+	}
+	return codes, nil
+}
+
+// IssueCodes replaces userID's recovery codes with n new ones and returns
+// them; only their hashes are stored. A code is redeemed for the emergency
+// role, so issuing codes needs users.manage even for the caller's own.
+func (b *BreakGlass) IssueCodes(ctx context.Context, p *Principal, userID string, n int) ([]string, error) {
+	opID := fmt.Sprintf("break-glass:codes user:%s count:%d", userID, n)
+	if !p.HasPermission(config.PermUsersManage) {
+		b.log(p, opID, ErrCodesNotAllowed)
+		return nil, ErrCodesNotAllowed
+	}
+	if _, ok := b.cfg.FindUser(userID); !ok {
+		if _, err := b.store.GetUser(ctx, userID); err != nil {
+			return nil, fmt.Errorf("%w: %q", err, userID)
+		}
+	}
+
+	codes, err := GenerateRecoveryCodes(n)
+	if err != nil {
+		return nil, err
+	}
+	err = b.store.ReplaceRecoveryCodes(ctx, userID, codes)
+	b.log(p, opID, err)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Activate consumes code and grants p the emergency role for the configured
+// window. Until the window ends every entry of the audit logger carries
+// BreakGlassMark. A user locked out by auth.lockout may not try a code.
+// Notification failures are audited but do not block access: the sinks may
+// be part of the outage.
+func (b *BreakGlass) Activate(ctx context.Context, p *Principal, code, reason string) (*users.Grant, error) {
+	bg := b.cfg.Auth.BreakGlass
+	var err error
+	switch {
+	case !bg.Enabled():
+		err = ErrBreakGlassDisabled
+	case strings.TrimSpace(reason) == "":
+		err = ErrBreakGlassReason
+	default:
+		err = b.useCode(ctx, p, code)
+	}
+	if err != nil {
+		b.log(p, fmt.Sprintf("break-glass:denied reason:%q", reason), err)
+		b.notify(ctx, p, "break-glass:denied", fmt.Sprintf("rejected attempt (%v), reason: %s", err, reason))
+		return nil, err
+	}
+
+	g := &users.Grant{
+		UserID:      p.ConfigUser.ID,
+		Role:        bg.Role,
+		Reason:      reason,
+		Duration:    bg.DurationOrDefault(),
+		RequestedAt: b.now(),
+	}
+	if err := b.store.CreateGrant(ctx, g); err != nil {
+		return nil, err
+	}
+	g, err = b.store.ApproveGrant(ctx, g.ID, BreakGlassApprover, b.now())
+	if err != nil {
+		return nil, err
+	}
+	p.Grants = append(p.Grants, *g)
+	p.BreakGlass = g
+
+	if b.logger != nil {
+		b.logger.SetMark(BreakGlassMark, g.ExpiresAt)
+	}
+	b.log(p, fmt.Sprintf("break-glass:start #%d role:%s until:%s reason:%q",
+		g.ID, g.Role, g.ExpiresAt.UTC().Format(time.RFC3339), reason), nil)
+	b.notify(ctx, p, "break-glass:start", fmt.Sprintf("role %s until %s, reason: %s",
+		g.Role, g.ExpiresAt.UTC().Format(time.RFC3339), reason))
+	return g, nil
+}
+
+// useCode consumes p's recovery code, refusing it while p is locked out. A
+// wrong code counts as a failed sign-in; a right one resets the count.
+func (b *BreakGlass) useCode(ctx context.Context, p *Principal, code string) error {
+	if err := b.lockout.Check(ctx, p, ""); err != nil {
+		return err
+	}
+	err := b.store.UseRecoveryCode(ctx, p.ConfigUser.ID, code, b.now())
+	if err != nil && !errors.Is(err, users.ErrRecoveryCodeInvalid) {
+		return err
+	}
+	return b.lockout.Record(ctx, p, "", err)
+}
+
+func (b *BreakGlass) notify(ctx context.Context, p *Principal, kind, msg string) {
+	if b.notifier == nil {
+		return
+	}
+	err := b.notifier.Notify(ctx, notify.Event{
+		Time:    b.now(),
+		Kind:    kind,
+		Project: b.cfg.Project,
+		Env:     b.cfg.Env,
+		UserID:  p.ConfigUser.ID,
+		SSHUser: p.SSHUser,
+		Message: msg,
+	})
+	if err != nil {
+		b.log(p, "break-glass:notify "+kind, err)
+	}
+}
+
+func (b *BreakGlass) log(p *Principal, opID string, err error) {
+	if b.logger == nil {
+		return
+	}
+
+	entry := logging.AuditEntry{
+		Time:        b.now(),
+		UserID:      p.ConfigUser.ID,
+		SSHUser:     p.SSHUser,
+		OperationID: opID,
+		Success:     err == nil,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	_ = b.logger.Log(context.Background(), entry)
+}
@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/you/lazyadmin/internal/config"
+	"github.com/you/lazyadmin/internal/logging"
+	"github.com/you/lazyadmin/internal/notify"
+	"github.com/you/lazyadmin/internal/users"
+)
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(20)
+	if err != nil {
+		t.Fatalf("GenerateRecoveryCodes() error = %v", err)
+	}
+	format := regexp.MustCompile(`^[A-Z2-9]{4}-[A-Z2-9]{4}-[A-Z2-9]{4}$`)
+	seen := map[string]bool{}
+	for _, c := range codes {
+		if !format.MatchString(c) || seen[c] {
+			t.Errorf("code %q is malformed or repeated", c)
+		}
+		seen[c] = true
+	}
+}
+
+func TestBreakGlass(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "lazyadmin.db")
+	store, err := users.NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("users.NewStore() error = %v", err)
+	}
+	defer store.Close()
+	logger, err := logging.NewAuditLogger(dbPath)
+	if err != nil {
+		t.Fatalf("NewAuditLogger() error = %v", err)
+	}
+	defer logger.Close()
+
+	cfg := &config.Config{
+		Auth: config.AuthConfig{
+			BreakGlass: config.BreakGlassConfig{Role: "admin", Duration: 15 * time.Minute},
+			Lockout:    config.LockoutConfig{MaxFailures: 3, Duration: 10 * time.Minute},
+		},
+		Users: []config.User{
+			{ID: "alice", SSHUsers: []string{"alice"}, Roles: []string{"operator"}},
+			{ID: "bob", SSHUsers: []string{"bob"}, Roles: []string{"owner"}},
+			{ID: "carol", SSHUsers: []string{"carol"}, Roles: []string{"read_only"}},
+		},
+		// No URL is set, so every notification fails and is audited.
+		Notifications: []config.NotificationSink{{Name: "oncall", Type: "slack", URLEnv: "LAZYADMIN_TEST_UNSET_URL"}},
+	}
+	principal := func(id string) *Principal {
+		u, _ := cfg.FindUser(id)
+		return &Principal{ConfigUser: u, SSHUser: id, RBAC: NewRBAC(cfg)}
+	}
+
+	ctx := context.Background()
+	now := time.Now()
+	b := NewBreakGlass(cfg, store, logger, notify.New(cfg, nil))
+	b.now = func() time.Time { return now }
+	alice, bob := principal("alice"), principal("bob")
+
+	if _, err := b.IssueCodes(ctx, alice, "bob", 3); !errors.Is(err, ErrCodesNotAllowed) {
+		t.Errorf("IssueCodes() for another user error = %v, want ErrCodesNotAllowed", err)
+	}
+	// A code is worth the emergency role, so nobody without users.manage
+	// may issue one, even for themselves.
+	if _, err := b.IssueCodes(ctx, principal("carol"), "carol", 3); !errors.Is(err, ErrCodesNotAllowed) {
+		t.Errorf("IssueCodes() by read_only user for self error = %v, want ErrCodesNotAllowed", err)
+	}
+	if n, _ := store.UnusedRecoveryCodes(ctx, "carol"); n != 0 {
+		t.Errorf("UnusedRecoveryCodes(carol) = %d, want 0", n)
+	}
+	codes, err := b.IssueCodes(ctx, bob, "alice", 3)
+	if err != nil || len(codes) != 3 {
+		t.Fatalf("IssueCodes() = %v, %v", codes, err)
+	}
+
+	if _, err := b.Activate(ctx, alice, codes[0], " "); !errors.Is(err, ErrBreakGlassReason) {
+		t.Errorf("Activate() without reason error = %v, want ErrBreakGlassReason", err)
+	}
+	if _, err := b.Activate(ctx, alice, "AAAA-BBBB-CCCC", "db down"); !errors.Is(err, users.ErrRecoveryCodeInvalid) {
+		t.Errorf("Activate() with wrong code error = %v, want ErrRecoveryCodeInvalid", err)
+	}
+
+	// Codes are accepted as typed, in any case and without dashes.
+	g, err := b.Activate(ctx, alice, strings.ToLower(strings.ReplaceAll(codes[0], "-", "")), "db down")
+	if err != nil {
+		t.Fatalf("Activate() error = %v", err)
+	}
+	if g.Role != "admin" || g.DecidedBy != BreakGlassApprover || !g.ExpiresAt.Equal(now.Add(15*time.Minute)) {
+		t.Errorf("break-glass grant = %+v", g)
+	}
+	if !alice.HasRole("admin") || !alice.InBreakGlass(now) || alice.InBreakGlass(g.ExpiresAt) {
+		t.Errorf("HasRole(admin) = %v, InBreakGlass = %v", alice.HasRole("admin"), alice.InBreakGlass(now))
+	}
+	if err := RequireTaskYubiKey(alice, config.Task{ID: "drop", RequireYubiKey: true}); err != nil {
+		t.Errorf("RequireTaskYubiKey() during break-glass error = %v, want nil", err)
+	}
+	// Another session of alice's does not pick up the emergency role.
+	other := principal("alice")
+	e := NewElevator(cfg, store, logger)
+	e.now = b.now
+	if err := e.LoadGrants(ctx, other); err != nil {
+		t.Fatalf("LoadGrants() error = %v", err)
+	}
+	if other.HasRole("admin") {
+		t.Errorf("LoadGrants() in another session Grants = %+v, want no break-glass grant", other.Grants)
+	}
+
+	if _, err := b.Activate(ctx, alice, codes[0], "again"); !errors.Is(err, users.ErrRecoveryCodeInvalid) {
+		t.Errorf("Activate() with used code error = %v, want ErrRecoveryCodeInvalid", err)
+	}
+	if n, _ := store.UnusedRecoveryCodes(ctx, "alice"); n != 2 {
+		t.Errorf("UnusedRecoveryCodes() = %d, want 2", n)
+	}
+
+	// Reissuing invalidates the remaining codes.
+	fresh, err := b.IssueCodes(ctx, bob, "alice", 3)
+	if err != nil {
+		t.Fatalf("IssueCodes() error = %v", err)
+	}
+	if _, err := b.Activate(ctx, alice, codes[1], "db down"); !errors.Is(err, users.ErrRecoveryCodeInvalid) {
+		t.Errorf("Activate() with replaced code error = %v, want ErrRecoveryCodeInvalid", err)
+	}
+
+	// The used and the replaced code count toward auth.lockout; a third
+	// wrong code locks alice out, and then even a good code is refused.
+	if _, err := b.Activate(ctx, alice, "AAAA-BBBB-CCCC", "db down"); !errors.Is(err, users.ErrLockedOut) {
+		t.Errorf("Activate() with third wrong code error = %v, want ErrLockedOut", err)
+	}
+	if _, err := b.Activate(ctx, alice, fresh[0], "db down"); !errors.Is(err, users.ErrLockedOut) {
+		t.Errorf("Activate() while locked out error = %v, want ErrLockedOut", err)
+	}
+	if n, _ := store.UnusedRecoveryCodes(ctx, "alice"); n != 3 {
+		t.Errorf("UnusedRecoveryCodes() after lockout = %d, want 3", n)
+	}
+
+	// The mark ends with the window.
+	if err := logger.Log(ctx, logging.AuditEntry{Time: g.ExpiresAt, UserID: "alice", OperationID: "session:lock break-glass"}); err != nil {
+		t.Fatalf("Log() error = %v", err)
+	}
+
+	rows, err := logging.ReadRecent(logger, 50)
+	if err != nil {
+		t.Fatalf("ReadRecent() error = %v", err)
+	}
+	var started, notified, denied, ended bool
+	for _, r := range rows {
+		switch {
+		case r.OperationID == "session:lock break-glass":
+			ended = r.Mark == ""
+		case strings.HasPrefix(r.OperationID, "break-glass:start #"):
+			started = r.Success && r.Mark == BreakGlassMark
+		case r.OperationID == "break-glass:notify break-glass:start":
+			notified = !r.Success && strings.Contains(r.Error, "LAZYADMIN_TEST_UNSET_URL")
+		case strings.HasPrefix(r.OperationID, "break-glass:denied") && !r.Success:
+			denied = true
+		}
+	}
+	if !started || !notified || !denied || !ended {
+		t.Errorf("audit log: start marked = %v, notify failure = %v, denial = %v, unmarked after window = %v", started, notified, denied, ended)
+	}
+}
@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/you/lazyadmin/internal/config"
+	"github.com/you/lazyadmin/internal/logging"
+	"github.com/you/lazyadmin/internal/users"
+)
+
+var (
+	ErrElevationNotAllowed = errors.New("role is not available for elevation")
+	ErrElevationIneligible = errors.New("not eligible to request this role")
+	ErrElevationDuration   = errors.New("invalid elevation duration")
+	ErrElevationReason     = errors.New("elevation requires a reason")
+	ErrElevationHeld       = errors.New("role already held")
+	ErrNotApprover         = errors.New("elevation.approve permission and the requested role are required")
+	ErrSelfApproval        = errors.New("cannot decide on own elevation request")
+)
+
+// AutoApprover is recorded as the approver of grants for auto_approve roles.
+const AutoApprover = "auto"
+
+// expirySSHUser is recorded as the SSH user of audit entries for expired
+// grants, which no one acted on.
+const expirySSHUser = "system"
+
+// Elevator runs the just-in-time elevation flow: users request a role from
+// the elevation: section for a limited time, an approver (or the role's
+// auto_approve policy) grants it, and the grant lapses at its expiry. Every
+// step is audited.
+type Elevator struct {
+	cfg    *config.Config
+	store  *users.Store
+	logger *logging.AuditLogger
+	now    func() time.Time
+}
+
+// NewElevator returns an Elevator storing grants in store. logger may be nil.
+func NewElevator(cfg *config.Config, store *users.Store, logger *logging.AuditLogger) *Elevator {
+	return &Elevator{cfg: cfg, store: store, logger: logger, now: time.Now}
+}
+
+// LoadGrants sets p.Grants to the user's grants in force now. Break-glass
+// grants are left out: they belong to the session that redeemed the code,
+// whose audit entries carry BreakGlassMark, and must not reach other sessions
+// of the same user unmarked.
+func (e *Elevator) LoadGrants(ctx context.Context, p *Principal) error {
+	grants, err := e.store.ActiveGrants(ctx, p.ConfigUser.ID, e.now())
+	if err != nil {
+		return err
+	}
+	p.Grants = slices.DeleteFunc(grants, func(g users.Grant) bool {
+		return g.DecidedBy == BreakGlassApprover
+	})
+	return nil
+}
+
+// Request asks for role for duration d. Roles with auto_approve are granted
+// at once and added to p.Grants; others stay pending until approved.
+func (e *Elevator) Request(ctx context.Context, p *Principal, role string, d time.Duration, reason string) (*users.Grant, error) {
+	policy, ok := e.cfg.FindElevationRole(role)
+	var err error
+	switch {
+	case !ok:
+		err = fmt.Errorf("%w: %q", ErrElevationNotAllowed, role)
+	case len(policy.Eligible) > 0 && !p.HasAnyRole(policy.Eligible):
+		err = fmt.Errorf("%w: requires one of %v", ErrElevationIneligible, policy.Eligible)
+	case d <= 0 || d > policy.MaxDurationOrDefault():
+		err = fmt.Errorf("%w: %s (max %s)", ErrElevationDuration, d, policy.MaxDurationOrDefault())
+	case strings.TrimSpace(reason) == "":
+		err = ErrElevationReason
+	case p.HasRole(role):
+		err = fmt.Errorf("%w: %s", ErrElevationHeld, role)
+	}
+	if err != nil {
+		e.log(p, fmt.Sprintf("elevation:request role:%s for:%s reason:%q", role, d, reason), err)
+		return nil, err
+	}
+
+	g := &users.Grant{UserID: p.ConfigUser.ID, Role: role, Reason: reason, Duration: d, RequestedAt: e.now()}
+	if err := e.store.CreateGrant(ctx, g); err != nil {
+		return nil, err
+	}
+	e.log(p, fmt.Sprintf("elevation:request #%d role:%s for:%s reason:%q", g.ID, role, d, reason), nil)
+
+	if !policy.AutoApprove {
+		return g, nil
+	}
+	g, err = e.store.ApproveGrant(ctx, g.ID, AutoApprover, e.now())
+	if err != nil {
+		return nil, err
+	}
+	e.log(p, grantOpID("grant", g)+" approver:"+AutoApprover, nil)
+	p.Grants = append(p.Grants, *g)
+	return g, nil
+}
+
+// Approve activates a pending grant. The approver needs elevation.approve,
+// must hold the requested role, and cannot approve their own request.
+func (e *Elevator) Approve(ctx context.Context, p *Principal, id int64) (*users.Grant, error) {
+	return e.decide(ctx, p, id, "grant", e.store.ApproveGrant)
+}
+
+// Deny rejects a pending grant, with the same checks as Approve.
+func (e *Elevator) Deny(ctx context.Context, p *Principal, id int64) (*users.Grant, error) {
+	return e.decide(ctx, p, id, "deny", e.store.DenyGrant)
+}
+
+func (e *Elevator) decide(ctx context.Context, p *Principal, id int64, action string,
+	apply func(context.Context, int64, string, time.Time) (*users.Grant, error)) (*users.Grant, error) {
+	g, err := e.store.GetGrant(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case g.UserID == p.ConfigUser.ID:
+		err = ErrSelfApproval
+	case !p.HasPermission(config.PermElevationApprove) || !p.HasRole(g.Role):
+		err = ErrNotApprover
+	}
+	if err == nil {
+		var decided *users.Grant
+		if decided, err = apply(ctx, id, p.ConfigUser.ID, e.now()); err == nil {
+			g = decided
+		}
+	}
+	e.log(p, grantOpID(action, g), err)
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// Revoke ends an active grant early. The grantee may revoke their own grant;
+// anyone else needs elevation.approve.
+func (e *Elevator) Revoke(ctx context.Context, p *Principal, id int64) (*users.Grant, error) {
+	g, err := e.store.GetGrant(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if g.UserID != p.ConfigUser.ID && !p.HasPermission(config.PermElevationApprove) {
+		err = ErrNotApprover
+	}
+	if err == nil {
+		var revoked *users.Grant
+		if revoked, err = e.store.RevokeGrant(ctx, id, p.ConfigUser.ID, e.now()); err == nil {
+			g = revoked
+		}
+	}
+	e.log(p, grantOpID("revoke", g), err)
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// Expire marks grants past their expiry as expired and audits each one,
+// dated at its expiry. Grants stop conferring their role at expiry whether
+// or not Expire has run; this only records it.
+func (e *Elevator) Expire(ctx context.Context) error {
+	expired, err := e.store.ExpireGrants(ctx, e.now())
+	for _, g := range expired {
+		if e.logger == nil {
+			break
+		}
+		_ = e.logger.Log(ctx, logging.AuditEntry{
+			Time:        g.ExpiresAt,
+			UserID:      g.UserID,
+			SSHUser:     expirySSHUser,
+			OperationID: grantOpID("expire", g),
+			Success:     true,
+		})
+	}
+	return err
+}
+
+func grantOpID(action string, g *users.Grant) string {
+	s := fmt.Sprintf("elevation:%s #%d role:%s user:%s", action, g.ID, g.Role, g.UserID)
+	if action == "grant" && !g.ExpiresAt.IsZero() {
+		s += " until:" + g.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	return s
+}
+
+func (e *Elevator) log(p *Principal, opID string, err error) {
+	if e.logger == nil {
+		return
+	}
+
+	entry := logging.AuditEntry{
+		Time:        e.now(),
+		UserID:      p.ConfigUser.ID,
+		SSHUser:     p.SSHUser,
+		OperationID: opID,
+		Success:     err == nil,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	_ = e.logger.Log(context.Background(), entry)
+}
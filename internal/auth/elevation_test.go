@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/you/lazyadmin/internal/config"
+	"github.com/you/lazyadmin/internal/logging"
+	"github.com/you/lazyadmin/internal/users"
+)
+
+func TestElevator(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "lazyadmin.db")
+	store, err := users.NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("users.NewStore() error = %v", err)
+	}
+	defer store.Close()
+	logger, err := logging.NewAuditLogger(dbPath)
+	if err != nil {
+		t.Fatalf("NewAuditLogger() error = %v", err)
+	}
+	defer logger.Close()
+
+	cfg := &config.Config{
+		Users: []config.User{
+			{ID: "alice", SSHUsers: []string{"alice"}, Roles: []string{"read_only"}},
+			{ID: "bob", SSHUsers: []string{"bob"}, Roles: []string{"owner"}},
+			{ID: "carol", SSHUsers: []string{"carol"}, Roles: []string{"admin"}},
+		},
+		Elevation: config.ElevationConfig{Roles: []config.ElevationRole{
+			{Role: "owner", MaxDuration: time.Hour, Eligible: []string{"read_only"}},
+			{Role: "operator", AutoApprove: true},
+		}},
+	}
+	principal := func(id string) *Principal {
+		u, _ := cfg.FindUser(id)
+		return &Principal{ConfigUser: u, SSHUser: id, RBAC: NewRBAC(cfg)}
+	}
+
+	ctx := context.Background()
+	now := time.Now()
+	e := NewElevator(cfg, store, logger)
+	e.now = func() time.Time { return now }
+	alice, bob, carol := principal("alice"), principal("bob"), principal("carol")
+
+	// Policy checks.
+	for _, tc := range []struct {
+		role    string
+		d       time.Duration
+		reason  string
+		wantErr error
+	}{
+		{"admin", time.Hour, "incident", ErrElevationNotAllowed},
+		{"owner", 2 * time.Hour, "incident", ErrElevationDuration},
+		{"owner", time.Hour, " ", ErrElevationReason},
+		{"read_only", time.Hour, "incident", ErrElevationNotAllowed},
+	} {
+		if _, err := e.Request(ctx, alice, tc.role, tc.d, tc.reason); !errors.Is(err, tc.wantErr) {
+			t.Errorf("Request(%s, %s, %q) error = %v, want %v", tc.role, tc.d, tc.reason, err, tc.wantErr)
+		}
+	}
+	if _, err := e.Request(ctx, bob, "operator", time.Hour, "x"); !errors.Is(err, ErrElevationHeld) {
+		t.Errorf("Request() for inherited role error = %v, want ErrElevationHeld", err)
+	}
+
+	// Auto-approved roles are granted at once.
+	g, err := e.Request(ctx, alice, "operator", 30*time.Minute, "deploy")
+	if err != nil {
+		t.Fatalf("Request(operator) error = %v", err)
+	}
+	if g.Status != users.GrantActive || g.DecidedBy != AutoApprover || !alice.HasRole("operator") {
+		t.Errorf("auto-approved grant = %+v, HasRole(operator) = %v", g, alice.HasRole("operator"))
+	}
+
+	// Others wait for an approver holding elevation.approve and the role.
+	g, err = e.Request(ctx, alice, "owner", time.Hour, "incident 42")
+	if err != nil {
+		t.Fatalf("Request(owner) error = %v", err)
+	}
+	if g.Status != users.GrantPending || alice.HasRole("owner") {
+		t.Fatalf("pending grant = %+v, HasRole(owner) = %v", g, alice.HasRole("owner"))
+	}
+	if _, err := e.Approve(ctx, alice, g.ID); !errors.Is(err, ErrSelfApproval) {
+		t.Errorf("self Approve() error = %v, want ErrSelfApproval", err)
+	}
+	if _, err := e.Approve(ctx, carol, g.ID); !errors.Is(err, ErrNotApprover) {
+		t.Errorf("Approve() by admin error = %v, want ErrNotApprover", err)
+	}
+	g, err = e.Approve(ctx, bob, g.ID)
+	if err != nil {
+		t.Fatalf("Approve() error = %v", err)
+	}
+	if !g.ExpiresAt.Equal(now.Add(time.Hour)) || g.DecidedBy != "bob" {
+		t.Errorf("approved grant = %+v", g)
+	}
+	if _, err := e.Approve(ctx, bob, g.ID); !errors.Is(err, users.ErrGrantState) {
+		t.Errorf("second Approve() error = %v, want ErrGrantState", err)
+	}
+
+	if err := e.LoadGrants(ctx, alice); err != nil {
+		t.Fatalf("LoadGrants() error = %v", err)
+	}
+	if !alice.HasRole("owner") || !alice.HasPermission(config.PermUsersManage) {
+		t.Errorf("after approval HasRole(owner) = false")
+	}
+
+	// The operator grant is revoked by its holder; owner lapses at expiry.
+	if _, err := e.Revoke(ctx, carol, alice.Grants[0].ID); !errors.Is(err, ErrNotApprover) {
+		t.Errorf("Revoke() by admin error = %v, want ErrNotApprover", err)
+	}
+	if _, err := e.Revoke(ctx, alice, alice.Grants[0].ID); err != nil {
+		t.Errorf("Revoke() by grantee error = %v", err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if err := e.Expire(ctx); err != nil {
+		t.Fatalf("Expire() error = %v", err)
+	}
+	if err := e.LoadGrants(ctx, alice); err != nil {
+		t.Fatalf("LoadGrants() error = %v", err)
+	}
+	if len(alice.Grants) != 0 || alice.HasRole("owner") {
+		t.Errorf("after expiry Grants = %+v", alice.Grants)
+	}
+	if got, _ := store.GetGrant(ctx, g.ID); got.Status != users.GrantExpired {
+		t.Errorf("grant status = %s, want expired", got.Status)
+	}
+
+	rows, err := logging.ReadRecent(logger, 50)
+	if err != nil {
+		t.Fatalf("ReadRecent() error = %v", err)
+	}
+	want := map[string]bool{
+		"elevation:request #2":            false,
+		"elevation:grant #1":              false,
+		"elevation:grant #2":              false,
+		"elevation:revoke #1":             false,
+		"elevation:expire #2 role:owner ": false,
+	}
+	for _, r := range rows {
+		for prefix := range want {
+			if strings.HasPrefix(r.OperationID, prefix) && r.Success {
+				want[prefix] = true
+			}
+		}
+	}
+	for prefix, found := range want {
+		if !found {
+			t.Errorf("audit log has no successful %q entry", prefix)
+		}
+	}
+}
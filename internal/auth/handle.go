@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+)
+
+// userHandleSize is the length of generated WebAuthn user handles in bytes.
+const userHandleSize = 16
+
+// UserHandle decodes a base64url WebAuthn user handle, or generates a random
+// one when handle is empty, and returns both forms. All credentials of a
+// user should be registered with the same handle.
+func UserHandle(handle string) ([]byte, string, error) {
+	if handle != "" {
+		b, err := base64.RawURLEncoding.DecodeString(handle)
+		if err != nil {
+			return nil, "", fmt.Errorf("decode user handle: %w", err)
+		}
+		return b, handle, nil
+	}
+
+	b := make([]byte, userHandleSize)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", fmt.Errorf("generate user handle: %w", err)
+	}
+	return b, base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/you/lazyadmin/internal/config"
+	"github.com/you/lazyadmin/internal/logging"
+	"github.com/you/lazyadmin/internal/users"
+)
+
+// OpSignIn is the audit operation of sign-in attempts.
+const OpSignIn = "auth:login"
+
+// Lockout audits sign-in attempts and, with auth.lockout set, refuses
+// users after consecutive failed FIDO2 assertions until the lockout
+// expires or an administrator unlocks them. Failures are counted per user
+// ID in the user store, for config and store users alike.
+type Lockout struct {
+	cfg    *config.Config
+	store  *users.Store
+	logger *logging.AuditLogger
+	now    func() time.Time
+}
+
+// NewLockout returns a Lockout counting failures in store. logger may be
+// nil.
+func NewLockout(cfg *config.Config, store *users.Store, logger *logging.AuditLogger) *Lockout {
+	return &Lockout{cfg: cfg, store: store, logger: logger, now: time.Now}
+}
+
+// Check refuses p while their user is locked out, auditing the attempt
+// under opID. Refused attempts do not extend the lockout.
+func (l *Lockout) Check(ctx context.Context, p *Principal, opID string) error {
+	if l.store == nil {
+		return nil
+	}
+	f, err := l.store.GetAuthFailures(ctx, p.ConfigUser.ID)
+	if err != nil {
+		return err
+	}
+	if !f.Locked(l.now()) {
+		return nil
+	}
+	err = fmt.Errorf("%w: %s until %s", users.ErrLockedOut, p.ConfigUser.ID, f.LockedUntil.Local().Format(time.DateTime))
+	l.log(p.ConfigUser.ID, p.SSHUser, opID, err)
+	return err
+}
+
+// Record audits a sign-in attempt by p under opID. A failure counts toward
+// auth.lockout and may lock the user out, which is audited as well; a
+// success resets the count. It returns err, wrapped with ErrLockedOut if
+// this failure locked the user.
+func (l *Lockout) Record(ctx context.Context, p *Principal, opID string, err error) error {
+	l.log(p.ConfigUser.ID, p.SSHUser, opID, err)
+	if l.store == nil {
+		return err
+	}
+	if err == nil {
+		if _, rerr := l.store.ResetAuthFailures(ctx, p.ConfigUser.ID); rerr != nil {
+			l.log(p.ConfigUser.ID, p.SSHUser, "auth:reset-failures", rerr)
+		}
+		return nil
+	}
+
+	lc := l.cfg.Auth.Lockout
+	f, ferr := l.store.RecordAuthFailure(ctx, p.ConfigUser.ID, l.now(), lc.MaxFailures, lc.DurationOrDefault())
+	if ferr != nil {
+		return errors.Join(err, ferr)
+	}
+	if !f.Locked(l.now()) {
+		return err
+	}
+	l.log(p.ConfigUser.ID, p.SSHUser, fmt.Sprintf("auth:lockout user:%s failures:%d until:%s",
+		p.ConfigUser.ID, f.Failures, f.LockedUntil.UTC().Format(time.RFC3339)), nil)
+	return fmt.Errorf("%w (%w until %s)", err, users.ErrLockedOut, f.LockedUntil.Local().Format(time.DateTime))
+}
+
+// Unresolved audits a sign-in attempt by sshUser that matched no user, or
+// a user that may not sign in. There is no user ID to count it against.
+func (l *Lockout) Unresolved(sshUser string, err error) {
+	l.log("", sshUser, OpSignIn, err)
+}
+
+func (l *Lockout) log(userID, sshUser, opID string, err error) {
+	if l.logger == nil {
+		return
+	}
+
+	entry := logging.AuditEntry{
+		Time:        l.now(),
+		UserID:      userID,
+		SSHUser:     sshUser,
+		OperationID: opID,
+		Success:     err == nil,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	_ = l.logger.Log(context.Background(), entry)
+}
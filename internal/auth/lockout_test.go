@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/you/lazyadmin/internal/config"
+	"github.com/you/lazyadmin/internal/logging"
+	"github.com/you/lazyadmin/internal/users"
+)
+
+func TestLockout(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "lazyadmin.db")
+	store, err := users.NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("users.NewStore() error = %v", err)
+	}
+	defer store.Close()
+	logger, err := logging.NewAuditLogger(dbPath)
+	if err != nil {
+		t.Fatalf("NewAuditLogger() error = %v", err)
+	}
+	defer logger.Close()
+
+	cfg := &config.Config{
+		Auth:  config.AuthConfig{Lockout: config.LockoutConfig{MaxFailures: 3, Duration: 10 * time.Minute}},
+		Users: []config.User{{ID: "alice", SSHUsers: []string{"alice"}, Roles: []string{"operator"}}},
+	}
+	u, _ := cfg.FindUser("alice")
+	alice := &Principal{ConfigUser: u, SSHUser: "alice", RBAC: NewRBAC(cfg)}
+
+	ctx := context.Background()
+	now := time.Now()
+	l := NewLockout(cfg, store, logger)
+	l.now = func() time.Time { return now }
+	errAssertion := errors.New("assertion failed")
+
+	// A success resets the run of failures.
+	for range 2 {
+		if err := l.Record(ctx, alice, OpSignIn, errAssertion); !errors.Is(err, errAssertion) || errors.Is(err, users.ErrLockedOut) {
+			t.Fatalf("Record() error = %v, want only the assertion error", err)
+		}
+	}
+	if err := l.Record(ctx, alice, OpSignIn, nil); err != nil {
+		t.Fatalf("Record(success) error = %v", err)
+	}
+	if f, _ := store.GetAuthFailures(ctx, "alice"); f.Failures != 0 {
+		t.Errorf("failures after success = %d, want 0", f.Failures)
+	}
+
+	for i := range 3 {
+		err := l.Record(ctx, alice, OpSignIn, errAssertion)
+		if locked := errors.Is(err, users.ErrLockedOut); locked != (i == 2) || !errors.Is(err, errAssertion) {
+			t.Fatalf("Record() failure %d error = %v", i+1, err)
+		}
+	}
+	if err := l.Check(ctx, alice, OpSignIn); !errors.Is(err, users.ErrLockedOut) {
+		t.Errorf("Check() while locked error = %v, want ErrLockedOut", err)
+	}
+
+	now = now.Add(11 * time.Minute)
+	if err := l.Check(ctx, alice, OpSignIn); err != nil {
+		t.Errorf("Check() after lockout expired error = %v", err)
+	}
+	// The count is not reset by expiry: the next failure locks again.
+	if err := l.Record(ctx, alice, OpSignIn, errAssertion); !errors.Is(err, users.ErrLockedOut) {
+		t.Errorf("Record() after expiry error = %v, want ErrLockedOut", err)
+	}
+	if cleared, err := store.ResetAuthFailures(ctx, "alice"); !cleared || err != nil {
+		t.Errorf("ResetAuthFailures() = %v, %v", cleared, err)
+	}
+	if err := l.Check(ctx, alice, OpSignIn); err != nil {
+		t.Errorf("Check() after unlock error = %v", err)
+	}
+
+	l.Unresolved("mallory", ErrNoMatchingUser)
+
+	rows, err := logging.ReadRecent(logger, 50)
+	if err != nil {
+		t.Fatalf("ReadRecent() error = %v", err)
+	}
+	var lockouts, refused, unknown int
+	for _, r := range rows {
+		switch {
+		case strings.HasPrefix(r.OperationID, "auth:lockout user:alice"):
+			lockouts++
+		case r.OperationID == OpSignIn && strings.Contains(r.Error, users.ErrLockedOut.Error()) && !strings.Contains(r.Error, errAssertion.Error()):
+			refused++
+		case r.OperationID == OpSignIn && r.SSHUser == "mallory" && r.UserID == "" && !r.Success:
+			unknown++
+		}
+	}
+	if lockouts != 2 || refused != 1 || unknown != 1 {
+		t.Errorf("audit log: %d lockouts, %d refused, %d unknown; want 2, 1, 1", lockouts, refused, unknown)
+	}
+}
+
+func TestLockout_Disabled(t *testing.T) {
+	store, err := users.NewStore(filepath.Join(t.TempDir(), "lazyadmin.db"))
+	if err != nil {
+		t.Fatalf("users.NewStore() error = %v", err)
+	}
+	defer store.Close()
+
+	cfg := &config.Config{Users: []config.User{{ID: "alice", SSHUsers: []string{"alice"}}}}
+	u, _ := cfg.FindUser("alice")
+	alice := &Principal{ConfigUser: u, SSHUser: "alice"}
+
+	ctx := context.Background()
+	l := NewLockout(cfg, store, nil)
+	for range 10 {
+		if err := l.Record(ctx, alice, OpSignIn, errors.New("assertion failed")); errors.Is(err, users.ErrLockedOut) {
+			t.Fatalf("Record() without auth.lockout error = %v", err)
+		}
+	}
+	if f, _ := store.GetAuthFailures(ctx, "alice"); f.Failures != 10 {
+		t.Errorf("failures = %d, want 10 counted without lockout", f.Failures)
+	}
+}
@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/you/lazyadmin/internal/config"
+)
+
+// RBAC resolves role inheritance and permissions from the roles: section of
+// the config (or config.DefaultRoles), and the tag rules from access:.
+type RBAC struct {
+	roles map[string]config.Role
+	rules []config.AccessRule
+}
+
+// NewRBAC builds the role hierarchy and access rules for cfg.
+func NewRBAC(cfg *config.Config) *RBAC {
+	return newRBAC(cfg.EffectiveRoles(), cfg.Access)
+}
+
+func newRBAC(roles []config.Role, rules []config.AccessRule) *RBAC {
+	r := &RBAC{roles: make(map[string]config.Role, len(roles)), rules: rules}
+	for _, role := range roles {
+		r.roles[role.Name] = role
+	}
+	return r
+}
+
+var defaultRBAC = newRBAC(config.DefaultRoles(), nil)
+
+// expand returns the given roles plus every role they inherit, and the union
+// of their permissions. Roles not defined in the hierarchy stand for
+// themselves and grant no permissions.
+func (r *RBAC) expand(roles []string) (map[string]bool, map[string]bool) {
+	held := make(map[string]bool)
+	perms := make(map[string]bool)
+
+	var visit func(name string)
+	visit = func(name string) {
+		if held[name] {
+			return
+		}
+		held[name] = true
+		role, ok := r.roles[name]
+		if !ok {
+			return
+		}
+		for _, p := range role.Permissions {
+			perms[p] = true
+		}
+		for _, parent := range role.Inherits {
+			visit(parent)
+		}
+	}
+	for _, name := range roles {
+		visit(name)
+	}
+	return held, perms
+}
+
+func (p *Principal) rbac() *RBAC {
+	if p.RBAC != nil {
+		return p.RBAC
+	}
+	return defaultRBAC
+}
+
+// directRoles returns the roles assigned to the user in config or the store,
+// plus roles from elevation grants still in force. A store user's roles are
+// taken from ConfigUser, which holds them as the environment overrides them.
+func (p *Principal) directRoles() []string {
+	var roles []string
+	switch {
+	case p.ConfigUser != nil:
+		roles = append(roles, p.ConfigUser.Roles...)
+	case p.DBUser != nil:
+		roles = append(roles, p.DBUser.Roles...)
+	}
+	now := time.Now()
+	for _, g := range p.Grants {
+		if g.InForce(now) {
+			roles = append(roles, g.Role)
+		}
+	}
+	return roles
+}
+
+// HasRole reports whether the principal holds role, directly or through
+// inheritance.
+func (p *Principal) HasRole(role string) bool {
+	held, _ := p.rbac().expand(p.directRoles())
+	return held[role]
+}
+
+func (p *Principal) HasAnyRole(roles []string) bool {
+	if len(roles) == 0 {
+		return false
+	}
+	held, _ := p.rbac().expand(p.directRoles())
+	return containsAny(held, roles)
+}
+
+// HasPermission reports whether any of the principal's roles grants perm.
+// "*" grants everything and ops.run:* grants ops.run for every tag.
+func (p *Principal) HasPermission(perm string) bool {
+	_, perms := p.rbac().expand(p.directRoles())
+	return hasPermission(perms, perm)
+}
+
+func hasPermission(perms map[string]bool, perm string) bool {
+	if perms[config.PermAll] || perms[perm] {
+		return true
+	}
+	return strings.HasPrefix(perm, config.PermOpsRunPrefix) && perms[config.PermOpsRunPrefix+"*"]
+}
+
+// CanRun reports whether the principal may run an operation or task with the
+// given allowed_roles, permissions and tags. Any one of these admits it: a
+// matching role, all of the listed permissions, ops.run:<tag> for one of its
+// tags, or an access rule allowing one of the principal's roles one of its
+// tags.
+func (p *Principal) CanRun(allowedRoles, permissions, tags []string) bool {
+	rbac := p.rbac()
+	held, perms := rbac.expand(p.directRoles())
+
+	if perms[config.PermAll] {
+		return true
+	}
+	if containsAny(held, allowedRoles) {
+		return true
+	}
+	if len(permissions) > 0 {
+		all := true
+		for _, perm := range permissions {
+			if !hasPermission(perms, perm) {
+				all = false
+				break
+			}
+		}
+		if all {
+			return true
+		}
+	}
+	for _, tag := range tags {
+		if hasPermission(perms, config.PermOpsRunPrefix+tag) {
+			return true
+		}
+	}
+	for _, rule := range rbac.rules {
+		if containsAny(held, rule.Allow.Roles) && slices.ContainsFunc(tags, func(tag string) bool {
+			return slices.Contains(rule.Allow.Tags, tag)
+		}) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(set map[string]bool, names []string) bool {
+	for _, name := range names {
+		if set[name] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/you/lazyadmin/internal/config"
+)
+
+func TestPrincipal_DefaultHierarchy(t *testing.T) {
+	principal := func(role string) *Principal {
+		return &Principal{ConfigUser: &config.User{ID: role, Roles: []string{role}}}
+	}
+
+	tests := []struct {
+		name string
+		p    *Principal
+		role string
+		perm string
+		want bool
+	}{
+		{"owner inherits admin", principal("owner"), "admin", "", true},
+		{"admin inherits read_only", principal("admin"), "read_only", "", true},
+		{"operator does not inherit admin", principal("operator"), "admin", "", false},
+		{"read_only reads audit", principal("read_only"), "", config.PermAuditRead, true},
+		{"operator reads audit", principal("operator"), "", config.PermAuditRead, true},
+		{"operator cannot manage users", principal("operator"), "", config.PermUsersManage, false},
+		{"admin manages users", principal("admin"), "", config.PermUsersManage, true},
+		{"owner has every permission", principal("owner"), "", "ops.run:billing", true},
+		{"unknown role grants nothing", principal("guest"), "", config.PermAuditRead, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got bool
+			if tt.role != "" {
+				got = tt.p.HasRole(tt.role)
+			} else {
+				got = tt.p.HasPermission(tt.perm)
+			}
+			if got != tt.want {
+				t.Errorf("role=%q perm=%q: got %v, want %v", tt.role, tt.perm, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrincipal_CanRun(t *testing.T) {
+	cfg := &config.Config{
+		Roles: []config.Role{
+			{Name: "viewer", Permissions: []string{config.PermAuditRead}},
+			{Name: "billing", Inherits: []string{"viewer"}, Permissions: []string{"ops.run:billing"}},
+			{Name: "sre", Inherits: []string{"viewer"}, Permissions: []string{"ops.run:*", config.PermLocksForceUnlock}},
+			{Name: "root", Permissions: []string{config.PermAll}},
+		},
+		Access: []config.AccessRule{
+			{Allow: config.AccessGrant{Roles: []string{"viewer"}, Tags: []string{"billing-read"}}},
+		},
+	}
+	principal := func(role string) *Principal {
+		return &Principal{ConfigUser: &config.User{ID: role, Roles: []string{role}}, RBAC: NewRBAC(cfg)}
+	}
+
+	tests := []struct {
+		name         string
+		role         string
+		allowedRoles []string
+		permissions  []string
+		tags         []string
+		want         bool
+	}{
+		{"inherited role", "billing", []string{"viewer"}, nil, nil, true},
+		{"no matching role", "viewer", []string{"billing"}, nil, nil, false},
+		{"tag permission", "billing", nil, nil, []string{"billing"}, true},
+		{"other tag", "billing", nil, nil, []string{"db"}, false},
+		{"wildcard tag permission", "sre", nil, nil, []string{"db"}, true},
+		{"all permissions held", "sre", nil, []string{config.PermAuditRead, config.PermLocksForceUnlock}, nil, true},
+		{"one permission missing", "billing", nil, []string{config.PermAuditRead, config.PermLocksForceUnlock}, nil, false},
+		{"access rule", "viewer", nil, nil, []string{"billing-read"}, true},
+		{"access rule through inheritance", "billing", nil, nil, []string{"openapi", "billing-read"}, true},
+		{"access rule other tag", "viewer", nil, nil, []string{"billing-write"}, false},
+		{"star allows untagged", "root", nil, nil, nil, true},
+		{"nothing listed", "sre", nil, nil, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := principal(tt.role).CanRun(tt.allowedRoles, tt.permissions, tt.tags)
+			if got != tt.want {
+				t.Errorf("CanRun(%v, %v, %v) = %v, want %v", tt.allowedRoles, tt.permissions, tt.tags, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/you/lazyadmin/internal/config"
+)
+
+// LockReason says why an interactive session was locked.
+type LockReason string
+
+const (
+	LockIdle   LockReason = "idle"
+	LockMaxAge LockReason = "max-age"
+	// LockBreakGlass locks a break-glass session when its emergency grant
+	// expires; continuing needs FIDO2 as usual.
+	LockBreakGlass LockReason = "break-glass"
+)
+
+// Session tracks an interactive session's activity against
+// auth.session_idle_timeout and auth.session_max_age. It is a value type so
+// the TUI model can carry it.
+type Session struct {
+	IdleTimeout time.Duration
+	MaxAge      time.Duration
+	// BreakGlassUntil is the end of the emergency window of a break-glass
+	// session. While set, idle and max-age locks are suspended, since the
+	// user cannot re-authenticate.
+	BreakGlassUntil time.Time
+
+	lastActivity    time.Time
+	authenticatedAt time.Time
+}
+
+// NewSession starts a session that authenticated at now.
+func NewSession(cfg *config.Config, now time.Time) Session {
+	return Session{
+		IdleTimeout:     cfg.Auth.SessionIdleTimeout,
+		MaxAge:          cfg.Auth.SessionMaxAge,
+		lastActivity:    now,
+		authenticatedAt: now,
+	}
+}
+
+// Enabled reports whether the session can expire at all.
+func (s Session) Enabled() bool {
+	return s.IdleTimeout > 0 || s.MaxAge > 0 || !s.BreakGlassUntil.IsZero()
+}
+
+// Touch records user input at now.
+func (s *Session) Touch(now time.Time) {
+	s.lastActivity = now
+}
+
+// Renew records a fresh authentication at now, which also ends a
+// break-glass window.
+func (s *Session) Renew(now time.Time) {
+	s.lastActivity = now
+	s.authenticatedAt = now
+	s.BreakGlassUntil = time.Time{}
+}
+
+// Expired returns why the session must be locked at now, or "" if it is
+// still valid. Max age takes precedence, since input does not extend it.
+// A break-glass session ends only with its window.
+func (s Session) Expired(now time.Time) LockReason {
+	if !s.BreakGlassUntil.IsZero() {
+		if !now.Before(s.BreakGlassUntil) {
+			return LockBreakGlass
+		}
+		return ""
+	}
+	if s.MaxAge > 0 && now.Sub(s.authenticatedAt) >= s.MaxAge {
+		return LockMaxAge
+	}
+	if s.IdleTimeout > 0 && now.Sub(s.lastActivity) >= s.IdleTimeout {
+		return LockIdle
+	}
+	return ""
+}
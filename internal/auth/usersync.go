@@ -0,0 +1,221 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/you/lazyadmin/internal/config"
+	"github.com/you/lazyadmin/internal/users"
+)
+
+var ErrUserConflict = errors.New("user conflicts with a user of the authoritative source (auth.user_source)")
+
+// Kinds of UserConflict.
+const (
+	ConflictID      = "id"       // the same user ID in both sources
+	ConflictSSHUser = "ssh_user" // an SSH username claimed by users with different IDs
+	ConflictSSHKey  = "ssh_key"  // an SSH key fingerprint claimed by users with different IDs
+)
+
+// UserConflict is an identity claimed by both a config user and a store
+// user. Only the user from the authoritative source (auth.user_source) may
+// sign in; the other is refused.
+type UserConflict struct {
+	Kind       string
+	Value      string // the user ID, SSH username or key fingerprint
+	ConfigUser string
+	StoreUser  string
+}
+
+func (c UserConflict) String() string {
+	switch c.Kind {
+	case ConflictID:
+		return fmt.Sprintf("user %s is defined in config and in the store", c.Value)
+	case ConflictSSHKey:
+		return fmt.Sprintf("ssh key %s belongs to config user %s and store user %s", c.Value, c.ConfigUser, c.StoreUser)
+	default:
+		return fmt.Sprintf("ssh user %s belongs to config user %s and store user %s", c.Value, c.ConfigUser, c.StoreUser)
+	}
+}
+
+// FindUserConflicts compares users[] with the SQLite user store. A store
+// user with the same ID as a config user is reported once, as ConflictID;
+// otherwise each shared SSH username and key is reported.
+func FindUserConflicts(ctx context.Context, cfg *config.Config, userStore *users.Store) ([]UserConflict, error) {
+	if userStore == nil {
+		return nil, nil
+	}
+	dbUsers, err := userStore.ListUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var conflicts []UserConflict
+	for _, su := range dbUsers {
+		keys, err := userStore.GetSSHKeys(ctx, su.ID)
+		if err != nil {
+			return nil, err
+		}
+		for i := range cfg.Users {
+			cu := &cfg.Users[i]
+			if cu.ID == su.ID {
+				conflicts = append(conflicts, UserConflict{Kind: ConflictID, Value: su.ID, ConfigUser: cu.ID, StoreUser: su.ID})
+				continue
+			}
+			for _, s := range su.SSHUsers {
+				if slices.Contains(cu.SSHUsers, s) {
+					conflicts = append(conflicts, UserConflict{Kind: ConflictSSHUser, Value: s, ConfigUser: cu.ID, StoreUser: su.ID})
+				}
+			}
+			for _, k := range keys {
+				if slices.Contains(cu.SSHKeys, k) {
+					conflicts = append(conflicts, UserConflict{Kind: ConflictSSHKey, Value: k, ConfigUser: cu.ID, StoreUser: su.ID})
+				}
+			}
+		}
+	}
+	return conflicts, nil
+}
+
+// resolveInSourceOrder returns the first principal found by fromConfig and
+// fromStore, trying the store first when it is authoritative, or nil if
+// neither matches. A principal from the other source that conflicts with an
+// authoritative user is refused with ErrUserConflict.
+func resolveInSourceOrder(ctx context.Context, cfg *config.Config, userStore *users.Store, fromConfig, fromStore func() (*Principal, error)) (*Principal, error) {
+	lookups := []func() (*Principal, error){fromConfig, fromStore}
+	if cfg.Auth.StoreAuthoritative() {
+		slices.Reverse(lookups)
+	}
+
+	for _, lookup := range lookups {
+		p, err := lookup()
+		if err != nil {
+			return nil, err
+		}
+		if p == nil {
+			continue
+		}
+		if err := checkUserSource(ctx, cfg, userStore, p); err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+	return nil, nil
+}
+
+// checkUserSource refuses p if it comes from the non-authoritative source
+// and conflicts with a user of the other.
+func checkUserSource(ctx context.Context, cfg *config.Config, userStore *users.Store, p *Principal) error {
+	conflicts, err := FindUserConflicts(ctx, cfg, userStore)
+	if err != nil {
+		return err
+	}
+	storeWins := cfg.Auth.StoreAuthoritative()
+	for _, c := range conflicts {
+		if p.DBUser != nil && !storeWins && c.StoreUser == p.DBUser.ID ||
+			p.DBUser == nil && storeWins && c.ConfigUser == p.ConfigUser.ID {
+			return fmt.Errorf("%w: %s", ErrUserConflict, c)
+		}
+	}
+	return nil
+}
+
+// ImportConfigUsers copies config users into the store, with their SSH keys
+// and YubiKey credentials, so the store can become authoritative. Users
+// that conflict with a store user are skipped and returned as conflicts. A
+// user whose keys or credentials cannot be added is removed again, so a
+// later import can retry it. Users are taken from the file's configuration,
+// so an environment's user_roles are not stored as their base roles.
+func ImportConfigUsers(ctx context.Context, cfg *config.Config, userStore *users.Store) (imported []string, skipped []UserConflict, err error) {
+	cfg = cfg.Base()
+	conflicts, err := FindUserConflicts(ctx, cfg, userStore)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, cu := range cfg.Users {
+		if i := slices.IndexFunc(conflicts, func(c UserConflict) bool { return c.ConfigUser == cu.ID }); i >= 0 {
+			skipped = append(skipped, conflicts[i])
+			continue
+		}
+
+		if err := importConfigUser(ctx, userStore, &cu); err != nil {
+			return imported, skipped, fmt.Errorf("import %s: %w", cu.ID, err)
+		}
+		imported = append(imported, cu.ID)
+	}
+	return imported, skipped, nil
+}
+
+// importConfigUser creates cu in the store with its SSH keys and
+// credentials, deleting it again if any of them cannot be added.
+func importConfigUser(ctx context.Context, userStore *users.Store, cu *config.User) error {
+	if err := userStore.CreateUser(ctx, &users.User{ID: cu.ID, SSHUsers: cu.SSHUsers, Roles: cu.Roles}); err != nil {
+		return err
+	}
+	err := func() error {
+		for _, k := range cu.SSHKeys {
+			if err := userStore.AddSSHKey(ctx, cu.ID, k); err != nil {
+				return err
+			}
+		}
+		for _, c := range cu.YubiKeyCreds {
+			cred := &users.Credential{RPID: c.RPID, CredentialID: c.CredentialID, PublicKey: c.PublicKey, Name: c.Name, UserHandle: c.UserHandle}
+			if err := userStore.AddCredential(ctx, cu.ID, cred); err != nil {
+				return err
+			}
+		}
+		return nil
+	}()
+	if err != nil {
+		_ = userStore.DeleteUser(ctx, cu.ID)
+	}
+	return err
+}
+
+// ExportStoreUsers returns store users in config form, for moving them to
+// users[] when config becomes authoritative. Users that conflict with a
+// config user are left out, as are disabled users, which config cannot
+// express; the IDs of the latter are returned.
+func ExportStoreUsers(ctx context.Context, cfg *config.Config, userStore *users.Store) (exported []config.User, disabled []string, err error) {
+	conflicts, err := FindUserConflicts(ctx, cfg, userStore)
+	if err != nil {
+		return nil, nil, err
+	}
+	dbUsers, err := userStore.ListUsers(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, su := range dbUsers {
+		if slices.ContainsFunc(conflicts, func(c UserConflict) bool { return c.StoreUser == su.ID }) {
+			continue
+		}
+		if su.Disabled {
+			disabled = append(disabled, su.ID)
+			continue
+		}
+
+		u := config.User{ID: su.ID, SSHUsers: su.SSHUsers, Roles: su.Roles}
+		if u.SSHKeys, err = userStore.GetSSHKeys(ctx, su.ID); err != nil {
+			return nil, nil, err
+		}
+		creds, err := userStore.GetCredentials(ctx, su.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, c := range creds {
+			u.YubiKeyCreds = append(u.YubiKeyCreds, config.YubiKeyCredential{
+				RPID:         c.RPID,
+				CredentialID: c.CredentialID,
+				PublicKey:    c.PublicKey,
+				Name:         c.Name,
+				UserHandle:   c.UserHandle,
+			})
+		}
+		exported = append(exported, u)
+	}
+	return exported, disabled, nil
+}
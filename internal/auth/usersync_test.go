@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/you/lazyadmin/internal/config"
+	"github.com/you/lazyadmin/internal/users"
+)
+
+func newSyncStore(t *testing.T) *users.Store {
+	t.Helper()
+	store, err := users.NewStore(filepath.Join(t.TempDir(), "lazyadmin.db"))
+	if err != nil {
+		t.Fatalf("users.NewStore() error = %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	ctx := context.Background()
+	for _, u := range []*users.User{
+		{ID: "alice", SSHUsers: []string{"alice"}, Roles: []string{"read_only"}}, // same ID as config
+		{ID: "robert", SSHUsers: []string{"bob"}, Roles: []string{"owner"}},      // bob's SSH user
+		{ID: "erin", SSHUsers: []string{"erin"}, Roles: []string{"operator"}},    // no conflict
+		{ID: "frank", SSHUsers: []string{"frank"}, Roles: []string{"operator"}},  // disabled
+		{ID: "gina", SSHUsers: []string{"gina"}, Roles: []string{"operator"}},    // carol's key
+	} {
+		if err := store.CreateUser(ctx, u); err != nil {
+			t.Fatalf("CreateUser(%s) error = %v", u.ID, err)
+		}
+	}
+	if err := store.SetDisabled(ctx, "frank", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddSSHKey(ctx, "gina", "SHA256:carolkey"); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func syncConfig(source string) *config.Config {
+	return &config.Config{
+		Auth: config.AuthConfig{UserSource: source},
+		Users: []config.User{
+			{ID: "alice", SSHUsers: []string{"alice"}, Roles: []string{"admin"}},
+			{ID: "bob", SSHUsers: []string{"bob"}, Roles: []string{"operator"}},
+			{ID: "carol", SSHUsers: []string{"carol"}, Roles: []string{"operator"}, SSHKeys: []string{"SHA256:carolkey"}},
+			{ID: "dan", SSHUsers: []string{"dan"}, Roles: []string{"operator"}, SSHKeys: []string{"SHA256:dankey"},
+				YubiKeyCreds: []config.YubiKeyCredential{{RPID: "lazyadmin.local", CredentialID: "cred", PublicKey: "key"}}},
+		},
+	}
+}
+
+func TestFindUserConflicts(t *testing.T) {
+	store := newSyncStore(t)
+	got, err := FindUserConflicts(context.Background(), syncConfig(""), store)
+	if err != nil {
+		t.Fatalf("FindUserConflicts() error = %v", err)
+	}
+	want := []UserConflict{
+		{Kind: ConflictID, Value: "alice", ConfigUser: "alice", StoreUser: "alice"},
+		{Kind: ConflictSSHKey, Value: "SHA256:carolkey", ConfigUser: "carol", StoreUser: "gina"},
+		{Kind: ConflictSSHUser, Value: "bob", ConfigUser: "bob", StoreUser: "robert"},
+	}
+	for _, w := range want {
+		if !slices.Contains(got, w) {
+			t.Errorf("FindUserConflicts() lacks %+v", w)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("FindUserConflicts() = %+v, want %d conflicts", got, len(want))
+	}
+
+	if got, err := FindUserConflicts(context.Background(), syncConfig(""), nil); err != nil || got != nil {
+		t.Errorf("FindUserConflicts() without store = %v, %v", got, err)
+	}
+}
+
+func TestResolvePrincipal_UserSource(t *testing.T) {
+	store := newSyncStore(t)
+
+	tests := []struct {
+		source   string
+		sshUser  string
+		wantUser string
+		fromDB   bool
+		wantErr  error
+	}{
+		{source: "", sshUser: "bob", wantUser: "bob"},
+		{source: "", sshUser: "alice", wantUser: "alice"},
+		{source: "", sshUser: "erin", wantUser: "erin", fromDB: true},
+		{source: config.UserSourceStore, sshUser: "bob", wantUser: "robert", fromDB: true},
+		{source: config.UserSourceStore, sshUser: "alice", wantUser: "alice", fromDB: true},
+		{source: config.UserSourceStore, sshUser: "carol", wantErr: ErrUserConflict},
+		{source: config.UserSourceStore, sshUser: "dan", wantUser: "dan"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source+"/"+tt.sshUser, func(t *testing.T) {
+			t.Setenv("SSH_USER", tt.sshUser)
+			p, err := ResolvePrincipal(syncConfig(tt.source), store)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("ResolvePrincipal() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolvePrincipal() error = %v", err)
+			}
+			if p.ConfigUser.ID != tt.wantUser || (p.DBUser != nil) != tt.fromDB {
+				t.Errorf("ResolvePrincipal() = %s (store %v), want %s (store %v)", p.ConfigUser.ID, p.DBUser != nil, tt.wantUser, tt.fromDB)
+			}
+		})
+	}
+
+	// With config authoritative, a store user conflicting by key is refused.
+	cfg := syncConfig("")
+	cfg.Auth.Bastion = true
+	if _, err := ResolveBastionPrincipal(cfg, store, BastionIdentity{UserID: "gina"}); !errors.Is(err, ErrUserConflict) {
+		t.Errorf("ResolveBastionPrincipal(gina) error = %v, want ErrUserConflict", err)
+	}
+	if p, err := ResolveBastionPrincipal(cfg, store, BastionIdentity{Fingerprint: "SHA256:carolkey"}); err != nil || p.ConfigUser.ID != "carol" {
+		t.Errorf("ResolveBastionPrincipal(carolkey) = %v, %v, want carol", p, err)
+	}
+}
+
+func TestImportExportUsers(t *testing.T) {
+	ctx := context.Background()
+	store := newSyncStore(t)
+	cfg := syncConfig("")
+
+	exported, disabled, err := ExportStoreUsers(ctx, cfg, store)
+	if err != nil {
+		t.Fatalf("ExportStoreUsers() error = %v", err)
+	}
+	if len(exported) != 1 || exported[0].ID != "erin" || !slices.Equal(disabled, []string{"frank"}) {
+		t.Errorf("ExportStoreUsers() = %+v, disabled %v; want erin, disabled [frank]", exported, disabled)
+	}
+
+	imported, skipped, err := ImportConfigUsers(ctx, cfg, store)
+	if err != nil {
+		t.Fatalf("ImportConfigUsers() error = %v", err)
+	}
+	if !slices.Equal(imported, []string{"dan"}) || len(skipped) != 3 {
+		t.Errorf("ImportConfigUsers() = %v, skipped %+v; want [dan] and 3 skipped", imported, skipped)
+	}
+
+	dan, err := store.GetUser(ctx, "dan")
+	if err != nil {
+		t.Fatalf("GetUser(dan) error = %v", err)
+	}
+	keys, _ := store.GetSSHKeys(ctx, "dan")
+	creds, _ := store.GetCredentials(ctx, "dan")
+	if !slices.Equal(dan.Roles, []string{"operator"}) || !slices.Equal(keys, []string{"SHA256:dankey"}) || len(creds) != 1 {
+		t.Errorf("imported dan = %+v, keys %v, %d credentials", dan, keys, len(creds))
+	}
+}
+
+func TestImportConfigUsers_Partial(t *testing.T) {
+	ctx := context.Background()
+	store := newSyncStore(t)
+	cfg := syncConfig("")
+
+	// erin already holds dan's credential, which conflict detection does
+	// not look at, so adding it fails after dan was created.
+	if err := store.AddCredential(ctx, "erin", &users.Credential{RPID: "lazyadmin.local", CredentialID: "cred", PublicKey: "key"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ImportConfigUsers(ctx, cfg, store); !errors.Is(err, users.ErrCredentialExists) {
+		t.Fatalf("ImportConfigUsers() error = %v, want ErrCredentialExists", err)
+	}
+	if _, err := store.GetUser(ctx, "dan"); err == nil {
+		t.Error("dan was left in the store after a failed import")
+	}
+	if keys, _ := store.GetSSHKeys(ctx, "dan"); len(keys) != 0 {
+		t.Errorf("dan's SSH keys left behind: %v", keys)
+	}
+
+	// Once the clash is resolved, the next import picks dan up.
+	creds, _ := store.GetCredentials(ctx, "erin")
+	if err := store.RemoveCredential(ctx, "erin", creds[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	imported, _, err := ImportConfigUsers(ctx, cfg, store)
+	if err != nil || !slices.Equal(imported, []string{"dan"}) {
+		t.Errorf("second ImportConfigUsers() = %v, %v; want [dan]", imported, err)
+	}
+}
+
+func TestImportConfigUsers_EnvironmentRoles(t *testing.T) {
+	ctx := context.Background()
+	store := newSyncStore(t)
+	base := syncConfig("")
+	base.Environments = map[string]config.Environment{
+		"prod": {UserRoles: map[string][]string{"dan": {"owner"}}},
+	}
+	prod, err := base.ForEnvironment("prod")
+	if err != nil {
+		t.Fatalf("ForEnvironment(prod) error = %v", err)
+	}
+
+	if _, _, err := ImportConfigUsers(ctx, prod, store); err != nil {
+		t.Fatalf("ImportConfigUsers() error = %v", err)
+	}
+	dan, err := store.GetUser(ctx, "dan")
+	if err != nil {
+		t.Fatalf("GetUser(dan) error = %v", err)
+	}
+	if !slices.Equal(dan.Roles, []string{"operator"}) {
+		t.Errorf("imported dan roles = %v, want [operator] from users[], not prod's override", dan.Roles)
+	}
+}
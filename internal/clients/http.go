@@ -3,33 +3,86 @@ package clients
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
-	"time"
+	"net/url"
 )
 
 type HTTPClient struct {
 	baseURL string
 	client  *http.Client
+	token   func(ctx context.Context) (string, error)
 }
 
+// NewHTTPClient returns a client for the resource at baseURL. It sets no
+// timeout of its own: requests are bounded by their context, which carries
+// the step, operation or retry timeout.
 func NewHTTPClient(baseURL string) *HTTPClient {
 	return &HTTPClient{
 		baseURL: baseURL,
-		client: &http.Client{
-			Timeout: 5 * time.Second,
-		},
+		client:  &http.Client{},
 	}
 }
 
+// SetBearerToken makes every request carry an Authorization: Bearer header
+// with the value token returns, which is called per request so rotated
+// secrets are picked up.
+func (c *HTTPClient) SetBearerToken(token func(ctx context.Context) (string, error)) {
+	c.token = token
+}
+
 func (c *HTTPClient) Request(ctx context.Context, method, path string) (string, error) {
+	_, out, err := c.RequestStatus(ctx, method, path)
+	return out, err
+}
+
+// RequestStatus is like Request but also returns the response status code so
+// callers can act on it (e.g. retry on 503).
+func (c *HTTPClient) RequestStatus(ctx context.Context, method, path string) (int, string, error) {
 	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, nil)
 	if err != nil {
-		return "", err
+		return 0, "", err
+	}
+	if c.token != nil {
+		token, err := c.token(ctx)
+		if err != nil {
+			return 0, "", fmt.Errorf("bearer token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
 	}
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return "", err
+		return 0, "", err
 	}
 	defer resp.Body.Close()
-	return fmt.Sprintf("HTTP %d %s", resp.StatusCode, http.StatusText(resp.StatusCode)), nil
+	return resp.StatusCode, fmt.Sprintf("HTTP %d %s", resp.StatusCode, http.StatusText(resp.StatusCode)), nil
+}
+
+// BaseURL returns the resource's base URL.
+func (c *HTTPClient) BaseURL() string {
+	return c.baseURL
+}
+
+// Ping checks that the resource's host accepts TCP connections without
+// sending an HTTP request.
+func (c *HTTPClient) Ping(ctx context.Context) error {
+	u, err := url.Parse(c.baseURL)
+	if err != nil {
+		return fmt.Errorf("parse base url: %w", err)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
 }
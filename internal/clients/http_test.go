@@ -2,6 +2,7 @@ package clients
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -25,8 +26,8 @@ func TestNewHTTPClient(t *testing.T) {
 		t.Error("client is nil")
 	}
 
-	if client.client.Timeout != 5*time.Second {
-		t.Errorf("client.Timeout = %v, want %v", client.client.Timeout, 5*time.Second)
+	if client.client.Timeout != 0 {
+		t.Errorf("client.Timeout = %v, want none; the request context bounds requests", client.client.Timeout)
 	}
 }
 
@@ -176,3 +177,60 @@ func TestHTTPClient_Request_ContextCancellation(t *testing.T) {
 		t.Errorf("Request() error = %v, want error containing 'canceled'", err)
 	}
 }
+
+func TestHTTPClient_Request_LongerThanFiveSeconds(t *testing.T) {
+	if testing.Short() {
+		t.Skip("takes over 5 seconds")
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5500 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	// A step with timeout: 30s must not be cut short by the client.
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	out, err := NewHTTPClient(server.URL).Request(ctx, "POST", "/slow")
+	if err != nil || out != "HTTP 200 OK" {
+		t.Errorf("Request() = %q, %v; want HTTP 200 OK within the context's timeout", out, err)
+	}
+}
+
+func TestHTTPClient_Request_BearerToken(t *testing.T) {
+	var got []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := NewHTTPClient(server.URL)
+	if _, err := client.Request(context.Background(), "GET", "/"); err != nil {
+		t.Fatalf("Request() error = %v", err)
+	}
+
+	tokens := []string{"first", "rotated"}
+	client.SetBearerToken(func(context.Context) (string, error) {
+		token := tokens[0]
+		tokens = tokens[1:]
+		return token, nil
+	})
+	for range 2 {
+		if _, err := client.Request(context.Background(), "GET", "/"); err != nil {
+			t.Fatalf("Request() error = %v", err)
+		}
+	}
+	want := []string{"", "Bearer first", "Bearer rotated"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Authorization headers = %q, want %q", got, want)
+	}
+
+	client.SetBearerToken(func(context.Context) (string, error) { return "", errors.New("vault sealed") })
+	if _, err := client.Request(context.Background(), "GET", "/"); err == nil || !contains(err.Error(), "bearer token: vault sealed") {
+		t.Errorf("Request() error = %v, want bearer token error", err)
+	}
+	if len(got) != 3 {
+		t.Errorf("requests sent = %d, want 3; a failed token must not send the request", len(got))
+	}
+}
@@ -23,11 +23,66 @@ func NewPostgresClient(dsn string) (*PostgresClient, error) {
 	return &PostgresClient{DB: db}, nil
 }
 
-func (c *PostgresClient) RunScalarQuery(ctx context.Context, query string) (string, error) {
-	row := c.DB.QueryRowContext(ctx, query)
+// RunScalarQuery runs query with args bound to its $n placeholders and
+// returns the first column of the first row.
+func (c *PostgresClient) RunScalarQuery(ctx context.Context, query string, args ...any) (string, error) {
+	row := c.DB.QueryRowContext(ctx, query, args...)
 	var value any
 	if err := row.Scan(&value); err != nil {
 		return "", fmt.Errorf("scan: %w", err)
 	}
 	return fmt.Sprintf("%v", value), nil
 }
+
+// RunScalarQueryReadOnly is like RunScalarQuery but runs the query in a
+// READ ONLY transaction that is always rolled back, so the database rejects
+// any write it attempts whatever the step claims about itself.
+func (c *PostgresClient) RunScalarQueryReadOnly(ctx context.Context, query string, args ...any) (string, error) {
+	tx, err := c.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return "", fmt.Errorf("begin read only: %w", err)
+	}
+	defer tx.Rollback()
+
+	var value any
+	if err := tx.QueryRowContext(ctx, query, args...).Scan(&value); err != nil {
+		return "", fmt.Errorf("scan: %w", err)
+	}
+	return fmt.Sprintf("%v", value), nil
+}
+
+// TryAdvisoryLock takes a session-level advisory lock keyed by name without
+// waiting. The lock lives on a dedicated connection; unlock releases it and
+// returns the connection to the pool. ok is false if another session holds it.
+func (c *PostgresClient) TryAdvisoryLock(ctx context.Context, name string) (unlock func() error, ok bool, err error) {
+	conn, err := c.DB.Conn(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("advisory lock conn: %w", err)
+	}
+
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(hashtext($1))", name).Scan(&ok); err != nil {
+		conn.Close()
+		return nil, false, fmt.Errorf("advisory lock: %w", err)
+	}
+	if !ok {
+		conn.Close()
+		return nil, false, nil
+	}
+
+	unlock = func() error {
+		defer conn.Close()
+		_, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock(hashtext($1))", name)
+		return err
+	}
+	return unlock, true, nil
+}
+
+// Close closes the connection pool.
+func (c *PostgresClient) Close() error {
+	return c.DB.Close()
+}
+
+// Ping checks that the database is reachable.
+func (c *PostgresClient) Ping(ctx context.Context) error {
+	return c.DB.PingContext(ctx)
+}
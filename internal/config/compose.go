@@ -0,0 +1,424 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrIncludeCycle = errors.New("include cycle")
+	ErrDuplicateID  = errors.New("duplicate id")
+	ErrConflict     = errors.New("set in more than one file")
+)
+
+// OverlaysEnv names the environment variable listing overlay files, separated
+// like PATH, merged over the configuration in order.
+const OverlaysEnv = "LAZYADMIN_CONFIG_OVERLAYS"
+
+// listKeys are the lists concatenated across files, with the key that
+// identifies their items. Lists without an ID key are only concatenated.
+var listKeys = map[string]struct{ key, noun string }{
+	"users":           {"id", "user"},
+	"roles":           {"name", "role"},
+	"access":          {"", "access rule"},
+	"elevation.roles": {"role", "elevation role"},
+	"notifications":   {"name", "notification sink"},
+	"operations":      {"id", "operation"},
+	"tasks":           {"id", "task"},
+	"schedules":       {"id", "schedule"},
+}
+
+// fragment is one file of a composed configuration.
+type fragment struct {
+	path    string
+	data    []byte
+	line    int  // line of the fragment's first line in the combined document
+	overlay bool // merged as an overlay rather than an include
+}
+
+// composer reads a configuration file with its includes and overlays.
+//
+// The files are parsed as items of one YAML sequence, in load order, so
+// anchors defined in a file can be used by every file loaded after it.
+type composer struct {
+	root   *yaml.Node // the merged configuration, once composed
+	frags  []*fragment
+	loaded map[string]bool // absolute paths already read
+	stack  []string        // include chain, for cycle errors
+}
+
+// compose reads path, the files it includes, and the overlays with theirs,
+// and merges them into one mapping node, root, with aliases expanded.
+//
+// Files are merged in load order: path, then its includes depth first, then
+// each overlay. Top-level lists are concatenated; an ID used twice is an
+// error citing both files. Mappings are merged key by key. Any other value
+// set by two files is an error, except that overlays replace values,
+// including list items with the same ID.
+func compose(path string, overlays []string) (*composer, error) {
+	c := &composer{loaded: make(map[string]bool)}
+	if err := c.add(path, false); err != nil {
+		return nil, err
+	}
+	for _, o := range overlays {
+		if err := c.add(o, true); err != nil {
+			return nil, err
+		}
+	}
+
+	items, err := c.parse()
+	if err != nil {
+		return nil, err
+	}
+	c.root = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for i, f := range c.frags {
+		item := expand(items[i])
+		if isNull(item) {
+			continue
+		}
+		if item.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("parse config: %s is not a YAML mapping", f.path)
+		}
+		if err := c.merge(c.root, item, "", f.overlay); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// decode decodes the merged configuration, reporting errors by file and
+// line.
+func (c *composer) decode(cfg *Config) error {
+	if err := c.root.Decode(cfg); err != nil {
+		return fmt.Errorf("parse config: %s", c.positions(err.Error()))
+	}
+	cfg.files = c.files()
+	cfg.hash = c.hash()
+	cfg.manifest = c.manifest()
+	return nil
+}
+
+// manifest returns the signed manifest of the files read: one line per file
+// with its SHA-256 and its path relative to the main file's directory.
+func (c *composer) manifest() []byte {
+	var b strings.Builder
+	b.WriteString(manifestHeader)
+	dir := filepath.Dir(c.frags[0].path)
+	for _, f := range c.frags {
+		rel, err := filepath.Rel(dir, f.path)
+		if err != nil {
+			rel = f.path
+		}
+		fmt.Fprintf(&b, "%x  %s\n", sha256.Sum256(f.data), filepath.ToSlash(rel))
+	}
+	return []byte(b.String())
+}
+
+// hash returns the hex SHA-256 of the files read, each prefixed with its
+// path and length so moving content between files changes the hash.
+func (c *composer) hash() string {
+	h := sha256.New()
+	for _, f := range c.frags {
+		fmt.Fprintf(h, "%s\x00%d\x00", f.path, len(f.data))
+		h.Write(f.data)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// files returns the files read, in load order.
+func (c *composer) files() []string {
+	var out []string
+	for _, f := range c.frags {
+		out = append(out, f.path)
+	}
+	return out
+}
+
+// add reads path and, depth first, the files it includes.
+func (c *composer) add(path string, overlay bool) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+	if i := slices.Index(c.stack, abs); i >= 0 {
+		chain := append(slices.Clone(c.stack[i:]), abs)
+		return fmt.Errorf("%w: %s", ErrIncludeCycle, strings.Join(chain, " -> "))
+	}
+	if c.loaded[abs] {
+		return nil
+	}
+	c.loaded[abs] = true
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+	f := &fragment{path: path, data: data, overlay: overlay}
+	c.frags = append(c.frags, f)
+
+	items, err := c.parse()
+	if err != nil {
+		return err
+	}
+	includes, err := includePaths(path, items[len(items)-1])
+	if err != nil {
+		return err
+	}
+
+	c.stack = append(c.stack, abs)
+	defer func() { c.stack = c.stack[:len(c.stack)-1] }()
+	for _, inc := range includes {
+		if err := c.add(inc, overlay); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parse parses the fragments read so far and returns one node per fragment.
+func (c *composer) parse() ([]*yaml.Node, error) {
+	var b strings.Builder
+	line := 1
+	for _, f := range c.frags {
+		f.line = line
+		lines := strings.Split(string(f.data), "\n")
+		for i, l := range lines {
+			l = strings.TrimSuffix(l, "\r")
+			if i == 0 {
+				// A document start marker would end the combined document.
+				l = strings.TrimPrefix(l, "\ufeff")
+				if strings.TrimRight(l, " ") == "---" {
+					l = ""
+				}
+				b.WriteString("- " + l + "\n")
+			} else {
+				b.WriteString("  " + l + "\n")
+			}
+		}
+		line += len(lines)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(b.String()), &doc); err != nil {
+		return nil, fmt.Errorf("parse config: %s", c.positions(err.Error()))
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.SequenceNode || len(doc.Content[0].Content) != len(c.frags) {
+		return nil, fmt.Errorf("parse config: %s must hold one YAML document", c.frags[len(c.frags)-1].path)
+	}
+	return doc.Content[0].Content, nil
+}
+
+// includePaths returns the files included by the fragment node n of path.
+// Relative patterns are resolved against the directory of path; a glob
+// matching nothing includes nothing, but a missing plain path is an error.
+func includePaths(path string, n *yaml.Node) ([]string, error) {
+	inc := mappingValue(n, "include")
+	if inc == nil || isNull(inc) {
+		return nil, nil
+	}
+	if inc.Kind == yaml.AliasNode {
+		inc = inc.Alias
+	}
+	var patterns []string
+	if err := inc.Decode(&patterns); err != nil {
+		var one string
+		if inc.Decode(&one) != nil {
+			return nil, fmt.Errorf("%s: include must be a path or a list of paths", path)
+		}
+		patterns = []string{one}
+	}
+
+	var out []string
+	for _, p := range patterns {
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(filepath.Dir(path), p)
+		}
+		matches, err := filepath.Glob(p)
+		if err != nil {
+			return nil, fmt.Errorf("%s: include %s: %w", path, p, err)
+		}
+		if len(matches) == 0 && !strings.ContainsAny(p, `*?[\`) {
+			return nil, fmt.Errorf("%s: include %s: %w", path, p, os.ErrNotExist)
+		}
+		out = append(out, matches...)
+	}
+	return out, nil
+}
+
+// merge merges mapping src into mapping dst. path is the dotted key of dst.
+func (c *composer) merge(dst, src *yaml.Node, path string, overlay bool) error {
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, val := src.Content[i], src.Content[i+1]
+		if path == "" && key.Value == "include" {
+			continue
+		}
+		p := key.Value
+		if path != "" {
+			p = path + "." + key.Value
+		}
+
+		j := mappingIndex(dst, key.Value)
+		_, isList := listKeys[p]
+		switch {
+		case j < 0 && (val.Kind == yaml.MappingNode || isList && val.Kind == yaml.SequenceNode):
+			// Merge into an empty node so lists are checked for
+			// duplicates within a file as well.
+			empty := *val
+			empty.Content = nil
+			dst.Content = append(dst.Content, key, &empty)
+			j = len(dst.Content) - 1
+		case j < 0:
+			dst.Content = append(dst.Content, key, val)
+			continue
+		case isNull(val):
+			continue
+		case isNull(dst.Content[j]):
+			dst.Content[j] = val
+			continue
+		}
+
+		cur := dst.Content[j]
+		if lk, ok := listKeys[p]; ok && cur.Kind == yaml.SequenceNode && val.Kind == yaml.SequenceNode {
+			if err := c.mergeList(cur, val, lk.key, lk.noun, overlay); err != nil {
+				return err
+			}
+			continue
+		}
+		if cur.Kind == yaml.MappingNode && val.Kind == yaml.MappingNode {
+			if err := c.merge(cur, val, p, overlay); err != nil {
+				return err
+			}
+			continue
+		}
+		if !overlay {
+			return fmt.Errorf("%s: %w: %s and %s", p, ErrConflict, c.position(cur.Line), c.position(val.Line))
+		}
+		dst.Content[j] = val
+	}
+	return nil
+}
+
+// mergeList appends the items of sequence src to dst. Items identified by
+// key must be unique, unless src is an overlay, which replaces them.
+func (c *composer) mergeList(dst, src *yaml.Node, key, noun string, overlay bool) error {
+	for _, item := range src.Content {
+		id := ""
+		if v := mappingValue(item, key); key != "" && v != nil {
+			id = v.Value
+		}
+		j := -1
+		if id != "" {
+			j = slices.IndexFunc(dst.Content, func(n *yaml.Node) bool {
+				v := mappingValue(n, key)
+				return v != nil && v.Value == id
+			})
+		}
+		switch {
+		case j < 0:
+			dst.Content = append(dst.Content, item)
+		case overlay:
+			dst.Content[j] = item
+		default:
+			return fmt.Errorf("%w: %s %q in %s and %s", ErrDuplicateID, noun, id, c.position(dst.Content[j].Line), c.position(item.Line))
+		}
+	}
+	return nil
+}
+
+// position returns the file and line of line in the combined document.
+func (c *composer) position(line int) string {
+	for i := len(c.frags) - 1; i >= 0; i-- {
+		if f := c.frags[i]; line >= f.line {
+			return fmt.Sprintf("%s:%d", f.path, line-f.line+1)
+		}
+	}
+	return fmt.Sprintf("line %d", line)
+}
+
+var lineRef = regexp.MustCompile(`line (\d+)`)
+
+// positions rewrites the line numbers in a YAML error message to files and
+// lines.
+func (c *composer) positions(msg string) string {
+	return lineRef.ReplaceAllStringFunc(msg, func(m string) string {
+		n, _ := strconv.Atoi(strings.TrimPrefix(m, "line "))
+		return c.position(n)
+	})
+}
+
+// expand returns a copy of n with aliases replaced by copies of their
+// anchored nodes and merge keys (<<) applied, so nodes can be merged and
+// rendered without sharing.
+func expand(n *yaml.Node) *yaml.Node {
+	if n.Kind == yaml.AliasNode {
+		out := expand(n.Alias)
+		out.Line, out.Column = n.Line, n.Column
+		return out
+	}
+	out := *n
+	out.Anchor = ""
+	out.Content = nil
+	for _, child := range n.Content {
+		out.Content = append(out.Content, expand(child))
+	}
+	if out.Kind != yaml.MappingNode {
+		return &out
+	}
+
+	// Explicit keys win over merged ones, and earlier merged mappings over
+	// later ones.
+	var merged, explicit []*yaml.Node
+	for i := 0; i+1 < len(out.Content); i += 2 {
+		key, val := out.Content[i], out.Content[i+1]
+		if key.Tag != "!!merge" {
+			explicit = append(explicit, key, val)
+			continue
+		}
+		sources := []*yaml.Node{val}
+		if val.Kind == yaml.SequenceNode {
+			sources = val.Content
+		}
+		for _, s := range sources {
+			for k := 0; k+1 < len(s.Content); k += 2 {
+				if mappingIndex(&yaml.Node{Kind: yaml.MappingNode, Content: merged}, s.Content[k].Value) < 0 {
+					merged = append(merged, s.Content[k], s.Content[k+1])
+				}
+			}
+		}
+	}
+	if merged == nil {
+		return &out
+	}
+	out.Content = explicit
+	for k := 0; k+1 < len(merged); k += 2 {
+		if mappingIndex(&out, merged[k].Value) < 0 {
+			out.Content = append(out.Content, merged[k], merged[k+1])
+		}
+	}
+	return &out
+}
+
+// mappingIndex returns the index of the value for key in mapping node m, or
+// -1.
+func mappingIndex(m *yaml.Node, key string) int {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return i + 1
+		}
+	}
+	return -1
+}
+
+func isNull(n *yaml.Node) bool {
+	return n.Kind == yaml.ScalarNode && n.Tag == "!!null"
+}
@@ -1,8 +1,11 @@
 package config
 
 import (
+	"bytes"
 	"fmt"
 	"os"
+	"path/filepath"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -13,28 +16,115 @@ type LoggingConfig struct {
 
 type YubiKeyCredential struct {
 	RPID         string `yaml:"rp_id"`
-	CredentialID string `yaml:"credential_id"` // base64url-encoded
-	PublicKey    string `yaml:"public_key"`    // base64url-encoded raw public key bytes
+	CredentialID string `yaml:"credential_id"`         // base64url-encoded
+	PublicKey    string `yaml:"public_key"`            // base64url-encoded raw public key bytes
+	Name         string `yaml:"name,omitempty"`        // e.g. "blue backup key"
+	UserHandle   string `yaml:"user_handle,omitempty"` // base64url-encoded WebAuthn user handle
 }
 
 type AuthConfig struct {
 	RequireYubiKey bool   `yaml:"require_yubikey"`
 	YubiKeyMode    string `yaml:"yubikey_mode"`
+	// Bastion restricts identity to `lazyadmin bastion` (SSH ForceCommand):
+	// users are identified by SSH key, and SSH_USER/USER are never trusted.
+	Bastion bool `yaml:"bastion"`
+	// The TUI locks and asks for a fresh FIDO2 assertion after this long
+	// without input (idle) or since the last assertion (max age). Zero disables.
+	SessionIdleTimeout time.Duration `yaml:"session_idle_timeout"`
+	SessionMaxAge      time.Duration `yaml:"session_max_age"`
+	// BreakGlass allows emergency access with a one-time recovery code when
+	// FIDO2 hardware is unavailable.
+	BreakGlass BreakGlassConfig `yaml:"break_glass"`
+	// UserSource is the authoritative source of users when users[] and the
+	// SQLite store disagree: UserSourceConfig (default) or UserSourceStore.
+	UserSource string `yaml:"user_source"`
+	// Lockout refuses sign-in to a user after repeated failed FIDO2
+	// assertions.
+	Lockout LockoutConfig `yaml:"lockout"`
+}
+
+// User sources for auth.user_source.
+const (
+	UserSourceConfig = "config"
+	UserSourceStore  = "store"
+)
+
+// StoreAuthoritative reports whether store users win over conflicting
+// config users.
+func (a AuthConfig) StoreAuthoritative() bool {
+	return a.UserSource == UserSourceStore
+}
+
+// BreakGlassConfig configures emergency access. Every use is audited and
+// sent to all notification sinks.
+type BreakGlassConfig struct {
+	Role     string        `yaml:"role"`     // emergency role granted; empty disables break-glass
+	Duration time.Duration `yaml:"duration"` // how long the role is held (default DefaultBreakGlassDuration)
+}
+
+// DefaultBreakGlassDuration applies when auth.break_glass.duration is unset.
+const DefaultBreakGlassDuration = 30 * time.Minute
+
+// Enabled reports whether break-glass access is configured.
+func (b BreakGlassConfig) Enabled() bool {
+	return b.Role != ""
+}
+
+// DurationOrDefault returns the break-glass window.
+func (b BreakGlassConfig) DurationOrDefault() time.Duration {
+	if b.Duration > 0 {
+		return b.Duration
+	}
+	return DefaultBreakGlassDuration
+}
+
+// LockoutConfig locks a user out after consecutive failed sign-ins. Every
+// attempt is audited whether or not lockout is enabled.
+type LockoutConfig struct {
+	MaxFailures int           `yaml:"max_failures"` // failures in a row before lockout; zero disables
+	Duration    time.Duration `yaml:"duration"`     // how long the lockout lasts (default DefaultLockoutDuration)
+}
+
+// DefaultLockoutDuration applies when auth.lockout.duration is unset.
+const DefaultLockoutDuration = 15 * time.Minute
+
+// Enabled reports whether failed sign-ins lead to lockout.
+func (l LockoutConfig) Enabled() bool {
+	return l.MaxFailures > 0
+}
+
+// DurationOrDefault returns how long a lockout lasts.
+func (l LockoutConfig) DurationOrDefault() time.Duration {
+	if l.Duration > 0 {
+		return l.Duration
+	}
+	return DefaultLockoutDuration
+}
+
+// NotificationSink receives security events such as break-glass use.
+type NotificationSink struct {
+	Name   string `yaml:"name"`
+	Type   string `yaml:"type"`    // "webhook" (JSON event) or "slack" (incoming webhook)
+	URLEnv string `yaml:"url_env"` // environment variable holding the URL
+	URL    string `yaml:"url"`     // the URL, usually a secret:// reference; instead of url_env
 }
 
 type User struct {
 	ID           string              `yaml:"id"`
 	SSHUsers     []string            `yaml:"ssh_users"`
 	Roles        []string            `yaml:"roles"`
-	YubiKeyCreds []YubiKeyCredential `yaml:"yubikey_credentials"`
+	SSHKeys      []string            `yaml:"ssh_keys,omitempty"` // public key fingerprints (SHA256:...) for bastion mode
+	YubiKeyCreds []YubiKeyCredential `yaml:"yubikey_credentials,omitempty"`
 }
 
 type HTTPResource struct {
 	BaseURL string `yaml:"base_url"`
+	Token   string `yaml:"token"` // bearer token, usually a secret:// reference
 }
 
 type PostgresResource struct {
-	DSNEnv string `yaml:"dsn_env"`
+	DSN    string `yaml:"dsn"`     // connection string, usually a secret:// reference
+	DSNEnv string `yaml:"dsn_env"` // environment variable holding the DSN, as secret://env/
 }
 
 type ResourcesConfig struct {
@@ -43,14 +133,64 @@ type ResourcesConfig struct {
 }
 
 type Operation struct {
-	ID           string   `yaml:"id"`
-	Label        string   `yaml:"label"`
-	Type         string   `yaml:"type"`   // "http" | "postgres"
-	Target       string   `yaml:"target"` // key into resources
-	Method       string   `yaml:"method"` // for http
-	Path         string   `yaml:"path"`   // for http
-	Query        string   `yaml:"query"`  // for postgres
-	AllowedRoles []string `yaml:"allowed_roles"`
+	ID           string      `yaml:"id"`
+	Label        string      `yaml:"label"`
+	Type         string      `yaml:"type"`   // "http" | "postgres"
+	Target       string      `yaml:"target"` // key into resources
+	Method       string      `yaml:"method"` // for http
+	Path         string      `yaml:"path"`   // for http
+	Query        string      `yaml:"query"`  // for postgres
+	AllowedRoles []string    `yaml:"allowed_roles"`
+	Permissions  []string    `yaml:"permissions"` // alternative to allowed_roles: all are required
+	Tags         []string    `yaml:"tags"`        // ops.run:<tag> admits the operation
+	Lock         *Lock       `yaml:"lock"`
+	Retry        RetryPolicy `yaml:",inline"`
+}
+
+type LockScope string
+
+const (
+	LockScopeEnv    LockScope = "env"
+	LockScopeGlobal LockScope = "global"
+)
+
+// Lock makes a task or operation exclusive: while one principal holds the
+// lock, nobody else (on any bastion sharing the SQLite database) can start an
+// item with the same lock name.
+type Lock struct {
+	Name     string        `yaml:"name"`     // templated with {{ .Inputs.x }}; defaults to the task or operation ID
+	Scope    LockScope     `yaml:"scope"`    // env (default) prefixes the name with project and env; global does not
+	Lease    time.Duration `yaml:"lease"`    // expiry if the holder stops renewing, defaults to 5m
+	Resource string        `yaml:"resource"` // postgres resource to also take an advisory lock on
+}
+
+// MinLockLease is the shortest lease a lock may set. The lease is renewed
+// every third of it, so it must leave time for a renewal to reach SQLite.
+const MinLockLease = time.Second
+
+type BackoffType string
+
+const (
+	BackoffFixed       BackoffType = "fixed"
+	BackoffExponential BackoffType = "exponential"
+)
+
+// Backoff controls the delay between retry attempts.
+type Backoff struct {
+	Type     BackoffType   `yaml:"type"`      // defaults to fixed
+	Delay    time.Duration `yaml:"delay"`     // base delay, defaults to 1s
+	MaxDelay time.Duration `yaml:"max_delay"` // cap for exponential backoff, defaults to 30s
+	Jitter   bool          `yaml:"jitter"`    // randomize each delay between 50% and 100%
+}
+
+// RetryPolicy is shared by task steps and operations. RetryOn entries are
+// "error" (any execution error), an HTTP status code such as "503", or a
+// status class such as "5xx"; it defaults to ["error"].
+type RetryPolicy struct {
+	Retries int           `yaml:"retries"`
+	Backoff Backoff       `yaml:"backoff"`
+	RetryOn []string      `yaml:"retry_on"`
+	Timeout time.Duration `yaml:"timeout"` // per attempt
 }
 
 type OpenAPIBackend struct {
@@ -89,55 +229,212 @@ const (
 )
 
 type TaskStep struct {
-	ID       string      `yaml:"id"`
-	Type     string      `yaml:"type"`     // "http" | "postgres" | "redis" | "sleep"
-	Resource string      `yaml:"resource"` // key in resources.* maps (except sleep)
-	Method   string      `yaml:"method"`   // http
-	Path     string      `yaml:"path"`     // http
-	Query    string      `yaml:"query"`    // postgres
-	Command  string      `yaml:"command"`  // redis
-	Seconds  int         `yaml:"seconds"`  // sleep
-	OnError  StepOnError `yaml:"on_error"`
+	ID        string            `yaml:"id"`
+	Type      string            `yaml:"type"`      // "http" | "postgres" | "redis" | "sleep" | "task" | "operation"
+	Resource  string            `yaml:"resource"`  // key in resources.* maps (except sleep, task, operation)
+	Method    string            `yaml:"method"`    // http
+	Path      string            `yaml:"path"`      // http
+	Query     string            `yaml:"query"`     // postgres
+	Command   string            `yaml:"command"`   // redis
+	Seconds   int               `yaml:"seconds"`   // sleep
+	Task      string            `yaml:"task"`      // task: ID of the task to invoke
+	Operation string            `yaml:"operation"` // operation: ID of the operation to invoke
+	Inputs    map[string]string `yaml:"inputs"`    // task: inputs passed to the nested task (templated)
+	OnError   StepOnError       `yaml:"on_error"`
+	Parallel  *ParallelGroup    `yaml:"parallel"`  // when set, the step is a group of concurrent child steps
+	Rollback  []TaskStep        `yaml:"rollback"`  // compensating steps run if the task fails after this step completed
+	ReadOnly  bool              `yaml:"read_only"` // safe to execute during plan previews (GET/HEAD http steps always are)
+	Retry     RetryPolicy       `yaml:",inline"`
+}
+
+// ParallelGroup runs its child steps concurrently. MaxConcurrency <= 0 means
+// all children start at once.
+type ParallelGroup struct {
+	MaxConcurrency int        `yaml:"max_concurrency"`
+	Steps          []TaskStep `yaml:"steps"`
+}
+
+// TaskInput declares a named value a task accepts. Inputs are available to
+// step paths, queries, commands and nested task inputs as {{ .Inputs.<name> }}.
+type TaskInput struct {
+	Name     string `yaml:"name"`
+	Label    string `yaml:"label"`
+	Default  string `yaml:"default"`
+	Required bool   `yaml:"required"`
 }
 
 type Task struct {
 	ID              string        `yaml:"id"`
 	Label           string        `yaml:"label"`
 	AllowedRoles    []string      `yaml:"allowed_roles"`
+	Permissions     []string      `yaml:"permissions"` // alternative to allowed_roles: all are required
+	Tags            []string      `yaml:"tags"`        // ops.run:<tag> admits the task
 	RiskLevel       RiskLevel     `yaml:"risk_level"`
 	RequireYubiKey  bool          `yaml:"require_yubikey"`
 	OnError         OnErrorPolicy `yaml:"on_error"`
+	Inputs          []TaskInput   `yaml:"inputs"`
 	Steps           []TaskStep    `yaml:"steps"`
+	Rollback        []TaskStep    `yaml:"rollback"`          // run after step rollbacks when the task fails
+	RollbackOnError OnErrorPolicy `yaml:"rollback_on_error"` // defaults to best_effort
+	Timeout         time.Duration `yaml:"timeout"`           // whole-task budget, defaults to 60s
+	Lock            *Lock         `yaml:"lock"`
 	SummaryTemplate string        `yaml:"summary_template"`
 }
 
+// Schedule runs a task on a cron schedule under a service identity when
+// lazyadmin runs in scheduler mode.
+type Schedule struct {
+	ID     string            `yaml:"id"`
+	Cron   string            `yaml:"cron"`   // five-field cron expression or @hourly/@daily/..., in local time
+	Task   string            `yaml:"task"`   // ID of the task to run
+	Inputs map[string]string `yaml:"inputs"` // task inputs
+	RunAs  string            `yaml:"run_as"` // ID of the config user the task runs as
+}
+
 type Config struct {
-	Project    string          `yaml:"project"`
-	Env        string          `yaml:"env"`
-	Logging    LoggingConfig   `yaml:"logging"`
-	Auth       AuthConfig      `yaml:"auth"`
-	Users      []User          `yaml:"users"`
-	Resources  ResourcesConfig `yaml:"resources"`
-	Operations []Operation     `yaml:"operations"`
-	OpenAPI    OpenAPIConfig   `yaml:"openapi"`
-	Tasks      []Task          `yaml:"tasks"`
+	Project       string             `yaml:"project"`
+	Env           string             `yaml:"env"`
+	Logging       LoggingConfig      `yaml:"logging"`
+	Auth          AuthConfig         `yaml:"auth"`
+	Roles         []Role             `yaml:"roles"`
+	Access        []AccessRule       `yaml:"access"`
+	Elevation     ElevationConfig    `yaml:"elevation"`
+	Notifications []NotificationSink `yaml:"notifications"`
+	Users         []User             `yaml:"users"`
+	Resources     ResourcesConfig    `yaml:"resources"`
+	Operations    []Operation        `yaml:"operations"`
+	OpenAPI       OpenAPIConfig      `yaml:"openapi"`
+	Tasks         []Task             `yaml:"tasks"`
+	Schedules     []Schedule         `yaml:"schedules"`
+	RiskPolicy    RiskPolicy         `yaml:"risk_policy"`
+	Secrets       SecretsConfig      `yaml:"secrets"`
+	// Environments override resources, user roles and the risk policy per
+	// environment; env: names the default one. See ForEnvironment.
+	Environments map[string]Environment `yaml:"environments"`
+
+	base  *Config  // the loaded configuration, when this one is for an environment
+	files []string // files the configuration was loaded from
+	hash  string   // SHA-256 of those files, see Hash
+
+	manifest []byte // what a signature covers, see Manifest
 }
 
+// Load reads the configuration file named by LAZYADMIN_CONFIG_PATH, or
+// config/lazyadmin.yaml, with the files it includes and the overlays listed
+// in LAZYADMIN_CONFIG_OVERLAYS. See LoadFiles.
+//
+// When TrustFile exists, the configuration must carry a valid signature by
+// one of its keys; see Verify.
 func Load() (*Config, error) {
-	path := os.Getenv("LAZYADMIN_CONFIG_PATH")
-	if path == "" {
-		path = "config/lazyadmin.yaml"
+	cfg, err := LoadFiles(Path(), Overlays()...)
+	if err != nil {
+		return nil, err
 	}
+	if err := cfg.Verify(TrustFile); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
 
-	data, err := os.ReadFile(path)
+// Path returns the path of the main configuration file.
+func Path() string {
+	if path := os.Getenv("LAZYADMIN_CONFIG_PATH"); path != "" {
+		return path
+	}
+	return "config/lazyadmin.yaml"
+}
+
+// Overlays returns the overlay files listed in LAZYADMIN_CONFIG_OVERLAYS.
+func Overlays() []string {
+	var out []string
+	for _, p := range filepath.SplitList(os.Getenv(OverlaysEnv)) {
+		if p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// LoadFiles reads the configuration file at path, merging in the files it
+// lists under include: (paths or globs, relative to the including file) and
+// then the overlays, in order. See compose for the merge rules.
+func LoadFiles(path string, overlays ...string) (*Config, error) {
+	c, err := compose(path, overlays)
 	if err != nil {
-		return nil, fmt.Errorf("read config: %w", err)
+		return nil, err
 	}
 
 	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		return nil, fmt.Errorf("parse config: %w", err)
+	if err := c.decode(&cfg); err != nil {
+		return nil, err
 	}
-
 	return &cfg, nil
 }
+
+// Render returns the configuration LoadFiles would load from path and
+// overlays as one YAML document, with anchors and merge keys expanded,
+// headed by a comment listing the files it was merged from.
+func Render(path string, overlays ...string) ([]byte, error) {
+	c, err := compose(path, overlays)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	b.WriteString("# Merged from:\n")
+	for _, f := range c.files() {
+		fmt.Fprintf(&b, "#   %s\n", f)
+	}
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(&yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{c.root}}); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// Files returns the files the configuration was loaded from, in load
+// order.
+func (c *Config) Files() []string {
+	return c.Base().files
+}
+
+// Hash returns the hex SHA-256 of the files the configuration was loaded
+// from, in load order, identifying the configuration in audit entries. It
+// is empty for a configuration not loaded from files.
+func (c *Config) Hash() string {
+	return c.Base().hash
+}
+
+// FindTask returns the task with the given ID.
+func (c *Config) FindTask(id string) (*Task, bool) {
+	for i := range c.Tasks {
+		if c.Tasks[i].ID == id {
+			return &c.Tasks[i], true
+		}
+	}
+	return nil, false
+}
+
+// FindOperation returns the operation with the given ID.
+func (c *Config) FindOperation(id string) (*Operation, bool) {
+	for i := range c.Operations {
+		if c.Operations[i].ID == id {
+			return &c.Operations[i], true
+		}
+	}
+	return nil, false
+}
+
+// FindUser returns the config user with the given ID.
+func (c *Config) FindUser(id string) (*User, bool) {
+	for i := range c.Users {
+		if c.Users[i].ID == id {
+			return &c.Users[i], true
+		}
+	}
+	return nil, false
+}
@@ -1,9 +1,22 @@
 package config
 
 import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
 )
 
 func TestLoad(t *testing.T) {
@@ -277,3 +290,1092 @@ func TestLoad_MissingFile(t *testing.T) {
 		t.Error("Load() error = nil, want error")
 	}
 }
+
+func TestValidate(t *testing.T) {
+	ops := []Operation{{ID: "health"}}
+	callTask := func(id string, calls ...string) Task {
+		task := Task{ID: id}
+		for _, c := range calls {
+			task.Steps = append(task.Steps, TaskStep{ID: "call_" + c, Type: "task", Task: c})
+		}
+		return task
+	}
+
+	tests := []struct {
+		name    string
+		tasks   []Task
+		wantErr string
+	}{
+		{
+			name: "valid composition",
+			tasks: []Task{
+				callTask("a", "b", "c"),
+				callTask("b", "c"),
+				{ID: "c", Steps: []TaskStep{{ID: "op", Type: "operation", Operation: "health"}}},
+			},
+		},
+		{
+			name:    "unknown task",
+			tasks:   []Task{callTask("a", "missing")},
+			wantErr: `unknown task "missing"`,
+		},
+		{
+			name:    "unknown operation",
+			tasks:   []Task{{ID: "a", Steps: []TaskStep{{ID: "op", Type: "operation", Operation: "nope"}}}},
+			wantErr: `unknown operation "nope"`,
+		},
+		{
+			name:    "self recursion",
+			tasks:   []Task{callTask("a", "a")},
+			wantErr: "task call cycle: a -> a",
+		},
+		{
+			name:    "indirect cycle through parallel group",
+			tasks:   []Task{callTask("a", "b"), {ID: "b", Steps: []TaskStep{{ID: "g", Parallel: &ParallelGroup{Steps: []TaskStep{{ID: "x", Type: "task", Task: "a"}}}}}}},
+			wantErr: "task call cycle: a -> b -> a",
+		},
+		{
+			name:    "caller without step-up",
+			tasks:   []Task{callTask("a", "b"), {ID: "b", RequireYubiKey: true}},
+			wantErr: "task a step call_b: task b requires a YubiKey step-up, so a must too",
+		},
+		{
+			name:  "caller with step-up",
+			tasks: []Task{{ID: "a", RequireYubiKey: true, Steps: callTask("a", "b").Steps}, {ID: "b", RequireYubiKey: true}},
+		},
+		{
+			name:    "duplicate step id",
+			tasks:   []Task{{ID: "a", Steps: []TaskStep{{ID: "op", Type: "operation", Operation: "health"}, {ID: "op", Type: "operation", Operation: "health"}}}},
+			wantErr: `task a: duplicate step id "op"`,
+		},
+		{
+			name: "duplicate step id in parallel group and rollback",
+			tasks: []Task{{
+				ID:       "a",
+				Steps:    []TaskStep{{ID: "g", Parallel: &ParallelGroup{Steps: []TaskStep{{ID: "undo", Type: "operation", Operation: "health"}}}}},
+				Rollback: []TaskStep{{ID: "undo", Type: "operation", Operation: "health"}},
+			}},
+			wantErr: `task a: duplicate step id "undo"`,
+		},
+		{
+			name:    "unknown lock scope",
+			tasks:   []Task{{ID: "a", Lock: &Lock{Scope: "cluster"}}},
+			wantErr: `lock: unknown scope "cluster"`,
+		},
+		{
+			name:    "unknown lock resource",
+			tasks:   []Task{{ID: "a", Lock: &Lock{Resource: "db"}}},
+			wantErr: `lock: unknown postgres resource "db"`,
+		},
+		{
+			name:    "lock lease too short",
+			tasks:   []Task{{ID: "a", Lock: &Lock{Lease: time.Nanosecond}}},
+			wantErr: "lock: lease 1ns is shorter than 1s",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Operations: ops, Tasks: tt.tasks}
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_Schedules(t *testing.T) {
+	base := Config{
+		Users: []User{{ID: "svc_backup", SSHUsers: []string{"svc"}, Roles: []string{"admin"}}},
+		Tasks: []Task{
+			{ID: "backup"},
+			{ID: "restore", RequireYubiKey: true},
+			{ID: "purge", RiskLevel: RiskHigh},
+		},
+		RiskPolicy: RiskPolicy{RequireYubiKey: []RiskLevel{RiskHigh}},
+	}
+
+	tests := []struct {
+		name      string
+		schedules []Schedule
+		wantErr   string
+	}{
+		{
+			name:      "valid",
+			schedules: []Schedule{{ID: "nightly", Cron: "30 2 * * *", Task: "backup", RunAs: "svc_backup"}},
+		},
+		{
+			name:      "bad cron",
+			schedules: []Schedule{{ID: "nightly", Cron: "30 25 * * *", Task: "backup", RunAs: "svc_backup"}},
+			wantErr:   "schedule nightly: cron",
+		},
+		{
+			name:      "unknown task",
+			schedules: []Schedule{{ID: "nightly", Cron: "@daily", Task: "reindex", RunAs: "svc_backup"}},
+			wantErr:   `unknown task "reindex"`,
+		},
+		{
+			name:      "task requires yubikey",
+			schedules: []Schedule{{ID: "nightly", Cron: "@daily", Task: "restore", RunAs: "svc_backup"}},
+			wantErr:   "schedule nightly: task restore requires a YubiKey step-up",
+		},
+		{
+			name:      "risk policy requires yubikey",
+			schedules: []Schedule{{ID: "nightly", Cron: "@daily", Task: "purge", RunAs: "svc_backup"}},
+			wantErr:   "schedule nightly: task purge requires a YubiKey step-up",
+		},
+		{
+			name:      "unknown run_as",
+			schedules: []Schedule{{ID: "nightly", Cron: "@daily", Task: "backup", RunAs: "nobody"}},
+			wantErr:   `run_as: unknown user "nobody"`,
+		},
+		{
+			name: "duplicate id",
+			schedules: []Schedule{
+				{ID: "nightly", Cron: "@daily", Task: "backup", RunAs: "svc_backup"},
+				{ID: "nightly", Cron: "@hourly", Task: "backup", RunAs: "svc_backup"},
+			},
+			wantErr: "schedule nightly: duplicate id",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := base
+			cfg.Schedules = tt.schedules
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_SSHKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		users   []User
+		wantErr string
+	}{
+		{
+			name:  "valid",
+			users: []User{{ID: "alice", SSHKeys: []string{"SHA256:abc"}}, {ID: "bob", SSHKeys: []string{"SHA256:def"}}},
+		},
+		{
+			name:    "not a fingerprint",
+			users:   []User{{ID: "alice", SSHKeys: []string{"ssh-ed25519 AAAA"}}},
+			wantErr: "user alice: ssh_keys",
+		},
+		{
+			name:    "shared between users",
+			users:   []User{{ID: "alice", SSHKeys: []string{"SHA256:abc"}}, {ID: "bob", SSHKeys: []string{"SHA256:abc"}}},
+			wantErr: "also mapped to user alice",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{Users: tt.users}
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_Roles(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr string
+	}{
+		{
+			name: "valid",
+			cfg: Config{
+				Roles: []Role{
+					{Name: "viewer", Permissions: []string{"audit.read"}},
+					{Name: "billing", Inherits: []string{"viewer"}, Permissions: []string{"ops.run:billing"}},
+				},
+				Users:      []User{{ID: "alice", Roles: []string{"billing"}}},
+				Operations: []Operation{{ID: "invoices", AllowedRoles: []string{"viewer"}}},
+			},
+		},
+		{
+			name:    "unknown inherits",
+			cfg:     Config{Roles: []Role{{Name: "admin", Inherits: []string{"operator"}}}},
+			wantErr: `role admin: inherits unknown role "operator"`,
+		},
+		{
+			name: "cycle",
+			cfg: Config{Roles: []Role{
+				{Name: "a", Inherits: []string{"b"}},
+				{Name: "b", Inherits: []string{"a"}},
+			}},
+			wantErr: "role inheritance cycle: a -> b -> a",
+		},
+		{
+			name:    "unknown permission",
+			cfg:     Config{Roles: []Role{{Name: "admin", Permissions: []string{"users.delete"}}}},
+			wantErr: `role admin: unknown permission "users.delete"`,
+		},
+		{
+			name: "unknown user role",
+			cfg: Config{
+				Roles: []Role{{Name: "admin"}},
+				Users: []User{{ID: "alice", Roles: []string{"owner"}}},
+			},
+			wantErr: `user alice: unknown role "owner"`,
+		},
+		{
+			name: "unknown access rule role",
+			cfg: Config{
+				Roles:  []Role{{Name: "admin"}},
+				Access: []AccessRule{{Allow: AccessGrant{Roles: []string{"support"}, Tags: []string{"billing"}}}},
+			},
+			wantErr: `access[0]: unknown role "support"`,
+		},
+		{
+			name:    "access rule without tags",
+			cfg:     Config{Access: []AccessRule{{Allow: AccessGrant{Roles: []string{"support"}}}}},
+			wantErr: "access[0]: allow needs both roles and tags",
+		},
+		{
+			name:    "unknown operation permission",
+			cfg:     Config{Operations: []Operation{{ID: "restart", Permissions: []string{"ops.run:"}}}},
+			wantErr: `operation restart: unknown permission "ops.run:"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_Elevation(t *testing.T) {
+	tests := []struct {
+		name    string
+		roles   []ElevationRole
+		wantErr string
+	}{
+		{
+			name:  "valid",
+			roles: []ElevationRole{{Role: "owner", MaxDuration: time.Hour, Eligible: []string{"admin"}}, {Role: "operator", AutoApprove: true}},
+		},
+		{
+			name:    "unknown role",
+			roles:   []ElevationRole{{Role: "root"}},
+			wantErr: `elevation: unknown role "root"`,
+		},
+		{
+			name:    "listed twice",
+			roles:   []ElevationRole{{Role: "owner"}, {Role: "owner"}},
+			wantErr: "elevation: role owner listed twice",
+		},
+		{
+			name:    "negative max_duration",
+			roles:   []ElevationRole{{Role: "owner", MaxDuration: -time.Minute}},
+			wantErr: "negative max_duration",
+		},
+		{
+			name:    "unknown eligible role",
+			roles:   []ElevationRole{{Role: "owner", Eligible: []string{"support"}}},
+			wantErr: `unknown eligible role "support"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{Elevation: ElevationConfig{Roles: tt.roles}}
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_BreakGlass(t *testing.T) {
+	sink := NotificationSink{Name: "oncall", Type: "slack", URLEnv: "ONCALL_SLACK_URL"}
+	tests := []struct {
+		name       string
+		breakGlass BreakGlassConfig
+		sinks      []NotificationSink
+		wantErr    string
+	}{
+		{
+			name:       "valid",
+			breakGlass: BreakGlassConfig{Role: "admin", Duration: 15 * time.Minute},
+			sinks:      []NotificationSink{sink, {Name: "siem", Type: "webhook", URLEnv: "SIEM_URL"}},
+		},
+		{
+			name:  "sinks without break-glass",
+			sinks: []NotificationSink{sink},
+		},
+		{
+			name:       "unknown role",
+			breakGlass: BreakGlassConfig{Role: "root"},
+			sinks:      []NotificationSink{sink},
+			wantErr:    `auth.break_glass: unknown role "root"`,
+		},
+		{
+			name:       "negative duration",
+			breakGlass: BreakGlassConfig{Role: "admin", Duration: -time.Minute},
+			sinks:      []NotificationSink{sink},
+			wantErr:    "auth.break_glass: negative duration",
+		},
+		{
+			name:       "no sinks",
+			breakGlass: BreakGlassConfig{Role: "admin"},
+			wantErr:    "at least one notification sink is required",
+		},
+		{
+			name:    "duplicate sink",
+			sinks:   []NotificationSink{sink, sink},
+			wantErr: `missing or duplicate name "oncall"`,
+		},
+		{
+			name:    "unknown sink type",
+			sinks:   []NotificationSink{{Name: "mail", Type: "email", URLEnv: "MAIL_URL"}},
+			wantErr: "notification mail: type must be webhook or slack",
+		},
+		{
+			name:    "missing url_env",
+			sinks:   []NotificationSink{{Name: "siem", Type: "webhook"}},
+			wantErr: "notification siem: set one of url_env and url",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{Auth: AuthConfig{BreakGlass: tt.breakGlass}, Notifications: tt.sinks}
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_UserSource(t *testing.T) {
+	for _, source := range []string{"", UserSourceConfig, UserSourceStore} {
+		cfg := Config{Auth: AuthConfig{UserSource: source}}
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("Validate() with user_source %q error = %v", source, err)
+		}
+	}
+	cfg := Config{Auth: AuthConfig{UserSource: "ldap"}}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "auth: user_source must be config or store") {
+		t.Errorf("Validate() with user_source ldap error = %v", err)
+	}
+}
+
+func TestValidate_Lockout(t *testing.T) {
+	tests := []struct {
+		lockout LockoutConfig
+		wantErr string
+	}{
+		{LockoutConfig{}, ""},
+		{LockoutConfig{MaxFailures: 5, Duration: 10 * time.Minute}, ""},
+		{LockoutConfig{MaxFailures: -1}, "auth.lockout: negative max_failures"},
+		{LockoutConfig{MaxFailures: 5, Duration: -time.Minute}, "auth.lockout: negative duration"},
+	}
+	for _, tt := range tests {
+		cfg := Config{Auth: AuthConfig{Lockout: tt.lockout}}
+		err := cfg.Validate()
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("Validate() with lockout %+v error = %v, want %q", tt.lockout, err, tt.wantErr)
+		}
+	}
+	if d := (LockoutConfig{MaxFailures: 3}).DurationOrDefault(); d != DefaultLockoutDuration {
+		t.Errorf("DurationOrDefault() = %v, want %v", d, DefaultLockoutDuration)
+	}
+}
+
+func TestAddYubiKeyCredential(t *testing.T) {
+	const original = `# lazyadmin config
+project: demo
+
+users:
+  # the on-call admin
+  - id: alice
+    ssh_users: ["alice"]
+    roles: ["admin"]
+
+  - id: bob
+    ssh_users: ["bob"]
+    roles: ["operator"]
+    yubikey_credentials:
+      - rp_id: lazyadmin.local
+        credential_id: bobcred
+        public_key: bobkey
+        name: primary
+`
+	path := filepath.Join(t.TempDir(), "lazyadmin.yaml")
+	if err := os.WriteFile(path, []byte(original), 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	cred := YubiKeyCredential{RPID: "lazyadmin.local", CredentialID: "alicecred", PublicKey: "alicekey", Name: "blue backup key", UserHandle: "aGFuZGxl"}
+	if err := AddYubiKeyCredential(path, "alice", cred); err != nil {
+		t.Fatalf("AddYubiKeyCredential() error = %v", err)
+	}
+	second := YubiKeyCredential{RPID: "lazyadmin.local", CredentialID: "bobcred2", PublicKey: "bobkey2", Name: "backup"}
+	if err := AddYubiKeyCredential(path, "bob", second); err != nil {
+		t.Fatalf("AddYubiKeyCredential(bob) error = %v", err)
+	}
+
+	for _, tc := range []struct {
+		user    string
+		cred    YubiKeyCredential
+		wantErr error
+	}{
+		{"carol", YubiKeyCredential{RPID: "lazyadmin.local", CredentialID: "new"}, ErrUserNotInConfig},
+		{"alice", YubiKeyCredential{RPID: "lazyadmin.local", CredentialID: "bobcred"}, ErrDuplicateCredential},
+		{"bob", YubiKeyCredential{RPID: "lazyadmin.local", CredentialID: "new", Name: "primary"}, ErrDuplicateCredential},
+	} {
+		if err := AddYubiKeyCredential(path, tc.user, tc.cred); !errors.Is(err, tc.wantErr) {
+			t.Errorf("AddYubiKeyCredential(%s, %+v) error = %v, want %v", tc.user, tc.cred, err, tc.wantErr)
+		}
+	}
+	if u, err := CheckYubiKeyCredential(path, "alice", YubiKeyCredential{Name: "spare"}); err != nil || u.UserHandle() != "aGFuZGxl" {
+		t.Errorf("CheckYubiKeyCredential(alice) = %v, %v; want handle aGFuZGxl", u, err)
+	}
+	if _, err := CheckYubiKeyCredential(path, "alice", YubiKeyCredential{Name: "blue backup key"}); !errors.Is(err, ErrDuplicateCredential) {
+		t.Errorf("CheckYubiKeyCredential(alice, blue backup key) error = %v, want ErrDuplicateCredential", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"# lazyadmin config", "# the on-call admin", "name: blue backup key", "user_handle: aGFuZGxl\n\n  - id: bob"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("patched config lacks %q:\n%s", want, data)
+		}
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o640 {
+		t.Errorf("patched config mode = %v, want 0640", info.Mode().Perm())
+	}
+
+	t.Setenv("LAZYADMIN_CONFIG_PATH", path)
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	alice, _ := cfg.FindUser("alice")
+	bob, _ := cfg.FindUser("bob")
+	if len(alice.YubiKeyCreds) != 1 || alice.YubiKeyCreds[0] != cred || len(bob.YubiKeyCreds) != 2 {
+		t.Errorf("credentials after patch: alice %+v, bob %+v", alice.YubiKeyCreds, bob.YubiKeyCreds)
+	}
+}
+
+func TestForEnvironment(t *testing.T) {
+	cfg := &Config{
+		Env: "dev",
+		Users: []User{
+			{ID: "alice", Roles: []string{"admin"}},
+			{ID: "bob", Roles: []string{"operator"}},
+		},
+		Resources: ResourcesConfig{HTTP: map[string]HTTPResource{
+			"api":  {BaseURL: "http://localhost:8080"},
+			"auth": {BaseURL: "http://localhost:9000"},
+		}},
+		Tasks: []Task{
+			{ID: "deploy", RiskLevel: RiskHigh},
+			{ID: "restart", RiskLevel: RiskMedium},
+		},
+		Environments: map[string]Environment{
+			"dev": {},
+			"prod": {
+				Production: true,
+				Resources:  ResourcesConfig{HTTP: map[string]HTTPResource{"api": {BaseURL: "https://api.example.com"}}},
+				UserRoles:  map[string][]string{"alice": {"operator"}, "carol": {"read_only"}},
+				RiskPolicy: &RiskPolicy{RequireYubiKey: []RiskLevel{RiskHigh}, Deny: []RiskLevel{RiskMedium}},
+			},
+		},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	prod, err := cfg.ForEnvironment("prod")
+	if err != nil {
+		t.Fatalf("ForEnvironment(prod) error = %v", err)
+	}
+	alice, _ := prod.FindUser("alice")
+	deploy, _ := prod.FindTask("deploy")
+	switch {
+	case prod.Env != "prod" || !prod.Production():
+		t.Errorf("prod: Env = %q, Production() = %v", prod.Env, prod.Production())
+	case prod.Resources.HTTP["api"].BaseURL != "https://api.example.com" || prod.Resources.HTTP["auth"].BaseURL != "http://localhost:9000":
+		t.Errorf("prod resources = %+v", prod.Resources.HTTP)
+	case !slices.Equal(alice.Roles, []string{"operator"}):
+		t.Errorf("prod alice roles = %v, want [operator]", alice.Roles)
+	case !deploy.RequireYubiKey || !prod.RiskPolicy.Denies(RiskMedium):
+		t.Errorf("prod risk policy: deploy require_yubikey = %v, policy %+v", deploy.RequireYubiKey, prod.RiskPolicy)
+	}
+	if roles, ok := prod.EnvironmentRoles("carol"); !ok || !slices.Equal(roles, []string{"read_only"}) {
+		t.Errorf("EnvironmentRoles(carol) = %v, %v", roles, ok)
+	}
+
+	// Switching back starts from the loaded configuration.
+	dev, err := prod.ForEnvironment("dev")
+	if err != nil {
+		t.Fatalf("ForEnvironment(dev) error = %v", err)
+	}
+	alice, _ = dev.FindUser("alice")
+	deploy, _ = dev.FindTask("deploy")
+	if dev.Production() || !slices.Equal(alice.Roles, []string{"admin"}) || deploy.RequireYubiKey || dev.Resources.HTTP["api"].BaseURL != "http://localhost:8080" {
+		t.Errorf("dev after prod: production %v, alice %v, deploy step-up %v, api %v", dev.Production(), alice.Roles, deploy.RequireYubiKey, dev.Resources.HTTP["api"])
+	}
+	if orig, _ := cfg.FindUser("alice"); !slices.Equal(orig.Roles, []string{"admin"}) {
+		t.Errorf("ForEnvironment() modified the loaded config: alice %v", orig.Roles)
+	}
+
+	if _, err := cfg.ForEnvironment("qa"); !errors.Is(err, ErrUnknownEnvironment) {
+		t.Errorf("ForEnvironment(qa) error = %v, want ErrUnknownEnvironment", err)
+	}
+	if got := prod.EnvironmentNames(); !slices.Equal(got, []string{"dev", "prod"}) {
+		t.Errorf("EnvironmentNames() = %v", got)
+	}
+
+	// Without environments, the top-level risk policy still marks tasks.
+	single := &Config{
+		Env:        "dev",
+		Tasks:      []Task{{ID: "deploy", RiskLevel: RiskHigh}},
+		RiskPolicy: RiskPolicy{RequireYubiKey: []RiskLevel{RiskHigh}},
+	}
+	got, err := single.ForEnvironment("")
+	if err != nil {
+		t.Fatalf("ForEnvironment() without environments error = %v", err)
+	}
+	if deploy, _ := got.FindTask("deploy"); !deploy.RequireYubiKey || single.Tasks[0].RequireYubiKey {
+		t.Errorf("without environments: deploy require_yubikey = %v, loaded config modified = %v", deploy.RequireYubiKey, single.Tasks[0].RequireYubiKey)
+	}
+}
+
+func TestValidate_Environments(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr string
+	}{
+		{"no environments", Config{Env: "anything"}, ""},
+		{"undefined env", Config{Env: "qa", Environments: map[string]Environment{"dev": {}}}, `env: "qa" is not defined in environments`},
+		{"unknown role", Config{Env: "dev", Environments: map[string]Environment{"dev": {UserRoles: map[string][]string{"alice": {"root"}}}}},
+			`environments.dev.user_roles.alice: unknown role "root"`},
+		{"unknown risk level", Config{RiskPolicy: RiskPolicy{Deny: []RiskLevel{"extreme"}}}, `risk_policy: unknown risk level "extreme"`},
+		{"unknown env risk level", Config{Env: "dev", Environments: map[string]Environment{"dev": {RiskPolicy: &RiskPolicy{RequireYubiKey: []RiskLevel{"hi"}}}}},
+			`environments.dev.risk_policy: unknown risk level "hi"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_Secrets(t *testing.T) {
+	pg := func(dsn, env string) ResourcesConfig {
+		return ResourcesConfig{Postgres: map[string]PostgresResource{"orders": {DSN: dsn, DSNEnv: env}}}
+	}
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr string
+	}{
+		{"dsn_env", Config{Resources: pg("", "ORDERS_DSN")}, ""},
+		{"dsn reference", Config{Resources: pg("secret://vault/kv/db/orders#dsn", "")}, ""},
+		{"literal dsn", Config{Resources: pg("postgres://localhost/orders", "")}, ""},
+		{"neither", Config{Resources: pg("", "")}, "resources.postgres.orders: set one of dsn and dsn_env"},
+		{"both", Config{Resources: pg("secret://env/X", "X")}, "resources.postgres.orders: set one of dsn and dsn_env"},
+		{"bad provider", Config{Resources: pg("secret://keychain/orders", "")}, `resources.postgres.orders.dsn: bad secret reference: secret://keychain/orders: unknown provider "keychain"`},
+		{"env with key", Config{Resources: ResourcesConfig{HTTP: map[string]HTTPResource{"api": {Token: "secret://env/TOKEN#x"}}}},
+			"resources.http.api.token: bad secret reference: secret://env/TOKEN#x: env references take no key"},
+		{"vault without path", Config{Notifications: []NotificationSink{{Name: "slack", Type: "slack", URL: "secret://vault/kv"}}},
+			"notification slack: url: bad secret reference: secret://vault/kv needs a mount and a path"},
+		{"negative cache_ttl", Config{Secrets: SecretsConfig{CacheTTL: -time.Second}}, "secrets: negative cache_ttl"},
+		{"literal vault token", Config{Secrets: SecretsConfig{Vault: VaultConfig{Token: "hvs.abc"}}}, "secrets.vault.token: must be a secret reference"},
+		{"vault token from vault", Config{Secrets: SecretsConfig{Vault: VaultConfig{Token: "secret://vault/kv/token"}}}, "secrets.vault.token: cannot be read from Vault itself"},
+		{"vault token from systemd", Config{Secrets: SecretsConfig{Vault: VaultConfig{Token: "secret://systemd/vault-token"}}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	ref, err := ParseSecretRef("secret://file/run/secrets/db.yaml#orders.dsn")
+	if want := (SecretRef{Provider: SecretFile, Path: "/run/secrets/db.yaml", Key: "orders.dsn"}); err != nil || ref != want {
+		t.Errorf("ParseSecretRef() = %+v, %v; want %+v", ref, err, want)
+	}
+	if s := ref.String(); s != "secret://file/run/secrets/db.yaml#orders.dsn" {
+		t.Errorf("String() = %q", s)
+	}
+}
+
+func TestLoadFiles(t *testing.T) {
+	write := func(t *testing.T, dir string, files map[string]string) {
+		t.Helper()
+		for name, data := range files {
+			path := filepath.Join(dir, name)
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	tests := []struct {
+		name     string
+		files    map[string]string
+		overlays []string
+		wantErr  []string // substrings of the error
+		check    func(t *testing.T, cfg *Config)
+	}{
+		{
+			name: "includes, globs and anchors across files",
+			files: map[string]string{
+				"main.yaml": `project: demo
+env: dev
+include: [users.yaml, "tasks.d/*.yaml"]
+defaults: &http_step
+  type: http
+  resource: api
+resources:
+  http:
+    api: {base_url: "http://localhost:8080"}
+`,
+				"users.yaml": `users:
+  - {id: alice, ssh_users: [alice], roles: [admin]}
+`,
+				"tasks.d/a.yaml": `tasks:
+  - id: deploy
+    steps:
+      - {<<: *http_step, id: call, method: POST, path: /deploy}
+`,
+				"tasks.d/b.yaml": `---
+tasks:
+  - id: restart
+    steps:
+      - <<: *http_step
+        id: call
+        method: POST
+        path: /restart
+resources:
+  http:
+    auth: {base_url: "http://localhost:9000"}
+`,
+			},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Project != "demo" || len(cfg.Users) != 1 || len(cfg.Tasks) != 2 || len(cfg.Resources.HTTP) != 2 {
+					t.Fatalf("merged config = %+v", cfg)
+				}
+				if cfg.Tasks[0].ID != "deploy" || cfg.Tasks[1].ID != "restart" {
+					t.Errorf("tasks = %s, %s; want deploy, restart in load order", cfg.Tasks[0].ID, cfg.Tasks[1].ID)
+				}
+				if s := cfg.Tasks[1].Steps[0]; s.Type != "http" || s.Resource != "api" || s.Path != "/restart" {
+					t.Errorf("step from anchor = %+v", s)
+				}
+				if got := len(cfg.Files()); got != 4 {
+					t.Errorf("Files() = %v, want 4 files", cfg.Files())
+				}
+			},
+		},
+		{
+			name: "overlays replace values and items by ID",
+			files: map[string]string{
+				"main.yaml": `project: demo
+env: dev
+auth: {require_yubikey: false, session_idle_timeout: 10m}
+users:
+  - {id: alice, ssh_users: [alice], roles: [admin]}
+  - {id: bob, ssh_users: [bob], roles: [operator]}
+`,
+				"prod.yaml": `env: prod
+auth: {require_yubikey: true}
+users:
+  - {id: bob, ssh_users: [bob], roles: [read_only]}
+  - {id: carol, ssh_users: [carol], roles: [operator]}
+`,
+			},
+			overlays: []string{"prod.yaml"},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Env != "prod" || !cfg.Auth.RequireYubiKey || cfg.Auth.SessionIdleTimeout != 10*time.Minute {
+					t.Errorf("env = %q, auth = %+v", cfg.Env, cfg.Auth)
+				}
+				var ids []string
+				for _, u := range cfg.Users {
+					ids = append(ids, u.ID+":"+strings.Join(u.Roles, ","))
+				}
+				if want := []string{"alice:admin", "bob:read_only", "carol:operator"}; !slices.Equal(ids, want) {
+					t.Errorf("users = %v, want %v", ids, want)
+				}
+			},
+		},
+		{
+			name: "duplicate ID cites both files",
+			files: map[string]string{
+				"main.yaml":      "include: [tasks.d/*.yaml]\ntasks:\n  - id: deploy\n",
+				"tasks.d/a.yaml": "\ntasks:\n  - id: deploy\n",
+			},
+			wantErr: []string{ErrDuplicateID.Error(), `task "deploy"`, "main.yaml:3", "a.yaml:3"},
+		},
+		{
+			name: "duplicate ID within a file",
+			files: map[string]string{
+				"main.yaml": "users:\n  - id: alice\n  - id: alice\n",
+			},
+			wantErr: []string{`user "alice"`, "main.yaml:2", "main.yaml:3"},
+		},
+		{
+			name: "value set by two includes",
+			files: map[string]string{
+				"main.yaml":  "project: demo\ninclude: other.yaml\n",
+				"other.yaml": "project: other\n",
+			},
+			wantErr: []string{"project", ErrConflict.Error(), "main.yaml:1", "other.yaml:1"},
+		},
+		{
+			name: "include cycle",
+			files: map[string]string{
+				"main.yaml": "include: a.yaml\n",
+				"a.yaml":    "include: main.yaml\n",
+			},
+			wantErr: []string{ErrIncludeCycle.Error()},
+		},
+		{
+			name:    "missing include",
+			files:   map[string]string{"main.yaml": "include: nope.yaml\n"},
+			wantErr: []string{"nope.yaml"},
+		},
+		{
+			name: "syntax error names the file",
+			files: map[string]string{
+				"main.yaml": "include: bad.yaml\n",
+				"bad.yaml":  "project: demo\ntasks: [\n",
+			},
+			wantErr: []string{"bad.yaml:"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			write(t, dir, tt.files)
+			var overlays []string
+			for _, o := range tt.overlays {
+				overlays = append(overlays, filepath.Join(dir, o))
+			}
+
+			cfg, err := LoadFiles(filepath.Join(dir, "main.yaml"), overlays...)
+			if tt.wantErr != nil {
+				if err == nil {
+					t.Fatal("LoadFiles() error = nil, want error")
+				}
+				for _, want := range tt.wantErr {
+					if !strings.Contains(err.Error(), want) {
+						t.Errorf("LoadFiles() error = %v, want it to mention %q", err, want)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadFiles() error = %v", err)
+			}
+			tt.check(t, cfg)
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	dir := t.TempDir()
+	main := filepath.Join(dir, "main.yaml")
+	if err := os.WriteFile(main, []byte("project: demo\ninclude: ops.yaml\nbase: &b {type: http, target: api}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "ops.yaml"), []byte("operations:\n  - {<<: *b, id: health}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := Render(main)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	for _, want := range []string{"ops.yaml", "id: health", "target: api"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("Render() lacks %q:\n%s", want, out)
+		}
+	}
+	for _, unwanted := range []string{"include:", "*b", "<<"} {
+		if strings.Contains(string(out), unwanted) {
+			t.Errorf("Render() has %q:\n%s", unwanted, out)
+		}
+	}
+
+	// The rendered config loads to the same operations.
+	rendered := filepath.Join(dir, "rendered.yaml")
+	if err := os.WriteFile(rendered, out, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadFiles(rendered)
+	if err != nil {
+		t.Fatalf("LoadFiles(rendered) error = %v", err)
+	}
+	if op, ok := cfg.FindOperation("health"); !ok || op.Target != "api" {
+		t.Errorf("rendered operation = %+v", op)
+	}
+}
+
+func TestHash(t *testing.T) {
+	dir := t.TempDir()
+	main := filepath.Join(dir, "main.yaml")
+	write := func(name, data string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("main.yaml", "project: demo\ninclude: ops.yaml\n")
+	write("ops.yaml", "operations: []\n")
+
+	load := func() string {
+		cfg, err := LoadFiles(main)
+		if err != nil {
+			t.Fatalf("LoadFiles() error = %v", err)
+		}
+		return cfg.Hash()
+	}
+	first := load()
+	if len(first) != 64 || load() != first {
+		t.Fatalf("Hash() = %q, want a stable SHA-256", first)
+	}
+	write("ops.yaml", "operations: [] # edited\n")
+	if load() == first {
+		t.Error("Hash() unchanged after editing an included file")
+	}
+
+	cfg, err := (&Config{Env: "dev", Environments: map[string]Environment{"dev": {}}}).ForEnvironment("dev")
+	if err != nil || cfg.Hash() != "" {
+		t.Errorf("Hash() of an unloaded config = %q, %v", cfg.Hash(), err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	main := filepath.Join(dir, "main.yaml")
+	if err := os.WriteFile(main, []byte("project: demo\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := make(chan struct{}, 10)
+	go Watch(ctx, func() []string { return []string{main} }, 10*time.Millisecond, func() { changed <- struct{}{} })
+
+	select {
+	case <-changed:
+		t.Fatal("Watch() reported a change before any")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// A new file in the directory, as matched by an include glob, is a
+	// change too.
+	if err := os.WriteFile(filepath.Join(dir, "extra.yaml"), []byte("tasks: []\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Watch() did not report a new file")
+	}
+	select {
+	case <-changed:
+		t.Fatal("Watch() reported one change twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	main := filepath.Join(dir, "main.yaml")
+	trust := filepath.Join(dir, "trusted_keys")
+	write := func(path, data string) {
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(main, "project: demo\ninclude: ops.yaml\n")
+	write(filepath.Join(dir, "ops.yaml"), "operations: []\n")
+
+	newKey := func() (ed25519.PrivateKey, ssh.Signer) {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signer, err := ssh.NewSignerFromKey(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return priv, signer
+	}
+	priv, signer := newKey()
+	_, other := newKey()
+	write(trust, "# config signers\n"+string(ssh.MarshalAuthorizedKey(signer.PublicKey())))
+
+	ownerErr := error(nil)
+	defer func(f func(os.FileInfo) error) { checkTrustOwner = f }(checkTrustOwner)
+	checkTrustOwner = func(os.FileInfo) error { return ownerErr }
+
+	load := func() *Config {
+		cfg, err := LoadFiles(main)
+		if err != nil {
+			t.Fatalf("LoadFiles() error = %v", err)
+		}
+		return cfg
+	}
+	if m := string(load().Manifest()); !strings.HasPrefix(m, manifestHeader) || !strings.Contains(m, "  main.yaml\n") || !strings.HasSuffix(m, "  ops.yaml\n") {
+		t.Fatalf("Manifest() = %q", m)
+	}
+	sshSig := func(s ssh.Signer) func(*Config) []byte {
+		return func(cfg *Config) []byte {
+			sig, err := Sign(cfg.Manifest(), s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return sig
+		}
+	}
+
+	tests := []struct {
+		name    string
+		sign    func(*Config) []byte // nil leaves the config unsigned
+		edit    bool                 // edit the include after signing
+		owner   error
+		noTrust bool
+		wantErr error
+	}{
+		{name: "ssh signature", sign: sshSig(signer)},
+		{name: "raw ed25519", sign: func(cfg *Config) []byte {
+			return []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(priv, cfg.Manifest())) + "\n")
+		}},
+		{name: "include edited after signing", sign: sshSig(signer), edit: true, wantErr: ErrBadSignature},
+		{name: "untrusted key", sign: sshSig(other), wantErr: ErrBadSignature},
+		{name: "garbage", sign: func(*Config) []byte { return []byte("not a signature") }, wantErr: ErrBadSignature},
+		{name: "unsigned", wantErr: ErrUnsigned},
+		{name: "trust file not root-owned", sign: sshSig(signer), owner: ErrUntrustedTrust, wantErr: ErrUntrustedTrust},
+		{name: "no trust file", noTrust: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			write(filepath.Join(dir, "ops.yaml"), "operations: []\n")
+			os.Remove(SignaturePath(main))
+			if tt.sign != nil {
+				write(SignaturePath(main), string(tt.sign(load())))
+			}
+			if tt.edit {
+				write(filepath.Join(dir, "ops.yaml"), "operations: [] # edited\n")
+			}
+			ownerErr = tt.owner
+			trustFile := trust
+			if tt.noTrust {
+				trustFile = filepath.Join(dir, "missing")
+			}
+
+			err := load().Verify(trustFile)
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil) != (err == nil) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifySignature_Namespace(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []ssh.PublicKey{signer.PublicKey()}
+	manifest := []byte(manifestHeader)
+
+	// A valid signature of the same text made for another purpose, such as
+	// a git commit, must not pass as a configuration signature.
+	h := sha512.Sum512(manifest)
+	sig, err := signer.Sign(rand.Reader, sshsigSignedData("git", "sha512", h[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob := binary.BigEndian.AppendUint32([]byte("SSHSIG"), 1)
+	for _, f := range [][]byte{signer.PublicKey().Marshal(), []byte("git"), nil, []byte("sha512"), ssh.Marshal(sig)} {
+		blob = appendSSHString(blob, f)
+	}
+	armored := pem.EncodeToMemory(&pem.Block{Type: "SSH SIGNATURE", Bytes: blob})
+	if err := VerifySignature(manifest, armored, keys); !errors.Is(err, ErrBadSignature) || !strings.Contains(err.Error(), "namespace") {
+		t.Errorf("VerifySignature() in namespace git error = %v", err)
+	}
+
+	good, err := Sign(manifest, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifySignature(manifest, good, keys); err != nil {
+		t.Errorf("VerifySignature() error = %v", err)
+	}
+	if err := VerifySignature([]byte("other"), good, keys); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("VerifySignature() of another manifest error = %v", err)
+	}
+}
@@ -0,0 +1,219 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+)
+
+var ErrUnknownEnvironment = errors.New("unknown environment")
+
+// Environment overrides parts of the configuration for one deployment
+// environment, so dev, staging and prod can share one file:
+//
+//	environments:
+//	  prod:
+//	    production: true
+//	    resources:
+//	      http: {api: {base_url: https://api.example.com}}
+//	    user_roles: {alice: [operator]}
+//	    risk_policy: {require_yubikey: [medium, high]}
+type Environment struct {
+	// Production environments get a red banner in the TUI, and switching
+	// into one asks for confirmation.
+	Production bool `yaml:"production"`
+	// Resources are merged over resources:, by type and name.
+	Resources ResourcesConfig `yaml:"resources"`
+	// UserRoles replaces the roles of users, config or store, by user ID.
+	UserRoles map[string][]string `yaml:"user_roles"`
+	// RiskPolicy replaces risk_policy: when set.
+	RiskPolicy *RiskPolicy `yaml:"risk_policy"`
+}
+
+// RiskPolicy sets what tasks of each risk level need.
+type RiskPolicy struct {
+	// RequireYubiKey asks for a FIDO2 step-up before tasks at these risk
+	// levels, as if require_yubikey were set on them.
+	RequireYubiKey []RiskLevel `yaml:"require_yubikey"`
+	// Deny refuses to run or plan tasks at these risk levels.
+	Deny []RiskLevel `yaml:"deny"`
+}
+
+// Denies reports whether the policy refuses tasks at level.
+func (p RiskPolicy) Denies(level RiskLevel) bool {
+	return slices.Contains(p.Deny, level)
+}
+
+// RequiresYubiKey reports whether t needs a FIDO2 step-up before it runs:
+// it sets require_yubikey, or risk_policy asks for one at its risk level.
+func (c *Config) RequiresYubiKey(t Task) bool {
+	return t.RequireYubiKey || slices.Contains(c.RiskPolicy.RequireYubiKey, t.RiskLevel)
+}
+
+// FindStepUpTask returns the ID of task id, or of a task it calls directly
+// or indirectly, that needs a FIDO2 step-up, if there is one.
+func (c *Config) FindStepUpTask(id string) (string, bool) {
+	seen := make(map[string]bool)
+	var find func(id string) (string, bool)
+	find = func(id string) (string, bool) {
+		t, ok := c.FindTask(id)
+		if !ok || seen[id] {
+			return "", false
+		}
+		seen[id] = true
+		if c.RequiresYubiKey(*t) {
+			return id, true
+		}
+		var found string
+		forEachStep(t.allSteps(), func(step TaskStep) {
+			if found == "" && step.Type == "task" {
+				found, _ = find(step.Task)
+			}
+		})
+		return found, found != ""
+	}
+	return find(id)
+}
+
+// EnvironmentNames returns the names of the configured environments,
+// sorted, or just env when there is no environments: section.
+func (c *Config) EnvironmentNames() []string {
+	b := c.Base()
+	if len(b.Environments) == 0 {
+		return []string{b.Env}
+	}
+	names := slices.Collect(maps.Keys(b.Environments))
+	sort.Strings(names)
+	return names
+}
+
+// Production reports whether the current environment is marked production.
+func (c *Config) Production() bool {
+	return c.Environments[c.Env].Production
+}
+
+// EnvironmentRoles returns the roles the current environment gives userID
+// instead of their own, if it overrides them.
+func (c *Config) EnvironmentRoles(userID string) ([]string, bool) {
+	roles, ok := c.Environments[c.Env].UserRoles[userID]
+	return roles, ok
+}
+
+// ForEnvironment returns the configuration of environment name: a copy of
+// the file's configuration with Env set to name and that environment's
+// overrides applied. An empty name selects env:. Without environments: the
+// copy only marks the tasks risk_policy requires a YubiKey for. Calling it
+// on the result starts again from the file's configuration, so environments
+// can be switched back and forth.
+func (c *Config) ForEnvironment(name string) (*Config, error) {
+	b := c.Base()
+	if name == "" {
+		name = b.Env
+	}
+	if len(b.Environments) == 0 {
+		if name != b.Env {
+			return nil, fmt.Errorf("%w %q: no environments are configured", ErrUnknownEnvironment, name)
+		}
+		out := *b
+		out.base = b
+		out.markStepUpTasks()
+		return &out, nil
+	}
+	env, ok := b.Environments[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownEnvironment, name)
+	}
+
+	out := *b
+	out.base = b
+	out.Env = name
+
+	out.Resources.HTTP = maps.Clone(b.Resources.HTTP)
+	if out.Resources.HTTP == nil && len(env.Resources.HTTP) > 0 {
+		out.Resources.HTTP = make(map[string]HTTPResource)
+	}
+	maps.Copy(out.Resources.HTTP, env.Resources.HTTP)
+	out.Resources.Postgres = maps.Clone(b.Resources.Postgres)
+	if out.Resources.Postgres == nil && len(env.Resources.Postgres) > 0 {
+		out.Resources.Postgres = make(map[string]PostgresResource)
+	}
+	maps.Copy(out.Resources.Postgres, env.Resources.Postgres)
+
+	out.Users = slices.Clone(b.Users)
+	for i, u := range out.Users {
+		if roles, ok := env.UserRoles[u.ID]; ok {
+			out.Users[i].Roles = roles
+		}
+	}
+
+	if env.RiskPolicy != nil {
+		out.RiskPolicy = *env.RiskPolicy
+	}
+	out.markStepUpTasks()
+	return &out, nil
+}
+
+// markStepUpTasks sets RequireYubiKey on a copy of the tasks whose risk
+// level the risk policy requires a YubiKey for.
+func (c *Config) markStepUpTasks() {
+	c.Tasks = slices.Clone(c.Tasks)
+	for i, t := range c.Tasks {
+		if slices.Contains(c.RiskPolicy.RequireYubiKey, t.RiskLevel) {
+			c.Tasks[i].RequireYubiKey = true
+		}
+	}
+}
+
+// Base returns the configuration as loaded, before ForEnvironment.
+func (c *Config) Base() *Config {
+	if c.base != nil {
+		return c.base
+	}
+	return c
+}
+
+// validateEnvironments checks env:, the environments: section and the risk
+// policies against the defined roles.
+func (c *Config) validateEnvironments() []error {
+	var errs []error
+
+	if len(c.Environments) > 0 {
+		if _, ok := c.Environments[c.Env]; !ok {
+			errs = append(errs, fmt.Errorf("env: %q is not defined in environments", c.Env))
+		}
+	}
+
+	roles := make(map[string]bool)
+	for _, r := range c.EffectiveRoles() {
+		roles[r.Name] = true
+	}
+	errs = append(errs, c.RiskPolicy.validate("risk_policy")...)
+	for _, name := range slices.Sorted(maps.Keys(c.Environments)) {
+		env := c.Environments[name]
+		for _, userID := range slices.Sorted(maps.Keys(env.UserRoles)) {
+			for _, r := range env.UserRoles[userID] {
+				if !roles[r] {
+					errs = append(errs, fmt.Errorf("environments.%s.user_roles.%s: unknown role %q", name, userID, r))
+				}
+			}
+		}
+		if env.RiskPolicy != nil {
+			errs = append(errs, env.RiskPolicy.validate("environments."+name+".risk_policy")...)
+		}
+	}
+	return errs
+}
+
+func (p RiskPolicy) validate(path string) []error {
+	var errs []error
+	for _, l := range slices.Concat(p.RequireYubiKey, p.Deny) {
+		switch l {
+		case RiskLow, RiskMedium, RiskHigh:
+		default:
+			errs = append(errs, fmt.Errorf("%s: unknown risk level %q", path, l))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,220 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrUserNotInConfig     = errors.New("user not found in config")
+	ErrDuplicateCredential = errors.New("credential already in config")
+)
+
+// CheckYubiKeyCredential reports whether cred can be added to user userID
+// in the YAML config file at path, and returns that user. It returns
+// ErrUserNotInConfig if there is no such user, and ErrDuplicateCredential
+// if any user already has the credential ID, or the user already has a
+// credential with the same name. An empty credential ID only checks the
+// name, before a credential is registered.
+func CheckYubiKeyCredential(path, userID string, cred YubiKeyCredential) (*User, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+	return checkYubiKeyCredential(&cfg, path, userID, cred)
+}
+
+func checkYubiKeyCredential(cfg *Config, path, userID string, cred YubiKeyCredential) (*User, error) {
+	for _, u := range cfg.Users {
+		for _, c := range u.YubiKeyCreds {
+			if cred.CredentialID != "" && c.RPID == cred.RPID && c.CredentialID == cred.CredentialID {
+				return nil, fmt.Errorf("%w: registered to %s", ErrDuplicateCredential, u.ID)
+			}
+			if u.ID == userID && cred.Name != "" && c.Name == cred.Name {
+				return nil, fmt.Errorf("%w: %s already has a credential named %q", ErrDuplicateCredential, userID, cred.Name)
+			}
+		}
+	}
+	u, ok := cfg.FindUser(userID)
+	if !ok {
+		return nil, userNotInConfig(path, userID)
+	}
+	return u, nil
+}
+
+// userNotInConfig reports that userID is not defined in the file at path.
+// Only that file is read, so the user may still be in a file it includes.
+func userNotInConfig(path, userID string) error {
+	return fmt.Errorf("%w: %s in %s (if the user is defined in an included file, give that file instead)", ErrUserNotInConfig, userID, path)
+}
+
+// UserHandle returns the WebAuthn user handle of the user's existing
+// credentials, or "" if none records one.
+func (u *User) UserHandle() string {
+	for _, c := range u.YubiKeyCreds {
+		if c.UserHandle != "" {
+			return c.UserHandle
+		}
+	}
+	return ""
+}
+
+// AddYubiKeyCredential appends cred to the yubikey_credentials of user
+// userID in the YAML config file at path. Only the new lines are inserted,
+// so comments, blank lines and formatting are kept. Duplicates are refused
+// as by CheckYubiKeyCredential.
+func AddYubiKeyCredential(path, userID string, cred YubiKeyCredential) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("parse config: %w", err)
+	}
+	if _, err := checkYubiKeyCredential(&cfg, path, userID, cred); err != nil {
+		return err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("parse config: %w", err)
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return fmt.Errorf("parse config: %s is not a YAML mapping", path)
+	}
+
+	usersNode := mappingValue(doc.Content[0], "users")
+	if usersNode == nil || usersNode.Kind != yaml.SequenceNode {
+		return userNotInConfig(path, userID)
+	}
+
+	var target *yaml.Node
+	for _, un := range usersNode.Content {
+		if id := mappingValue(un, "id"); id != nil && id.Value == userID {
+			target = un
+		}
+	}
+	if target == nil {
+		return userNotInConfig(path, userID)
+	}
+
+	item, err := yaml.Marshal(cred)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(string(data), "\n")
+	keyIndent := target.Content[0].Column - 1
+
+	// New lines are inserted after line `after` (1-based) and the list item
+	// dash goes at column dashCol (0-based), matching existing items.
+	var (
+		after   int
+		dashCol int
+		added   []string
+	)
+	credsNode := mappingValue(target, "yubikey_credentials")
+	switch {
+	case credsNode == nil:
+		after = lastLine(target)
+		dashCol = keyIndent + 2
+		added = append(added, strings.Repeat(" ", keyIndent)+"yubikey_credentials:")
+	case credsNode.Kind == yaml.SequenceNode && len(credsNode.Content) == 0:
+		// Replace an empty flow list ("yubikey_credentials: []"), keeping
+		// any comment after it.
+		line := lines[credsNode.Line-1]
+		start := credsNode.Column - 1
+		end := start + strings.Index(line[start:], "]") + 1
+		lines[credsNode.Line-1] = strings.TrimRight(line[:start], " ") + line[end:]
+		after = credsNode.Line
+		dashCol = keyIndent + 2
+	case credsNode.Kind == yaml.SequenceNode && credsNode.Style&yaml.FlowStyle == 0:
+		after = lastLine(credsNode)
+		dashCol = credsNode.Content[0].Column - 3
+	default:
+		return fmt.Errorf("%s: yubikey_credentials of %s must be a block list", path, userID)
+	}
+
+	for i, l := range strings.Split(strings.TrimSuffix(string(item), "\n"), "\n") {
+		prefix := strings.Repeat(" ", dashCol) + "  "
+		if i == 0 {
+			prefix = strings.Repeat(" ", dashCol) + "- "
+		}
+		added = append(added, prefix+l)
+	}
+	lines = append(lines[:after], append(added, lines[after:]...)...)
+	out := []byte(strings.Join(lines, "\n"))
+
+	// Check the edit before replacing the file.
+	cfg = Config{}
+	if err := yaml.Unmarshal(out, &cfg); err != nil {
+		return fmt.Errorf("patch config: %w", err)
+	}
+	if u, ok := cfg.FindUser(userID); !ok || !slices.Contains(u.YubiKeyCreds, cred) {
+		return fmt.Errorf("patch config: credential not found in %s after edit", path)
+	}
+	return writeFileAtomic(path, out)
+}
+
+// lastLine returns the last line (1-based) spanned by n and its children.
+func lastLine(n *yaml.Node) int {
+	last := n.Line
+	for _, c := range n.Content {
+		last = max(last, lastLine(c))
+	}
+	return last
+}
+
+// mappingValue returns the value node for key in mapping node m, or nil.
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	if m.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic replaces path with data, keeping its permissions, so a
+// reader never sees a partly written config.
+func writeFileAtomic(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("write config: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write config: %w", err)
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write config: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write config: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("write config: %w", err)
+	}
+	return nil
+}
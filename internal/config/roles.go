@@ -0,0 +1,262 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Role is a named set of permissions that may inherit other roles. A user
+// holding a role also holds every role it inherits, transitively, so
+// allowed_roles: [operator] admits admins and owners too.
+type Role struct {
+	Name        string   `yaml:"name"`
+	Inherits    []string `yaml:"inherits"`
+	Permissions []string `yaml:"permissions"`
+}
+
+// AccessRule grants roles the right to run every operation and task carrying
+// one of a set of tags, without editing each item's allowed_roles:
+//
+//	access:
+//	  - allow: {roles: [support], tags: [billing-read]}
+type AccessRule struct {
+	Allow AccessGrant `yaml:"allow"`
+}
+
+// AccessGrant is the roles and tags an AccessRule applies to. Roles are
+// matched with inheritance, so a rule for operator also admits admins.
+type AccessGrant struct {
+	Roles []string `yaml:"roles"`
+	Tags  []string `yaml:"tags"`
+}
+
+// ElevationConfig lists the roles users may hold temporarily through
+// just-in-time elevation instead of permanently.
+type ElevationConfig struct {
+	Roles []ElevationRole `yaml:"roles"`
+}
+
+// ElevationRole is a role that may be requested for a limited time.
+type ElevationRole struct {
+	Role string `yaml:"role"`
+	// MaxDuration caps the requested duration (default DefaultElevationMaxDuration).
+	MaxDuration time.Duration `yaml:"max_duration"`
+	// AutoApprove grants requests immediately, for low-risk roles.
+	AutoApprove bool `yaml:"auto_approve"`
+	// Eligible limits who may request the role to holders of these roles.
+	// Empty means any configured user.
+	Eligible []string `yaml:"eligible"`
+}
+
+// DefaultElevationMaxDuration applies when an elevation role has no
+// max_duration.
+const DefaultElevationMaxDuration = time.Hour
+
+// MaxDurationOrDefault returns the longest grant that may be requested.
+func (r ElevationRole) MaxDurationOrDefault() time.Duration {
+	if r.MaxDuration > 0 {
+		return r.MaxDuration
+	}
+	return DefaultElevationMaxDuration
+}
+
+// FindElevationRole returns the elevation policy for role.
+func (c *Config) FindElevationRole(role string) (*ElevationRole, bool) {
+	for i := range c.Elevation.Roles {
+		if c.Elevation.Roles[i].Role == role {
+			return &c.Elevation.Roles[i], true
+		}
+	}
+	return nil, false
+}
+
+// Permissions checked by lazyadmin itself. ops.run:<tag> is open-ended: it
+// admits any operation or task carrying the tag.
+const (
+	PermAll              = "*"
+	PermUsersManage      = "users.manage"
+	PermAuditRead        = "audit.read"
+	PermLocksForceUnlock = "locks.force_unlock"
+	PermRunsResumeAny    = "runs.resume_any"
+	PermElevationApprove = "elevation.approve"
+	PermOpsRunPrefix     = "ops.run:"
+)
+
+// TagOpenAPI is carried by every operation generated from an OpenAPI backend.
+const TagOpenAPI = "openapi"
+
+var knownPermissions = map[string]bool{
+	PermAll:              true,
+	PermUsersManage:      true,
+	PermAuditRead:        true,
+	PermLocksForceUnlock: true,
+	PermRunsResumeAny:    true,
+	PermElevationApprove: true,
+}
+
+// DefaultRoles is the hierarchy used when the config has no roles: section.
+// It matches the roles lazyadmin historically treated specially.
+func DefaultRoles() []Role {
+	return []Role{
+		{Name: "read_only", Permissions: []string{PermAuditRead}},
+		{Name: "operator", Inherits: []string{"read_only"}},
+		{Name: "admin", Inherits: []string{"operator"}, Permissions: []string{
+			PermUsersManage, PermLocksForceUnlock, PermRunsResumeAny, PermOpsRunPrefix + TagOpenAPI,
+		}},
+		{Name: "owner", Inherits: []string{"admin"}, Permissions: []string{PermAll}},
+	}
+}
+
+// EffectiveRoles returns the configured roles, or DefaultRoles if none are
+// configured.
+func (c *Config) EffectiveRoles() []Role {
+	if len(c.Roles) == 0 {
+		return DefaultRoles()
+	}
+	return c.Roles
+}
+
+// validateElevation checks the elevation: section against the effective
+// role hierarchy.
+func (c *Config) validateElevation() []error {
+	var errs []error
+
+	defined := make(map[string]bool)
+	for _, r := range c.EffectiveRoles() {
+		defined[r.Name] = true
+	}
+	seen := make(map[string]bool)
+	for _, er := range c.Elevation.Roles {
+		if !defined[er.Role] {
+			errs = append(errs, fmt.Errorf("elevation: unknown role %q", er.Role))
+		}
+		if seen[er.Role] {
+			errs = append(errs, fmt.Errorf("elevation: role %s listed twice", er.Role))
+		}
+		seen[er.Role] = true
+		if er.MaxDuration < 0 {
+			errs = append(errs, fmt.Errorf("elevation: role %s: negative max_duration", er.Role))
+		}
+		for _, name := range er.Eligible {
+			if !defined[name] {
+				errs = append(errs, fmt.Errorf("elevation: role %s: unknown eligible role %q", er.Role, name))
+			}
+		}
+	}
+	return errs
+}
+
+// validateRoles checks the roles: section and, when it is present, that every
+// role referenced elsewhere is defined.
+func (c *Config) validateRoles() []error {
+	var errs []error
+	if len(c.Roles) == 0 {
+		return nil
+	}
+
+	defined := make(map[string]Role, len(c.Roles))
+	for _, r := range c.Roles {
+		if r.Name == "" {
+			errs = append(errs, fmt.Errorf("roles: role without a name"))
+			continue
+		}
+		if _, dup := defined[r.Name]; dup {
+			errs = append(errs, fmt.Errorf("role %s: duplicate name", r.Name))
+		}
+		defined[r.Name] = r
+		for _, p := range r.Permissions {
+			if err := validatePermission(p); err != nil {
+				errs = append(errs, fmt.Errorf("role %s: %w", r.Name, err))
+			}
+		}
+	}
+
+	for _, r := range c.Roles {
+		for _, parent := range r.Inherits {
+			if _, ok := defined[parent]; !ok {
+				errs = append(errs, fmt.Errorf("role %s: inherits unknown role %q", r.Name, parent))
+			}
+		}
+	}
+	if cycle := findRoleCycle(c.Roles, defined); cycle != nil {
+		errs = append(errs, fmt.Errorf("role inheritance cycle: %s", strings.Join(cycle, " -> ")))
+	}
+
+	checkRefs := func(owner string, roles []string) {
+		for _, name := range roles {
+			if _, ok := defined[name]; !ok {
+				errs = append(errs, fmt.Errorf("%s: unknown role %q", owner, name))
+			}
+		}
+	}
+	for _, u := range c.Users {
+		checkRefs("user "+u.ID, u.Roles)
+	}
+	for _, op := range c.Operations {
+		checkRefs("operation "+op.ID, op.AllowedRoles)
+	}
+	for _, t := range c.Tasks {
+		checkRefs("task "+t.ID, t.AllowedRoles)
+	}
+	for i, r := range c.Access {
+		checkRefs(fmt.Sprintf("access[%d]", i), r.Allow.Roles)
+	}
+
+	return errs
+}
+
+func validatePermission(p string) error {
+	if knownPermissions[p] {
+		return nil
+	}
+	if tag, ok := strings.CutPrefix(p, PermOpsRunPrefix); ok && tag != "" {
+		return nil
+	}
+	return fmt.Errorf("unknown permission %q", p)
+}
+
+// findRoleCycle returns a role inheritance cycle, if any.
+func findRoleCycle(roles []Role, defined map[string]Role) []string {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int, len(roles))
+	var path []string
+
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		switch state[name] {
+		case visiting:
+			for i, n := range path {
+				if n == name {
+					return append(append([]string{}, path[i:]...), name)
+				}
+			}
+		case done:
+			return nil
+		}
+		state[name] = visiting
+		path = append(path, name)
+		for _, parent := range defined[name].Inherits {
+			if _, ok := defined[parent]; !ok {
+				continue
+			}
+			if cycle := visit(parent); cycle != nil {
+				return cycle
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = done
+		return nil
+	}
+
+	for _, r := range roles {
+		if cycle := visit(r.Name); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
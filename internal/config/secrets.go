@@ -0,0 +1,151 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+var ErrBadSecretRef = errors.New("bad secret reference")
+
+// SecretScheme starts a secret reference, such as
+// secret://vault/kv/db/orders#dsn, which is resolved when the value is
+// used instead of being kept in the config or the environment.
+const SecretScheme = "secret://"
+
+// Secret providers, the first element of a secret reference.
+const (
+	SecretEnv     = "env"     // secret://env/NAME: environment variable
+	SecretFile    = "file"    // secret://file/abs/path[#key]: file contents
+	SecretSystemd = "systemd" // secret://systemd/name[#key]: $CREDENTIALS_DIRECTORY/name
+	SecretSops    = "sops"    // secret://sops/abs/path[#key]: sops --decrypt
+	SecretAge     = "age"     // secret://age/abs/path[#key]: age --decrypt
+	SecretVault   = "vault"   // secret://vault/mount/path[#key]: Vault KV v2
+)
+
+// SecretsConfig configures the providers of secret references.
+type SecretsConfig struct {
+	CacheTTL    time.Duration `yaml:"cache_ttl"`    // how long resolved values are reused (default DefaultSecretCacheTTL)
+	AgeIdentity string        `yaml:"age_identity"` // identity file for secret://age/ references
+	Vault       VaultConfig   `yaml:"vault"`
+}
+
+// DefaultSecretCacheTTL applies when secrets.cache_ttl is unset.
+const DefaultSecretCacheTTL = 5 * time.Minute
+
+// CacheTTLOrDefault returns how long resolved values are reused.
+func (s SecretsConfig) CacheTTLOrDefault() time.Duration {
+	if s.CacheTTL > 0 {
+		return s.CacheTTL
+	}
+	return DefaultSecretCacheTTL
+}
+
+// VaultConfig locates the HashiCorp Vault server of secret://vault/
+// references.
+type VaultConfig struct {
+	Address   string `yaml:"address"`   // defaults to $VAULT_ADDR
+	Token     string `yaml:"token"`     // secret reference to the token; defaults to $VAULT_TOKEN
+	Namespace string `yaml:"namespace"` // Vault Enterprise namespace, if any
+}
+
+// SecretRef is a parsed secret reference.
+type SecretRef struct {
+	Provider string
+	Path     string // variable name, file path (absolute), credential name or Vault path
+	Key      string // dotted key within the secret's YAML, JSON or KV data; may be empty
+}
+
+func (r SecretRef) String() string {
+	s := SecretScheme + r.Provider + "/" + strings.TrimPrefix(r.Path, "/")
+	if r.Key != "" {
+		s += "#" + r.Key
+	}
+	return s
+}
+
+// IsSecretRef reports whether s is a secret reference rather than a
+// literal value.
+func IsSecretRef(s string) bool {
+	return strings.HasPrefix(s, SecretScheme)
+}
+
+// ParseSecretRef parses a secret reference. File paths of the file, sops
+// and age providers are absolute: secret://file/run/secrets/dsn names
+// /run/secrets/dsn.
+func ParseSecretRef(s string) (SecretRef, error) {
+	rest, ok := strings.CutPrefix(s, SecretScheme)
+	if !ok {
+		return SecretRef{}, fmt.Errorf("%w: %q does not start with %s", ErrBadSecretRef, s, SecretScheme)
+	}
+	rest, key, _ := strings.Cut(rest, "#")
+	provider, path, _ := strings.Cut(rest, "/")
+
+	ref := SecretRef{Provider: provider, Path: path, Key: key}
+	switch provider {
+	case SecretEnv, SecretSystemd:
+		if path == "" || strings.Contains(path, "/") {
+			return SecretRef{}, fmt.Errorf("%w: %s needs a name without slashes", ErrBadSecretRef, s)
+		}
+	case SecretFile, SecretSops, SecretAge:
+		if strings.Trim(path, "/") == "" {
+			return SecretRef{}, fmt.Errorf("%w: %s needs a file path", ErrBadSecretRef, s)
+		}
+		ref.Path = "/" + strings.TrimPrefix(path, "/")
+	case SecretVault:
+		mount, p, _ := strings.Cut(path, "/")
+		if mount == "" || p == "" {
+			return SecretRef{}, fmt.Errorf("%w: %s needs a mount and a path", ErrBadSecretRef, s)
+		}
+	default:
+		return SecretRef{}, fmt.Errorf("%w: %s: unknown provider %q", ErrBadSecretRef, s, provider)
+	}
+	if provider == SecretEnv && key != "" {
+		return SecretRef{}, fmt.Errorf("%w: %s: env references take no key", ErrBadSecretRef, s)
+	}
+	return ref, nil
+}
+
+// validateSecrets checks the secret references in the config and the
+// secrets: section.
+func (c *Config) validateSecrets() []error {
+	var errs []error
+	check := func(field, value string) {
+		if IsSecretRef(value) {
+			if _, err := ParseSecretRef(value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", field, err))
+			}
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(c.Resources.Postgres)) {
+		res := c.Resources.Postgres[name]
+		if (res.DSN == "") == (res.DSNEnv == "") {
+			errs = append(errs, fmt.Errorf("resources.postgres.%s: set one of dsn and dsn_env", name))
+		}
+		check("resources.postgres."+name+".dsn", res.DSN)
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.Resources.HTTP)) {
+		check("resources.http."+name+".token", c.Resources.HTTP[name].Token)
+	}
+	for _, n := range c.Notifications {
+		check("notification "+n.Name+": url", n.URL)
+	}
+
+	if c.Secrets.CacheTTL < 0 {
+		errs = append(errs, fmt.Errorf("secrets: negative cache_ttl"))
+	}
+	if t := c.Secrets.Vault.Token; t != "" {
+		ref, err := ParseSecretRef(t)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("secrets.vault.token: must be a secret reference: %w", err))
+		case ref.Provider == SecretVault:
+			errs = append(errs, fmt.Errorf("secrets.vault.token: cannot be read from Vault itself"))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,267 @@
+package config
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	ErrUnsigned       = errors.New("config is not signed")
+	ErrBadSignature   = errors.New("config signature does not verify")
+	ErrUntrustedTrust = errors.New("trust file must be owned by root and writable only by root")
+)
+
+// TrustFile lists the public keys, in authorized_keys format, whose
+// signatures the configuration must carry. It is deliberately not
+// configurable from the configuration or the environment: when it exists,
+// every configuration load is verified and fails without a valid signature.
+const TrustFile = "/etc/lazyadmin/trusted_keys"
+
+// SignatureNamespace is the SSH signature namespace of configuration
+// signatures, as in ssh-keygen -Y sign -n lazyadmin-config.
+const SignatureNamespace = "lazyadmin-config"
+
+// checkTrustOwner checks the owner and mode of the trust file; tests
+// replace it, as they do not run as root.
+var checkTrustOwner = trustOwnedByRoot
+
+// manifestHeader starts the manifest, the text that is signed.
+const manifestHeader = "lazyadmin config manifest v1\n"
+
+// SignaturePath returns the path of the detached signature of the
+// configuration whose main file is path.
+func SignaturePath(path string) string {
+	return path + ".sig"
+}
+
+// Manifest returns the text a configuration signature covers: the SHA-256
+// of every file the configuration was loaded from, in load order, with its
+// path relative to the main file's directory.
+func (c *Config) Manifest() []byte {
+	return c.Base().manifest
+}
+
+// Verify checks the configuration's detached signature against the keys in
+// trustFile. Without a trust file signing is not in use and Verify returns
+// nil; any other problem with the trust file or the signature is an error.
+func (c *Config) Verify(trustFile string) error {
+	keys, err := TrustedKeys(trustFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	files := c.Files()
+	if len(files) == 0 {
+		return fmt.Errorf("%w: no files were loaded", ErrUnsigned)
+	}
+	sigPath := SignaturePath(files[0])
+	sig, err := os.ReadFile(sigPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %s is missing; sign it with lazyadmin config sign", ErrUnsigned, sigPath)
+	}
+	if err != nil {
+		return fmt.Errorf("read signature: %w", err)
+	}
+	if err := VerifySignature(c.Manifest(), sig, keys); err != nil {
+		return fmt.Errorf("%s: %w", sigPath, err)
+	}
+	return nil
+}
+
+// TrustedKeys reads the public keys of a trust file, which must be owned by
+// root and not writable by anyone else.
+func TrustedKeys(path string) ([]ssh.PublicKey, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTrustOwner(fi); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []ssh.PublicKey
+	for rest := data; len(bytes.TrimSpace(rest)) > 0; {
+		var key ssh.PublicKey
+		key, _, _, rest, err = ssh.ParseAuthorizedKey(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no keys", path)
+	}
+	return keys, nil
+}
+
+// VerifySignature checks that sig is a signature of manifest by one of
+// keys. sig is either an SSH signature (ssh-keygen -Y sign, namespace
+// lazyadmin-config) or a base64 raw ed25519 signature of the manifest.
+func VerifySignature(manifest, sig []byte, keys []ssh.PublicKey) error {
+	if block, _ := pem.Decode(sig); block != nil {
+		if block.Type != "SSH SIGNATURE" {
+			return fmt.Errorf("%w: unexpected %s block", ErrBadSignature, block.Type)
+		}
+		return verifySSHSig(manifest, block.Bytes, keys)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil || len(raw) != ed25519.SignatureSize {
+		return fmt.Errorf("%w: neither an SSH signature nor a base64 ed25519 signature", ErrBadSignature)
+	}
+	for _, k := range keys {
+		if pub, ok := ed25519Key(k); ok && ed25519.Verify(pub, manifest, raw) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: no trusted ed25519 key made it", ErrBadSignature)
+}
+
+// Sign returns an SSH signature of manifest by signer, in the format of
+// ssh-keygen -Y sign.
+func Sign(manifest []byte, signer ssh.Signer) ([]byte, error) {
+	const hashAlg = "sha512"
+	h := sha512.Sum512(manifest)
+	signed := sshsigSignedData(SignatureNamespace, hashAlg, h[:])
+
+	var sig *ssh.Signature
+	var err error
+	if as, ok := signer.(ssh.AlgorithmSigner); ok && signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		sig, err = as.SignWithAlgorithm(rand.Reader, signed, ssh.KeyAlgoRSASHA512)
+	} else {
+		sig, err = signer.Sign(rand.Reader, signed)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var blob []byte
+	blob = append(blob, "SSHSIG"...)
+	blob = binary.BigEndian.AppendUint32(blob, 1)
+	blob = appendSSHString(blob, signer.PublicKey().Marshal())
+	blob = appendSSHString(blob, []byte(SignatureNamespace))
+	blob = appendSSHString(blob, nil)
+	blob = appendSSHString(blob, []byte(hashAlg))
+	blob = appendSSHString(blob, ssh.Marshal(sig))
+
+	var out bytes.Buffer
+	out.WriteString("-----BEGIN SSH SIGNATURE-----\n")
+	enc := base64.StdEncoding.EncodeToString(blob)
+	for len(enc) > 70 {
+		out.WriteString(enc[:70] + "\n")
+		enc = enc[70:]
+	}
+	out.WriteString(enc + "\n-----END SSH SIGNATURE-----\n")
+	return out.Bytes(), nil
+}
+
+// verifySSHSig verifies an SSHSIG blob as described in OpenSSH's
+// PROTOCOL.sshsig.
+func verifySSHSig(manifest, blob []byte, keys []ssh.PublicKey) error {
+	rest, ok := bytes.CutPrefix(blob, []byte("SSHSIG"))
+	if !ok || len(rest) < 4 || binary.BigEndian.Uint32(rest) != 1 {
+		return fmt.Errorf("%w: not a version 1 SSH signature", ErrBadSignature)
+	}
+	rest = rest[4:]
+
+	var fields [5][]byte // public key, namespace, reserved, hash algorithm, signature
+	for i := range fields {
+		if fields[i], rest, ok = readSSHString(rest); !ok {
+			return fmt.Errorf("%w: truncated SSH signature", ErrBadSignature)
+		}
+	}
+	pubBlob, namespace, hashAlg, sigBlob := fields[0], string(fields[1]), string(fields[3]), fields[4]
+	if namespace != SignatureNamespace {
+		return fmt.Errorf("%w: namespace %q, want %q", ErrBadSignature, namespace, SignatureNamespace)
+	}
+
+	pub, err := ssh.ParsePublicKey(pubBlob)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBadSignature, err)
+	}
+	trusted := false
+	for _, k := range keys {
+		if bytes.Equal(k.Marshal(), pub.Marshal()) {
+			trusted = true
+			break
+		}
+	}
+	if !trusted {
+		return fmt.Errorf("%w: signed by untrusted key %s", ErrBadSignature, ssh.FingerprintSHA256(pub))
+	}
+
+	var digest []byte
+	switch hashAlg {
+	case "sha512":
+		h := sha512.Sum512(manifest)
+		digest = h[:]
+	case "sha256":
+		h := sha256.Sum256(manifest)
+		digest = h[:]
+	default:
+		return fmt.Errorf("%w: unsupported hash %q", ErrBadSignature, hashAlg)
+	}
+	var sig ssh.Signature
+	if err := ssh.Unmarshal(sigBlob, &sig); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadSignature, err)
+	}
+	if err := pub.Verify(sshsigSignedData(namespace, hashAlg, digest), &sig); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadSignature, err)
+	}
+	return nil
+}
+
+// sshsigSignedData returns the data an SSH signature's key signs.
+func sshsigSignedData(namespace, hashAlg string, digest []byte) []byte {
+	var b []byte
+	b = append(b, "SSHSIG"...)
+	b = appendSSHString(b, []byte(namespace))
+	b = appendSSHString(b, nil)
+	b = appendSSHString(b, []byte(hashAlg))
+	return appendSSHString(b, digest)
+}
+
+func appendSSHString(b, s []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+func readSSHString(b []byte) (s, rest []byte, ok bool) {
+	if len(b) < 4 {
+		return nil, nil, false
+	}
+	n := binary.BigEndian.Uint32(b)
+	if uint64(len(b)-4) < uint64(n) {
+		return nil, nil, false
+	}
+	return b[4 : 4+n], b[4+n:], true
+}
+
+// ed25519Key returns the ed25519 key of an ssh-ed25519 public key.
+func ed25519Key(k ssh.PublicKey) (ed25519.PublicKey, bool) {
+	ck, ok := k.(ssh.CryptoPublicKey)
+	if !ok {
+		return nil, false
+	}
+	pub, ok := ck.CryptoPublicKey().(ed25519.PublicKey)
+	return pub, ok
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// trustOwnedByRoot fails closed where file ownership cannot be checked.
+func trustOwnedByRoot(os.FileInfo) error {
+	return fmt.Errorf("%w: cannot check file ownership on %s", ErrUntrustedTrust, runtime.GOOS)
+}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package config
+
+import (
+	"os"
+	"syscall"
+)
+
+// trustOwnedByRoot reports whether fi, the trust file, is owned by root and
+// writable by nobody else.
+func trustOwnedByRoot(fi os.FileInfo) error {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || st.Uid != 0 || fi.Mode().Perm()&0o022 != 0 {
+		return ErrUntrustedTrust
+	}
+	return nil
+}
@@ -0,0 +1,297 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/you/lazyadmin/internal/cron"
+)
+
+// Validate checks references between config items that the YAML schema
+// cannot express. It must run after generated operations (OpenAPI) have been
+// appended, since tasks may call them.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.Auth.SessionIdleTimeout < 0 {
+		errs = append(errs, fmt.Errorf("auth: negative session_idle_timeout"))
+	}
+	if c.Auth.SessionMaxAge < 0 {
+		errs = append(errs, fmt.Errorf("auth: negative session_max_age"))
+	}
+
+	switch c.Auth.UserSource {
+	case "", UserSourceConfig, UserSourceStore:
+	default:
+		errs = append(errs, fmt.Errorf("auth: user_source must be %s or %s", UserSourceConfig, UserSourceStore))
+	}
+
+	errs = append(errs, c.validateRoles()...)
+	errs = append(errs, c.validateElevation()...)
+	errs = append(errs, c.validateEnvironments()...)
+	errs = append(errs, c.validateSecrets()...)
+
+	sinks := make(map[string]bool)
+	for _, n := range c.Notifications {
+		if n.Name == "" || sinks[n.Name] {
+			errs = append(errs, fmt.Errorf("notifications: missing or duplicate name %q", n.Name))
+		}
+		sinks[n.Name] = true
+		if n.Type != "webhook" && n.Type != "slack" {
+			errs = append(errs, fmt.Errorf("notification %s: type must be webhook or slack", n.Name))
+		}
+		if (n.URLEnv == "") == (n.URL == "") {
+			errs = append(errs, fmt.Errorf("notification %s: set one of url_env and url", n.Name))
+		}
+	}
+
+	if c.Auth.Lockout.MaxFailures < 0 {
+		errs = append(errs, fmt.Errorf("auth.lockout: negative max_failures"))
+	}
+	if c.Auth.Lockout.Duration < 0 {
+		errs = append(errs, fmt.Errorf("auth.lockout: negative duration"))
+	}
+	if bg := c.Auth.BreakGlass; bg.Enabled() {
+		if !slices.ContainsFunc(c.EffectiveRoles(), func(r Role) bool { return r.Name == bg.Role }) {
+			errs = append(errs, fmt.Errorf("auth.break_glass: unknown role %q", bg.Role))
+		}
+		if bg.Duration < 0 {
+			errs = append(errs, fmt.Errorf("auth.break_glass: negative duration"))
+		}
+		if len(c.Notifications) == 0 {
+			errs = append(errs, fmt.Errorf("auth.break_glass: at least one notification sink is required"))
+		}
+	}
+
+	for i, r := range c.Access {
+		if len(r.Allow.Roles) == 0 || len(r.Allow.Tags) == 0 {
+			errs = append(errs, fmt.Errorf("access[%d]: allow needs both roles and tags", i))
+		}
+	}
+
+	for _, op := range c.Operations {
+		for _, perm := range op.Permissions {
+			if err := validatePermission(perm); err != nil {
+				errs = append(errs, fmt.Errorf("operation %s: %w", op.ID, err))
+			}
+		}
+		if err := op.Retry.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("operation %s: %w", op.ID, err))
+		}
+		if err := c.validateLock(op.Lock); err != nil {
+			errs = append(errs, fmt.Errorf("operation %s: %w", op.ID, err))
+		}
+	}
+
+	for _, t := range c.Tasks {
+		for _, perm := range t.Permissions {
+			if err := validatePermission(perm); err != nil {
+				errs = append(errs, fmt.Errorf("task %s: %w", t.ID, err))
+			}
+		}
+		if t.Timeout < 0 {
+			errs = append(errs, fmt.Errorf("task %s: negative timeout", t.ID))
+		}
+		if err := c.validateLock(t.Lock); err != nil {
+			errs = append(errs, fmt.Errorf("task %s: %w", t.ID, err))
+		}
+		stepIDs := make(map[string]bool)
+		forEachStep(t.allSteps(), func(step TaskStep) {
+			if stepIDs[step.ID] {
+				errs = append(errs, fmt.Errorf("task %s: duplicate step id %q", t.ID, step.ID))
+			}
+			stepIDs[step.ID] = true
+			if err := step.Retry.validate(); err != nil {
+				errs = append(errs, fmt.Errorf("task %s step %s: %w", t.ID, step.ID, err))
+			}
+			switch step.Type {
+			case "task":
+				called, ok := c.FindTask(step.Task)
+				if !ok {
+					errs = append(errs, fmt.Errorf("task %s step %s: unknown task %q", t.ID, step.ID, step.Task))
+				} else if c.RequiresYubiKey(*called) && !c.RequiresYubiKey(t) {
+					errs = append(errs, fmt.Errorf("task %s step %s: task %s requires a YubiKey step-up, so %s must too", t.ID, step.ID, called.ID, t.ID))
+				}
+			case "operation":
+				if _, ok := c.FindOperation(step.Operation); !ok {
+					errs = append(errs, fmt.Errorf("task %s step %s: unknown operation %q", t.ID, step.ID, step.Operation))
+				}
+			}
+		})
+	}
+
+	keyOwner := make(map[string]string)
+	for _, u := range c.Users {
+		for _, fp := range u.SSHKeys {
+			if !strings.HasPrefix(fp, "SHA256:") {
+				errs = append(errs, fmt.Errorf("user %s: ssh_keys: %q is not a SHA256 fingerprint", u.ID, fp))
+			}
+			if other, ok := keyOwner[fp]; ok && other != u.ID {
+				errs = append(errs, fmt.Errorf("user %s: ssh_keys: %s is also mapped to user %s", u.ID, fp, other))
+			}
+			keyOwner[fp] = u.ID
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, s := range c.Schedules {
+		if seen[s.ID] {
+			errs = append(errs, fmt.Errorf("schedule %s: duplicate id", s.ID))
+		}
+		seen[s.ID] = true
+		if _, err := cron.Parse(s.Cron); err != nil {
+			errs = append(errs, fmt.Errorf("schedule %s: %w", s.ID, err))
+		}
+		if _, ok := c.FindTask(s.Task); !ok {
+			errs = append(errs, fmt.Errorf("schedule %s: unknown task %q", s.ID, s.Task))
+		}
+		if _, ok := c.FindUser(s.RunAs); !ok {
+			errs = append(errs, fmt.Errorf("schedule %s: run_as: unknown user %q", s.ID, s.RunAs))
+		}
+		if id, ok := c.FindStepUpTask(s.Task); ok {
+			errs = append(errs, fmt.Errorf("schedule %s: task %s requires a YubiKey step-up, which scheduled runs cannot give", s.ID, id))
+		}
+	}
+
+	if cycle := c.findTaskCycle(); cycle != nil {
+		errs = append(errs, fmt.Errorf("task call cycle: %s", strings.Join(cycle, " -> ")))
+	}
+
+	return errors.Join(errs...)
+}
+
+// allSteps returns the task's top-level steps followed by its task-level
+// rollback steps.
+func (t Task) allSteps() []TaskStep {
+	steps := make([]TaskStep, 0, len(t.Steps)+len(t.Rollback))
+	steps = append(steps, t.Steps...)
+	return append(steps, t.Rollback...)
+}
+
+// forEachStep visits every step in steps, including parallel group children
+// and rollback steps.
+func forEachStep(steps []TaskStep, fn func(TaskStep)) {
+	for _, step := range steps {
+		fn(step)
+		if step.Parallel != nil {
+			forEachStep(step.Parallel.Steps, fn)
+		}
+		forEachStep(step.Rollback, fn)
+	}
+}
+
+// findTaskCycle returns the task IDs forming the first call cycle found, with
+// the starting task repeated at the end, or nil if task calls form a DAG.
+func (c *Config) findTaskCycle() []string {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+
+	state := make(map[string]int)
+	var stack []string
+	var cycle []string
+
+	var visit func(id string) bool
+	visit = func(id string) bool {
+		switch state[id] {
+		case visiting:
+			for i, s := range stack {
+				if s == id {
+					cycle = append(append([]string{}, stack[i:]...), id)
+					break
+				}
+			}
+			return true
+		case done:
+			return false
+		}
+
+		t, ok := c.FindTask(id)
+		if !ok {
+			return false
+		}
+
+		state[id] = visiting
+		stack = append(stack, id)
+
+		found := false
+		forEachStep(t.allSteps(), func(step TaskStep) {
+			if !found && step.Type == "task" {
+				found = visit(step.Task)
+			}
+		})
+
+		stack = stack[:len(stack)-1]
+		state[id] = done
+		return found
+	}
+
+	for _, t := range c.Tasks {
+		if visit(t.ID) {
+			return cycle
+		}
+	}
+	return nil
+}
+
+func (c *Config) validateLock(l *Lock) error {
+	if l == nil {
+		return nil
+	}
+	switch l.Scope {
+	case "", LockScopeEnv, LockScopeGlobal:
+	default:
+		return fmt.Errorf("lock: unknown scope %q", l.Scope)
+	}
+	if l.Lease < 0 {
+		return fmt.Errorf("lock: negative lease")
+	}
+	if l.Lease > 0 && l.Lease < MinLockLease {
+		return fmt.Errorf("lock: lease %s is shorter than %s", l.Lease, MinLockLease)
+	}
+	if l.Resource != "" {
+		if _, ok := c.Resources.Postgres[l.Resource]; !ok {
+			return fmt.Errorf("lock: unknown postgres resource %q", l.Resource)
+		}
+	}
+	return nil
+}
+
+func (p RetryPolicy) validate() error {
+	if p.Retries < 0 {
+		return fmt.Errorf("negative retries")
+	}
+	if p.Timeout < 0 || p.Backoff.Delay < 0 || p.Backoff.MaxDelay < 0 {
+		return fmt.Errorf("negative timeout or backoff delay")
+	}
+	switch p.Backoff.Type {
+	case "", BackoffFixed, BackoffExponential:
+	default:
+		return fmt.Errorf("unknown backoff type %q", p.Backoff.Type)
+	}
+	for _, cond := range p.RetryOn {
+		if !validRetryCondition(cond) {
+			return fmt.Errorf("invalid retry_on entry %q", cond)
+		}
+	}
+	return nil
+}
+
+// validRetryCondition accepts "error", a three-digit status code, or a status
+// class like "5xx".
+func validRetryCondition(cond string) bool {
+	if cond == "error" {
+		return true
+	}
+	if len(cond) != 3 || cond[0] < '1' || cond[0] > '5' {
+		return false
+	}
+	if cond[1:] == "xx" {
+		return true
+	}
+	return cond[1] >= '0' && cond[1] <= '9' && cond[2] >= '0' && cond[2] <= '9'
+}
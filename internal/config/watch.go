@@ -0,0 +1,75 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultWatchInterval is how often Watch polls the configuration files.
+const DefaultWatchInterval = 2 * time.Second
+
+// stamp is what Watch compares to notice a change to a file or directory.
+type stamp struct {
+	modTime time.Time
+	size    int64
+	exists  bool
+}
+
+// Watch polls the files returned by files, and the directories holding
+// them, every interval until ctx is done. It calls onChange once they have
+// changed and then stayed unchanged for a full interval, so a file still
+// being written is not reloaded half done. Watching the directories catches
+// files added to an include glob, such as tasks.d/*.yaml. files is called on
+// every poll, so it may return a new list after a reload; paths new to the
+// list are not reported as changes.
+func Watch(ctx context.Context, files func() []string, interval time.Duration, onChange func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := stamps(files())
+	pending := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		cur := stamps(files())
+		changed := false
+		for path, s := range cur {
+			if old, ok := last[path]; ok && old != s {
+				changed = true
+				break
+			}
+		}
+		last = cur
+		switch {
+		case changed:
+			pending = true
+		case pending:
+			pending = false
+			onChange()
+		}
+	}
+}
+
+// stamps stats files and their directories.
+func stamps(files []string) map[string]stamp {
+	out := make(map[string]stamp)
+	for _, f := range files {
+		for _, path := range []string{f, filepath.Dir(f)} {
+			if _, ok := out[path]; ok {
+				continue
+			}
+			var s stamp
+			if fi, err := os.Stat(path); err == nil {
+				s = stamp{modTime: fi.ModTime(), size: fi.Size(), exists: true}
+			}
+			out[path] = s
+		}
+	}
+	return out
+}
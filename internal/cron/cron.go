@@ -0,0 +1,178 @@
+// Package cron parses standard five-field cron expressions.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression. Each field is a bit set of the values
+// it matches.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// When both day-of-month and day-of-week are restricted, a day matches
+	// if either does (traditional cron semantics).
+	domStar, dowStar bool
+}
+
+type field struct {
+	name     string
+	min, max int
+}
+
+var fields = []field{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7}, // 0 and 7 are both Sunday
+}
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse parses a cron expression: "minute hour day-of-month month
+// day-of-week", where each field is "*", a value, a range "a-b", a list of
+// those separated by commas, and optionally a step "/n". The macros @hourly,
+// @daily, @weekly, @monthly and @yearly are also accepted.
+func Parse(expr string) (*Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if m, ok := macros[expr]; ok {
+		expr = m
+	}
+
+	parts := strings.Fields(expr)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("cron %q: expected %d fields, got %d", expr, len(fields), len(parts))
+	}
+
+	var sets [5]uint64
+	for i, part := range parts {
+		set, err := parseField(part, fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("cron %q: %w", expr, err)
+		}
+		sets[i] = set
+	}
+
+	s := &Schedule{
+		minute:  sets[0],
+		hour:    sets[1],
+		dom:     sets[2],
+		month:   sets[3],
+		dow:     sets[4],
+		domStar: parts[2] == "*",
+		dowStar: parts[4] == "*",
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+func parseField(s string, f field) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(s, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(item, "/")
+
+		lo, hi := f.min, f.max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			a, b, _ := strings.Cut(rangePart, "-")
+			var err error
+			if lo, err = parseValue(a, f); err != nil {
+				return 0, err
+			}
+			if hi, err = parseValue(b, f); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("%s: range %q is backwards", f.name, rangePart)
+			}
+		default:
+			v, err := parseValue(rangePart, f)
+			if err != nil {
+				return 0, err
+			}
+			lo = v
+			if !hasStep {
+				hi = v
+			}
+		}
+
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("%s: invalid step %q", f.name, stepPart)
+			}
+			step = n
+		}
+
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+func parseValue(s string, f field) (int, error) {
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid value %q", f.name, s)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("%s: %d out of range %d-%d", f.name, v, f.min, f.max)
+	}
+	return v, nil
+}
+
+// Next returns the first time after t that matches the schedule, in t's
+// location. It returns the zero time if nothing matches within five years
+// (e.g. "0 0 30 2 *").
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	domOK := s.dom&(1<<uint(t.Day())) != 0
+	dowOK := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domOK && dowOK
+	}
+	return domOK || dowOK
+}
@@ -0,0 +1,67 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		wantErr bool
+	}{
+		{name: "every minute", expr: "* * * * *"},
+		{name: "lists ranges and steps", expr: "0,30 9-17 */2 1-12/3 1-5"},
+		{name: "sunday as 7", expr: "0 0 * * 7"},
+		{name: "macro", expr: "@daily"},
+		{name: "too few fields", expr: "* * * *", wantErr: true},
+		{name: "out of range", expr: "60 * * * *", wantErr: true},
+		{name: "backwards range", expr: "* 5-2 * * *", wantErr: true},
+		{name: "bad step", expr: "*/0 * * * *", wantErr: true},
+		{name: "not a number", expr: "x * * * *", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse(%q) error = %v, wantErr %v", tt.expr, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSchedule_Next(t *testing.T) {
+	// Wednesday 2025-01-15 10:07:30 UTC
+	from := time.Date(2025, 1, 15, 10, 7, 30, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		expr string
+		want time.Time
+	}{
+		{name: "every minute", expr: "* * * * *", want: time.Date(2025, 1, 15, 10, 8, 0, 0, time.UTC)},
+		{name: "every 15 minutes", expr: "*/15 * * * *", want: time.Date(2025, 1, 15, 10, 15, 0, 0, time.UTC)},
+		{name: "daily at 02:30", expr: "30 2 * * *", want: time.Date(2025, 1, 16, 2, 30, 0, 0, time.UTC)},
+		{name: "hourly", expr: "@hourly", want: time.Date(2025, 1, 15, 11, 0, 0, 0, time.UTC)},
+		{name: "weekdays at 9", expr: "0 9 * * 1-5", want: time.Date(2025, 1, 16, 9, 0, 0, 0, time.UTC)},
+		{name: "sunday", expr: "0 0 * * 0", want: time.Date(2025, 1, 19, 0, 0, 0, 0, time.UTC)},
+		{name: "first of month", expr: "0 0 1 * *", want: time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{name: "dom or dow", expr: "0 0 20 * 5", want: time.Date(2025, 1, 17, 0, 0, 0, 0, time.UTC)},
+		{name: "leap day", expr: "0 0 29 2 *", want: time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{name: "never", expr: "0 0 30 2 *", want: time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", tt.expr, err)
+			}
+			if got := s.Next(from); !got.Equal(tt.want) {
+				t.Errorf("Next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,290 @@
+package locks
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	_ "github.com/glebarez/sqlite"
+
+	"github.com/you/lazyadmin/internal/migrate"
+)
+
+var (
+	ErrLockHeld     = errors.New("lock is held")
+	ErrLockNotFound = errors.New("lock not found")
+	ErrLockLost     = errors.New("lock lease lost")
+)
+
+// Owner identifies who is taking a lock.
+type Owner struct {
+	UserID  string
+	SSHUser string
+	RunID   string
+}
+
+// Holder describes a currently held lock.
+type Holder struct {
+	Name       string
+	UserID     string
+	SSHUser    string
+	Host       string
+	PID        int
+	RunID      string
+	AcquiredAt time.Time
+	ExpiresAt  time.Time
+}
+
+// HeldError is returned by Acquire when another holder has the lock. It
+// matches ErrLockHeld with errors.Is.
+type HeldError struct {
+	Holder Holder
+}
+
+func (e *HeldError) Error() string {
+	h := e.Holder
+	return fmt.Sprintf("lock %s is held by %s (ssh %s on %s) since %s, lease expires %s",
+		h.Name, h.UserID, h.SSHUser, h.Host,
+		h.AcquiredAt.Local().Format("15:04:05"),
+		h.ExpiresAt.Local().Format("15:04:05"))
+}
+
+func (e *HeldError) Unwrap() error {
+	return ErrLockHeld
+}
+
+// Store implements named leases in SQLite. Bastions sharing the database see
+// each other's locks; a lease that is not renewed expires and can be taken
+// over.
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore creates a lock store with the given SQLite database path.
+func NewStore(sqlitePath string) (*Store, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=journal_mode(WAL)", sqlitePath)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite: %w", err)
+	}
+
+	if _, err := migrate.Up(context.Background(), db, sqlitePath); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("init schema: %w", err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Close closes the database connection.
+func (s *Store) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// Lease is a held lock. It is renewed in the background until Release.
+type Lease struct {
+	store *Store
+	name  string
+	token string
+	ttl   time.Duration
+
+	stop chan struct{}
+	done chan struct{}
+	lost chan struct{} // closed when renewal finds the lease gone
+	once sync.Once
+}
+
+// Acquire takes the named lock for owner with the given lease duration, or
+// returns a *HeldError naming the current holder. An expired lease is taken
+// over.
+func (s *Store) Acquire(ctx context.Context, name string, owner Owner, ttl time.Duration) (*Lease, error) {
+	host, _ := os.Hostname()
+	token := newToken()
+	now := time.Now()
+
+	res, err := s.db.ExecContext(ctx,
+		`INSERT INTO locks (name, token, user_id, ssh_user, host, pid, run_id, acquired_at, expires_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(name) DO UPDATE SET
+		   token = excluded.token, user_id = excluded.user_id, ssh_user = excluded.ssh_user,
+		   host = excluded.host, pid = excluded.pid, run_id = excluded.run_id,
+		   acquired_at = excluded.acquired_at, expires_at = excluded.expires_at
+		 WHERE locks.expires_at < excluded.acquired_at`,
+		name, token, owner.UserID, owner.SSHUser, host, os.Getpid(), owner.RunID,
+		now.UnixNano(), now.Add(ttl).UnixNano(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("acquire lock %s: %w", name, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		holder, err := s.Get(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("lock %s: %w", name, ErrLockHeld)
+		}
+		return nil, &HeldError{Holder: *holder}
+	}
+
+	l := &Lease{
+		store: s,
+		name:  name,
+		token: token,
+		ttl:   ttl,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+		lost:  make(chan struct{}),
+	}
+	go l.keepAlive()
+	return l, nil
+}
+
+// Name returns the lock name.
+func (l *Lease) Name() string {
+	return l.name
+}
+
+// Lost is closed if background renewal finds the lease expired and taken
+// over, or force-released.
+func (l *Lease) Lost() <-chan struct{} {
+	return l.lost
+}
+
+func (l *Lease) keepAlive() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			if err := l.Renew(context.Background()); errors.Is(err, ErrLockLost) {
+				close(l.lost)
+				return
+			}
+		}
+	}
+}
+
+// Renew extends the lease. It returns ErrLockLost if the lock expired and
+// was taken over, or was force-released.
+func (l *Lease) Renew(ctx context.Context) error {
+	res, err := l.store.db.ExecContext(ctx,
+		`UPDATE locks SET expires_at = ? WHERE name = ? AND token = ?`,
+		time.Now().Add(l.ttl).UnixNano(), l.name, l.token,
+	)
+	if err != nil {
+		return fmt.Errorf("renew lock %s: %w", l.name, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("lock %s: %w", l.name, ErrLockLost)
+	}
+	return nil
+}
+
+// Release stops renewal and frees the lock if this lease still holds it.
+func (l *Lease) Release(ctx context.Context) error {
+	l.once.Do(func() { close(l.stop) })
+	<-l.done
+
+	_, err := l.store.db.ExecContext(ctx,
+		`DELETE FROM locks WHERE name = ? AND token = ?`,
+		l.name, l.token,
+	)
+	if err != nil {
+		return fmt.Errorf("release lock %s: %w", l.name, err)
+	}
+	return nil
+}
+
+// Get returns the current holder of the named lock. Expired leases are
+// reported as not found.
+func (s *Store) Get(ctx context.Context, name string) (*Holder, error) {
+	row := s.db.QueryRowContext(ctx,
+		`SELECT name, user_id, ssh_user, host, pid, run_id, acquired_at, expires_at
+		 FROM locks WHERE name = ? AND expires_at >= ?`,
+		name, time.Now().UnixNano(),
+	)
+	h, err := scanHolder(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrLockNotFound
+		}
+		return nil, fmt.Errorf("get lock: %w", err)
+	}
+	return h, nil
+}
+
+// List returns all unexpired locks ordered by name.
+func (s *Store) List(ctx context.Context) ([]Holder, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT name, user_id, ssh_user, host, pid, run_id, acquired_at, expires_at
+		 FROM locks WHERE expires_at >= ? ORDER BY name`,
+		time.Now().UnixNano(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list locks: %w", err)
+	}
+	defer rows.Close()
+
+	var out []Holder
+	for rows.Next() {
+		h, err := scanHolder(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan lock: %w", err)
+		}
+		out = append(out, *h)
+	}
+	return out, rows.Err()
+}
+
+// ForceRelease removes the named lock regardless of who holds it and returns
+// the holder it displaced. Callers are responsible for authorization and
+// auditing.
+func (s *Store) ForceRelease(ctx context.Context, name string) (*Holder, error) {
+	h, err := s.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM locks WHERE name = ?`, name); err != nil {
+		return nil, fmt.Errorf("force release lock %s: %w", name, err)
+	}
+	return h, nil
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanHolder(sc scanner) (*Holder, error) {
+	var (
+		h          Holder
+		acquiredAt int64
+		expiresAt  int64
+	)
+	if err := sc.Scan(&h.Name, &h.UserID, &h.SSHUser, &h.Host, &h.PID, &h.RunID, &acquiredAt, &expiresAt); err != nil {
+		return nil, err
+	}
+	h.AcquiredAt = time.Unix(0, acquiredAt)
+	h.ExpiresAt = time.Unix(0, expiresAt)
+	return &h, nil
+}
+
+func newToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,118 @@
+package locks
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	store, err := NewStore(t.TempDir() + "/locks.db")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestStore_AcquireRelease(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+
+	alice := Owner{UserID: "alice", SSHUser: "alice", RunID: "r1"}
+	bob := Owner{UserID: "bob", SSHUser: "bob"}
+
+	lease, err := store.Acquire(ctx, "prod/rotate_keys", alice, time.Minute)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	_, err = store.Acquire(ctx, "prod/rotate_keys", bob, time.Minute)
+	var held *HeldError
+	if !errors.As(err, &held) || !errors.Is(err, ErrLockHeld) {
+		t.Fatalf("second Acquire() error = %v, want *HeldError", err)
+	}
+	if held.Holder.UserID != "alice" || held.Holder.RunID != "r1" {
+		t.Errorf("holder = %+v, want alice/r1", held.Holder)
+	}
+
+	if _, err := store.Acquire(ctx, "staging/rotate_keys", bob, time.Minute); err != nil {
+		t.Errorf("Acquire() of a different name error = %v", err)
+	}
+
+	if err := lease.Release(ctx); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if _, err := store.Get(ctx, "prod/rotate_keys"); !errors.Is(err, ErrLockNotFound) {
+		t.Errorf("Get() after release error = %v, want ErrLockNotFound", err)
+	}
+	if _, err := store.Acquire(ctx, "prod/rotate_keys", bob, time.Minute); err != nil {
+		t.Errorf("Acquire() after release error = %v", err)
+	}
+}
+
+func TestStore_LeaseExpiry(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+
+	stale, err := store.Acquire(ctx, "job", Owner{UserID: "alice"}, 30*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	// Stop renewal without deleting the row, as if the process died.
+	stale.once.Do(func() { close(stale.stop) })
+	<-stale.done
+
+	time.Sleep(50 * time.Millisecond)
+
+	lease, err := store.Acquire(ctx, "job", Owner{UserID: "bob"}, time.Minute)
+	if err != nil {
+		t.Fatalf("Acquire() of expired lock error = %v", err)
+	}
+	if err := stale.Renew(ctx); !errors.Is(err, ErrLockLost) {
+		t.Errorf("Renew() of taken-over lease error = %v, want ErrLockLost", err)
+	}
+
+	// The stale lease must not release the new holder's lock.
+	_ = stale.Release(ctx)
+	if h, err := store.Get(ctx, "job"); err != nil || h.UserID != "bob" {
+		t.Errorf("Get() = %+v, %v; want bob", h, err)
+	}
+	_ = lease.Release(ctx)
+}
+
+func TestStore_KeepAliveAndForceRelease(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+
+	lease, err := store.Acquire(ctx, "job", Owner{UserID: "alice"}, 60*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	defer lease.Release(ctx)
+
+	time.Sleep(150 * time.Millisecond)
+	if _, err := store.Acquire(ctx, "job", Owner{UserID: "bob"}, time.Minute); !errors.Is(err, ErrLockHeld) {
+		t.Fatalf("Acquire() while renewed error = %v, want ErrLockHeld", err)
+	}
+
+	list, err := store.List(ctx)
+	if err != nil || len(list) != 1 {
+		t.Fatalf("List() = %v, %v; want 1 lock", list, err)
+	}
+
+	h, err := store.ForceRelease(ctx, "job")
+	if err != nil || h.UserID != "alice" {
+		t.Fatalf("ForceRelease() = %+v, %v; want alice", h, err)
+	}
+	select {
+	case <-lease.Lost():
+	case <-time.After(time.Second):
+		t.Errorf("Lost() not closed after force release")
+	}
+	if _, err := store.ForceRelease(ctx, "job"); !errors.Is(err, ErrLockNotFound) {
+		t.Errorf("ForceRelease() of free lock error = %v, want ErrLockNotFound", err)
+	}
+}
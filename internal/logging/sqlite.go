@@ -7,10 +7,16 @@ import (
 	"time"
 
 	_ "github.com/glebarez/sqlite"
+
+	"github.com/you/lazyadmin/internal/migrate"
 )
 
 type AuditLogger struct {
-	db *sql.DB
+	db        *sql.DB
+	mark      string
+	markUntil time.Time
+	env       string
+	redact    func(string) string
 }
 
 type AuditEntry struct {
@@ -20,6 +26,10 @@ type AuditEntry struct {
 	OperationID string
 	Success     bool
 	Error       string
+	RunID       string // task run this entry belongs to, if any
+	ParentRunID string // run that invoked RunID as a nested task or operation
+	Mark        string // set from SetMark when empty and before its end
+	Env         string // set from SetEnv or WithEnv when empty
 }
 
 func NewAuditLogger(sqlitePath string) (*AuditLogger, error) {
@@ -29,18 +39,8 @@ func NewAuditLogger(sqlitePath string) (*AuditLogger, error) {
 		return nil, fmt.Errorf("open sqlite: %w", err)
 	}
 
-	schema := `
-CREATE TABLE IF NOT EXISTS audit_log (
-  id INTEGER PRIMARY KEY AUTOINCREMENT,
-  occurred_at TEXT NOT NULL,
-  user_id TEXT NOT NULL,
-  ssh_user TEXT NOT NULL,
-  operation_id TEXT NOT NULL,
-  success INTEGER NOT NULL,
-  error TEXT
-);`
-
-	if _, err := db.Exec(schema); err != nil {
+	if _, err := migrate.Up(context.Background(), db, sqlitePath); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("init schema: %w", err)
 	}
 
@@ -54,21 +54,63 @@ func (l *AuditLogger) Close() error {
 	return l.db.Close()
 }
 
+// SetMark tags every entry logged from now on whose time is before until,
+// such as "break-glass" for an emergency window; a zero until tags entries
+// indefinitely. Call it before the logger is shared between goroutines.
+func (l *AuditLogger) SetMark(mark string, until time.Time) {
+	l.mark = mark
+	l.markUntil = until
+}
+
+// SetEnv records env as the environment of every entry logged from now on.
+// Like SetMark, call it before the logger is shared; use WithEnv to switch
+// environments afterwards.
+func (l *AuditLogger) SetEnv(env string) {
+	l.env = env
+}
+
+// WithEnv returns a logger writing to the same database with entries in
+// environment env, keeping the mark. Close only the original logger.
+func (l *AuditLogger) WithEnv(env string) *AuditLogger {
+	return &AuditLogger{db: l.db, mark: l.mark, markUntil: l.markUntil, env: env, redact: l.redact}
+}
+
+// SetRedact passes the operation ID and error of every entry through redact
+// before it is written, so resolved secrets never reach the audit log. Like
+// SetMark, call it before the logger is shared.
+func (l *AuditLogger) SetRedact(redact func(string) string) {
+	l.redact = redact
+}
+
 func (l *AuditLogger) Log(ctx context.Context, entry AuditEntry) error {
 	if l.db == nil {
 		return nil
 	}
+	if entry.Mark == "" && (l.markUntil.IsZero() || entry.Time.Before(l.markUntil)) {
+		entry.Mark = l.mark
+	}
+	if entry.Env == "" {
+		entry.Env = l.env
+	}
+	if l.redact != nil {
+		entry.OperationID = l.redact(entry.OperationID)
+		entry.Error = l.redact(entry.Error)
+	}
 
 	_, err := l.db.ExecContext(ctx,
 		`INSERT INTO audit_log 
-		 (occurred_at, user_id, ssh_user, operation_id, success, error)
-		 VALUES (?, ?, ?, ?, ?, ?)`,
+		 (occurred_at, user_id, ssh_user, operation_id, success, error, run_id, parent_run_id, mark, env)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		entry.Time.UTC().Format(time.RFC3339Nano),
 		entry.UserID,
 		entry.SSHUser,
 		entry.OperationID,
 		boolToInt(entry.Success),
 		entry.Error,
+		entry.RunID,
+		entry.ParentRunID,
+		entry.Mark,
+		entry.Env,
 	)
 	return err
 }
@@ -87,6 +129,10 @@ type AuditRow struct {
 	OperationID string
 	Success     bool
 	Error       string
+	RunID       string
+	ParentRunID string
+	Mark        string
+	Env         string
 }
 
 // ReadRecent returns the most recent N audit log entries (newest first).
@@ -96,7 +142,7 @@ func ReadRecent(l *AuditLogger, limit int) ([]AuditRow, error) {
 	}
 
 	rows, err := l.db.Query(`
-SELECT occurred_at, user_id, ssh_user, operation_id, success, error
+SELECT occurred_at, user_id, ssh_user, operation_id, success, error, run_id, parent_run_id, mark, env
 FROM audit_log
 ORDER BY id DESC
 LIMIT ?`, limit)
@@ -114,9 +160,13 @@ LIMIT ?`, limit)
 			opID   string
 			succ   int
 			errMsg *string
+			runID  *string
+			parent *string
+			mark   *string
+			env    string
 		)
 
-		if err := rows.Scan(&tsStr, &userID, &ssh, &opID, &succ, &errMsg); err != nil {
+		if err := rows.Scan(&tsStr, &userID, &ssh, &opID, &succ, &errMsg, &runID, &parent, &mark, &env); err != nil {
 			return nil, err
 		}
 
@@ -127,10 +177,20 @@ LIMIT ?`, limit)
 			SSHUser:     ssh,
 			OperationID: opID,
 			Success:     succ == 1,
+			Env:         env,
 		}
 		if errMsg != nil {
 			row.Error = *errMsg
 		}
+		if runID != nil {
+			row.RunID = *runID
+		}
+		if parent != nil {
+			row.ParentRunID = *parent
+		}
+		if mark != nil {
+			row.Mark = *mark
+		}
 
 		out = append(out, row)
 	}
@@ -2,6 +2,7 @@ package logging
 
 import (
 	"context"
+	"strings"
 	"testing"
 	"time"
 )
@@ -208,6 +209,87 @@ func TestAuditLogger_ReadRecent_Empty(t *testing.T) {
 	}
 }
 
+func TestAuditLogger_SetMark(t *testing.T) {
+	logger, err := NewAuditLogger(":memory:")
+	if err != nil {
+		t.Fatalf("NewAuditLogger() error = %v", err)
+	}
+	defer logger.Close()
+
+	ctx := context.Background()
+	if err := logger.Log(ctx, AuditEntry{Time: time.Now(), UserID: "alice", OperationID: "before"}); err != nil {
+		t.Fatalf("Log() error = %v", err)
+	}
+	until := time.Now().Add(time.Hour)
+	logger.SetMark("break-glass", until)
+	if err := logger.Log(ctx, AuditEntry{Time: time.Now(), UserID: "alice", OperationID: "during"}); err != nil {
+		t.Fatalf("Log() error = %v", err)
+	}
+	// The mark ends with its window, for copies of the logger too.
+	if err := logger.WithEnv("prod").Log(ctx, AuditEntry{Time: until, UserID: "alice", OperationID: "after"}); err != nil {
+		t.Fatalf("Log() error = %v", err)
+	}
+
+	rows, err := ReadRecent(logger, 10)
+	if err != nil {
+		t.Fatalf("ReadRecent() error = %v", err)
+	}
+	if len(rows) != 3 || rows[0].Mark != "" || rows[1].Mark != "break-glass" || rows[2].Mark != "" {
+		t.Errorf("ReadRecent() = %+v, want only the entry during the window marked", rows)
+	}
+}
+
+func TestAuditLogger_Env(t *testing.T) {
+	logger, err := NewAuditLogger(":memory:")
+	if err != nil {
+		t.Fatalf("NewAuditLogger() error = %v", err)
+	}
+	defer logger.Close()
+
+	ctx := context.Background()
+	logger.SetEnv("staging")
+	logger.SetMark("break-glass", time.Time{})
+	prod := logger.WithEnv("prod")
+	for _, l := range []*AuditLogger{logger, prod} {
+		if err := l.Log(ctx, AuditEntry{Time: time.Now(), UserID: "alice", OperationID: "op"}); err != nil {
+			t.Fatalf("Log() error = %v", err)
+		}
+	}
+	if err := prod.Log(ctx, AuditEntry{Time: time.Now(), UserID: "alice", OperationID: "op", Env: "dev"}); err != nil {
+		t.Fatalf("Log() error = %v", err)
+	}
+
+	rows, err := ReadRecent(logger, 10)
+	if err != nil {
+		t.Fatalf("ReadRecent() error = %v", err)
+	}
+	if len(rows) != 3 || rows[0].Env != "dev" || rows[1].Env != "prod" || rows[2].Env != "staging" || rows[1].Mark != "break-glass" {
+		t.Errorf("ReadRecent() = %+v, want envs dev, prod, staging", rows)
+	}
+}
+
+func TestAuditLogger_SetRedact(t *testing.T) {
+	logger, err := NewAuditLogger(":memory:")
+	if err != nil {
+		t.Fatalf("NewAuditLogger() error = %v", err)
+	}
+	defer logger.Close()
+
+	logger.SetRedact(func(s string) string { return strings.ReplaceAll(s, "hunter22", "[REDACTED]") })
+	entry := AuditEntry{Time: time.Now(), UserID: "alice", OperationID: "sql:hunter22", Error: `password "hunter22" rejected`}
+	if err := logger.WithEnv("prod").Log(context.Background(), entry); err != nil {
+		t.Fatalf("Log() error = %v", err)
+	}
+
+	rows, err := ReadRecent(logger, 10)
+	if err != nil {
+		t.Fatalf("ReadRecent() error = %v", err)
+	}
+	if len(rows) != 1 || rows[0].OperationID != "sql:[REDACTED]" || rows[0].Error != `password "[REDACTED]" rejected` {
+		t.Errorf("ReadRecent() = %+v, want the secret redacted", rows)
+	}
+}
+
 func TestAuditLogger_Close(t *testing.T) {
 	logger, err := NewAuditLogger(":memory:")
 	if err != nil {
@@ -0,0 +1,333 @@
+// Package migrate versions the schema of the shared SQLite database.
+//
+// Migrations are numbered SQL files embedded from sql/ (0001_baseline.sql,
+// 0002_...). Each is applied once, in its own transaction, and recorded in
+// the schema_migrations table. A database whose version is newer than the
+// latest migration known to this build is refused, and an existing database
+// is copied aside before it is upgraded.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/glebarez/sqlite"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+var ErrSchemaTooNew = errors.New("database schema is newer than this build of lazyadmin")
+
+// busyTimeoutMS is how long a migration waits for another process holding
+// the database write lock, for example a second bastion migrating at the
+// same time.
+const busyTimeoutMS = 10000
+
+// Migration is one numbered schema change.
+type Migration struct {
+	Version int
+	Name    string // file name without version and extension, e.g. "baseline"
+	SQL     string
+}
+
+// Applied is a migration recorded in schema_migrations.
+type Applied struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time
+}
+
+// State describes the schema version of a database.
+type State struct {
+	Current int // highest applied version; 0 for a new or unversioned database
+	Latest  int // highest version known to this build
+	Applied []Applied
+	Pending []Migration
+}
+
+// Result describes what Up did.
+type Result struct {
+	Applied []Migration
+	Backup  string // path of the copy taken before migrating, if any
+}
+
+// legacyColumns were added to tables by releases before schema versioning.
+// Databases created by those releases may lack them, so they are added
+// before the baseline migration adopts the database.
+var legacyColumns = []struct{ table, column, typ string }{
+	{"audit_log", "run_id", "TEXT"},
+	{"audit_log", "parent_run_id", "TEXT"},
+	{"audit_log", "mark", "TEXT"},
+	{"users", "disabled", "INTEGER NOT NULL DEFAULT 0"},
+}
+
+// Migrations returns the embedded migrations ordered by version.
+func Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(files, "sql")
+	if err != nil {
+		return nil, err
+	}
+
+	var ms []Migration
+	for _, e := range entries {
+		version, name, ok := strings.Cut(strings.TrimSuffix(e.Name(), ".sql"), "_")
+		v, err := strconv.Atoi(version)
+		if !ok || err != nil || v <= 0 {
+			return nil, fmt.Errorf("migration %s: name must be NNNN_name.sql", e.Name())
+		}
+		body, err := files.ReadFile(path.Join("sql", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if v != len(ms)+1 {
+			return nil, fmt.Errorf("migration %s: versions must be consecutive from 1", e.Name())
+		}
+		ms = append(ms, Migration{Version: v, Name: name, SQL: string(body)})
+	}
+	return ms, nil
+}
+
+// Open opens the SQLite database at path the same way the stores do.
+func Open(path string) (*sql.DB, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=journal_mode(WAL)", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite: %w", err)
+	}
+	return db, nil
+}
+
+// Status reports the schema version of db without changing it.
+func Status(ctx context.Context, db *sql.DB) (*State, error) {
+	ms, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	return status(ctx, db, ms)
+}
+
+// Up applies pending migrations to db, which was opened from path. If the
+// database already holds data, it is first copied to a backup file next to
+// path. Up returns ErrSchemaTooNew if db has a migration this build does
+// not know.
+func Up(ctx context.Context, db *sql.DB, path string) (*Result, error) {
+	ms, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	return up(ctx, db, path, ms)
+}
+
+func up(ctx context.Context, db *sql.DB, path string, ms []Migration) (*Result, error) {
+	// Transactions are run by hand on one connection so the write lock can
+	// be taken up front with BEGIN IMMEDIATE.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("PRAGMA busy_timeout = %d", busyTimeoutMS)); err != nil {
+		return nil, err
+	}
+	if _, err := conn.ExecContext(ctx, `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+  version INTEGER PRIMARY KEY,
+  name TEXT NOT NULL,
+  applied_at TEXT NOT NULL
+);`); err != nil {
+		return nil, fmt.Errorf("create schema_migrations: %w", err)
+	}
+
+	st, err := status(ctx, conn, ms)
+	if err != nil {
+		return nil, err
+	}
+	if err := st.check(); err != nil {
+		return nil, err
+	}
+	res := &Result{}
+	if len(st.Pending) == 0 {
+		return res, nil
+	}
+
+	existing := st.Current > 0
+	if !existing {
+		if existing, err = hasTables(ctx, conn); err != nil {
+			return nil, err
+		}
+	}
+	if existing && path != "" && path != ":memory:" {
+		res.Backup = fmt.Sprintf("%s.v%d-%s.bak", path, st.Current, time.Now().UTC().Format("20060102T150405Z"))
+		if _, err := conn.ExecContext(ctx, `VACUUM INTO ?`, res.Backup); err != nil {
+			return nil, fmt.Errorf("backup to %s: %w", res.Backup, err)
+		}
+	}
+
+	for _, m := range st.Pending {
+		applied, err := apply(ctx, conn, m)
+		if err != nil {
+			return res, fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+		if applied {
+			res.Applied = append(res.Applied, m)
+		}
+	}
+	return res, nil
+}
+
+// apply runs m in a transaction unless another process applied it first.
+func apply(ctx context.Context, conn *sql.Conn, m Migration) (applied bool, err error) {
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return false, err
+	}
+	defer func() {
+		if err != nil {
+			conn.ExecContext(context.Background(), "ROLLBACK")
+		}
+	}()
+
+	var n int
+	if err := conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM schema_migrations WHERE version = ?`, m.Version).Scan(&n); err != nil {
+		return false, err
+	}
+	if n == 0 {
+		if m.Version == 1 {
+			for _, c := range legacyColumns {
+				if err := ensureColumn(ctx, conn, c.table, c.column, c.typ); err != nil {
+					return false, err
+				}
+			}
+		}
+		if _, err := conn.ExecContext(ctx, m.SQL); err != nil {
+			return false, err
+		}
+		if _, err := conn.ExecContext(ctx,
+			`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+			m.Version, m.Name, time.Now().UTC().Format(time.RFC3339Nano),
+		); err != nil {
+			return false, err
+		}
+	}
+	if _, err := conn.ExecContext(ctx, "COMMIT"); err != nil {
+		return false, err
+	}
+	return n == 0, nil
+}
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func status(ctx context.Context, q querier, ms []Migration) (*State, error) {
+	st := &State{}
+	if len(ms) > 0 {
+		st.Latest = ms[len(ms)-1].Version
+	}
+
+	var n int
+	if err := q.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'`,
+	).Scan(&n); err != nil {
+		return nil, fmt.Errorf("read schema version: %w", err)
+	}
+
+	done := map[int]bool{}
+	if n > 0 {
+		rows, err := q.QueryContext(ctx, `SELECT version, name, applied_at FROM schema_migrations ORDER BY version`)
+		if err != nil {
+			return nil, fmt.Errorf("read schema version: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var (
+				a         Applied
+				appliedAt string
+			)
+			if err := rows.Scan(&a.Version, &a.Name, &appliedAt); err != nil {
+				return nil, fmt.Errorf("read schema version: %w", err)
+			}
+			a.AppliedAt, _ = time.Parse(time.RFC3339Nano, appliedAt)
+			st.Applied = append(st.Applied, a)
+			st.Current = max(st.Current, a.Version)
+			done[a.Version] = true
+		}
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("read schema version: %w", err)
+		}
+	}
+
+	for _, m := range ms {
+		if !done[m.Version] {
+			st.Pending = append(st.Pending, m)
+		}
+	}
+	return st, nil
+}
+
+// check returns ErrSchemaTooNew if the database was migrated by a newer build.
+func (st *State) check() error {
+	if st.Current > st.Latest {
+		return fmt.Errorf("%w: database is at version %d, this build knows up to %d", ErrSchemaTooNew, st.Current, st.Latest)
+	}
+	return nil
+}
+
+// hasTables reports whether the database holds tables other than
+// schema_migrations, i.e. was created by a release before versioning.
+func hasTables(ctx context.Context, q querier) (bool, error) {
+	var n int
+	err := q.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table'
+		   AND name NOT IN ('schema_migrations', 'sqlite_sequence')`,
+	).Scan(&n)
+	return n > 0, err
+}
+
+// ensureColumn adds column to table if the table exists without it.
+func ensureColumn(ctx context.Context, conn *sql.Conn, table, column, colType string) error {
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	found := false
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			typ       string
+			notNull   int
+			dfltValue *string
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dfltValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+		found = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if !found {
+		return nil // the baseline creates the table
+	}
+	rows.Close()
+
+	_, err = conn.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, colType))
+	return err
+}
@@ -0,0 +1,164 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openTemp(t *testing.T) (string, func() *Result) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "lazyadmin.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return path, func() *Result {
+		t.Helper()
+		res, err := Up(context.Background(), db, path)
+		if err != nil {
+			t.Fatalf("Up() error = %v", err)
+		}
+		return res
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	ms, err := Migrations()
+	if err != nil {
+		t.Fatalf("Migrations() error = %v", err)
+	}
+	if len(ms) == 0 || ms[0].Version != 1 || ms[0].Name != "baseline" {
+		t.Fatalf("Migrations() = %+v, want 0001_baseline first", ms)
+	}
+}
+
+func TestUp_NewDatabase(t *testing.T) {
+	path, migrate := openTemp(t)
+	ms, _ := Migrations()
+
+	res := migrate()
+	if len(res.Applied) != len(ms) || res.Backup != "" {
+		t.Errorf("first Up() = %+v, want %d migrations and no backup", res, len(ms))
+	}
+	if res := migrate(); len(res.Applied) != 0 || res.Backup != "" {
+		t.Errorf("second Up() = %+v, want nothing to do", res)
+	}
+
+	db, _ := Open(path)
+	defer db.Close()
+	st, err := Status(context.Background(), db)
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	if st.Current != st.Latest || len(st.Pending) != 0 || len(st.Applied) != len(ms) {
+		t.Errorf("Status() = %+v", st)
+	}
+}
+
+func TestUp_LegacyDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lazyadmin.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer db.Close()
+
+	// Tables as created by releases before run linkage, marks and disabled
+	// users.
+	legacy := `
+CREATE TABLE audit_log (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  occurred_at TEXT NOT NULL,
+  user_id TEXT NOT NULL,
+  ssh_user TEXT NOT NULL,
+  operation_id TEXT NOT NULL,
+  success INTEGER NOT NULL,
+  error TEXT
+);
+CREATE TABLE users (
+  id TEXT PRIMARY KEY,
+  ssh_users TEXT NOT NULL,
+  roles TEXT NOT NULL,
+  created_at TEXT NOT NULL,
+  updated_at TEXT NOT NULL
+);
+INSERT INTO users VALUES ('alice', '["alice"]', '["admin"]', '', '');`
+	if _, err := db.Exec(legacy); err != nil {
+		t.Fatalf("create legacy schema: %v", err)
+	}
+
+	ctx := context.Background()
+	res, err := Up(ctx, db, path)
+	if err != nil {
+		t.Fatalf("Up() error = %v", err)
+	}
+	if res.Backup == "" {
+		t.Fatal("Up() took no backup of the legacy database")
+	}
+	if _, err := os.Stat(res.Backup); err != nil {
+		t.Errorf("backup %s: %v", res.Backup, err)
+	}
+
+	var disabled int
+	if err := db.QueryRow(`SELECT disabled FROM users WHERE id = 'alice'`).Scan(&disabled); err != nil || disabled != 0 {
+		t.Errorf("users.disabled = %d, %v", disabled, err)
+	}
+	if _, err := db.Exec(`INSERT INTO audit_log (occurred_at, user_id, ssh_user, operation_id, success, run_id, parent_run_id, mark)
+		VALUES ('', '', '', '', 1, '', '', '')`); err != nil {
+		t.Errorf("audit_log lacks new columns: %v", err)
+	}
+	if _, err := db.Exec(`SELECT COUNT(*) FROM locks`); err != nil {
+		t.Errorf("baseline did not create locks: %v", err)
+	}
+}
+
+func TestUp_SchemaTooNew(t *testing.T) {
+	path, migrate := openTemp(t)
+	migrate()
+
+	db, _ := Open(path)
+	defer db.Close()
+	if _, err := db.Exec(`INSERT INTO schema_migrations VALUES (9999, 'future', '')`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Up(context.Background(), db, path); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("Up() error = %v, want ErrSchemaTooNew", err)
+	}
+}
+
+func TestUp_FailedMigrationRollsBack(t *testing.T) {
+	path, migrate := openTemp(t)
+	migrate()
+
+	db, _ := Open(path)
+	defer db.Close()
+	ms, _ := Migrations()
+	bad := Migration{
+		Version: len(ms) + 1,
+		Name:    "bad",
+		SQL:     `CREATE TABLE extra (id TEXT); INSERT INTO missing VALUES (1);`,
+	}
+	ctx := context.Background()
+	res, err := up(ctx, db, path, append(ms, bad))
+	if err == nil {
+		t.Fatal("up() with a failing migration succeeded")
+	}
+	if res.Backup == "" {
+		t.Error("up() took no backup before migrating")
+	}
+
+	st, err := status(ctx, db, append(ms, bad))
+	if err != nil {
+		t.Fatalf("status() error = %v", err)
+	}
+	if st.Current != len(ms) || len(st.Pending) != 1 {
+		t.Errorf("status() = %+v, want version %d with one pending", st, len(ms))
+	}
+	if _, err := db.Exec(`SELECT * FROM extra`); err == nil {
+		t.Error("table from the failed migration exists")
+	}
+}
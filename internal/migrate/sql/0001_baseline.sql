@@ -0,0 +1,125 @@
+-- Schema as of the first versioned release. Every statement is idempotent so
+-- databases created by earlier releases can be adopted; see legacyColumns for
+-- the columns those may lack.
+
+CREATE TABLE IF NOT EXISTS audit_log (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  occurred_at TEXT NOT NULL,
+  user_id TEXT NOT NULL,
+  ssh_user TEXT NOT NULL,
+  operation_id TEXT NOT NULL,
+  success INTEGER NOT NULL,
+  error TEXT,
+  run_id TEXT,
+  parent_run_id TEXT,
+  mark TEXT
+);
+
+CREATE TABLE IF NOT EXISTS users (
+  id TEXT PRIMARY KEY,
+  ssh_users TEXT NOT NULL, -- JSON array
+  roles TEXT NOT NULL,     -- JSON array
+  disabled INTEGER NOT NULL DEFAULT 0,
+  created_at TEXT NOT NULL,
+  updated_at TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS credentials (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id TEXT NOT NULL,
+  rp_id TEXT NOT NULL,
+  credential_id TEXT NOT NULL,
+  public_key TEXT NOT NULL,
+  created_at TEXT NOT NULL,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  UNIQUE(user_id, rp_id, credential_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_credentials_user_id ON credentials(user_id);
+CREATE INDEX IF NOT EXISTS idx_credentials_rp_id ON credentials(rp_id);
+
+CREATE TABLE IF NOT EXISTS ssh_keys (
+  fingerprint TEXT PRIMARY KEY, -- SHA256:<base64>, as printed by ssh-keygen -l
+  user_id TEXT NOT NULL,
+  created_at TEXT NOT NULL,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_ssh_keys_user_id ON ssh_keys(user_id);
+
+CREATE TABLE IF NOT EXISTS role_grants (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id TEXT NOT NULL,      -- config or store user ID
+  role TEXT NOT NULL,
+  reason TEXT NOT NULL,
+  duration_ms INTEGER NOT NULL,
+  status TEXT NOT NULL,       -- pending, active, denied, revoked, expired
+  requested_at TEXT NOT NULL,
+  decided_by TEXT NOT NULL,
+  decided_at TEXT NOT NULL,
+  expires_at TEXT NOT NULL,
+  revoked_by TEXT NOT NULL,
+  revoked_at TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_role_grants_user_id ON role_grants(user_id, status);
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+  hash TEXT PRIMARY KEY,  -- SHA-256 of the normalized code, hex
+  user_id TEXT NOT NULL,  -- config or store user ID
+  created_at TEXT NOT NULL,
+  used_at TEXT NOT NULL   -- empty until the code is used
+);
+
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON recovery_codes(user_id);
+
+CREATE TABLE IF NOT EXISTS task_runs (
+  id TEXT PRIMARY KEY,
+  task_id TEXT NOT NULL,
+  user_id TEXT NOT NULL,
+  ssh_user TEXT NOT NULL,
+  inputs TEXT NOT NULL,   -- JSON object
+  status TEXT NOT NULL,
+  host TEXT NOT NULL,
+  pid INTEGER NOT NULL,
+  resumed_from TEXT,
+  started_at TEXT NOT NULL,
+  updated_at TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS task_run_steps (
+  run_id TEXT NOT NULL,
+  step_id TEXT NOT NULL,
+  seq INTEGER NOT NULL,
+  ok INTEGER NOT NULL,
+  output TEXT NOT NULL,
+  error TEXT NOT NULL,
+  finished_at TEXT NOT NULL,
+  PRIMARY KEY (run_id, step_id),
+  FOREIGN KEY (run_id) REFERENCES task_runs(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_task_runs_status ON task_runs(status);
+
+-- Lock times are unix nanoseconds so expiry can be compared in SQL.
+CREATE TABLE IF NOT EXISTS locks (
+  name TEXT PRIMARY KEY,
+  token TEXT NOT NULL,
+  user_id TEXT NOT NULL,
+  ssh_user TEXT NOT NULL,
+  host TEXT NOT NULL,
+  pid INTEGER NOT NULL,
+  run_id TEXT NOT NULL,
+  acquired_at INTEGER NOT NULL,
+  expires_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS schedule_state (
+  id TEXT PRIMARY KEY,
+  running INTEGER NOT NULL,
+  last_started_at TEXT NOT NULL,
+  last_finished_at TEXT NOT NULL,
+  last_run_id TEXT NOT NULL,
+  last_success INTEGER NOT NULL,
+  last_error TEXT NOT NULL
+);
@@ -0,0 +1,5 @@
+-- Credentials get a human-readable name ("blue backup key") and keep the
+-- WebAuthn user handle they were registered with, base64url-encoded.
+
+ALTER TABLE credentials ADD COLUMN name TEXT NOT NULL DEFAULT '';
+ALTER TABLE credentials ADD COLUMN user_handle TEXT NOT NULL DEFAULT '';
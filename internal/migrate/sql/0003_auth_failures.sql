@@ -0,0 +1,9 @@
+-- Consecutive failed sign-ins per user, for auth.lockout. Config users have
+-- no users row, so there is no foreign key.
+
+CREATE TABLE IF NOT EXISTS auth_failures (
+  user_id TEXT PRIMARY KEY,      -- config or store user ID
+  failures INTEGER NOT NULL,     -- consecutive failures since the last success or unlock
+  last_failure_at TEXT NOT NULL,
+  locked_until TEXT NOT NULL     -- empty unless a lockout was imposed
+);
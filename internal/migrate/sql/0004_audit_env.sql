@@ -0,0 +1,4 @@
+-- Audit entries record the environment (env:, or the one switched to in the
+-- TUI) they were written in. Older entries keep an empty env.
+
+ALTER TABLE audit_log ADD COLUMN env TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,4 @@
+-- Recovery codes are hashed with a random salt per code. Codes issued before
+-- keep an empty salt, which hashes them as before, until they are reissued.
+
+ALTER TABLE recovery_codes ADD COLUMN salt TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,6 @@
+-- Task runs record the boot ID and start time of the process that owns
+-- them, so a run whose PID was reused after a reboot or in a new container
+-- is recognised as interrupted. Older runs keep an empty value and are
+-- checked by PID alone.
+
+ALTER TABLE task_runs ADD COLUMN proc_start TEXT NOT NULL DEFAULT '';
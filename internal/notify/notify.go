@@ -0,0 +1,116 @@
+// Package notify sends security events, such as break-glass use, to the
+// notification sinks in the config's notifications: section.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/you/lazyadmin/internal/config"
+	"github.com/you/lazyadmin/internal/secrets"
+)
+
+// sendTimeout bounds each delivery so an unreachable sink cannot hold up
+// the caller.
+const sendTimeout = 5 * time.Second
+
+// Event is a security event. Webhook sinks receive it as JSON.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"` // e.g. "break-glass:start"
+	Project string    `json:"project"`
+	Env     string    `json:"env"`
+	UserID  string    `json:"user_id"`
+	SSHUser string    `json:"ssh_user"`
+	Message string    `json:"message"`
+}
+
+// text renders the event as a single line for chat sinks.
+func (e Event) text() string {
+	return fmt.Sprintf("[lazyadmin %s/%s] %s by %s (ssh:%s): %s",
+		e.Project, e.Env, e.Kind, e.UserID, e.SSHUser, e.Message)
+}
+
+// Notifier delivers events to every configured sink.
+type Notifier struct {
+	sinks   []config.NotificationSink
+	secrets *secrets.Resolver
+	client  *http.Client
+	getenv  func(string) string
+}
+
+// New returns a Notifier for cfg.Notifications. Sink URLs that are secret
+// references are resolved through res on each delivery.
+func New(cfg *config.Config, res *secrets.Resolver) *Notifier {
+	return &Notifier{
+		sinks:   cfg.Notifications,
+		secrets: res,
+		client:  &http.Client{Timeout: sendTimeout},
+		getenv:  os.Getenv,
+	}
+}
+
+// Notify sends ev to every sink, including those after one that fails, and
+// returns the joined delivery errors.
+func (n *Notifier) Notify(ctx context.Context, ev Event) error {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+
+	var errs []error
+	for _, sink := range n.sinks {
+		if err := n.send(ctx, sink, ev); err != nil {
+			errs = append(errs, fmt.Errorf("notification %s: %w", sink.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (n *Notifier) send(ctx context.Context, sink config.NotificationSink, ev Event) error {
+	target := sink.URL
+	if target != "" {
+		var err error
+		if target, err = n.secrets.Resolve(ctx, target); err != nil {
+			return err
+		}
+	} else if target = n.getenv(sink.URLEnv); target == "" {
+		return fmt.Errorf("env %s not set", sink.URLEnv)
+	}
+
+	var payload any = ev
+	if sink.Type == "slack" {
+		payload = map[string]string{"text": ev.text()}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		// Drop the URL from the error: for most sinks it is a credential.
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			return fmt.Errorf("%s: %w", uerr.Op, uerr.Err)
+		}
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
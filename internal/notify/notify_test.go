@@ -0,0 +1,75 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/you/lazyadmin/internal/config"
+	"github.com/you/lazyadmin/internal/secrets"
+)
+
+func TestNotifier_Notify(t *testing.T) {
+	var (
+		webhook, audit Event
+		slack          map[string]string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/webhook":
+			_ = json.NewDecoder(r.Body).Decode(&webhook)
+		case "/audit":
+			_ = json.NewDecoder(r.Body).Decode(&audit)
+		case "/slack":
+			_ = json.NewDecoder(r.Body).Decode(&slack)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	hook := filepath.Join(t.TempDir(), "audit-url")
+	if err := os.WriteFile(hook, []byte(srv.URL+"/audit\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{Notifications: []config.NotificationSink{
+		{Name: "broken", Type: "webhook", URLEnv: "BROKEN_URL"},
+		{Name: "unset", Type: "webhook", URLEnv: "UNSET_URL"},
+		{Name: "siem", Type: "webhook", URLEnv: "SIEM_URL"},
+		{Name: "oncall", Type: "slack", URLEnv: "SLACK_URL"},
+		{Name: "audit", Type: "webhook", URL: "secret://file" + hook},
+		{Name: "missing", Type: "webhook", URL: "secret://file" + hook + ".missing"},
+	}}
+	env := map[string]string{
+		"BROKEN_URL": srv.URL + "/broken",
+		"SIEM_URL":   srv.URL + "/webhook",
+		"SLACK_URL":  srv.URL + "/slack",
+	}
+	n := New(cfg, secrets.New(cfg))
+	n.getenv = func(k string) string { return env[k] }
+
+	err := n.Notify(context.Background(), Event{
+		Kind: "break-glass:start", Project: "demo", Env: "prod",
+		UserID: "alice", SSHUser: "alice", Message: "db down",
+	})
+
+	// Failing sinks are reported, but do not stop delivery to the others.
+	if err == nil || !strings.Contains(err.Error(), "notification broken") || !strings.Contains(err.Error(), "UNSET_URL") ||
+		!strings.Contains(err.Error(), "notification missing: secret://file") {
+		t.Errorf("Notify() error = %v, want broken, unset and missing sinks reported", err)
+	}
+	if audit.Kind != "break-glass:start" {
+		t.Errorf("audit sink received %+v", audit)
+	}
+	if webhook.Kind != "break-glass:start" || webhook.UserID != "alice" || webhook.Time.IsZero() {
+		t.Errorf("webhook received %+v", webhook)
+	}
+	if !strings.Contains(slack["text"], "break-glass:start by alice") {
+		t.Errorf("slack received %q", slack["text"])
+	}
+}
@@ -79,13 +79,13 @@ func (g *Generator) generateForBackend(ctx context.Context, cfg *config.Config,
 				}
 
 				ops = append(ops, config.Operation{
-					ID:           opID,
-					Label:        buildLabel(op, method, path),
-					Type:         "http",
-					Target:       name,
-					Method:       strings.ToUpper(method),
-					Path:         path,
-					AllowedRoles: []string{"owner", "admin"},
+					ID:     opID,
+					Label:  buildLabel(op, method, path),
+					Type:   "http",
+					Target: name,
+					Method: strings.ToUpper(method),
+					Path:   path,
+					Tags:   operationTags(op),
 				})
 			}
 		}
@@ -113,6 +113,14 @@ func operationEligible(op *openapi3.Operation, backend config.OpenAPIBackend) bo
 	return false
 }
 
+// operationTags returns the endpoint's OpenAPI tags followed by
+// config.TagOpenAPI. The first tag decides the endpoint's group in the TUI.
+func operationTags(op *openapi3.Operation) []string {
+	tags := make([]string, 0, len(op.Tags)+1)
+	tags = append(tags, op.Tags...)
+	return append(tags, config.TagOpenAPI)
+}
+
 func hasRequiredRequestBody(op *openapi3.Operation) bool {
 	if op.RequestBody == nil || op.RequestBody.Value == nil {
 		return false
@@ -0,0 +1,374 @@
+package runs
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	_ "github.com/glebarez/sqlite"
+
+	"github.com/you/lazyadmin/internal/migrate"
+)
+
+var (
+	ErrRunNotFound     = errors.New("task run not found")
+	ErrRunNotResumable = errors.New("task run is not failed or interrupted")
+)
+
+// Status is the lifecycle state of a persisted task run.
+type Status string
+
+const (
+	StatusRunning     Status = "running"
+	StatusSucceeded   Status = "succeeded"
+	StatusFailed      Status = "failed"
+	StatusInterrupted Status = "interrupted" // process died while the run was in progress
+	StatusResumed     Status = "resumed"     // superseded by a run that resumed it
+)
+
+// Resumable reports whether a run in this state may be resumed.
+func (s Status) Resumable() bool {
+	return s == StatusFailed || s == StatusInterrupted
+}
+
+// Run is a persisted top-level task run.
+type Run struct {
+	ID          string
+	TaskID      string
+	UserID      string
+	SSHUser     string
+	Inputs      map[string]string
+	Status      Status
+	Host        string
+	PID         int
+	ProcStart   string // boot ID and start time of process PID, where known
+	ResumedFrom string // run this one continues, if any
+	StartedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// StepState is the checkpoint of one completed top-level step.
+type StepState struct {
+	RunID      string
+	StepID     string
+	Seq        int
+	OK         bool
+	Output     string
+	Error      string
+	FinishedAt time.Time
+}
+
+// Store persists task runs and their step checkpoints in SQLite.
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore creates a run store with the given SQLite database path.
+func NewStore(sqlitePath string) (*Store, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=journal_mode(WAL)", sqlitePath)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite: %w", err)
+	}
+
+	if _, err := migrate.Up(context.Background(), db, sqlitePath); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("init schema: %w", err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Close closes the database connection.
+func (s *Store) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// StartRun records a new run in the running state, owned by this process.
+func (s *Store) StartRun(ctx context.Context, run *Run) error {
+	now := time.Now().UTC()
+	run.Status = StatusRunning
+	run.StartedAt = now
+	run.UpdatedAt = now
+	if run.Host == "" {
+		run.Host, _ = os.Hostname()
+	}
+	if run.PID == 0 {
+		run.PID = os.Getpid()
+		run.ProcStart = processStart(run.PID)
+	}
+
+	inputs, err := json.Marshal(run.Inputs)
+	if err != nil {
+		return fmt.Errorf("marshal inputs: %w", err)
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO task_runs (id, task_id, user_id, ssh_user, inputs, status, host, pid, proc_start, resumed_from, started_at, updated_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		run.ID, run.TaskID, run.UserID, run.SSHUser, string(inputs), string(run.Status),
+		run.Host, run.PID, run.ProcStart, run.ResumedFrom,
+		run.StartedAt.Format(time.RFC3339Nano),
+		run.UpdatedAt.Format(time.RFC3339Nano),
+	)
+	if err != nil {
+		return fmt.Errorf("start run: %w", err)
+	}
+	return nil
+}
+
+// RecordStep checkpoints a completed step.
+func (s *Store) RecordStep(ctx context.Context, st StepState) error {
+	now := time.Now().UTC()
+	st.FinishedAt = now
+
+	_, err := s.db.ExecContext(ctx,
+		`INSERT OR REPLACE INTO task_run_steps (run_id, step_id, seq, ok, output, error, finished_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		st.RunID, st.StepID, st.Seq, boolToInt(st.OK), st.Output, st.Error,
+		st.FinishedAt.Format(time.RFC3339Nano),
+	)
+	if err != nil {
+		return fmt.Errorf("record step: %w", err)
+	}
+
+	return s.touch(ctx, st.RunID)
+}
+
+// SetStatus updates a run's status.
+func (s *Store) SetStatus(ctx context.Context, runID string, status Status) error {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE task_runs SET status = ?, updated_at = ? WHERE id = ?`,
+		string(status), time.Now().UTC().Format(time.RFC3339Nano), runID,
+	)
+	if err != nil {
+		return fmt.Errorf("set run status: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrRunNotFound
+	}
+	return nil
+}
+
+// ClaimResume marks a failed or interrupted run as resumed in a single
+// statement, so of several callers resuming the same run only one succeeds.
+// The others get ErrRunNotResumable.
+func (s *Store) ClaimResume(ctx context.Context, runID string) error {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE task_runs SET status = ?, updated_at = ? WHERE id = ? AND status IN (?, ?)`,
+		string(StatusResumed), time.Now().UTC().Format(time.RFC3339Nano), runID,
+		string(StatusFailed), string(StatusInterrupted),
+	)
+	if err != nil {
+		return fmt.Errorf("claim run: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		return ErrRunNotResumable
+	}
+	return nil
+}
+
+func (s *Store) touch(ctx context.Context, runID string) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE task_runs SET updated_at = ? WHERE id = ?`,
+		time.Now().UTC().Format(time.RFC3339Nano), runID,
+	)
+	return err
+}
+
+// MarkInterrupted marks runs left in the running state by dead processes on
+// this host as interrupted. A process whose PID was reused by another, after
+// a reboot or in a new container, counts as dead where its start is known.
+// Runs owned by other hosts sharing the database, or by live processes here,
+// are left alone. It returns the number of runs marked.
+func (s *Store) MarkInterrupted(ctx context.Context) (int, error) {
+	host, _ := os.Hostname()
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, pid, proc_start FROM task_runs WHERE status = ? AND host = ?`,
+		string(StatusRunning), host,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("query running runs: %w", err)
+	}
+
+	var stale []string
+	for rows.Next() {
+		var (
+			id    string
+			pid   int
+			start string
+		)
+		if err := rows.Scan(&id, &pid, &start); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan run: %w", err)
+		}
+		if !processAlive(pid, start) {
+			stale = append(stale, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range stale {
+		if err := s.SetStatus(ctx, id, StatusInterrupted); err != nil {
+			return 0, err
+		}
+	}
+	return len(stale), nil
+}
+
+// processAlive reports whether process pid, started as start, is running.
+// Without /proc, or for runs recorded without a start, only the PID is
+// checked.
+func processAlive(pid int, start string) bool {
+	if start != "" && processStart(os.Getpid()) != "" {
+		return processStart(pid) == start
+	}
+	if pid == os.Getpid() {
+		return true
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return p.Signal(syscall.Signal(0)) == nil
+}
+
+// processStart identifies process pid beyond its PID, which is reused: the
+// boot ID and the process's start time in clock ticks since boot. It returns
+// "" if the process does not exist or /proc is not available.
+func processStart(pid int) string {
+	bootID, err := os.ReadFile("/proc/sys/kernel/random/boot_id")
+	if err != nil {
+		return ""
+	}
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return ""
+	}
+	// The command name in field 2 may contain spaces and parentheses; the
+	// start time is field 22, the 20th after it.
+	i := bytes.LastIndexByte(stat, ')')
+	if i < 0 {
+		return ""
+	}
+	fields := strings.Fields(string(stat[i+1:]))
+	if len(fields) < 20 {
+		return ""
+	}
+	return strings.TrimSpace(string(bootID)) + ":" + fields[19]
+}
+
+// GetRun retrieves a run by ID.
+func (s *Store) GetRun(ctx context.Context, runID string) (*Run, error) {
+	row := s.db.QueryRowContext(ctx,
+		`SELECT id, task_id, user_id, ssh_user, inputs, status, host, pid, proc_start, resumed_from, started_at, updated_at
+		 FROM task_runs WHERE id = ?`,
+		runID,
+	)
+	run, err := scanRun(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrRunNotFound
+		}
+		return nil, fmt.Errorf("get run: %w", err)
+	}
+	return run, nil
+}
+
+// ListRuns returns the most recent runs, newest first.
+func (s *Store) ListRuns(ctx context.Context, limit int) ([]*Run, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, task_id, user_id, ssh_user, inputs, status, host, pid, proc_start, resumed_from, started_at, updated_at
+		 FROM task_runs ORDER BY started_at DESC LIMIT ?`,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list runs: %w", err)
+	}
+	defer rows.Close()
+
+	var out []*Run
+	for rows.Next() {
+		run, err := scanRun(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan run: %w", err)
+		}
+		out = append(out, run)
+	}
+	return out, rows.Err()
+}
+
+// Steps returns the checkpoints of a run in execution order.
+func (s *Store) Steps(ctx context.Context, runID string) ([]StepState, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT run_id, step_id, seq, ok, output, error, finished_at
+		 FROM task_run_steps WHERE run_id = ? ORDER BY seq`,
+		runID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get run steps: %w", err)
+	}
+	defer rows.Close()
+
+	var out []StepState
+	for rows.Next() {
+		var (
+			st         StepState
+			ok         int
+			finishedAt string
+		)
+		if err := rows.Scan(&st.RunID, &st.StepID, &st.Seq, &ok, &st.Output, &st.Error, &finishedAt); err != nil {
+			return nil, fmt.Errorf("scan step: %w", err)
+		}
+		st.OK = ok == 1
+		st.FinishedAt, _ = time.Parse(time.RFC3339Nano, finishedAt)
+		out = append(out, st)
+	}
+	return out, rows.Err()
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanRun(sc scanner) (*Run, error) {
+	var (
+		run         Run
+		inputs      string
+		status      string
+		resumedFrom *string
+		startedAt   string
+		updatedAt   string
+	)
+	if err := sc.Scan(&run.ID, &run.TaskID, &run.UserID, &run.SSHUser, &inputs, &status,
+		&run.Host, &run.PID, &run.ProcStart, &resumedFrom, &startedAt, &updatedAt); err != nil {
+		return nil, err
+	}
+
+	run.Status = Status(status)
+	if resumedFrom != nil {
+		run.ResumedFrom = *resumedFrom
+	}
+	_ = json.Unmarshal([]byte(inputs), &run.Inputs)
+	run.StartedAt, _ = time.Parse(time.RFC3339Nano, startedAt)
+	run.UpdatedAt, _ = time.Parse(time.RFC3339Nano, updatedAt)
+	return &run, nil
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
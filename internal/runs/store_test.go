@@ -0,0 +1,175 @@
+package runs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	store, err := NewStore(t.TempDir() + "/runs.db")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestStore_RunLifecycle(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+
+	run := &Run{ID: "r1", TaskID: "deploy", UserID: "alice", SSHUser: "alice", Inputs: map[string]string{"version": "1.2"}}
+	if err := store.StartRun(ctx, run); err != nil {
+		t.Fatalf("StartRun() error = %v", err)
+	}
+	for i, id := range []string{"build", "push"} {
+		st := StepState{RunID: "r1", StepID: id, Seq: i, OK: id == "build", Output: id + " done"}
+		if id == "push" {
+			st.Error = "connection refused"
+		}
+		if err := store.RecordStep(ctx, st); err != nil {
+			t.Fatalf("RecordStep(%s) error = %v", id, err)
+		}
+	}
+	if err := store.SetStatus(ctx, "r1", StatusFailed); err != nil {
+		t.Fatalf("SetStatus() error = %v", err)
+	}
+
+	got, err := store.GetRun(ctx, "r1")
+	if err != nil {
+		t.Fatalf("GetRun() error = %v", err)
+	}
+	if got.Status != StatusFailed || got.Inputs["version"] != "1.2" || got.TaskID != "deploy" {
+		t.Errorf("GetRun() = %+v", got)
+	}
+	if !got.Status.Resumable() {
+		t.Errorf("failed run should be resumable")
+	}
+
+	steps, err := store.Steps(ctx, "r1")
+	if err != nil {
+		t.Fatalf("Steps() error = %v", err)
+	}
+	if len(steps) != 2 || steps[0].StepID != "build" || !steps[0].OK || steps[1].OK || steps[1].Error != "connection refused" {
+		t.Errorf("Steps() = %+v", steps)
+	}
+
+	list, err := store.ListRuns(ctx, 10)
+	if err != nil || len(list) != 1 {
+		t.Errorf("ListRuns() = %v, %v; want 1 run", list, err)
+	}
+
+	if _, err := store.GetRun(ctx, "missing"); !errors.Is(err, ErrRunNotFound) {
+		t.Errorf("GetRun(missing) error = %v, want ErrRunNotFound", err)
+	}
+	if err := store.SetStatus(ctx, "missing", StatusFailed); !errors.Is(err, ErrRunNotFound) {
+		t.Errorf("SetStatus(missing) error = %v, want ErrRunNotFound", err)
+	}
+}
+
+func TestStore_MarkInterrupted(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+
+	type runCase struct {
+		id    string
+		host  string
+		pid   int
+		start string
+		want  Status
+	}
+	tests := []runCase{
+		{id: "dead", pid: 1 << 30, want: StatusInterrupted},
+		{id: "self", want: StatusRunning}, // this process is alive
+		{id: "remote", host: "other-bastion", pid: 1 << 30, want: StatusRunning},
+	}
+	// A run of an earlier process that had this PID, e.g. before a reboot.
+	if processStart(os.Getpid()) != "" {
+		tests = append(tests, runCase{id: "reused", pid: os.Getpid(), start: "old-boot:1", want: StatusInterrupted})
+	}
+
+	var want int
+	for _, tt := range tests {
+		run := &Run{ID: tt.id, TaskID: "deploy", Host: tt.host, PID: tt.pid, ProcStart: tt.start}
+		if err := store.StartRun(ctx, run); err != nil {
+			t.Fatalf("StartRun(%s) error = %v", tt.id, err)
+		}
+		if tt.want == StatusInterrupted {
+			want++
+		}
+	}
+
+	n, err := store.MarkInterrupted(ctx)
+	if err != nil {
+		t.Fatalf("MarkInterrupted() error = %v", err)
+	}
+	if n != want {
+		t.Errorf("MarkInterrupted() = %d, want %d", n, want)
+	}
+
+	for _, tt := range tests {
+		got, err := store.GetRun(ctx, tt.id)
+		if err != nil {
+			t.Fatalf("GetRun(%s) error = %v", tt.id, err)
+		}
+		if got.Status != tt.want {
+			t.Errorf("run %s status = %s, want %s", tt.id, got.Status, tt.want)
+		}
+	}
+}
+
+func TestStore_ClaimResume(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+
+	for _, id := range []string{"failed", "running"} {
+		if err := store.StartRun(ctx, &Run{ID: id, TaskID: "deploy"}); err != nil {
+			t.Fatalf("StartRun(%s) error = %v", id, err)
+		}
+	}
+	if err := store.SetStatus(ctx, "failed", StatusFailed); err != nil {
+		t.Fatal(err)
+	}
+
+	const racers = 8
+	var (
+		wg   sync.WaitGroup
+		won  atomic.Int32
+		errs = make(chan error, racers)
+	)
+	for range racers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := store.ClaimResume(ctx, "failed"); err != nil {
+				errs <- err
+				return
+			}
+			won.Add(1)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	if won.Load() != 1 {
+		t.Errorf("%d concurrent ClaimResume() calls succeeded, want 1", won.Load())
+	}
+	for err := range errs {
+		if !errors.Is(err, ErrRunNotResumable) {
+			t.Errorf("losing ClaimResume() error = %v, want ErrRunNotResumable", err)
+		}
+	}
+	if got, _ := store.GetRun(ctx, "failed"); got.Status != StatusResumed {
+		t.Errorf("status after claim = %s, want resumed", got.Status)
+	}
+
+	for _, id := range []string{"running", "missing"} {
+		if err := store.ClaimResume(ctx, id); !errors.Is(err, ErrRunNotResumable) {
+			t.Errorf("ClaimResume(%s) error = %v, want ErrRunNotResumable", id, err)
+		}
+	}
+}
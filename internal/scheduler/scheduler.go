@@ -0,0 +1,212 @@
+// Package scheduler runs configured tasks on cron schedules.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/you/lazyadmin/internal/auth"
+	"github.com/you/lazyadmin/internal/config"
+	"github.com/you/lazyadmin/internal/cron"
+	"github.com/you/lazyadmin/internal/locks"
+	"github.com/you/lazyadmin/internal/logging"
+	"github.com/you/lazyadmin/internal/tasks"
+)
+
+// ServiceName is recorded as the SSH user of scheduled runs in the audit log.
+const ServiceName = "scheduler"
+
+// scheduleLockLease bounds how long a crashed scheduler blocks another
+// daemon from running the same schedule; the lease is renewed while held.
+const scheduleLockLease = 5 * time.Minute
+
+// Scheduler executes due schedules through a tasks.Runner. A schedule never
+// overlaps itself: a tick that comes due while the previous run is still
+// going is skipped, both within this process and, through the lock store,
+// across daemons sharing the database.
+type Scheduler struct {
+	cfg     *config.Config
+	runner  *tasks.Runner
+	logger  *logging.AuditLogger
+	state   *Store
+	locks   *locks.Store
+	entries []*entry
+	now     func() time.Time
+
+	mu      sync.Mutex
+	running map[string]bool
+	wg      sync.WaitGroup
+}
+
+type entry struct {
+	sched     config.Schedule
+	cron      *cron.Schedule
+	task      config.Task
+	principal *auth.Principal
+	next      time.Time
+}
+
+// New prepares a scheduler for cfg.Schedules. state and lockStore may be nil.
+func New(cfg *config.Config, runner *tasks.Runner, logger *logging.AuditLogger, state *Store, lockStore *locks.Store) (*Scheduler, error) {
+	s := &Scheduler{
+		cfg:     cfg,
+		runner:  runner,
+		logger:  logger,
+		state:   state,
+		locks:   lockStore,
+		now:     time.Now,
+		running: make(map[string]bool),
+	}
+
+	for _, sched := range cfg.Schedules {
+		c, err := cron.Parse(sched.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %s: %w", sched.ID, err)
+		}
+		task, ok := cfg.FindTask(sched.Task)
+		if !ok {
+			return nil, fmt.Errorf("schedule %s: unknown task %q", sched.ID, sched.Task)
+		}
+		if id, ok := cfg.FindStepUpTask(sched.Task); ok {
+			return nil, fmt.Errorf("schedule %s: task %s requires a YubiKey step-up", sched.ID, id)
+		}
+		p, err := auth.ServicePrincipal(cfg, sched.RunAs, ServiceName)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %s: %w", sched.ID, err)
+		}
+		s.entries = append(s.entries, &entry{sched: sched, cron: c, task: *task, principal: p})
+	}
+
+	return s, nil
+}
+
+// Run executes schedules until ctx is cancelled, then waits for runs in
+// progress to finish. Runs missed while the scheduler was not running are not
+// caught up.
+func (s *Scheduler) Run(ctx context.Context) error {
+	now := s.now()
+	for _, e := range s.entries {
+		e.next = e.cron.Next(now)
+	}
+
+	for {
+		next := s.nextDue()
+		if next.IsZero() {
+			<-ctx.Done()
+			break
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			s.wg.Wait()
+			return nil
+		case <-timer.C:
+			s.tick(ctx, s.now())
+		}
+	}
+
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Scheduler) nextDue() time.Time {
+	var next time.Time
+	for _, e := range s.entries {
+		if !e.next.IsZero() && (next.IsZero() || e.next.Before(next)) {
+			next = e.next
+		}
+	}
+	return next
+}
+
+// tick starts every schedule due at or before now and advances it.
+func (s *Scheduler) tick(ctx context.Context, now time.Time) {
+	for _, e := range s.entries {
+		if e.next.IsZero() || now.Before(e.next) {
+			continue
+		}
+		s.start(ctx, e)
+		e.next = e.cron.Next(now)
+	}
+}
+
+func (s *Scheduler) start(ctx context.Context, e *entry) {
+	id := e.sched.ID
+
+	s.mu.Lock()
+	if s.running[id] {
+		s.mu.Unlock()
+		s.logSchedule(e, "", false, "skipped: previous run still in progress")
+		return
+	}
+	s.running[id] = true
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer func() {
+			s.mu.Lock()
+			delete(s.running, id)
+			s.mu.Unlock()
+		}()
+
+		// Runs in progress finish even when the daemon is asked to stop;
+		// the task's own timeout still applies.
+		runCtx := context.WithoutCancel(ctx)
+
+		if s.locks != nil {
+			name := fmt.Sprintf("schedule:%s/%s/%s", s.cfg.Project, s.cfg.Env, id)
+			owner := locks.Owner{UserID: e.sched.RunAs, SSHUser: ServiceName}
+			lease, err := s.locks.Acquire(runCtx, name, owner, scheduleLockLease)
+			if err != nil {
+				reason := err.Error()
+				if errors.Is(err, locks.ErrLockHeld) {
+					reason = "skipped: " + reason
+				}
+				s.logSchedule(e, "", false, reason)
+				return
+			}
+			defer lease.Release(context.Background())
+		}
+
+		if s.state != nil {
+			_ = s.state.MarkStarted(runCtx, id, s.now())
+		}
+
+		tr := s.runner.Run(runCtx, e.principal, e.task, e.sched.Inputs)
+
+		errText := ""
+		if tr.Err != nil {
+			errText = tr.Err.Error()
+		} else if !tr.Success {
+			errText = "task failed"
+		}
+		if s.state != nil {
+			_ = s.state.MarkFinished(runCtx, id, s.now(), tr.RunID, tr.Success, errText)
+		}
+		s.logSchedule(e, tr.RunID, tr.Success, errText)
+	}()
+}
+
+func (s *Scheduler) logSchedule(e *entry, runID string, success bool, errText string) {
+	if s.logger == nil {
+		return
+	}
+
+	entry := logging.AuditEntry{
+		Time:        time.Now(),
+		UserID:      e.sched.RunAs,
+		SSHUser:     ServiceName,
+		OperationID: fmt.Sprintf("schedule:%s task:%s", e.sched.ID, e.sched.Task),
+		Success:     success,
+		Error:       errText,
+		RunID:       runID,
+	}
+	_ = s.logger.Log(context.Background(), entry)
+}
@@ -0,0 +1,170 @@
+package scheduler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/you/lazyadmin/internal/clients"
+	"github.com/you/lazyadmin/internal/config"
+	"github.com/you/lazyadmin/internal/locks"
+	"github.com/you/lazyadmin/internal/logging"
+	"github.com/you/lazyadmin/internal/tasks"
+)
+
+func TestScheduler_Tick(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	dbPath := t.TempDir() + "/lazyadmin.db"
+	logger, err := logging.NewAuditLogger(dbPath)
+	if err != nil {
+		t.Fatalf("NewAuditLogger() error = %v", err)
+	}
+	defer logger.Close()
+	state, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	defer state.Close()
+	lockStore, err := locks.NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("locks.NewStore() error = %v", err)
+	}
+	defer lockStore.Close()
+
+	cfg := &config.Config{
+		Project: "shop",
+		Env:     "prod",
+		Users:   []config.User{{ID: "svc_backup", Roles: []string{"operator"}}},
+		Tasks: []config.Task{{
+			ID:           "backup",
+			AllowedRoles: []string{"operator"},
+			Inputs:       []config.TaskInput{{Name: "target"}},
+			Steps: []config.TaskStep{{
+				ID: "dump", Type: "http", Resource: "backend", Method: "POST", Path: "/backup/{{ .Inputs.target }}",
+			}},
+		}},
+		Schedules: []config.Schedule{{
+			ID: "nightly", Cron: "*/5 * * * *", Task: "backup", RunAs: "svc_backup",
+			Inputs: map[string]string{"target": "orders"},
+		}},
+	}
+	runner := tasks.NewRunner(cfg, logger, map[string]*clients.HTTPClient{
+		"backend": clients.NewHTTPClient(server.URL),
+	}, nil)
+
+	s, err := New(cfg, runner, logger, state, lockStore)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	t0 := time.Date(2025, 1, 15, 10, 2, 0, 0, time.UTC)
+	s.entries[0].next = s.entries[0].cron.Next(t0)
+
+	ctx := context.Background()
+
+	s.tick(ctx, t0.Add(time.Minute)) // 10:03, not due
+	select {
+	case <-started:
+		t.Fatalf("schedule ran before it was due")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	s.tick(ctx, t0.Add(3*time.Minute)) // 10:05, due
+	<-started
+
+	s.tick(ctx, t0.Add(8*time.Minute)) // 10:10, due while the first run is still going
+	select {
+	case <-started:
+		t.Fatalf("schedule overlapped its previous run")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if got, want := s.entries[0].next, time.Date(2025, 1, 15, 10, 15, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("next = %v, want %v", got, want)
+	}
+
+	close(release)
+	s.wg.Wait()
+
+	states, err := state.List(ctx)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	st := states["nightly"]
+	if st.Running || !st.LastSuccess || st.LastRunID == "" || st.LastFinishedAt.IsZero() {
+		t.Errorf("state = %+v, want finished successful run", st)
+	}
+
+	rows, err := logging.ReadRecent(logger, 50)
+	if err != nil {
+		t.Fatalf("ReadRecent() error = %v", err)
+	}
+	var skipped, ran, task bool
+	for _, r := range rows {
+		if r.UserID != "svc_backup" || r.SSHUser != ServiceName {
+			t.Errorf("audit row %q by %s/%s, want svc_backup/%s", r.OperationID, r.UserID, r.SSHUser, ServiceName)
+		}
+		switch {
+		case r.OperationID == "schedule:nightly task:backup" && strings.HasPrefix(r.Error, "skipped"):
+			skipped = true
+		case r.OperationID == "schedule:nightly task:backup" && r.Success:
+			ran = r.RunID == st.LastRunID
+		case r.OperationID == "task:backup":
+			task = r.Success
+		}
+	}
+	if !skipped || !ran || !task {
+		t.Errorf("audit log missing entries: skipped=%v ran=%v task=%v", skipped, ran, task)
+	}
+}
+
+func TestNew_StepUpTask(t *testing.T) {
+	users := []config.User{{ID: "svc", SSHUsers: []string{"svc"}, Roles: []string{"admin"}}}
+	tests := []struct {
+		name string
+		cfg  config.Config
+	}{
+		{
+			name: "require_yubikey",
+			cfg:  config.Config{Tasks: []config.Task{{ID: "restore", RequireYubiKey: true}}},
+		},
+		{
+			name: "risk_policy",
+			cfg: config.Config{
+				Tasks:      []config.Task{{ID: "restore", RiskLevel: config.RiskHigh}},
+				RiskPolicy: config.RiskPolicy{RequireYubiKey: []config.RiskLevel{config.RiskHigh}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.Users = users
+			cfg.Schedules = []config.Schedule{{ID: "nightly", Cron: "@daily", Task: "restore", RunAs: "svc"}}
+			if _, err := New(&cfg, tasks.NewRunner(&cfg, nil, nil, nil), nil, nil, nil); err == nil || !strings.Contains(err.Error(), "step-up") {
+				t.Errorf("New() error = %v, want step-up refusal", err)
+			}
+		})
+	}
+}
+
+func TestNew_UnknownRunAs(t *testing.T) {
+	cfg := &config.Config{
+		Tasks:     []config.Task{{ID: "backup"}},
+		Schedules: []config.Schedule{{ID: "nightly", Cron: "@daily", Task: "backup", RunAs: "ghost"}},
+	}
+	if _, err := New(cfg, tasks.NewRunner(cfg, nil, nil, nil), nil, nil, nil); err == nil {
+		t.Errorf("New() error = nil, want unknown service user")
+	}
+}
@@ -0,0 +1,121 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "github.com/glebarez/sqlite"
+
+	"github.com/you/lazyadmin/internal/migrate"
+)
+
+// State is the last known activity of a schedule, as recorded by the
+// scheduler daemon.
+type State struct {
+	ID             string
+	Running        bool
+	LastStartedAt  time.Time
+	LastFinishedAt time.Time
+	LastRunID      string
+	LastSuccess    bool
+	LastError      string
+}
+
+// Store persists schedule state in SQLite so the TUI, running in another
+// process, can show last run times.
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore creates a schedule state store with the given SQLite database path.
+func NewStore(sqlitePath string) (*Store, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=journal_mode(WAL)", sqlitePath)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite: %w", err)
+	}
+
+	if _, err := migrate.Up(context.Background(), db, sqlitePath); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("init schema: %w", err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Close closes the database connection.
+func (s *Store) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// MarkStarted records that a schedule's task started at t.
+func (s *Store) MarkStarted(ctx context.Context, id string, t time.Time) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO schedule_state (id, running, last_started_at, last_finished_at, last_run_id, last_success, last_error)
+		 VALUES (?, 1, ?, '', '', 0, '')
+		 ON CONFLICT(id) DO UPDATE SET running = 1, last_started_at = excluded.last_started_at`,
+		id, t.UTC().Format(time.RFC3339Nano),
+	)
+	if err != nil {
+		return fmt.Errorf("mark schedule started: %w", err)
+	}
+	return nil
+}
+
+// MarkFinished records the outcome of a schedule's task.
+func (s *Store) MarkFinished(ctx context.Context, id string, t time.Time, runID string, success bool, errText string) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE schedule_state
+		 SET running = 0, last_finished_at = ?, last_run_id = ?, last_success = ?, last_error = ?
+		 WHERE id = ?`,
+		t.UTC().Format(time.RFC3339Nano), runID, boolToInt(success), errText, id,
+	)
+	if err != nil {
+		return fmt.Errorf("mark schedule finished: %w", err)
+	}
+	return nil
+}
+
+// List returns the state of every schedule that has run, keyed by ID.
+func (s *Store) List(ctx context.Context) (map[string]State, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, running, last_started_at, last_finished_at, last_run_id, last_success, last_error
+		 FROM schedule_state`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list schedule state: %w", err)
+	}
+	defer rows.Close()
+
+	out := make(map[string]State)
+	for rows.Next() {
+		var (
+			st         State
+			running    int
+			startedAt  string
+			finishedAt string
+			success    int
+		)
+		if err := rows.Scan(&st.ID, &running, &startedAt, &finishedAt, &st.LastRunID, &success, &st.LastError); err != nil {
+			return nil, fmt.Errorf("scan schedule state: %w", err)
+		}
+		st.Running = running == 1
+		st.LastSuccess = success == 1
+		st.LastStartedAt, _ = time.Parse(time.RFC3339Nano, startedAt)
+		st.LastFinishedAt, _ = time.Parse(time.RFC3339Nano, finishedAt)
+		out[st.ID] = st
+	}
+	return out, rows.Err()
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
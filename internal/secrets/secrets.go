@@ -0,0 +1,333 @@
+// Package secrets resolves secret:// references in the config, such as
+// secret://vault/kv/db/orders#dsn, through pluggable providers, caches the
+// values, and redacts every value it has resolved from output.
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/you/lazyadmin/internal/config"
+)
+
+var ErrNotFound = errors.New("secret not found")
+
+// Redacted replaces resolved values in output.
+const Redacted = "[REDACTED]"
+
+// minRedactLen is the length below which values are not redacted, as
+// replacing them would mangle unrelated output.
+const minRedactLen = 4
+
+// fetchTimeout bounds each provider call.
+const fetchTimeout = 10 * time.Second
+
+// provider fetches the value of a reference.
+type provider func(ctx context.Context, ref config.SecretRef) (string, error)
+
+type cached struct {
+	value   string
+	expires time.Time
+}
+
+// Resolver resolves secret references. It is safe for concurrent use. A nil
+// Resolver returns literal values unchanged and fails on references.
+type Resolver struct {
+	cfg       config.SecretsConfig
+	providers map[string]provider
+	client    *http.Client
+	getenv    func(string) string
+	run       func(ctx context.Context, name string, args ...string) ([]byte, error)
+	now       func() time.Time
+
+	mu       sync.Mutex
+	cache    map[string]cached
+	resolved []string // every value resolved, longest first, for Redact
+}
+
+// New returns a Resolver for cfg.Secrets.
+func New(cfg *config.Config) *Resolver {
+	r := &Resolver{
+		cfg:    cfg.Secrets,
+		client: &http.Client{Timeout: fetchTimeout},
+		getenv: os.Getenv,
+		run:    runCommand,
+		now:    time.Now,
+		cache:  make(map[string]cached),
+	}
+	r.providers = map[string]provider{
+		config.SecretEnv:     r.env,
+		config.SecretFile:    r.file,
+		config.SecretSystemd: r.systemd,
+		config.SecretSops:    r.sops,
+		config.SecretAge:     r.age,
+		config.SecretVault:   r.vault,
+	}
+	return r
+}
+
+// Reconfigure switches r to cfg.Secrets, as after a configuration reload, and
+// drops cached values so they are fetched again with the new settings. Values
+// already resolved stay redacted.
+func (r *Resolver) Reconfigure(cfg *config.Config) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cfg = cfg.Secrets
+	r.cache = make(map[string]cached)
+}
+
+// settings returns the secrets configuration in use.
+func (r *Resolver) settings() config.SecretsConfig {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cfg
+}
+
+// Resolve returns the value of s if it is a secret reference, or s itself.
+// Values are cached for secrets.cache_ttl and redacted by Redact from then
+// on.
+func (r *Resolver) Resolve(ctx context.Context, s string) (string, error) {
+	if !config.IsSecretRef(s) {
+		return s, nil
+	}
+	ref, err := config.ParseSecretRef(s)
+	if err != nil {
+		return "", err
+	}
+	if r == nil {
+		return "", fmt.Errorf("%s: secrets are not configured", ref)
+	}
+
+	r.mu.Lock()
+	c, ok := r.cache[s]
+	r.mu.Unlock()
+	if ok && r.now().Before(c.expires) {
+		return c.value, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	v, err := r.providers[ref.Provider](ctx, ref)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", ref, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cache[s] = cached{value: v, expires: r.now().Add(r.cfg.CacheTTLOrDefault())}
+	r.remember(v)
+	return v, nil
+}
+
+// remember adds v, and a password embedded in it as in a URL DSN, to the
+// values Redact replaces. r.mu must be held.
+func (r *Resolver) remember(v string) {
+	parts := []string{v}
+	if u, err := url.Parse(v); err == nil && u.User != nil {
+		if p, ok := u.User.Password(); ok {
+			parts = append(parts, p)
+		}
+	}
+	for _, p := range parts {
+		if len(p) >= minRedactLen && !slices.Contains(r.resolved, p) {
+			r.resolved = append(r.resolved, p)
+		}
+	}
+	slices.SortFunc(r.resolved, func(a, b string) int { return len(b) - len(a) })
+}
+
+// Redact replaces every value resolved so far in s with [REDACTED].
+func (r *Resolver) Redact(s string) string {
+	if r == nil {
+		return s
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, v := range r.resolved {
+		s = strings.ReplaceAll(s, v, Redacted)
+	}
+	return s
+}
+
+func (r *Resolver) env(_ context.Context, ref config.SecretRef) (string, error) {
+	v := r.getenv(ref.Path)
+	if v == "" {
+		return "", fmt.Errorf("%w: env %s not set", ErrNotFound, ref.Path)
+	}
+	return v, nil
+}
+
+func (r *Resolver) file(_ context.Context, ref config.SecretRef) (string, error) {
+	data, err := os.ReadFile(ref.Path)
+	if err != nil {
+		return "", err
+	}
+	return selectKey(data, ref.Key)
+}
+
+// systemd reads a credential passed with LoadCredential= or
+// LoadCredentialEncrypted=, which systemd places in $CREDENTIALS_DIRECTORY.
+func (r *Resolver) systemd(ctx context.Context, ref config.SecretRef) (string, error) {
+	dir := r.getenv("CREDENTIALS_DIRECTORY")
+	if dir == "" {
+		return "", fmt.Errorf("%w: CREDENTIALS_DIRECTORY not set; is lazyadmin running under systemd with LoadCredential=?", ErrNotFound)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, ref.Path))
+	if err != nil {
+		return "", err
+	}
+	return selectKey(data, ref.Key)
+}
+
+// sops decrypts a sops file with the sops binary, which finds its keys as
+// usual (SOPS_AGE_KEY_FILE, KMS, PGP).
+func (r *Resolver) sops(ctx context.Context, ref config.SecretRef) (string, error) {
+	data, err := r.run(ctx, "sops", "--decrypt", ref.Path)
+	if err != nil {
+		return "", err
+	}
+	return selectKey(data, ref.Key)
+}
+
+// age decrypts an age file with the age binary and secrets.age_identity.
+func (r *Resolver) age(ctx context.Context, ref config.SecretRef) (string, error) {
+	identity := r.settings().AgeIdentity
+	if identity == "" {
+		return "", fmt.Errorf("secrets.age_identity is not set")
+	}
+	data, err := r.run(ctx, "age", "--decrypt", "--identity", identity, ref.Path)
+	if err != nil {
+		return "", err
+	}
+	return selectKey(data, ref.Key)
+}
+
+// vault reads a secret from a KV version 2 engine: secret://vault/kv/db#dsn
+// reads key dsn of secret db in the engine mounted at kv. Without a key the
+// secret must hold a single value.
+func (r *Resolver) vault(ctx context.Context, ref config.SecretRef) (string, error) {
+	cfg := r.settings().Vault
+	addr := cfg.Address
+	if addr == "" {
+		addr = r.getenv("VAULT_ADDR")
+	}
+	if addr == "" {
+		return "", fmt.Errorf("secrets.vault.address and VAULT_ADDR are not set")
+	}
+	token := r.getenv("VAULT_TOKEN")
+	if t := cfg.Token; t != "" {
+		var err error
+		if token, err = r.Resolve(ctx, t); err != nil {
+			return "", fmt.Errorf("vault token: %w", err)
+		}
+	}
+	if token == "" {
+		return "", fmt.Errorf("secrets.vault.token and VAULT_TOKEN are not set")
+	}
+
+	mount, path, _ := strings.Cut(ref.Path, "/")
+	u := strings.TrimRight(addr, "/") + "/v1/" + mount + "/data/" + path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if ns := cfg.Namespace; ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("vault: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Data struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+		Errors []string `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil && resp.StatusCode == http.StatusOK {
+		return "", fmt.Errorf("vault: decode response: %w", err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return "", fmt.Errorf("%w: vault %s", ErrNotFound, ref.Path)
+	case resp.StatusCode != http.StatusOK:
+		return "", fmt.Errorf("vault: %s %s", resp.Status, strings.Join(body.Errors, "; "))
+	}
+	return pick(body.Data.Data, ref.Key)
+}
+
+// selectKey returns data without its trailing newline, or with a key, the
+// value at that dotted key of data parsed as YAML or JSON.
+func selectKey(data []byte, key string) (string, error) {
+	if key == "" {
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	var m map[string]any
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return "", fmt.Errorf("parse secret for key %q: %w", key, err)
+	}
+	return pick(m, key)
+}
+
+// pick returns the scalar at dotted key of m. Without a key, m must hold a
+// single value.
+func pick(m map[string]any, key string) (string, error) {
+	if key == "" {
+		if len(m) != 1 {
+			return "", fmt.Errorf("secret holds %d values; add #key to the reference", len(m))
+		}
+		for k := range m {
+			key = k
+		}
+	}
+
+	var v any = m
+	for _, k := range strings.Split(key, ".") {
+		mm, ok := v.(map[string]any)
+		if !ok {
+			return "", fmt.Errorf("%w: key %q", ErrNotFound, key)
+		}
+		if v, ok = mm[k]; !ok {
+			return "", fmt.Errorf("%w: key %q", ErrNotFound, key)
+		}
+	}
+	switch v := v.(type) {
+	case map[string]any, []any:
+		return "", fmt.Errorf("key %q is not a single value", key)
+	case nil:
+		return "", fmt.Errorf("%w: key %q is empty", ErrNotFound, key)
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+// runCommand runs a decryption tool and returns its output. Its stderr is
+// kept for the error, never its stdout.
+func runCommand(ctx context.Context, name string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,214 @@
+package secrets
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/you/lazyadmin/internal/config"
+)
+
+func TestResolver_Resolve(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	plain := write("token", "s3cr3t-token\n")
+	structured := write("db.yaml", "orders:\n  dsn: postgres://app:pw-orders@db/orders\n")
+	creds := filepath.Join(dir, "creds")
+	if err := os.Mkdir(creds, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	write("creds/slack-url", "https://hooks.example.com/T000")
+
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Header.Get("X-Vault-Token") != "vault-token":
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+		case r.URL.Path == "/v1/kv/data/db/orders":
+			w.Write([]byte(`{"data":{"data":{"dsn":"postgres://vault-dsn","port":5432},"metadata":{"version":3}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+		}
+	}))
+	defer vault.Close()
+
+	env := map[string]string{
+		"PG_DSN":                "postgres://env-dsn",
+		"CREDENTIALS_DIRECTORY": creds,
+		"VAULT_TOKEN":           "vault-token",
+	}
+	r := New(&config.Config{Secrets: config.SecretsConfig{
+		AgeIdentity: "/etc/lazyadmin/age.key",
+		Vault:       config.VaultConfig{Address: vault.URL},
+	}})
+	r.getenv = func(k string) string { return env[k] }
+	var ran []string
+	r.run = func(_ context.Context, name string, args ...string) ([]byte, error) {
+		ran = append(ran, name+" "+strings.Join(args, " "))
+		return []byte(`{"api": {"token": "decrypted-token"}}`), nil
+	}
+
+	tests := []struct {
+		ref     string
+		want    string
+		wantErr string
+	}{
+		{ref: "literal value", want: "literal value"},
+		{ref: "secret://env/PG_DSN", want: "postgres://env-dsn"},
+		{ref: "secret://env/MISSING", wantErr: "env MISSING not set"},
+		{ref: "secret://file" + plain, want: "s3cr3t-token"},
+		{ref: "secret://file" + structured + "#orders.dsn", want: "postgres://app:pw-orders@db/orders"},
+		{ref: "secret://file" + structured + "#orders.user", wantErr: `key "orders.user"`},
+		{ref: "secret://systemd/slack-url", want: "https://hooks.example.com/T000"},
+		{ref: "secret://sops/etc/lazyadmin/secrets.enc.yaml#api.token", want: "decrypted-token"},
+		{ref: "secret://age/etc/lazyadmin/api.age#api.token", want: "decrypted-token"},
+		{ref: "secret://vault/kv/db/orders#dsn", want: "postgres://vault-dsn"},
+		{ref: "secret://vault/kv/db/orders#port", want: "5432"},
+		{ref: "secret://vault/kv/db/orders", wantErr: "add #key"},
+		{ref: "secret://vault/kv/db/missing#dsn", wantErr: ErrNotFound.Error()},
+		{ref: "secret://nope/x", wantErr: config.ErrBadSecretRef.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			got, err := r.Resolve(context.Background(), tt.ref)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Resolve() error = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Resolve() = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+
+	wantRan := []string{
+		"sops --decrypt /etc/lazyadmin/secrets.enc.yaml",
+		"age --decrypt --identity /etc/lazyadmin/age.key /etc/lazyadmin/api.age",
+	}
+	if strings.Join(ran, "\n") != strings.Join(wantRan, "\n") {
+		t.Errorf("commands run = %q, want %q", ran, wantRan)
+	}
+
+	env["VAULT_TOKEN"] = "wrong"
+	r.cache = make(map[string]cached)
+	if _, err := r.Resolve(context.Background(), "secret://vault/kv/db/orders#dsn"); err == nil || !strings.Contains(err.Error(), "permission denied") || strings.Contains(err.Error(), "wrong") {
+		t.Errorf("Resolve() with a bad token error = %v", err)
+	}
+}
+
+func TestResolver_Cache(t *testing.T) {
+	var hits int
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Write([]byte(`{"data":{"data":{"token":"cached-token"}}}`))
+	}))
+	defer vault.Close()
+
+	r := New(&config.Config{Secrets: config.SecretsConfig{
+		CacheTTL: time.Minute,
+		Vault:    config.VaultConfig{Address: vault.URL, Token: "secret://env/TOKEN"},
+	}})
+	r.getenv = func(string) string { return "t" }
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	for range 3 {
+		if v, err := r.Resolve(ctx, "secret://vault/kv/api"); v != "cached-token" || err != nil {
+			t.Fatalf("Resolve() = %q, %v", v, err)
+		}
+	}
+	if hits != 1 {
+		t.Errorf("vault hits = %d, want 1 within cache_ttl", hits)
+	}
+	now = now.Add(2 * time.Minute)
+	if _, err := r.Resolve(ctx, "secret://vault/kv/api"); err != nil {
+		t.Fatal(err)
+	}
+	if hits != 2 {
+		t.Errorf("vault hits = %d, want 2 after cache_ttl", hits)
+	}
+}
+
+func TestResolver_Reconfigure(t *testing.T) {
+	server := func(value string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"data":{"data":{"token":"` + value + `"}}}`))
+		}))
+	}
+	old, moved := server("old-vault-token"), server("new-vault-token")
+	defer old.Close()
+	defer moved.Close()
+
+	r := New(&config.Config{Secrets: config.SecretsConfig{Vault: config.VaultConfig{Address: old.URL}}})
+	r.getenv = func(string) string { return "t" }
+
+	ctx := context.Background()
+	if v, err := r.Resolve(ctx, "secret://vault/kv/api"); v != "old-vault-token" || err != nil {
+		t.Fatalf("Resolve() = %q, %v", v, err)
+	}
+
+	// A reload that moves Vault takes effect at once, and what was resolved
+	// before stays redacted.
+	r.Reconfigure(&config.Config{Secrets: config.SecretsConfig{Vault: config.VaultConfig{Address: moved.URL}}})
+	if v, err := r.Resolve(ctx, "secret://vault/kv/api"); v != "new-vault-token" || err != nil {
+		t.Errorf("Resolve() after Reconfigure = %q, %v; want the new Vault's value", v, err)
+	}
+	if got := r.Redact("old-vault-token new-vault-token"); got != "[REDACTED] [REDACTED]" {
+		t.Errorf("Redact() after Reconfigure = %q", got)
+	}
+}
+
+func TestResolver_Redact(t *testing.T) {
+	env := map[string]string{
+		"DSN":   "postgres://app:hunter22@db:5432/orders",
+		"TOKEN": "tok-123456",
+		"SHORT": "abc",
+	}
+	r := New(&config.Config{})
+	r.getenv = func(k string) string { return env[k] }
+
+	if got := r.Redact("token tok-123456"); got != "token tok-123456" {
+		t.Errorf("Redact() before resolving = %q", got)
+	}
+	ctx := context.Background()
+	for _, ref := range []string{"secret://env/DSN", "secret://env/TOKEN", "secret://env/SHORT"} {
+		if _, err := r.Resolve(ctx, ref); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct{ in, want string }{
+		{"connect postgres://app:hunter22@db:5432/orders: refused", "connect [REDACTED]: refused"},
+		{`password authentication failed (password "hunter22")`, `password authentication failed (password "[REDACTED]")`},
+		{"Authorization: Bearer tok-123456", "Authorization: Bearer [REDACTED]"},
+		{"abc is too short to redact", "abc is too short to redact"},
+	}
+	for _, tt := range tests {
+		if got := r.Redact(tt.in); got != tt.want {
+			t.Errorf("Redact(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	var nilResolver *Resolver
+	if got := nilResolver.Redact("x"); got != "x" {
+		t.Errorf("nil Redact() = %q", got)
+	}
+	if _, err := nilResolver.Resolve(ctx, "secret://env/DSN"); err == nil {
+		t.Error("nil Resolve() of a reference error = nil")
+	}
+}
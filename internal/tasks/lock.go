@@ -0,0 +1,186 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/you/lazyadmin/internal/auth"
+	"github.com/you/lazyadmin/internal/config"
+	"github.com/you/lazyadmin/internal/locks"
+	"github.com/you/lazyadmin/internal/logging"
+)
+
+var (
+	ErrNoLockStore = errors.New("locking is not enabled")
+)
+
+// defaultLockLease applies to locks that do not set lease. The lease is
+// renewed while the item runs, so it only bounds how long a crashed holder
+// blocks others.
+const defaultLockLease = 5 * time.Minute
+
+// SetLockStore enables the lock: setting on tasks and operations.
+func (r *Runner) SetLockStore(store *locks.Store) {
+	r.locks = store
+}
+
+// lockName renders the lock's name for an item and applies its scope.
+func (r *Runner) lockName(l *config.Lock, itemID string, inputs map[string]string) (string, error) {
+	name := itemID
+	if l.Name != "" {
+		rendered, err := renderInputs("lock", l.Name, inputs)
+		if err != nil {
+			return "", fmt.Errorf("lock name: %w", err)
+		}
+		name = rendered
+	}
+
+	if l.Scope == config.LockScopeGlobal {
+		return name, nil
+	}
+	return fmt.Sprintf("%s/%s/%s", r.cfg.Project, r.cfg.Env, name), nil
+}
+
+// acquireLock takes the lock configured for an item, if any. The SQLite lease
+// is taken first so a conflict can name the holder; a Postgres advisory lock
+// is added when the lock (or, for operations, the target) names a postgres
+// resource. The returned context is cancelled if the lease is lost, e.g. by a
+// force-unlock, and release must be called when the item finishes.
+func (r *Runner) acquireLock(ctx context.Context, p *auth.Principal, l *config.Lock, itemID, runID string, inputs map[string]string, pgResource string) (context.Context, func(), error) {
+	if l == nil {
+		return ctx, func() {}, nil
+	}
+
+	name, err := r.lockName(l, itemID, inputs)
+	if err != nil {
+		return ctx, nil, fmt.Errorf("%s: %w", itemID, err)
+	}
+	if r.locks == nil {
+		return ctx, nil, fmt.Errorf("lock %s: %w", name, ErrNoLockStore)
+	}
+
+	ttl := l.Lease
+	if ttl == 0 {
+		ttl = defaultLockLease
+	}
+	owner := locks.Owner{
+		UserID:  principalUserID(p),
+		SSHUser: principalSSHUser(p),
+		RunID:   runID,
+	}
+	lease, err := r.locks.Acquire(ctx, name, owner, ttl)
+	if err != nil {
+		return ctx, nil, err
+	}
+
+	if l.Resource != "" {
+		pgResource = l.Resource
+	}
+	var unlockPG func() error
+	if pgResource != "" {
+		client, ok := r.pgClients[pgResource]
+		if !ok {
+			_ = lease.Release(context.Background())
+			return ctx, nil, fmt.Errorf("lock %s: no postgres resource %q", name, pgResource)
+		}
+		unlock, ok, err := client.TryAdvisoryLock(ctx, name)
+		if err != nil {
+			_ = lease.Release(context.Background())
+			return ctx, nil, fmt.Errorf("lock %s: %w", name, err)
+		}
+		if !ok {
+			_ = lease.Release(context.Background())
+			return ctx, nil, fmt.Errorf("lock %s: postgres advisory lock on %s is held by another session: %w", name, pgResource, locks.ErrLockHeld)
+		}
+		unlockPG = unlock
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	go func() {
+		select {
+		case <-lease.Lost():
+			cancel(fmt.Errorf("lock %s: %w", name, locks.ErrLockLost))
+		case <-ctx.Done():
+		}
+	}()
+
+	release := func() {
+		cancel(nil)
+		if unlockPG != nil {
+			_ = unlockPG()
+		}
+		_ = lease.Release(context.Background())
+	}
+	return ctx, release, nil
+}
+
+// LockHolder returns who holds the lock an item would take with inputs, or
+// nil if the item has no lock or the lock is free.
+func (r *Runner) LockHolder(ctx context.Context, l *config.Lock, itemID string, inputs map[string]string) (*locks.Holder, error) {
+	if l == nil {
+		return nil, nil
+	}
+	if r.locks == nil {
+		return nil, ErrNoLockStore
+	}
+	name, err := r.lockName(l, itemID, inputs)
+	if err != nil {
+		return nil, err
+	}
+	h, err := r.locks.Get(ctx, name)
+	if errors.Is(err, locks.ErrLockNotFound) {
+		return nil, nil
+	}
+	return h, err
+}
+
+// Locks lists the locks currently held.
+func (r *Runner) Locks(ctx context.Context) ([]locks.Holder, error) {
+	if r.locks == nil {
+		return nil, ErrNoLockStore
+	}
+	return r.locks.List(ctx)
+}
+
+// ForceUnlock removes a lock held by someone else. It is restricted to admins
+// and always audited, including refusals. A run still holding the lock loses
+// it at its next renewal and is cancelled.
+func (r *Runner) ForceUnlock(ctx context.Context, p *auth.Principal, name string) (*locks.Holder, error) {
+	var (
+		holder *locks.Holder
+		err    error
+	)
+	switch {
+	case p == nil || !p.HasPermission(config.PermLocksForceUnlock):
+		err = fmt.Errorf("force-unlock %s: %w", name, ErrNotAllowed)
+	case r.locks == nil:
+		err = ErrNoLockStore
+	default:
+		holder, err = r.locks.ForceRelease(ctx, name)
+	}
+
+	if r.logger != nil {
+		opID := fmt.Sprintf("lock:%s force-unlock", name)
+		if holder != nil {
+			opID = fmt.Sprintf("lock:%s force-unlock holder:%s", name, holder.UserID)
+		}
+		entry := logging.AuditEntry{
+			Time:        time.Now(),
+			UserID:      principalUserID(p),
+			SSHUser:     principalSSHUser(p),
+			OperationID: opID,
+			Success:     err == nil,
+		}
+		if holder != nil {
+			entry.RunID = holder.RunID
+		}
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		_ = r.logger.Log(context.Background(), entry)
+	}
+
+	return holder, err
+}
@@ -0,0 +1,155 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/you/lazyadmin/internal/config"
+	"github.com/you/lazyadmin/internal/locks"
+)
+
+func TestRunner_RunLocked(t *testing.T) {
+	ctx := context.Background()
+	store, err := locks.NewStore(t.TempDir() + "/locks.db")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	defer store.Close()
+
+	task := config.Task{
+		ID:           "rotate_keys",
+		AllowedRoles: []string{"admin"},
+		Inputs:       []config.TaskInput{{Name: "service", Required: true}},
+		Lock:         &config.Lock{Name: "rotate-{{ .Inputs.service }}"},
+		Steps:        []config.TaskStep{{ID: "wait", Type: "sleep", Seconds: 0}},
+	}
+	cfg := &config.Config{Project: "shop", Env: "prod", Tasks: []config.Task{task}}
+
+	runner := NewRunner(cfg, nil, nil, nil)
+	runner.SetLockStore(store)
+
+	lease, err := store.Acquire(ctx, "shop/prod/rotate-api", locks.Owner{UserID: "bob", SSHUser: "bob"}, time.Minute)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	res := runner.Run(ctx, testPrincipal("admin"), task, map[string]string{"service": "api"})
+	var held *locks.HeldError
+	if res.Success || !errors.As(res.Err, &held) || held.Holder.UserID != "bob" {
+		t.Fatalf("Run() while locked = success %v, err %v; want HeldError naming bob", res.Success, res.Err)
+	}
+
+	res = runner.Run(ctx, testPrincipal("admin"), task, map[string]string{"service": "web"})
+	if !res.Success {
+		t.Errorf("Run() with a different lock name failed: %v", res.Err)
+	}
+
+	holder, err := runner.LockHolder(ctx, task.Lock, task.ID, map[string]string{"service": "api"})
+	if err != nil || holder == nil || holder.UserID != "bob" {
+		t.Errorf("LockHolder() = %+v, %v; want bob", holder, err)
+	}
+
+	if _, err := runner.ForceUnlock(ctx, testPrincipal("operator"), "shop/prod/rotate-api"); !errors.Is(err, ErrNotAllowed) {
+		t.Errorf("ForceUnlock() by non-admin error = %v, want ErrNotAllowed", err)
+	}
+	holder, err = runner.ForceUnlock(ctx, testPrincipal("admin"), "shop/prod/rotate-api")
+	if err != nil || holder.UserID != "bob" {
+		t.Fatalf("ForceUnlock() = %+v, %v; want bob", holder, err)
+	}
+	_ = lease.Release(ctx)
+
+	res = runner.Run(ctx, testPrincipal("admin"), task, map[string]string{"service": "api"})
+	if !res.Success {
+		t.Errorf("Run() after force-unlock failed: %v", res.Err)
+	}
+	if h, _ := runner.LockHolder(ctx, task.Lock, task.ID, map[string]string{"service": "api"}); h != nil {
+		t.Errorf("lock still held after run: %+v", h)
+	}
+}
+
+func TestRunner_RunLockLost(t *testing.T) {
+	ctx := context.Background()
+	store, err := locks.NewStore(t.TempDir() + "/locks.db")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	defer store.Close()
+
+	task := config.Task{
+		ID:           "long",
+		AllowedRoles: []string{"admin"},
+		Lock:         &config.Lock{Scope: config.LockScopeGlobal, Lease: 60 * time.Millisecond},
+		Steps:        []config.TaskStep{{ID: "wait", Type: "sleep", Seconds: 5}},
+	}
+	runner := NewRunner(&config.Config{}, nil, nil, nil)
+	runner.SetLockStore(store)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_, _ = store.ForceRelease(ctx, "long")
+	}()
+
+	start := time.Now()
+	res := runner.Run(ctx, testPrincipal("admin"), task, nil)
+	if res.Success || !errors.Is(res.Err, locks.ErrLockLost) {
+		t.Errorf("Run() = success %v, err %v; want ErrLockLost", res.Success, res.Err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Run() took %s after losing its lock", elapsed)
+	}
+}
+
+func TestRunner_RunLockedWithoutStore(t *testing.T) {
+	task := config.Task{
+		ID:           "locked",
+		AllowedRoles: []string{"admin"},
+		Lock:         &config.Lock{},
+	}
+	runner := NewRunner(&config.Config{}, nil, nil, nil)
+
+	res := runner.Run(context.Background(), testPrincipal("admin"), task, nil)
+	if res.Success || !errors.Is(res.Err, ErrNoLockStore) {
+		t.Errorf("Run() = success %v, err %v; want ErrNoLockStore", res.Success, res.Err)
+	}
+}
+
+func TestRunner_RunLockLostSkipsRollback(t *testing.T) {
+	ctx := context.Background()
+	store, err := locks.NewStore(t.TempDir() + "/locks.db")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	defer store.Close()
+
+	task := config.Task{
+		ID:           "long",
+		AllowedRoles: []string{"admin"},
+		Lock:         &config.Lock{Scope: config.LockScopeGlobal, Lease: 60 * time.Millisecond},
+		Steps: []config.TaskStep{
+			{ID: "prepare", Type: "sleep", Seconds: 0,
+				Rollback: []config.TaskStep{{ID: "unprepare", Type: "sleep", Seconds: 0}}},
+			{ID: "wait", Type: "sleep", Seconds: 5},
+		},
+		Rollback: []config.TaskStep{{ID: "cleanup", Type: "sleep", Seconds: 0}},
+	}
+	runner := NewRunner(&config.Config{}, nil, nil, nil)
+	runner.SetLockStore(store)
+
+	// Someone force-unlocks and takes the lock while the run is waiting.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_, _ = store.ForceRelease(ctx, "long")
+		_, _ = store.Acquire(ctx, "long", locks.Owner{UserID: "bob", SSHUser: "bob"}, time.Minute)
+	}()
+
+	res := runner.Run(ctx, testPrincipal("admin"), task, nil)
+	if res.Success || !errors.Is(res.Err, locks.ErrLockLost) {
+		t.Fatalf("Run() = success %v, err %v; want ErrLockLost", res.Success, res.Err)
+	}
+	rb := res.Rollback
+	if rb == nil || !errors.Is(rb.Skipped, locks.ErrLockLost) || rb.Success || len(rb.StepOrder) != 0 {
+		t.Errorf("Rollback = %+v, want skipped with no steps run", rb)
+	}
+}
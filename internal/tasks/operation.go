@@ -0,0 +1,183 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/you/lazyadmin/internal/auth"
+	"github.com/you/lazyadmin/internal/config"
+	"github.com/you/lazyadmin/internal/logging"
+)
+
+// defaultOperationTimeout bounds each operation attempt when the operation
+// does not set timeout.
+const defaultOperationTimeout = 5 * time.Second
+
+var (
+	ErrInvalidParams = errors.New("invalid operation params")
+)
+
+// pathParam matches an OpenAPI-style {name} placeholder in an operation path.
+var pathParam = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// RunOperation executes a single operation on behalf of p and records it in
+// the audit log. params fill {name} placeholders in an HTTP operation's path.
+func (r *Runner) RunOperation(ctx context.Context, p *auth.Principal, op config.Operation, params map[string]string) (string, error) {
+	defer r.track()()
+	return r.runOperation(ctx, p, op, params, "")
+}
+
+// runOperation checks RBAC, executes op and logs it. parentRunID links the
+// audit entry to the task run that invoked the operation as a step.
+func (r *Runner) runOperation(ctx context.Context, p *auth.Principal, op config.Operation, params map[string]string, parentRunID string) (string, error) {
+	var out string
+	var err error
+
+	// Postgres operations also take an advisory lock on their target.
+	pgResource := ""
+	if op.Type == "postgres" {
+		pgResource = op.Target
+	}
+
+	if p == nil || !p.CanRun(op.AllowedRoles, op.Permissions, op.Tags) {
+		err = fmt.Errorf("operation %s: %w", op.ID, ErrNotAllowed)
+	} else if bound, bindErr := bindParams(op, params); bindErr != nil {
+		err = bindErr
+	} else if lockCtx, release, lockErr := r.acquireLock(ctx, p, op.Lock, op.ID, parentRunID, params, pgResource); lockErr != nil {
+		err = lockErr
+	} else {
+		defer release()
+		ctx := lockCtx
+		op := bound
+
+		policy := op.Retry
+		if policy.Timeout == 0 {
+			policy.Timeout = defaultOperationTimeout
+		}
+
+		var onAttempt func(Attempt)
+		if policy.Retries > 0 {
+			onAttempt = func(a Attempt) { r.logOperationAttempt(p, op, parentRunID, a) }
+		}
+
+		attempt := func(ctx context.Context) (int, string, error) {
+			return r.redactAttempt(r.execOperation(ctx, op))
+		}
+		out, _, err = retry(ctx, policy, attempt, onAttempt)
+	}
+
+	if r.logger != nil {
+		entry := logging.AuditEntry{
+			Time:        time.Now(),
+			UserID:      principalUserID(p),
+			SSHUser:     principalSSHUser(p),
+			OperationID: op.ID,
+			Success:     err == nil,
+			ParentRunID: parentRunID,
+		}
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		_ = r.logger.Log(ctx, entry)
+	}
+
+	return out, err
+}
+
+func (r *Runner) logOperationAttempt(p *auth.Principal, op config.Operation, parentRunID string, a Attempt) {
+	if r.logger == nil {
+		return
+	}
+
+	entry := logging.AuditEntry{
+		Time:        time.Now(),
+		UserID:      principalUserID(p),
+		SSHUser:     principalSSHUser(p),
+		OperationID: fmt.Sprintf("%s attempt:%d", op.ID, a.Number),
+		Success:     a.Err == nil,
+		ParentRunID: parentRunID,
+	}
+	if a.Err != nil {
+		entry.Error = a.Err.Error()
+	}
+	_ = r.logger.Log(context.Background(), entry)
+}
+
+func (r *Runner) execOperation(ctx context.Context, op config.Operation) (int, string, error) {
+	switch op.Type {
+	case "http":
+		client, ok := r.httpClients[op.Target]
+		if !ok {
+			return 0, "", fmt.Errorf("no http resource named %q", op.Target)
+		}
+		return client.RequestStatus(ctx, op.Method, op.Path)
+	case "postgres":
+		client, ok := r.pgClients[op.Target]
+		if !ok {
+			return 0, "", fmt.Errorf("no postgres resource named %q", op.Target)
+		}
+		out, err := client.RunScalarQuery(ctx, op.Query)
+		return 0, out, err
+	default:
+		return 0, "", fmt.Errorf("unsupported op type: %s", op.Type)
+	}
+}
+
+// bindParams substitutes params into op's path placeholders, path-escaping
+// each value. Every placeholder must be filled and every param must match a
+// placeholder.
+func bindParams(op config.Operation, params map[string]string) (config.Operation, error) {
+	if op.Type != "http" {
+		if len(params) > 0 {
+			return op, fmt.Errorf("operation %s: %s operations take no params: %w", op.ID, op.Type, ErrInvalidParams)
+		}
+		return op, nil
+	}
+
+	used := make(map[string]bool, len(params))
+	var missing []string
+	op.Path = pathParam.ReplaceAllStringFunc(op.Path, func(m string) string {
+		name := m[1 : len(m)-1]
+		v, ok := params[name]
+		if !ok || v == "" {
+			missing = append(missing, name)
+			return m
+		}
+		used[name] = true
+		return url.PathEscape(v)
+	})
+	if len(missing) > 0 {
+		return op, fmt.Errorf("operation %s: missing params %s: %w", op.ID, strings.Join(missing, ", "), ErrInvalidParams)
+	}
+
+	var unknown []string
+	for name := range params {
+		if !used[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return op, fmt.Errorf("operation %s: unknown params %s: %w", op.ID, strings.Join(unknown, ", "), ErrInvalidParams)
+	}
+	return op, nil
+}
+
+// PathParams returns the names of the {name} placeholders in an HTTP
+// operation's path, in order.
+func PathParams(op config.Operation) []string {
+	if op.Type != "http" {
+		return nil
+	}
+	var names []string
+	for _, m := range pathParam.FindAllStringSubmatch(op.Path, -1) {
+		names = append(names, m[1])
+	}
+	return names
+}
@@ -0,0 +1,79 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/you/lazyadmin/internal/clients"
+	"github.com/you/lazyadmin/internal/config"
+)
+
+func TestBindParams(t *testing.T) {
+	userOp := config.Operation{ID: "get_user", Type: "http", Method: "GET", Path: "/users/{id}/orders/{order_id}"}
+
+	tests := []struct {
+		name     string
+		op       config.Operation
+		params   map[string]string
+		wantPath string
+		wantErr  bool
+	}{
+		{name: "no placeholders", op: config.Operation{ID: "health", Type: "http", Path: "/health"}, wantPath: "/health"},
+		{name: "all filled", op: userOp, params: map[string]string{"id": "42", "order_id": "7"}, wantPath: "/users/42/orders/7"},
+		{name: "values are escaped", op: userOp, params: map[string]string{"id": "a/b", "order_id": "x y"}, wantPath: "/users/a%2Fb/orders/x%20y"},
+		{name: "missing param", op: userOp, params: map[string]string{"id": "42"}, wantErr: true},
+		{name: "unknown param", op: userOp, params: map[string]string{"id": "42", "order_id": "7", "extra": "1"}, wantErr: true},
+		{name: "postgres takes no params", op: config.Operation{ID: "count", Type: "postgres"}, params: map[string]string{"id": "1"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := bindParams(tt.op, tt.params)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidParams) {
+					t.Errorf("bindParams() error = %v, want ErrInvalidParams", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("bindParams() error = %v", err)
+			}
+			if got.Path != tt.wantPath {
+				t.Errorf("bindParams() path = %q, want %q", got.Path, tt.wantPath)
+			}
+		})
+	}
+
+	if got := PathParams(userOp); len(got) != 2 || got[0] != "id" || got[1] != "order_id" {
+		t.Errorf("PathParams() = %v, want [id order_id]", got)
+	}
+}
+
+func TestRunner_RunOperationParams(t *testing.T) {
+	var gotPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	op := config.Operation{ID: "get_user", Type: "http", Target: "backend", Method: "GET", Path: "/users/{id}", AllowedRoles: []string{"operator"}}
+	cfg := &config.Config{Operations: []config.Operation{op}}
+	runner := NewRunner(cfg, nil, map[string]*clients.HTTPClient{
+		"backend": clients.NewHTTPClient(server.URL),
+	}, nil)
+
+	if _, err := runner.RunOperation(context.Background(), testPrincipal("operator"), op, map[string]string{"id": "42"}); err != nil {
+		t.Fatalf("RunOperation() error = %v", err)
+	}
+	if gotPath != "/users/42" {
+		t.Errorf("request path = %q, want /users/42", gotPath)
+	}
+
+	if _, err := runner.RunOperation(context.Background(), testPrincipal("viewer"), op, map[string]string{"id": "42"}); !errors.Is(err, ErrNotAllowed) {
+		t.Errorf("RunOperation() by viewer error = %v, want ErrNotAllowed", err)
+	}
+}
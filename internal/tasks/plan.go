@@ -0,0 +1,355 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/you/lazyadmin/internal/auth"
+	"github.com/you/lazyadmin/internal/config"
+	"github.com/you/lazyadmin/internal/locks"
+	"github.com/you/lazyadmin/internal/logging"
+)
+
+// pingTimeout bounds each resource connectivity check during planning.
+const pingTimeout = 2 * time.Second
+
+// PlanOptions controls what Plan does beyond static resolution.
+type PlanOptions struct {
+	// ExecuteReadOnly runs read-only steps for real so the plan can show
+	// current conditions, e.g. the status a health check returns today.
+	// Steps of tasks the principal may not run are never executed, and
+	// postgres steps run in a READ ONLY transaction.
+	ExecuteReadOnly bool
+}
+
+// Plan describes what running a task would do without running it.
+type Plan struct {
+	Task     config.Task
+	Inputs   map[string]string
+	Problems []string // task-level problems (RBAC, inputs)
+	Steps    []PlannedStep
+}
+
+// PlannedStep is one line of a plan. Parallel children, rollback steps and
+// the steps of nested tasks appear after their parent with a greater Depth.
+type PlannedStep struct {
+	Depth    int
+	ID       string
+	Type     string
+	Action   string // rendered description, e.g. "GET http://backend:3000/health"
+	ReadOnly bool
+	Rollback bool
+	Problems []string
+	Preview  *StepResult // set when the step was executed as a read-only preview
+}
+
+// OK reports whether the plan found no problems.
+func (p *Plan) OK() bool {
+	if len(p.Problems) > 0 {
+		return false
+	}
+	for _, s := range p.Steps {
+		if len(s.Problems) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// String renders the plan as a human-readable document.
+func (p *Plan) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Plan for task %s (risk: %s)\n", p.Task.ID, p.Task.RiskLevel)
+	if len(p.Inputs) > 0 {
+		names := make([]string, 0, len(p.Inputs))
+		for name := range p.Inputs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		b.WriteString("Inputs:\n")
+		for _, name := range names {
+			fmt.Fprintf(&b, "  %s = %q\n", name, p.Inputs[name])
+		}
+	}
+	for _, problem := range p.Problems {
+		fmt.Fprintf(&b, "! %s\n", problem)
+	}
+
+	b.WriteString("Steps:\n")
+	for _, s := range p.Steps {
+		indent := strings.Repeat("  ", s.Depth+1)
+		label := s.ID
+		if s.Rollback {
+			label = "rollback " + label
+		}
+		fmt.Fprintf(&b, "%s- %s [%s] %s", indent, label, s.Type, s.Action)
+		if s.ReadOnly {
+			b.WriteString(" (read-only)")
+		}
+		b.WriteString("\n")
+
+		for _, problem := range s.Problems {
+			fmt.Fprintf(&b, "%s    ! %s\n", indent, problem)
+		}
+		if s.Preview != nil {
+			if s.Preview.Err != nil {
+				fmt.Fprintf(&b, "%s    preview: error: %v\n", indent, s.Preview.Err)
+			} else {
+				fmt.Fprintf(&b, "%s    preview: %s\n", indent, s.Preview.Output)
+			}
+		}
+	}
+
+	if p.OK() {
+		b.WriteString("No problems found.\n")
+	} else {
+		b.WriteString("Plan has problems; running the task would fail.\n")
+	}
+
+	return b.String()
+}
+
+type planner struct {
+	r     *Runner
+	p     *auth.Principal
+	plan  *Plan
+	reach map[string]error // resource key -> connectivity result
+}
+
+// Plan resolves resources, renders every templated field with inputs, checks
+// RBAC for the task and everything it calls, and checks connectivity to each
+// resource involved. Nothing mutating is executed; with opts.ExecuteReadOnly,
+// read-only steps run for real to preview their output.
+func (r *Runner) Plan(ctx context.Context, p *auth.Principal, task config.Task, inputs map[string]string, opts PlanOptions) *Plan {
+	defer r.track()()
+	pl := &Plan{Task: task}
+	pc := &planner{r: r, p: p, plan: pl, reach: make(map[string]error)}
+
+	resolved, problems := pc.planTask(ctx, task, inputs, 0, nil, opts.ExecuteReadOnly)
+	pl.Inputs = resolved
+	pl.Problems = problems
+
+	if r.logger != nil {
+		entry := logging.AuditEntry{
+			Time:        time.Now(),
+			UserID:      principalUserID(p),
+			SSHUser:     principalSSHUser(p),
+			OperationID: fmt.Sprintf("task:%s plan", task.ID),
+			Success:     pl.OK(),
+		}
+		_ = r.logger.Log(context.Background(), entry)
+	}
+
+	return pl
+}
+
+// planTask appends the task's steps to the plan and returns its resolved
+// inputs along with problems that prevent the task from starting. Read-only
+// steps are previewed only if preview is set and the task has no such
+// problems, so a task the principal may not run never executes anything.
+func (pc *planner) planTask(ctx context.Context, task config.Task, inputs map[string]string, depth int, callStack []string, preview bool) (map[string]string, []string) {
+	for _, id := range callStack {
+		if id == task.ID {
+			return nil, []string{fmt.Sprintf("task %s: recursive task call", task.ID)}
+		}
+	}
+
+	var problems []string
+	if pc.p == nil || !pc.p.CanRun(task.AllowedRoles, task.Permissions, task.Tags) {
+		problems = append(problems, fmt.Sprintf("task %s: %v", task.ID, ErrNotAllowed))
+	}
+	if pc.r.cfg.RiskPolicy.Denies(task.RiskLevel) {
+		problems = append(problems, fmt.Sprintf("task %s: %v: %s in %s", task.ID, ErrRiskDenied, task.RiskLevel, pc.r.cfg.Env))
+	}
+	if pc.p != nil {
+		if err := pc.r.checkStepUp(pc.p, task, callStack); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	resolved, err := resolveInputs(task, inputs)
+	if err != nil {
+		problems = append(problems, err.Error())
+	} else if holder, err := pc.r.LockHolder(ctx, task.Lock, task.ID, resolved); err != nil {
+		problems = append(problems, fmt.Sprintf("task %s: %v", task.ID, err))
+	} else if holder != nil {
+		problems = append(problems, (&locks.HeldError{Holder: *holder}).Error())
+	}
+
+	inv := &invocation{
+		principal: pc.p,
+		taskID:    task.ID,
+		inputs:    resolved,
+		callStack: append(append([]string{}, callStack...), task.ID),
+		preview:   preview && len(problems) == 0,
+	}
+
+	for _, step := range task.Steps {
+		if step.Parallel != nil {
+			limit := "all"
+			if step.Parallel.MaxConcurrency > 0 {
+				limit = fmt.Sprintf("%d", step.Parallel.MaxConcurrency)
+			}
+			pc.plan.Steps = append(pc.plan.Steps, PlannedStep{
+				Depth:  depth,
+				ID:     step.ID,
+				Type:   "parallel",
+				Action: fmt.Sprintf("run %d steps concurrently (max %s at once)", len(step.Parallel.Steps), limit),
+			})
+			for _, child := range step.Parallel.Steps {
+				pc.planStep(ctx, inv, child, depth+1, false)
+			}
+		} else {
+			pc.planStep(ctx, inv, step, depth, false)
+		}
+	}
+	for _, rb := range task.Rollback {
+		pc.planStep(ctx, inv, rb, depth, true)
+	}
+
+	return resolved, problems
+}
+
+func (pc *planner) planStep(ctx context.Context, inv *invocation, step config.TaskStep, depth int, rollback bool) {
+	ps := PlannedStep{Depth: depth, ID: step.ID, Type: step.Type, Rollback: rollback}
+
+	rendered, err := renderStep(step, inv.inputs)
+	if err != nil {
+		ps.Problems = append(ps.Problems, err.Error())
+		rendered = renderedStep{TaskStep: step}
+	}
+
+	var nested *config.Task
+	switch step.Type {
+	case "http":
+		ps.ReadOnly = step.ReadOnly || isSafeMethod(step.Method)
+		ps.Action = fmt.Sprintf("%s %s", strings.ToUpper(step.Method), rendered.Path)
+		if client, ok := pc.r.httpClients[step.Resource]; ok {
+			ps.Action = fmt.Sprintf("%s %s%s", strings.ToUpper(step.Method), client.BaseURL(), rendered.Path)
+		}
+		ps.Problems = append(ps.Problems, pc.checkResource(ctx, "http", step.Resource)...)
+
+	case "postgres":
+		ps.ReadOnly = step.ReadOnly
+		ps.Action = fmt.Sprintf("%s: %s", step.Resource, rendered.Query)
+		if len(rendered.QueryArgs) > 0 {
+			ps.Action += fmt.Sprintf(" %q", rendered.QueryArgs)
+		}
+		ps.Problems = append(ps.Problems, pc.checkResource(ctx, "postgres", step.Resource)...)
+
+	case "sleep":
+		ps.Action = fmt.Sprintf("sleep %s", time.Duration(step.Seconds)*time.Second)
+
+	case "operation":
+		op, ok := pc.r.cfg.FindOperation(step.Operation)
+		if !ok {
+			ps.Problems = append(ps.Problems, fmt.Sprintf("unknown operation %q", step.Operation))
+			break
+		}
+		if pc.p == nil || !pc.p.CanRun(op.AllowedRoles, op.Permissions, op.Tags) {
+			ps.Problems = append(ps.Problems, fmt.Sprintf("operation %s: %v", op.ID, ErrNotAllowed))
+		}
+		switch op.Type {
+		case "http":
+			ps.ReadOnly = isSafeMethod(op.Method)
+			ps.Action = fmt.Sprintf("operation %s: %s %s", op.ID, strings.ToUpper(op.Method), op.Path)
+		case "postgres":
+			ps.Action = fmt.Sprintf("operation %s: %s: %s", op.ID, op.Target, op.Query)
+		default:
+			ps.Action = fmt.Sprintf("operation %s", op.ID)
+		}
+		ps.Problems = append(ps.Problems, pc.checkResource(ctx, op.Type, op.Target)...)
+
+	case "task":
+		t, ok := pc.r.cfg.FindTask(step.Task)
+		if !ok {
+			ps.Problems = append(ps.Problems, fmt.Sprintf("unknown task %q", step.Task))
+			break
+		}
+		nested = t
+		ps.Action = fmt.Sprintf("run task %s", t.ID)
+
+	default:
+		ps.Problems = append(ps.Problems, fmt.Sprintf("unsupported step type %q", step.Type))
+	}
+
+	if inv.preview && ps.ReadOnly && len(ps.Problems) == 0 && !rollback {
+		sr := pc.r.runStep(ctx, inv, step)
+		ps.Preview = &sr
+		pc.logPreview(inv, sr)
+	}
+
+	pc.plan.Steps = append(pc.plan.Steps, ps)
+	idx := len(pc.plan.Steps) - 1
+
+	if nested != nil {
+		_, problems := pc.planTask(ctx, *nested, rendered.Inputs, depth+1, inv.callStack, inv.preview)
+		pc.plan.Steps[idx].Problems = append(pc.plan.Steps[idx].Problems, problems...)
+	}
+
+	for _, rb := range step.Rollback {
+		pc.planStep(ctx, inv, rb, depth+1, true)
+	}
+}
+
+// checkResource reports a missing or unreachable resource. Results are cached
+// so each resource is contacted at most once per plan.
+func (pc *planner) checkResource(ctx context.Context, kind, name string) []string {
+	key := kind + ":" + name
+	err, checked := pc.reach[key]
+	if !checked {
+		pingCtx, cancel := context.WithTimeout(ctx, pingTimeout)
+		switch kind {
+		case "http":
+			if client, ok := pc.r.httpClients[name]; ok {
+				err = client.Ping(pingCtx)
+			} else {
+				err = fmt.Errorf("no http resource %q", name)
+			}
+		case "postgres":
+			if client, ok := pc.r.pgClients[name]; ok {
+				err = client.Ping(pingCtx)
+			} else {
+				err = fmt.Errorf("no postgres resource %q", name)
+			}
+		default:
+			err = fmt.Errorf("unsupported resource type %q", kind)
+		}
+		cancel()
+		pc.reach[key] = err
+	}
+
+	if err != nil {
+		return []string{fmt.Sprintf("%s resource %s: %v", kind, name, err)}
+	}
+	return nil
+}
+
+func (pc *planner) logPreview(inv *invocation, sr StepResult) {
+	if pc.r.logger == nil {
+		return
+	}
+
+	entry := logging.AuditEntry{
+		Time:        time.Now(),
+		UserID:      principalUserID(inv.principal),
+		SSHUser:     principalSSHUser(inv.principal),
+		OperationID: fmt.Sprintf("task:%s plan step:%s", inv.taskID, sr.Step.ID),
+		Success:     sr.Err == nil,
+	}
+	if sr.Err != nil {
+		entry.Error = sr.Err.Error()
+	}
+	_ = pc.r.logger.Log(context.Background(), entry)
+}
+
+func isSafeMethod(method string) bool {
+	switch strings.ToUpper(method) {
+	case "GET", "HEAD", "OPTIONS":
+		return true
+	}
+	return false
+}
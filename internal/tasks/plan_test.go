@@ -0,0 +1,153 @@
+package tasks
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/you/lazyadmin/internal/clients"
+	"github.com/you/lazyadmin/internal/config"
+)
+
+func TestRunner_Plan(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		Tasks: []config.Task{
+			{
+				ID:           "owner_only",
+				AllowedRoles: []string{"owner"},
+				Steps:        []config.TaskStep{{ID: "noop", Type: "sleep"}},
+			},
+		},
+	}
+	runner := NewRunner(cfg, nil, map[string]*clients.HTTPClient{"backend": clients.NewHTTPClient(server.URL)}, nil)
+
+	task := config.Task{
+		ID:           "deploy",
+		AllowedRoles: []string{"admin"},
+		Inputs:       []config.TaskInput{{Name: "version", Required: true}},
+		Steps: []config.TaskStep{
+			{ID: "health", Type: "http", Resource: "backend", Method: "GET", Path: "/health"},
+			{
+				ID: "release", Type: "http", Resource: "backend", Method: "POST", Path: "/releases/{{ .Inputs.version }}",
+				Rollback: []config.TaskStep{{ID: "unrelease", Type: "http", Resource: "backend", Method: "DELETE", Path: "/releases/{{ .Inputs.version }}"}},
+			},
+			{ID: "count", Type: "postgres", Resource: "main", Query: "SELECT 1"},
+			{ID: "escalate", Type: "task", Task: "owner_only"},
+		},
+	}
+
+	plan := runner.Plan(context.Background(), testPrincipal("admin"), task, map[string]string{"version": "1.2.3"}, PlanOptions{})
+
+	if len(requests) != 0 {
+		t.Errorf("plan made requests %v, want none", requests)
+	}
+	if plan.OK() {
+		t.Error("OK() = true, want problems for missing postgres resource and denied nested task")
+	}
+
+	byID := make(map[string]PlannedStep)
+	var ids []string
+	for _, s := range plan.Steps {
+		byID[s.ID] = s
+		ids = append(ids, s.ID)
+	}
+	wantIDs := []string{"health", "release", "unrelease", "count", "escalate", "noop"}
+	if !reflect.DeepEqual(ids, wantIDs) {
+		t.Errorf("planned steps = %v, want %v", ids, wantIDs)
+	}
+	if want := "POST " + server.URL + "/releases/1.2.3"; byID["release"].Action != want {
+		t.Errorf("release action = %q, want %q", byID["release"].Action, want)
+	}
+	if !byID["health"].ReadOnly || byID["release"].ReadOnly {
+		t.Error("GET step should be read-only and POST step should not")
+	}
+	if !byID["unrelease"].Rollback || byID["unrelease"].Depth != 1 {
+		t.Errorf("unrelease = %+v, want rollback at depth 1", byID["unrelease"])
+	}
+	if len(byID["count"].Problems) == 0 {
+		t.Error("count step has no problems, want missing postgres resource")
+	}
+	if p := byID["escalate"].Problems; len(p) == 0 || !strings.Contains(p[0], "not allowed") {
+		t.Errorf("escalate problems = %v, want RBAC denial", p)
+	}
+	if !strings.Contains(plan.String(), "Plan has problems") {
+		t.Errorf("String() = %q, want problem footer", plan.String())
+	}
+
+	preview := runner.Plan(context.Background(), testPrincipal("admin"), task, map[string]string{"version": "1.2.3"}, PlanOptions{ExecuteReadOnly: true})
+
+	if !reflect.DeepEqual(requests, []string{"GET /health"}) {
+		t.Errorf("preview requests = %v, want only the read-only GET", requests)
+	}
+	if sr := preview.Steps[0].Preview; sr == nil || sr.Output != "HTTP 200 OK" {
+		t.Errorf("health preview = %+v, want HTTP 200 OK", sr)
+	}
+}
+
+func TestRunner_PlanPreviewDenied(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	ownerOnly := config.Task{
+		ID:           "owner_only",
+		AllowedRoles: []string{"owner"},
+		Steps:        []config.TaskStep{{ID: "secrets", Type: "http", Resource: "backend", Method: "GET", Path: "/owner/secrets"}},
+	}
+	cfg := &config.Config{Tasks: []config.Task{ownerOnly}}
+	runner := NewRunner(cfg, nil, map[string]*clients.HTTPClient{"backend": clients.NewHTTPClient(server.URL)}, nil)
+
+	tests := []struct {
+		name      string
+		task      config.Task
+		principal []string
+		wantReqs  []string
+	}{
+		{
+			name:      "principal without the role",
+			task:      ownerOnly,
+			principal: []string{"viewer"},
+		},
+		{
+			name: "allowed task calling a denied one",
+			task: config.Task{
+				ID:           "wrapper",
+				AllowedRoles: []string{"viewer"},
+				Steps: []config.TaskStep{
+					{ID: "health", Type: "http", Resource: "backend", Method: "GET", Path: "/health"},
+					{ID: "escalate", Type: "task", Task: "owner_only"},
+				},
+			},
+			principal: []string{"viewer"},
+			wantReqs:  []string{"GET /health"},
+		},
+		{
+			name:      "owner",
+			task:      ownerOnly,
+			principal: []string{"owner"},
+			wantReqs:  []string{"GET /owner/secrets"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests = nil
+			runner.Plan(context.Background(), testPrincipal(tt.principal...), tt.task, nil, PlanOptions{ExecuteReadOnly: true})
+			if !reflect.DeepEqual(requests, tt.wantReqs) {
+				t.Errorf("preview requests = %v, want %v", requests, tt.wantReqs)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/you/lazyadmin/internal/auth"
+	"github.com/you/lazyadmin/internal/config"
+	"github.com/you/lazyadmin/internal/logging"
+	"github.com/you/lazyadmin/internal/runs"
+)
+
+var (
+	ErrNoRunStore   = errors.New("run persistence is not enabled")
+	ErrNotResumable = errors.New("run cannot be resumed")
+)
+
+// ResumeMode selects where a resumed run picks up.
+type ResumeMode int
+
+const (
+	// ResumeRetryFailed re-executes the step that failed or was interrupted.
+	ResumeRetryFailed ResumeMode = iota
+	// ResumeSkipFailed keeps the failed step's result and continues with the
+	// step after it.
+	ResumeSkipFailed
+)
+
+// resumePoint describes where a resumed run starts and the checkpoints it
+// restores for the steps that need not run again.
+type resumePoint struct {
+	runID string
+	start int // index into task.Steps of the first step that may execute
+	prior map[string]runs.StepState
+	// claim marks the resumed run as taken. run calls it once the new run
+	// has passed its start checks and holds the task lock; claimErr keeps
+	// its error for Resume.
+	claim    func() error
+	claimErr error
+}
+
+// restores reports whether the top-level step at index i is restored rather
+// than executed: every step that succeeded is, wherever it lies, and so is
+// everything before start. Only failed, interrupted and never started steps
+// from start on run again.
+func (rp *resumePoint) restores(i int, step config.TaskStep) bool {
+	if rp == nil {
+		return false
+	}
+	return i < rp.start || rp.prior[step.ID].OK
+}
+
+// restore rebuilds the results of a top-level step, and of its parallel
+// children, from the checkpoints of the run being resumed.
+func (rp *resumePoint) restore(step config.TaskStep) []StepResult {
+	out := []StepResult{rp.restoreOne(step)}
+	if step.Parallel != nil {
+		for _, child := range step.Parallel.Steps {
+			out = append(out, rp.restoreOne(child))
+		}
+	}
+	return out
+}
+
+func (rp *resumePoint) restoreOne(step config.TaskStep) StepResult {
+	st, ok := rp.prior[step.ID]
+	if !ok {
+		return StepResult{Step: step, OK: false, Err: fmt.Errorf("no checkpoint for step %q", step.ID)}
+	}
+	sr := StepResult{Step: step, OK: st.OK, Output: st.Output}
+	if !st.OK {
+		sr.Err = errors.New(st.Error)
+	}
+	return sr
+}
+
+// checkpointer persists the progress of one top-level run. A nil checkpointer
+// discards everything, so callers need not check whether persistence is on.
+type checkpointer struct {
+	store *runs.Store
+	runID string
+	seq   int
+}
+
+// startCheckpoints records the start of inv's run. Nested task runs are not
+// persisted on their own; they are resumed as part of their parent step.
+func (r *Runner) startCheckpoints(inv *invocation, rp *resumePoint) *checkpointer {
+	if r.runs == nil || inv.parentRunID != "" {
+		return nil
+	}
+
+	run := &runs.Run{
+		ID:      inv.runID,
+		TaskID:  inv.taskID,
+		UserID:  principalUserID(inv.principal),
+		SSHUser: principalSSHUser(inv.principal),
+		Inputs:  inv.inputs,
+	}
+	if rp != nil {
+		run.ResumedFrom = rp.runID
+	}
+	if err := r.runs.StartRun(context.Background(), run); err != nil {
+		return nil
+	}
+
+	return &checkpointer{store: r.runs, runID: inv.runID}
+}
+
+func (c *checkpointer) record(sr StepResult) {
+	if c == nil {
+		return
+	}
+
+	st := runs.StepState{
+		RunID:  c.runID,
+		StepID: sr.Step.ID,
+		Seq:    c.seq,
+		OK:     sr.OK,
+		Output: sr.Output,
+	}
+	if sr.Err != nil {
+		st.Error = sr.Err.Error()
+	}
+	c.seq++
+	_ = c.store.RecordStep(context.Background(), st)
+}
+
+func (c *checkpointer) finish(success bool) {
+	if c == nil {
+		return
+	}
+
+	status := runs.StatusSucceeded
+	if !success {
+		status = runs.StatusFailed
+	}
+	_ = c.store.SetStatus(context.Background(), c.runID, status)
+}
+
+// Resume continues a failed or interrupted run under a new run ID, using the
+// inputs it was started with. Steps that completed, including ones after a
+// step that failed with on_error: continue, are restored from their
+// checkpoints rather than executed again, and still take part in rollback if
+// the resumed run fails. Only the principal who started
+// the run, or an admin, may resume it; callers are expected to re-authenticate
+// the principal first. A run is resumed at most once: of concurrent resumes,
+// all but one fail with ErrNotResumable.
+func (r *Runner) Resume(ctx context.Context, p *auth.Principal, runID string, mode ResumeMode) (TaskResult, error) {
+	defer r.track()()
+	if r.runs == nil {
+		return TaskResult{}, ErrNoRunStore
+	}
+
+	run, err := r.runs.GetRun(ctx, runID)
+	if err != nil {
+		return TaskResult{}, err
+	}
+	if !run.Status.Resumable() {
+		return TaskResult{}, fmt.Errorf("run %s is %s: %w", run.ID, run.Status, ErrNotResumable)
+	}
+	if p == nil || (principalUserID(p) != run.UserID && !p.HasPermission(config.PermRunsResumeAny)) {
+		return TaskResult{}, fmt.Errorf("run %s: %w", run.ID, ErrNotAllowed)
+	}
+
+	task, ok := r.cfg.FindTask(run.TaskID)
+	if !ok {
+		return TaskResult{}, fmt.Errorf("run %s: unknown task %q", run.ID, run.TaskID)
+	}
+
+	states, err := r.runs.Steps(ctx, run.ID)
+	if err != nil {
+		return TaskResult{}, err
+	}
+	rp := &resumePoint{runID: run.ID, prior: make(map[string]runs.StepState, len(states))}
+	for _, st := range states {
+		rp.prior[st.StepID] = st
+	}
+
+	// Resume at the first top-level step without a successful checkpoint.
+	for rp.start < len(task.Steps) {
+		st, done := rp.prior[task.Steps[rp.start].ID]
+		if !done || !st.OK {
+			break
+		}
+		rp.start++
+	}
+	if mode == ResumeSkipFailed && rp.start < len(task.Steps) {
+		if _, failed := rp.prior[task.Steps[rp.start].ID]; failed {
+			rp.start++
+		}
+	}
+
+	// The run is claimed only once the new run may start and holds the
+	// task lock, so a resume refused by RBAC, the risk policy, step-up or a
+	// held lock leaves it resumable. A concurrent resume loses the claim.
+	rp.claim = func() error {
+		if err := r.runs.ClaimResume(ctx, run.ID); errors.Is(err, runs.ErrRunNotResumable) {
+			return fmt.Errorf("run %s was resumed by someone else: %w", run.ID, ErrNotResumable)
+		} else if err != nil {
+			return err
+		}
+		r.logResume(p, run)
+		return nil
+	}
+
+	res := r.run(ctx, p, *task, run.Inputs, "", nil, rp)
+	if rp.claimErr != nil {
+		return TaskResult{}, rp.claimErr
+	}
+	return res, nil
+}
+
+func (r *Runner) logResume(p *auth.Principal, run *runs.Run) {
+	if r.logger == nil {
+		return
+	}
+
+	entry := logging.AuditEntry{
+		Time:        time.Now(),
+		UserID:      principalUserID(p),
+		SSHUser:     principalSSHUser(p),
+		OperationID: fmt.Sprintf("task:%s resume:%s", run.TaskID, run.ID),
+		Success:     true,
+		RunID:       run.ID,
+	}
+	_ = r.logger.Log(context.Background(), entry)
+}
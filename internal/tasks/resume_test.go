@@ -0,0 +1,350 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/you/lazyadmin/internal/auth"
+	"github.com/you/lazyadmin/internal/clients"
+	"github.com/you/lazyadmin/internal/config"
+	"github.com/you/lazyadmin/internal/locks"
+	"github.com/you/lazyadmin/internal/runs"
+)
+
+func TestRunner_Resume(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		hits    = map[string]int{}
+		healthy bool
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		hits[r.URL.Path]++
+		if r.URL.Path == "/migrate" && !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	step := func(id string) config.TaskStep {
+		return config.TaskStep{ID: id, Type: "http", Resource: "backend", Method: "POST", Path: "/" + id}
+	}
+	task := config.Task{
+		ID:           "deploy",
+		AllowedRoles: []string{"admin"},
+		Inputs:       []config.TaskInput{{Name: "version", Required: true}},
+		Steps: []config.TaskStep{
+			step("build"),
+			{ID: "migrate", Type: "http", Resource: "backend", Method: "POST", Path: "/migrate",
+				Retry: config.RetryPolicy{RetryOn: []string{"5xx"}}},
+			step("restart"),
+		},
+	}
+	cfg := &config.Config{Tasks: []config.Task{task}}
+
+	tests := []struct {
+		name      string
+		mode      ResumeMode
+		wantHits  map[string]int // hits during the resumed run
+		wantOrder []string
+	}{
+		{
+			name:      "retry failed step",
+			mode:      ResumeRetryFailed,
+			wantHits:  map[string]int{"/migrate": 1, "/restart": 1},
+			wantOrder: []string{"build", "migrate", "restart"},
+		},
+		{
+			name:      "skip failed step",
+			mode:      ResumeSkipFailed,
+			wantHits:  map[string]int{"/restart": 1},
+			wantOrder: []string{"build", "migrate", "restart"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := runs.NewStore(t.TempDir() + "/runs.db")
+			if err != nil {
+				t.Fatalf("NewStore() error = %v", err)
+			}
+			defer store.Close()
+
+			runner := NewRunner(cfg, nil, map[string]*clients.HTTPClient{
+				"backend": clients.NewHTTPClient(server.URL),
+			}, nil)
+			runner.SetRunStore(store)
+
+			mu.Lock()
+			healthy = false
+			mu.Unlock()
+
+			first := runner.Run(context.Background(), testPrincipal("admin"), task, map[string]string{"version": "1.2"})
+			if first.Success {
+				t.Fatalf("first run succeeded, want failure at migrate")
+			}
+			saved, err := store.GetRun(context.Background(), first.RunID)
+			if err != nil || saved.Status != runs.StatusFailed {
+				t.Fatalf("GetRun() = %+v, %v; want failed run", saved, err)
+			}
+
+			other := &auth.Principal{ConfigUser: &config.User{ID: "bob", Roles: []string{"operator"}}, SSHUser: "bob"}
+			if _, err := runner.Resume(context.Background(), other, first.RunID, tt.mode); !errors.Is(err, ErrNotAllowed) {
+				t.Errorf("Resume() by another user error = %v, want ErrNotAllowed", err)
+			}
+
+			mu.Lock()
+			healthy = true
+			for k := range hits {
+				delete(hits, k)
+			}
+			mu.Unlock()
+
+			res, err := runner.Resume(context.Background(), testPrincipal("admin"), first.RunID, tt.mode)
+			if err != nil {
+				t.Fatalf("Resume() error = %v", err)
+			}
+			if !res.Success {
+				t.Errorf("resumed run Success = false (steps: %+v)", res.Steps)
+			}
+			if res.ResumedFrom != first.RunID || res.Inputs["version"] != "1.2" {
+				t.Errorf("ResumedFrom = %q, Inputs = %v", res.ResumedFrom, res.Inputs)
+			}
+			if !reflect.DeepEqual(res.StepOrder, tt.wantOrder) {
+				t.Errorf("StepOrder = %v, want %v", res.StepOrder, tt.wantOrder)
+			}
+			mu.Lock()
+			if !reflect.DeepEqual(hits, tt.wantHits) {
+				t.Errorf("requests during resume = %v, want %v", hits, tt.wantHits)
+			}
+			mu.Unlock()
+
+			prev, _ := store.GetRun(context.Background(), first.RunID)
+			if prev.Status != runs.StatusResumed {
+				t.Errorf("original run status = %s, want resumed", prev.Status)
+			}
+			if _, err := runner.Resume(context.Background(), testPrincipal("admin"), first.RunID, tt.mode); !errors.Is(err, ErrNotResumable) {
+				t.Errorf("second Resume() error = %v, want ErrNotResumable", err)
+			}
+		})
+	}
+}
+
+func TestRunner_ResumeKeepsStepsAfterContinuedFailure(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		hits = map[string]int{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	step := func(id string) config.TaskStep {
+		return config.TaskStep{ID: id, Type: "http", Resource: "backend", Method: "POST", Path: "/" + id}
+	}
+	notify := step("notify")
+	notify.OnError = config.StepOnErrorContinue
+	task := config.Task{
+		ID:           "deploy",
+		AllowedRoles: []string{"admin"},
+		Steps:        []config.TaskStep{notify, step("migrate"), step("restart")},
+	}
+	cfg := &config.Config{Tasks: []config.Task{task}}
+
+	store, err := runs.NewStore(t.TempDir() + "/runs.db")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	defer store.Close()
+
+	// notify failed and was continued past, migrate succeeded, and the
+	// process died during restart.
+	ctx := context.Background()
+	prior := &runs.Run{ID: "prior", TaskID: "deploy", UserID: "alice", SSHUser: "alice"}
+	if err := store.StartRun(ctx, prior); err != nil {
+		t.Fatalf("StartRun() error = %v", err)
+	}
+	for i, st := range []runs.StepState{
+		{StepID: "notify", OK: false, Error: "status 503"},
+		{StepID: "migrate", OK: true, Output: "migrated"},
+	} {
+		st.RunID, st.Seq = prior.ID, i
+		if err := store.RecordStep(ctx, st); err != nil {
+			t.Fatalf("RecordStep() error = %v", err)
+		}
+	}
+	if err := store.SetStatus(ctx, prior.ID, runs.StatusInterrupted); err != nil {
+		t.Fatalf("SetStatus() error = %v", err)
+	}
+
+	runner := NewRunner(cfg, nil, map[string]*clients.HTTPClient{
+		"backend": clients.NewHTTPClient(server.URL),
+	}, nil)
+	runner.SetRunStore(store)
+
+	res, err := runner.Resume(ctx, testPrincipal("admin"), prior.ID, ResumeRetryFailed)
+	if err != nil {
+		t.Fatalf("Resume() error = %v", err)
+	}
+	if !res.Success || res.Steps["migrate"].Output != "migrated" {
+		t.Errorf("resumed run Success = %v, migrate = %+v", res.Success, res.Steps["migrate"])
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := map[string]int{"/notify": 1, "/restart": 1}; !reflect.DeepEqual(hits, want) {
+		t.Errorf("requests during resume = %v, want %v (migrate must not run again)", hits, want)
+	}
+}
+
+func TestRunner_ResumeRace(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		hits    int
+		healthy bool
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		hits++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	task := config.Task{
+		ID:           "migrate",
+		AllowedRoles: []string{"admin"},
+		Steps: []config.TaskStep{{ID: "migrate", Type: "http", Resource: "backend", Method: "POST", Path: "/migrate",
+			Retry: config.RetryPolicy{RetryOn: []string{"5xx"}}}},
+	}
+	store, err := runs.NewStore(t.TempDir() + "/runs.db")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	defer store.Close()
+	runner := NewRunner(&config.Config{Tasks: []config.Task{task}}, nil, map[string]*clients.HTTPClient{
+		"backend": clients.NewHTTPClient(server.URL),
+	}, nil)
+	runner.SetRunStore(store)
+
+	first := runner.Run(context.Background(), testPrincipal("admin"), task, nil)
+	if first.Success {
+		t.Fatal("first run succeeded, want failure")
+	}
+	mu.Lock()
+	healthy = true
+	mu.Unlock()
+
+	// Two operators, or the TUI and the CLI, resume the same run at once.
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = runner.Resume(context.Background(), testPrincipal("admin"), first.RunID, ResumeRetryFailed)
+		}()
+	}
+	wg.Wait()
+
+	var resumed int
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			resumed++
+		case !errors.Is(err, ErrNotResumable):
+			t.Errorf("losing Resume() error = %v, want ErrNotResumable", err)
+		}
+	}
+	if resumed != 1 {
+		t.Errorf("%d resumes ran, want 1 (errors: %v)", resumed, errs)
+	}
+	if hits != 1 {
+		t.Errorf("migrate ran %d times during the resumes, want 1", hits)
+	}
+}
+
+func TestRunner_ResumeWhileLocked(t *testing.T) {
+	ctx := context.Background()
+	var (
+		mu      sync.Mutex
+		healthy bool
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	task := config.Task{
+		ID:           "migrate",
+		AllowedRoles: []string{"admin"},
+		Lock:         &config.Lock{Scope: config.LockScopeGlobal},
+		Steps: []config.TaskStep{{ID: "migrate", Type: "http", Resource: "backend", Method: "POST", Path: "/migrate",
+			Retry: config.RetryPolicy{RetryOn: []string{"5xx"}}}},
+	}
+	runStore, err := runs.NewStore(t.TempDir() + "/runs.db")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	defer runStore.Close()
+	lockStore, err := locks.NewStore(t.TempDir() + "/locks.db")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	defer lockStore.Close()
+	runner := NewRunner(&config.Config{Tasks: []config.Task{task}}, nil, map[string]*clients.HTTPClient{
+		"backend": clients.NewHTTPClient(server.URL),
+	}, nil)
+	runner.SetRunStore(runStore)
+	runner.SetLockStore(lockStore)
+
+	first := runner.Run(ctx, testPrincipal("admin"), task, nil)
+	if first.Success {
+		t.Fatal("first run succeeded, want failure")
+	}
+	mu.Lock()
+	healthy = true
+	mu.Unlock()
+
+	// A resume refused because someone holds the lock leaves the run
+	// resumable.
+	lease, err := lockStore.Acquire(ctx, "migrate", locks.Owner{UserID: "bob", SSHUser: "bob"}, time.Minute)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	res, err := runner.Resume(ctx, testPrincipal("admin"), first.RunID, ResumeRetryFailed)
+	if err != nil || res.Success || !errors.Is(res.Err, locks.ErrLockHeld) {
+		t.Fatalf("Resume() while locked = success %v, err %v, %v; want ErrLockHeld", res.Success, res.Err, err)
+	}
+	if prev, _ := runStore.GetRun(ctx, first.RunID); prev.Status != runs.StatusFailed {
+		t.Errorf("original run status after refused resume = %s, want failed", prev.Status)
+	}
+	_ = lease.Release(ctx)
+
+	res, err = runner.Resume(ctx, testPrincipal("admin"), first.RunID, ResumeRetryFailed)
+	if err != nil || !res.Success || res.ResumedFrom != first.RunID {
+		t.Errorf("Resume() after unlock = success %v, resumed from %q, %v (%v)", res.Success, res.ResumedFrom, err, res.Err)
+	}
+}
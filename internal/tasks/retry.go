@@ -0,0 +1,121 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"time"
+
+	"github.com/you/lazyadmin/internal/config"
+)
+
+const (
+	defaultBackoffDelay    = time.Second
+	defaultBackoffMaxDelay = 30 * time.Second
+)
+
+// Attempt records one execution attempt of a step or operation.
+type Attempt struct {
+	Number   int
+	Status   int // HTTP status code, 0 for non-HTTP attempts
+	Output   string
+	Err      error
+	Duration time.Duration
+}
+
+type attemptFunc func(ctx context.Context) (status int, out string, err error)
+
+// retry runs fn until it succeeds, policy.Retries extra attempts have been
+// made, the failure is not retryable, or ctx is done. Each call of fn gets its
+// own policy.Timeout. An HTTP status listed in policy.RetryOn counts as a
+// failure even though the request itself succeeded. onAttempt, if non-nil, is
+// called after every attempt.
+func retry(ctx context.Context, policy config.RetryPolicy, fn attemptFunc, onAttempt func(Attempt)) (string, []Attempt, error) {
+	var attempts []Attempt
+
+	for n := 1; ; n++ {
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if policy.Timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, policy.Timeout)
+		}
+
+		start := time.Now()
+		status, out, err := fn(attemptCtx)
+		cancel()
+
+		if err == nil && status != 0 && matchesStatus(policy.RetryOn, status) {
+			err = fmt.Errorf("retryable status: %s", out)
+		}
+
+		a := Attempt{Number: n, Status: status, Output: out, Err: err, Duration: time.Since(start)}
+		attempts = append(attempts, a)
+		if onAttempt != nil {
+			onAttempt(a)
+		}
+
+		if err == nil || n > policy.Retries || !retryable(policy.RetryOn, status, err) {
+			return out, attempts, err
+		}
+
+		select {
+		case <-time.After(backoffDelay(policy.Backoff, n)):
+		case <-ctx.Done():
+			return out, attempts, err
+		}
+	}
+}
+
+// retryable reports whether a failed attempt should be retried. A status
+// match was already turned into err by retry; "error" covers every failure.
+func retryable(retryOn []string, status int, err error) bool {
+	if len(retryOn) == 0 {
+		retryOn = []string{"error"}
+	}
+	for _, cond := range retryOn {
+		if cond == "error" && err != nil {
+			return true
+		}
+	}
+	return status != 0 && matchesStatus(retryOn, status)
+}
+
+func matchesStatus(retryOn []string, status int) bool {
+	code := strconv.Itoa(status)
+	for _, cond := range retryOn {
+		if cond == code {
+			return true
+		}
+		if len(cond) == 3 && cond[1:] == "xx" && cond[0] == code[0] {
+			return true
+		}
+	}
+	return false
+}
+
+// backoffDelay returns the wait before attempt n+1.
+func backoffDelay(b config.Backoff, n int) time.Duration {
+	delay := b.Delay
+	if delay == 0 {
+		delay = defaultBackoffDelay
+	}
+	maxDelay := b.MaxDelay
+	if maxDelay == 0 {
+		maxDelay = defaultBackoffMaxDelay
+	}
+
+	if b.Type == config.BackoffExponential {
+		for i := 1; i < n && delay < maxDelay; i++ {
+			delay *= 2
+		}
+		if delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+
+	if b.Jitter && delay > 1 {
+		half := delay / 2
+		delay = half + rand.N(delay-half)
+	}
+	return delay
+}
@@ -0,0 +1,183 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/you/lazyadmin/internal/clients"
+	"github.com/you/lazyadmin/internal/config"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		backoff config.Backoff
+		attempt int
+		want    time.Duration
+	}{
+		{name: "fixed default", backoff: config.Backoff{}, attempt: 3, want: time.Second},
+		{name: "fixed custom", backoff: config.Backoff{Delay: 200 * time.Millisecond}, attempt: 2, want: 200 * time.Millisecond},
+		{name: "exponential first", backoff: config.Backoff{Type: config.BackoffExponential, Delay: 100 * time.Millisecond}, attempt: 1, want: 100 * time.Millisecond},
+		{name: "exponential third", backoff: config.Backoff{Type: config.BackoffExponential, Delay: 100 * time.Millisecond}, attempt: 3, want: 400 * time.Millisecond},
+		{name: "exponential capped", backoff: config.Backoff{Type: config.BackoffExponential, Delay: time.Second, MaxDelay: 5 * time.Second}, attempt: 10, want: 5 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := backoffDelay(tt.backoff, tt.attempt); got != tt.want {
+				t.Errorf("backoffDelay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	jittered := config.Backoff{Delay: time.Second, Jitter: true}
+	for i := 0; i < 20; i++ {
+		if got := backoffDelay(jittered, 1); got < 500*time.Millisecond || got >= time.Second {
+			t.Fatalf("jittered backoffDelay() = %v, want in [500ms, 1s)", got)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		policy       config.RetryPolicy
+		results      []error
+		statuses     []int
+		wantAttempts int
+		wantErr      bool
+	}{
+		{
+			name:         "no retries by default",
+			results:      []error{errors.New("boom"), nil},
+			wantAttempts: 1,
+			wantErr:      true,
+		},
+		{
+			name:         "retries errors until success",
+			policy:       config.RetryPolicy{Retries: 3, Backoff: config.Backoff{Delay: time.Millisecond}},
+			results:      []error{errors.New("boom"), errors.New("boom"), nil},
+			wantAttempts: 3,
+		},
+		{
+			name:         "gives up after retries",
+			policy:       config.RetryPolicy{Retries: 2, Backoff: config.Backoff{Delay: time.Millisecond}},
+			results:      []error{errors.New("a"), errors.New("b"), errors.New("c"), nil},
+			wantAttempts: 3,
+			wantErr:      true,
+		},
+		{
+			name:         "retries listed status codes",
+			policy:       config.RetryPolicy{Retries: 3, RetryOn: []string{"5xx"}, Backoff: config.Backoff{Delay: time.Millisecond}},
+			statuses:     []int{503, 502, 200},
+			wantAttempts: 3,
+		},
+		{
+			name:         "unlisted status is not an error",
+			policy:       config.RetryPolicy{Retries: 3, RetryOn: []string{"503"}, Backoff: config.Backoff{Delay: time.Millisecond}},
+			statuses:     []int{500, 200},
+			wantAttempts: 1,
+		},
+		{
+			name:         "errors not retried when only statuses listed",
+			policy:       config.RetryPolicy{Retries: 3, RetryOn: []string{"503"}, Backoff: config.Backoff{Delay: time.Millisecond}},
+			results:      []error{errors.New("boom"), nil},
+			wantAttempts: 1,
+			wantErr:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			fn := func(ctx context.Context) (int, string, error) {
+				i := calls
+				calls++
+				var err error
+				if i < len(tt.results) {
+					err = tt.results[i]
+				}
+				status := 0
+				if i < len(tt.statuses) {
+					status = tt.statuses[i]
+				}
+				return status, "out", err
+			}
+
+			var logged []Attempt
+			_, attempts, err := retry(context.Background(), tt.policy, fn, func(a Attempt) { logged = append(logged, a) })
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("retry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(attempts) != tt.wantAttempts || len(logged) != tt.wantAttempts {
+				t.Errorf("attempts = %d (logged %d), want %d", len(attempts), len(logged), tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestRunner_StepRetryAndTimeout(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	runner := NewRunner(&config.Config{}, nil, map[string]*clients.HTTPClient{"backend": clients.NewHTTPClient(server.URL)}, nil)
+	task := config.Task{
+		ID:           "flaky",
+		AllowedRoles: []string{"admin"},
+		Steps: []config.TaskStep{{
+			ID: "call", Type: "http", Resource: "backend", Method: "GET", Path: "/",
+			Retry: config.RetryPolicy{Retries: 1, Timeout: 50 * time.Millisecond, Backoff: config.Backoff{Delay: time.Millisecond}},
+		}},
+	}
+
+	res := runner.Run(context.Background(), testPrincipal("admin"), task, nil)
+
+	sr := res.Steps["call"]
+	if !res.Success || !sr.OK {
+		t.Fatalf("Success = %v, step err = %v", res.Success, sr.Err)
+	}
+	if len(sr.Attempts) != 2 {
+		t.Fatalf("len(Attempts) = %d, want 2", len(sr.Attempts))
+	}
+	if sr.Attempts[0].Err == nil {
+		t.Error("first attempt error = nil, want timeout")
+	}
+	if sr.Attempts[1].Status != http.StatusOK {
+		t.Errorf("second attempt status = %d, want 200", sr.Attempts[1].Status)
+	}
+}
+
+func TestRunner_TaskTimeout(t *testing.T) {
+	runner := NewRunner(&config.Config{}, nil, nil, nil)
+	task := config.Task{
+		ID:           "slow",
+		AllowedRoles: []string{"admin"},
+		Timeout:      20 * time.Millisecond,
+		Steps:        []config.TaskStep{{ID: "wait", Type: "sleep", Seconds: 5}},
+	}
+
+	start := time.Now()
+	res := runner.Run(context.Background(), testPrincipal("admin"), task, nil)
+
+	if res.Success {
+		t.Error("Success = true, want false")
+	}
+	if !errors.Is(res.Steps["wait"].Err, context.DeadlineExceeded) {
+		t.Errorf("step err = %v, want deadline exceeded", res.Steps["wait"].Err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("task ran for %v, timeout not applied", time.Since(start))
+	}
+}
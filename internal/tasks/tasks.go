@@ -2,33 +2,76 @@ package tasks
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
+	"github.com/you/lazyadmin/internal/auth"
 	"github.com/you/lazyadmin/internal/clients"
 	"github.com/you/lazyadmin/internal/config"
+	"github.com/you/lazyadmin/internal/locks"
 	"github.com/you/lazyadmin/internal/logging"
+	"github.com/you/lazyadmin/internal/runs"
+)
+
+var (
+	ErrNotAllowed = errors.New("principal is not allowed to run this item")
+	ErrRiskDenied = errors.New("risk level is denied in this environment (risk_policy.deny)")
+	ErrStepUp     = errors.New("task requires a YubiKey step-up its caller did not give")
 )
 
 type StepResult struct {
-	Step   config.TaskStep
-	OK     bool
-	Output string
-	Err    error
+	Step     config.TaskStep
+	OK       bool
+	Output   string
+	Err      error
+	Nested   *TaskResult // set for "task" steps
+	Attempts []Attempt   // one entry per execution attempt
 }
 
 type TaskResult struct {
-	Task      config.Task
+	Task        config.Task
+	RunID       string
+	ParentRunID string
+	Inputs      map[string]string
+	Success     bool
+	Err         error // set when the task could not start (RBAC, inputs, lock held) or lost its lock
+	StepOrder   []string
+	Steps       map[string]StepResult
+	Rollback    *RollbackResult // set when the task failed and rollback ran
+	ResumedFrom string          // run this one resumed, if any
+}
+
+// RollbackResult records the compensating steps executed after a failed task.
+type RollbackResult struct {
 	Success   bool
+	Skipped   error // set when compensation did not run, e.g. the run lost its lock
 	StepOrder []string
 	Steps     map[string]StepResult
 }
 
+// DefaultTaskTimeout applies to tasks that do not set timeout.
+const DefaultTaskTimeout = 60 * time.Second
+
+// rollbackTimeout bounds compensation once the task's own context is done;
+// rollback must still run when the task failed because it timed out.
+const rollbackTimeout = 60 * time.Second
+
 type Runner struct {
 	cfg         *config.Config
 	logger      *logging.AuditLogger
 	httpClients map[string]*clients.HTTPClient
 	pgClients   map[string]*clients.PostgresClient
+	runs        *runs.Store
+	locks       *locks.Store
+	redact      func(string) string
+
+	mu      sync.Mutex
+	active  int    // calls in progress: runs, resumes, operations and plans
+	retired func() // called once active drops to zero, see Retire
 }
 
 func NewRunner(
@@ -45,31 +88,215 @@ func NewRunner(
 	}
 }
 
-func (r *Runner) Run(ctx context.Context, principalUserID, sshUser string, task config.Task) TaskResult {
+// SetRunStore enables checkpointing of top-level task runs so they can be
+// resumed after a failure or a lost session.
+func (r *Runner) SetRunStore(store *runs.Store) {
+	r.runs = store
+}
+
+// SetRedact makes the runner pass step and operation output and errors
+// through redact before they are returned, shown or audited, so resolved
+// secrets never leave it.
+func (r *Runner) SetRedact(redact func(string) string) {
+	r.redact = redact
+}
+
+// redactAttempt applies the runner's redaction to the result of an attempt.
+func (r *Runner) redactAttempt(status int, out string, err error) (int, string, error) {
+	if r.redact == nil {
+		return status, out, err
+	}
+	out = r.redact(out)
+	if err != nil {
+		if msg := r.redact(err.Error()); msg != err.Error() {
+			err = &redactedError{err: err, msg: msg}
+		}
+	}
+	return status, out, err
+}
+
+// redactedError is an error whose message had secrets removed. It still
+// unwraps to the original so retry classification keeps working.
+type redactedError struct {
+	err error
+	msg string
+}
+
+func (e *redactedError) Error() string { return e.msg }
+func (e *redactedError) Unwrap() error { return e.err }
+
+// invocation carries per-run state through step execution.
+type invocation struct {
+	principal   *auth.Principal
+	taskID      string
+	runID       string
+	parentRunID string
+	inputs      map[string]string
+	callStack   []string // task IDs currently executing, outermost first
+	preview     bool     // plan preview: read-only steps may run, postgres in a READ ONLY transaction
+}
+
+// Retire calls release once no call started on r is in progress, at once
+// if none is. It is meant for releasing the clients of a runner that is
+// being replaced, so nothing new should start on r afterwards.
+func (r *Runner) Retire(release func()) {
+	r.mu.Lock()
+	if r.active > 0 {
+		r.retired = release
+		release = nil
+	}
+	r.mu.Unlock()
+	if release != nil {
+		release()
+	}
+}
+
+// track counts a call in progress until the returned function is called.
+func (r *Runner) track() func() {
+	r.mu.Lock()
+	r.active++
+	r.mu.Unlock()
+	return func() {
+		r.mu.Lock()
+		r.active--
+		var release func()
+		if r.active == 0 {
+			release, r.retired = r.retired, nil
+		}
+		r.mu.Unlock()
+		if release != nil {
+			release()
+		}
+	}
+}
+
+// Run executes task on behalf of p with the supplied inputs.
+func (r *Runner) Run(ctx context.Context, p *auth.Principal, task config.Task, inputs map[string]string) TaskResult {
+	defer r.track()()
+	return r.run(ctx, p, task, inputs, "", nil, nil)
+}
+
+// run executes task. rp is non-nil when resuming a persisted run; steps it
+// restores are taken from their checkpoints instead of being executed.
+func (r *Runner) run(ctx context.Context, p *auth.Principal, task config.Task, inputs map[string]string, parentRunID string, callStack []string, rp *resumePoint) TaskResult {
 	res := TaskResult{
-		Task:      task,
-		Success:   true,
-		Steps:     make(map[string]StepResult),
-		StepOrder: make([]string, 0, len(task.Steps)),
+		Task:        task,
+		RunID:       newRunID(),
+		ParentRunID: parentRunID,
+		Success:     true,
+		Steps:       make(map[string]StepResult),
+		StepOrder:   make([]string, 0, len(task.Steps)),
+	}
+
+	inv := &invocation{
+		principal:   p,
+		taskID:      task.ID,
+		runID:       res.RunID,
+		parentRunID: parentRunID,
+		callStack:   append(append([]string{}, callStack...), task.ID),
+	}
+
+	if err := r.checkStart(p, task, callStack); err != nil {
+		res.Success = false
+		res.Err = err
+		_ = r.logTask(inv, false, err)
+		return res
 	}
 
+	resolved, err := resolveInputs(task, inputs)
+	if err != nil {
+		res.Success = false
+		res.Err = err
+		_ = r.logTask(inv, false, err)
+		return res
+	}
+	res.Inputs = resolved
+	inv.inputs = resolved
+
+	ctx, release, err := r.acquireLock(ctx, p, task.Lock, task.ID, res.RunID, resolved, "")
+	if err != nil {
+		res.Success = false
+		res.Err = err
+		_ = r.logTask(inv, false, err)
+		return res
+	}
+	defer release()
+
+	if rp != nil {
+		if rp.claimErr = rp.claim(); rp.claimErr != nil {
+			res.Success = false
+			res.Err = rp.claimErr
+			return res
+		}
+		res.ResumedFrom = rp.runID
+	}
+	cp := r.startCheckpoints(inv, rp)
+
+	timeout := task.Timeout
+	if timeout == 0 {
+		timeout = DefaultTaskTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
 	taskPolicy := task.OnError
 	if taskPolicy == "" {
 		taskPolicy = config.OnErrorFailFast
 	}
 
-	for _, step := range task.Steps {
-		res.StepOrder = append(res.StepOrder, step.ID)
+	// completed holds successfully finished steps in completion order; their
+	// rollback lists run in reverse if the task fails.
+	var completed []config.TaskStep
+
+	for i, step := range task.Steps {
+		if rp.restores(i, step) {
+			restored := rp.restore(step)
+			for _, sr := range restored {
+				res.StepOrder = append(res.StepOrder, sr.Step.ID)
+				res.Steps[sr.Step.ID] = sr
+				if sr.OK {
+					completed = append(completed, sr.Step)
+				}
+				cp.record(sr)
+			}
+			continue
+		}
+
+		stepPolicy := resolveStepPolicy(step.OnError, taskPolicy)
 
-		stepPolicy := step.OnError
-		if stepPolicy == "" || stepPolicy == config.StepOnErrorInherit {
-			stepPolicy = stepOnErrorFromTask(taskPolicy)
+		var sr StepResult
+		if step.Parallel != nil {
+			var children []StepResult
+			sr, children = r.runParallel(ctx, inv, taskPolicy, step)
+			res.StepOrder = append(res.StepOrder, step.ID)
+			res.Steps[step.ID] = sr
+			for _, child := range children {
+				res.StepOrder = append(res.StepOrder, child.Step.ID)
+				res.Steps[child.Step.ID] = child
+				if child.Err == nil {
+					completed = append(completed, child.Step)
+				} else if resolveStepPolicy(child.Step.OnError, taskPolicy) == config.StepOnErrorWarn {
+					res.Success = false
+				}
+			}
+		} else {
+			res.StepOrder = append(res.StepOrder, step.ID)
+			sr = r.runStep(ctx, inv, step)
+			res.Steps[step.ID] = sr
 		}
 
-		sr := r.runStep(ctx, step)
-		res.Steps[step.ID] = sr
+		if sr.Err == nil {
+			completed = append(completed, step)
+		}
 
-		_ = r.logStep(principalUserID, sshUser, task.ID, sr)
+		if step.Parallel != nil {
+			for _, child := range step.Parallel.Steps {
+				cp.record(res.Steps[child.ID])
+			}
+		}
+		cp.record(sr)
+
+		_ = r.logStep(inv, sr)
 
 		if sr.Err != nil {
 			if stepPolicy == config.StepOnErrorFail {
@@ -86,11 +313,175 @@ func (r *Runner) Run(ctx context.Context, principalUserID, sshUser string, task
 		}
 	}
 
-	_ = r.logTask(principalUserID, sshUser, task.ID, res.Success)
+	// A force-unlock cancels the run; say so rather than leaving only
+	// "context canceled" on the interrupted step.
+	lost := context.Cause(ctx)
+	if errors.Is(lost, locks.ErrLockLost) {
+		res.Success = false
+		res.Err = lost
+	} else {
+		lost = nil
+	}
+
+	if !res.Success {
+		res.Rollback = r.runRollback(ctx, inv, task, completed, lost)
+	}
+
+	cp.finish(res.Success)
+	_ = r.logTask(inv, res.Success, nil)
 
 	return res
 }
 
+// runRollback executes the rollback steps of every completed step in reverse
+// completion order, followed by the task-level rollback. Rollback steps use the
+// regular on_error semantics, inheriting from the task's rollback_on_error
+// (best_effort by default) rather than its on_error. If the run lost its lock,
+// someone else may already hold it, so nothing is compensated and the rollback
+// is reported as skipped with lost as the reason.
+func (r *Runner) runRollback(ctx context.Context, inv *invocation, task config.Task, completed []config.TaskStep, lost error) *RollbackResult {
+	var steps []config.TaskStep
+	var forStep []string
+	for i := len(completed) - 1; i >= 0; i-- {
+		for _, rb := range completed[i].Rollback {
+			steps = append(steps, rb)
+			forStep = append(forStep, completed[i].ID)
+		}
+	}
+	for _, rb := range task.Rollback {
+		steps = append(steps, rb)
+		forStep = append(forStep, "")
+	}
+	if len(steps) == 0 {
+		return nil
+	}
+	if lost != nil {
+		rr := &RollbackResult{Skipped: fmt.Errorf("rollback skipped: %w", lost)}
+		_ = r.logRollbackSkipped(inv, rr.Skipped)
+		return rr
+	}
+
+	policy := task.RollbackOnError
+	if policy == "" {
+		policy = config.OnErrorBestEffort
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rollbackTimeout)
+	defer cancel()
+
+	rr := &RollbackResult{
+		Success: true,
+		Steps:   make(map[string]StepResult),
+	}
+
+	for i, step := range steps {
+		sr := r.runStep(ctx, inv, step)
+		rr.StepOrder = append(rr.StepOrder, step.ID)
+		rr.Steps[step.ID] = sr
+
+		_ = r.logRollbackStep(inv, forStep[i], sr)
+
+		if sr.Err == nil {
+			continue
+		}
+		switch resolveStepPolicy(step.OnError, policy) {
+		case config.StepOnErrorFail:
+			rr.Success = false
+			return rr
+		case config.StepOnErrorWarn:
+			rr.Success = false
+		}
+	}
+
+	return rr
+}
+
+// checkStart verifies that p may run task and that running it would not
+// re-enter a task already on the call stack. Validate rejects call cycles at
+// load time; the stack check guards configs that skipped validation.
+func (r *Runner) checkStart(p *auth.Principal, task config.Task, callStack []string) error {
+	if p == nil || !p.CanRun(task.AllowedRoles, task.Permissions, task.Tags) {
+		return fmt.Errorf("task %s: %w", task.ID, ErrNotAllowed)
+	}
+	if r.cfg.RiskPolicy.Denies(task.RiskLevel) {
+		return fmt.Errorf("task %s: %w: %s in %s", task.ID, ErrRiskDenied, task.RiskLevel, r.cfg.Env)
+	}
+	for _, id := range callStack {
+		if id == task.ID {
+			return fmt.Errorf("task %s: recursive task call", task.ID)
+		}
+	}
+	return r.checkStepUp(p, task, callStack)
+}
+
+// checkStepUp refuses a nested call of a task that needs a YubiKey step-up
+// unless the top-level task needed one too. Callers step up only for the
+// task they start, so that is the only assertion the nested task can rely on.
+func (r *Runner) checkStepUp(p *auth.Principal, task config.Task, callStack []string) error {
+	if len(callStack) == 0 || !r.cfg.RequiresYubiKey(task) || p.InBreakGlass(time.Now()) {
+		return nil
+	}
+	if outer, ok := r.cfg.FindTask(callStack[0]); ok && r.cfg.RequiresYubiKey(*outer) {
+		return nil
+	}
+	return fmt.Errorf("task %s called from %s: %w", task.ID, callStack[0], ErrStepUp)
+}
+
+// runParallel executes the children of a parallel group with at most
+// MaxConcurrency steps in flight. Child results are returned in declaration
+// order. The group fails when any child whose effective policy is "fail"
+// fails; "warn" and "continue" children never fail the group itself, so the
+// group's own on_error decides what a failing group means for the task.
+func (r *Runner) runParallel(ctx context.Context, inv *invocation, taskPolicy config.OnErrorPolicy, group config.TaskStep) (StepResult, []StepResult) {
+	children := group.Parallel.Steps
+	results := make([]StepResult, len(children))
+
+	limit := group.Parallel.MaxConcurrency
+	if limit <= 0 || limit > len(children) {
+		limit = len(children)
+	}
+
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i, child := range children {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, child config.TaskStep) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			results[i] = r.runStep(ctx, inv, child)
+			_ = r.logStep(inv, results[i])
+		}(i, child)
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, cr := range results {
+		if cr.Err != nil && resolveStepPolicy(cr.Step.OnError, taskPolicy) == config.StepOnErrorFail {
+			failed++
+		}
+	}
+
+	sr := StepResult{
+		Step:   group,
+		OK:     failed == 0,
+		Output: fmt.Sprintf("%d/%d parallel steps succeeded", len(children)-failed, len(children)),
+	}
+	if failed > 0 {
+		sr.Err = fmt.Errorf("%d of %d parallel steps failed", failed, len(children))
+	}
+
+	return sr, results
+}
+
+func resolveStepPolicy(stepPolicy config.StepOnError, taskPolicy config.OnErrorPolicy) config.StepOnError {
+	if stepPolicy == "" || stepPolicy == config.StepOnErrorInherit {
+		return stepOnErrorFromTask(taskPolicy)
+	}
+	return stepPolicy
+}
+
 func stepOnErrorFromTask(taskPolicy config.OnErrorPolicy) config.StepOnError {
 	switch taskPolicy {
 	case config.OnErrorFailFast:
@@ -102,49 +493,117 @@ func stepOnErrorFromTask(taskPolicy config.OnErrorPolicy) config.StepOnError {
 	}
 }
 
-func (r *Runner) runStep(ctx context.Context, step config.TaskStep) StepResult {
+func (r *Runner) runStep(ctx context.Context, inv *invocation, step config.TaskStep) StepResult {
+	if step.Parallel != nil {
+		return StepResult{Step: step, OK: false, Err: fmt.Errorf("nested parallel group %q is not supported", step.ID)}
+	}
+
+	rendered, err := renderStep(step, inv.inputs)
+	if err != nil {
+		return StepResult{Step: step, OK: false, Err: err}
+	}
+
+	var nested *TaskResult
+	attempt := func(ctx context.Context) (int, string, error) {
+		var (
+			status int
+			out    string
+			err    error
+		)
+		status, out, nested, err = r.execStep(ctx, inv, rendered)
+		return r.redactAttempt(status, out, err)
+	}
+
+	var onAttempt func(Attempt)
+	if step.Retry.Retries > 0 {
+		onAttempt = func(a Attempt) { _ = r.logAttempt(inv, step.ID, a) }
+	}
+
+	out, attempts, err := retry(ctx, step.Retry, attempt, onAttempt)
+	return StepResult{Step: step, OK: err == nil, Output: out, Err: err, Nested: nested, Attempts: attempts}
+}
+
+// execStep performs a single attempt of an already rendered step. nested is
+// set for "task" steps.
+func (r *Runner) execStep(ctx context.Context, inv *invocation, step renderedStep) (status int, out string, nested *TaskResult, err error) {
 	switch step.Type {
 	case "http":
 		client, ok := r.httpClients[step.Resource]
 		if !ok {
-			return StepResult{Step: step, OK: false, Err: fmt.Errorf("no http resource %q", step.Resource)}
+			return 0, "", nil, fmt.Errorf("no http resource %q", step.Resource)
 		}
-		out, err := client.Request(ctx, step.Method, step.Path)
-		return StepResult{Step: step, OK: err == nil, Output: out, Err: err}
+		status, out, err = client.RequestStatus(ctx, step.Method, step.Path)
+		return status, out, nil, err
 
 	case "postgres":
 		client, ok := r.pgClients[step.Resource]
 		if !ok {
-			return StepResult{Step: step, OK: false, Err: fmt.Errorf("no postgres resource %q", step.Resource)}
+			return 0, "", nil, fmt.Errorf("no postgres resource %q", step.Resource)
 		}
-		out, err := client.RunScalarQuery(ctx, step.Query)
-		return StepResult{Step: step, OK: err == nil, Output: out, Err: err}
+		if inv.preview {
+			out, err = client.RunScalarQueryReadOnly(ctx, step.Query, step.QueryArgs...)
+		} else {
+			out, err = client.RunScalarQuery(ctx, step.Query, step.QueryArgs...)
+		}
+		return 0, out, nil, err
 
 	case "sleep":
 		d := time.Duration(step.Seconds) * time.Second
 		select {
 		case <-time.After(d):
-			return StepResult{Step: step, OK: true, Output: fmt.Sprintf("slept %s", d)}
+			return 0, fmt.Sprintf("slept %s", d), nil, nil
 		case <-ctx.Done():
-			return StepResult{Step: step, OK: false, Err: ctx.Err()}
+			return 0, "", nil, ctx.Err()
+		}
+
+	case "task":
+		task, ok := r.cfg.FindTask(step.Task)
+		if !ok {
+			return 0, "", nil, fmt.Errorf("unknown task %q", step.Task)
+		}
+		tr := r.run(ctx, inv.principal, *task, step.Inputs, inv.runID, inv.callStack, nil)
+		out = fmt.Sprintf("task %s: success=%v", task.ID, tr.Success)
+		if tr.Err != nil {
+			err = tr.Err
+		} else if !tr.Success {
+			err = fmt.Errorf("nested task %s failed", task.ID)
+		}
+		return 0, out, &tr, err
+
+	case "operation":
+		op, ok := r.cfg.FindOperation(step.Operation)
+		if !ok {
+			return 0, "", nil, fmt.Errorf("unknown operation %q", step.Operation)
 		}
+		out, err = r.runOperation(ctx, inv.principal, *op, nil, inv.runID)
+		return 0, out, nil, err
 
 	default:
-		return StepResult{Step: step, OK: false, Err: fmt.Errorf("unsupported step type %q", step.Type)}
+		return 0, "", nil, fmt.Errorf("unsupported step type %q", step.Type)
 	}
 }
 
-func (r *Runner) logStep(userID, sshUser, taskID string, sr StepResult) error {
+func newRunID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+func (r *Runner) logStep(inv *invocation, sr StepResult) error {
 	if r.logger == nil {
 		return nil
 	}
 
 	entry := logging.AuditEntry{
 		Time:        time.Now(),
-		UserID:      userID,
-		SSHUser:     sshUser,
-		OperationID: fmt.Sprintf("task:%s step:%s", taskID, sr.Step.ID),
+		UserID:      principalUserID(inv.principal),
+		SSHUser:     principalSSHUser(inv.principal),
+		OperationID: fmt.Sprintf("task:%s step:%s", inv.taskID, sr.Step.ID),
 		Success:     sr.Err == nil,
+		RunID:       inv.runID,
+		ParentRunID: inv.parentRunID,
 	}
 	if sr.Err != nil {
 		entry.Error = sr.Err.Error()
@@ -153,53 +612,166 @@ func (r *Runner) logStep(userID, sshUser, taskID string, sr StepResult) error {
 	return r.logger.Log(context.Background(), entry)
 }
 
-func (r *Runner) logTask(userID, sshUser, taskID string, success bool) error {
+func (r *Runner) logAttempt(inv *invocation, stepID string, a Attempt) error {
 	if r.logger == nil {
 		return nil
 	}
 
 	entry := logging.AuditEntry{
 		Time:        time.Now(),
-		UserID:      userID,
-		SSHUser:     sshUser,
-		OperationID: fmt.Sprintf("task:%s", taskID),
+		UserID:      principalUserID(inv.principal),
+		SSHUser:     principalSSHUser(inv.principal),
+		OperationID: fmt.Sprintf("task:%s step:%s attempt:%d", inv.taskID, stepID, a.Number),
+		Success:     a.Err == nil,
+		RunID:       inv.runID,
+		ParentRunID: inv.parentRunID,
+	}
+	if a.Err != nil {
+		entry.Error = a.Err.Error()
+	}
+
+	return r.logger.Log(context.Background(), entry)
+}
+
+// logRollbackSkipped records that a failed run was not compensated.
+func (r *Runner) logRollbackSkipped(inv *invocation, err error) error {
+	if r.logger == nil {
+		return nil
+	}
+
+	entry := logging.AuditEntry{
+		Time:        time.Now(),
+		UserID:      principalUserID(inv.principal),
+		SSHUser:     principalSSHUser(inv.principal),
+		OperationID: fmt.Sprintf("task:%s rollback skipped", inv.taskID),
+		Success:     false,
+		Error:       err.Error(),
+		RunID:       inv.runID,
+		ParentRunID: inv.parentRunID,
+	}
+	return r.logger.Log(context.Background(), entry)
+}
+
+func (r *Runner) logRollbackStep(inv *invocation, forStep string, sr StepResult) error {
+	if r.logger == nil {
+		return nil
+	}
+
+	opID := fmt.Sprintf("task:%s rollback:%s", inv.taskID, sr.Step.ID)
+	if forStep != "" {
+		opID = fmt.Sprintf("task:%s step:%s rollback:%s", inv.taskID, forStep, sr.Step.ID)
+	}
+
+	entry := logging.AuditEntry{
+		Time:        time.Now(),
+		UserID:      principalUserID(inv.principal),
+		SSHUser:     principalSSHUser(inv.principal),
+		OperationID: opID,
+		Success:     sr.Err == nil,
+		RunID:       inv.runID,
+		ParentRunID: inv.parentRunID,
+	}
+	if sr.Err != nil {
+		entry.Error = sr.Err.Error()
+	}
+
+	return r.logger.Log(context.Background(), entry)
+}
+
+func (r *Runner) logTask(inv *invocation, success bool, err error) error {
+	if r.logger == nil {
+		return nil
+	}
+
+	entry := logging.AuditEntry{
+		Time:        time.Now(),
+		UserID:      principalUserID(inv.principal),
+		SSHUser:     principalSSHUser(inv.principal),
+		OperationID: fmt.Sprintf("task:%s", inv.taskID),
 		Success:     success,
+		RunID:       inv.runID,
+		ParentRunID: inv.parentRunID,
+	}
+	if err != nil {
+		entry.Error = err.Error()
 	}
 
 	return r.logger.Log(context.Background(), entry)
 }
 
+func principalUserID(p *auth.Principal) string {
+	if p == nil || p.ConfigUser == nil {
+		return ""
+	}
+	return p.ConfigUser.ID
+}
+
+func principalSSHUser(p *auth.Principal) string {
+	if p == nil {
+		return ""
+	}
+	return p.SSHUser
+}
+
+type summaryStepView struct {
+	OK     bool
+	Output string
+	Error  string
+	Steps  map[string]summaryStepView // steps of a nested task
+}
+
+func stepViews(steps map[string]StepResult) map[string]summaryStepView {
+	views := make(map[string]summaryStepView)
+	for id, sr := range steps {
+		errText := ""
+		if sr.Err != nil {
+			errText = sr.Err.Error()
+		}
+		v := summaryStepView{
+			OK:     sr.OK,
+			Output: sr.Output,
+			Error:  errText,
+		}
+		if sr.Nested != nil {
+			v.Steps = stepViews(sr.Nested.Steps)
+		}
+		views[id] = v
+	}
+	return views
+}
+
 // RenderSummary executes the task's summary template with the task results.
 func RenderSummary(task config.Task, tr TaskResult) (string, error) {
 	if task.SummaryTemplate == "" {
 		return "", nil
 	}
 
-	type stepView struct {
-		OK     bool
-		Output string
-		Error  string
+	type rollbackView struct {
+		Attempted bool
+		Skipped   bool
+		Success   bool
+		Steps     map[string]summaryStepView
 	}
 
 	ctx := struct {
-		Task    config.Task
-		Success bool
-		Steps   map[string]stepView
+		Task     config.Task
+		Inputs   map[string]string
+		Success  bool
+		Steps    map[string]summaryStepView
+		Rollback rollbackView
 	}{
 		Task:    task,
+		Inputs:  tr.Inputs,
 		Success: tr.Success,
-		Steps:   make(map[string]stepView),
+		Steps:   stepViews(tr.Steps),
 	}
 
-	for id, sr := range tr.Steps {
-		errText := ""
-		if sr.Err != nil {
-			errText = sr.Err.Error()
-		}
-		ctx.Steps[id] = stepView{
-			OK:     sr.OK,
-			Output: sr.Output,
-			Error:  errText,
+	if tr.Rollback != nil {
+		ctx.Rollback = rollbackView{
+			Attempted: tr.Rollback.Skipped == nil,
+			Skipped:   tr.Rollback.Skipped != nil,
+			Success:   tr.Rollback.Success,
+			Steps:     stepViews(tr.Rollback.Steps),
 		}
 	}
 
@@ -0,0 +1,531 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/you/lazyadmin/internal/auth"
+	"github.com/you/lazyadmin/internal/clients"
+	"github.com/you/lazyadmin/internal/config"
+)
+
+func testPrincipal(roles ...string) *auth.Principal {
+	return &auth.Principal{
+		ConfigUser: &config.User{ID: "alice", SSHUsers: []string{"alice"}, Roles: roles},
+		SSHUser:    "alice",
+	}
+}
+
+func TestRunner_RunParallel(t *testing.T) {
+	var inFlight, maxInFlight int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			m := atomic.LoadInt32(&maxInFlight)
+			if n <= m || atomic.CompareAndSwapInt32(&maxInFlight, m, n) {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	httpClients := map[string]*clients.HTTPClient{
+		"backend": clients.NewHTTPClient(server.URL),
+	}
+	runner := NewRunner(&config.Config{}, nil, httpClients, nil)
+
+	check := func(id string) config.TaskStep {
+		return config.TaskStep{ID: id, Type: "http", Resource: "backend", Method: "GET", Path: "/health"}
+	}
+
+	task := config.Task{
+		ID:           "check_all",
+		AllowedRoles: []string{"admin"},
+		Steps: []config.TaskStep{
+			{
+				ID: "backends",
+				Parallel: &config.ParallelGroup{
+					MaxConcurrency: 2,
+					Steps:          []config.TaskStep{check("b1"), check("b2"), check("b3"), check("b4")},
+				},
+			},
+			{ID: "after", Type: "sleep", Seconds: 0},
+		},
+	}
+
+	res := runner.Run(context.Background(), testPrincipal("admin"), task, nil)
+
+	if !res.Success {
+		t.Fatalf("Success = false, want true (steps: %+v)", res.Steps)
+	}
+	wantOrder := []string{"backends", "b1", "b2", "b3", "b4", "after"}
+	if !reflect.DeepEqual(res.StepOrder, wantOrder) {
+		t.Errorf("StepOrder = %v, want %v", res.StepOrder, wantOrder)
+	}
+	if got := atomic.LoadInt32(&maxInFlight); got != 2 {
+		t.Errorf("max concurrent requests = %d, want 2", got)
+	}
+	if out := res.Steps["backends"].Output; out != "4/4 parallel steps succeeded" {
+		t.Errorf("group output = %q", out)
+	}
+}
+
+func TestRunner_RunParallelErrorPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		groupPolicy config.StepOnError
+		childPolicy config.StepOnError
+		wantSuccess bool
+		wantAfter   bool
+	}{
+		{
+			name:        "failing child fails group and stops task",
+			groupPolicy: config.StepOnErrorInherit,
+			childPolicy: config.StepOnErrorInherit,
+			wantSuccess: false,
+			wantAfter:   false,
+		},
+		{
+			name:        "group warn continues but marks task failed",
+			groupPolicy: config.StepOnErrorWarn,
+			childPolicy: config.StepOnErrorInherit,
+			wantSuccess: false,
+			wantAfter:   true,
+		},
+		{
+			name:        "group continue ignores failure",
+			groupPolicy: config.StepOnErrorContinue,
+			childPolicy: config.StepOnErrorInherit,
+			wantSuccess: true,
+			wantAfter:   true,
+		},
+		{
+			name:        "child continue does not fail group",
+			groupPolicy: config.StepOnErrorInherit,
+			childPolicy: config.StepOnErrorContinue,
+			wantSuccess: true,
+			wantAfter:   true,
+		},
+		{
+			name:        "child warn marks task failed without failing group",
+			groupPolicy: config.StepOnErrorInherit,
+			childPolicy: config.StepOnErrorWarn,
+			wantSuccess: false,
+			wantAfter:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := NewRunner(&config.Config{}, nil, nil, nil)
+			task := config.Task{
+				ID:           "t",
+				AllowedRoles: []string{"admin"},
+				OnError:      config.OnErrorFailFast,
+				Steps: []config.TaskStep{
+					{
+						ID:      "group",
+						OnError: tt.groupPolicy,
+						Parallel: &config.ParallelGroup{
+							Steps: []config.TaskStep{
+								{ID: "ok", Type: "sleep"},
+								{ID: "bad", Type: "http", Resource: "missing", OnError: tt.childPolicy},
+							},
+						},
+					},
+					{ID: "after", Type: "sleep"},
+				},
+			}
+
+			res := runner.Run(context.Background(), testPrincipal("admin"), task, nil)
+
+			if res.Success != tt.wantSuccess {
+				t.Errorf("Success = %v, want %v", res.Success, tt.wantSuccess)
+			}
+			if _, ran := res.Steps["after"]; ran != tt.wantAfter {
+				t.Errorf("after step ran = %v, want %v", ran, tt.wantAfter)
+			}
+			if res.Steps["bad"].Err == nil {
+				t.Error("bad step error = nil, want error")
+			}
+			if !res.Steps["ok"].OK {
+				t.Error("ok step OK = false, want true")
+			}
+		})
+	}
+}
+
+func TestRunner_RunComposition(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		Operations: []config.Operation{
+			{ID: "health", Type: "http", Target: "backend", Method: "GET", Path: "/health", AllowedRoles: []string{"admin"}},
+			{ID: "owner_only", Type: "http", Target: "backend", Method: "GET", Path: "/secret", AllowedRoles: []string{"owner"}},
+		},
+		Tasks: []config.Task{
+			{
+				ID:           "drain_node",
+				AllowedRoles: []string{"admin"},
+				Inputs:       []config.TaskInput{{Name: "node", Required: true}},
+				Steps: []config.TaskStep{
+					{ID: "drain", Type: "http", Resource: "backend", Method: "POST", Path: "/nodes/{{ .Inputs.node }}/drain"},
+				},
+			},
+			{
+				ID:           "owner_task",
+				AllowedRoles: []string{"owner"},
+				Steps:        []config.TaskStep{{ID: "noop", Type: "sleep"}},
+			},
+		},
+	}
+	runner := NewRunner(cfg, nil, map[string]*clients.HTTPClient{"backend": clients.NewHTTPClient(server.URL)}, nil)
+
+	tests := []struct {
+		name        string
+		steps       []config.TaskStep
+		wantSuccess bool
+		wantPaths   []string
+		check       func(t *testing.T, res TaskResult)
+	}{
+		{
+			name: "nested task receives rendered inputs",
+			steps: []config.TaskStep{
+				{ID: "call", Type: "task", Task: "drain_node", Inputs: map[string]string{"node": "{{ .Inputs.target }}-1"}},
+			},
+			wantSuccess: true,
+			wantPaths:   []string{"/nodes/web-1/drain"},
+			check: func(t *testing.T, res TaskResult) {
+				nested := res.Steps["call"].Nested
+				if nested == nil {
+					t.Fatal("Nested = nil, want nested result")
+				}
+				if nested.ParentRunID != res.RunID {
+					t.Errorf("nested ParentRunID = %q, want %q", nested.ParentRunID, res.RunID)
+				}
+				if !nested.Steps["drain"].OK {
+					t.Errorf("nested drain step failed: %v", nested.Steps["drain"].Err)
+				}
+			},
+		},
+		{
+			name:        "operation step",
+			steps:       []config.TaskStep{{ID: "check", Type: "operation", Operation: "health"}},
+			wantSuccess: true,
+			wantPaths:   []string{"/health"},
+		},
+		{
+			name:        "operation step re-checks RBAC",
+			steps:       []config.TaskStep{{ID: "check", Type: "operation", Operation: "owner_only"}},
+			wantSuccess: false,
+		},
+		{
+			name:        "task step re-checks RBAC",
+			steps:       []config.TaskStep{{ID: "call", Type: "task", Task: "owner_task"}},
+			wantSuccess: false,
+			check: func(t *testing.T, res TaskResult) {
+				if !errors.Is(res.Steps["call"].Err, ErrNotAllowed) {
+					t.Errorf("Err = %v, want ErrNotAllowed", res.Steps["call"].Err)
+				}
+			},
+		},
+		{
+			name:        "missing required input",
+			steps:       []config.TaskStep{{ID: "call", Type: "task", Task: "drain_node"}},
+			wantSuccess: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths = nil
+			parent := config.Task{
+				ID:           "maintenance",
+				AllowedRoles: []string{"admin"},
+				Inputs:       []config.TaskInput{{Name: "target", Default: "web"}},
+				Steps:        tt.steps,
+			}
+
+			res := runner.Run(context.Background(), testPrincipal("admin"), parent, nil)
+
+			if res.Success != tt.wantSuccess {
+				t.Errorf("Success = %v, want %v (steps: %+v)", res.Success, tt.wantSuccess, res.Steps)
+			}
+			if tt.wantPaths != nil && !reflect.DeepEqual(paths, tt.wantPaths) {
+				t.Errorf("requested paths = %v, want %v", paths, tt.wantPaths)
+			}
+			if tt.check != nil {
+				tt.check(t, res)
+			}
+		})
+	}
+}
+
+func TestRunner_RunNestedStepUp(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	call := func(id string) []config.TaskStep {
+		return []config.TaskStep{{ID: "call", Type: "task", Task: id}}
+	}
+	cfg := &config.Config{
+		RiskPolicy: config.RiskPolicy{RequireYubiKey: []config.RiskLevel{config.RiskHigh}},
+		Tasks: []config.Task{
+			{ID: "restore", AllowedRoles: []string{"admin"}, RequireYubiKey: true,
+				Steps: []config.TaskStep{{ID: "restore", Type: "http", Resource: "backend", Method: "POST", Path: "/restore"}}},
+			{ID: "purge", AllowedRoles: []string{"admin"}, RiskLevel: config.RiskHigh,
+				Steps: []config.TaskStep{{ID: "purge", Type: "http", Resource: "backend", Method: "POST", Path: "/purge"}}},
+			{ID: "wrap_restore", AllowedRoles: []string{"admin"}, Steps: call("restore")},
+			{ID: "wrap_purge", AllowedRoles: []string{"admin"}, Steps: call("purge")},
+			{ID: "guarded", AllowedRoles: []string{"admin"}, RequireYubiKey: true, Steps: call("restore")},
+		},
+	}
+	runner := NewRunner(cfg, nil, map[string]*clients.HTTPClient{"backend": clients.NewHTTPClient(server.URL)}, nil)
+
+	tests := []struct {
+		task      string
+		wantErr   error
+		wantPaths []string
+	}{
+		{task: "wrap_restore", wantErr: ErrStepUp},
+		{task: "wrap_purge", wantErr: ErrStepUp},
+		{task: "guarded", wantPaths: []string{"/restore"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.task, func(t *testing.T) {
+			paths = nil
+			task, _ := cfg.FindTask(tt.task)
+			res := runner.Run(context.Background(), testPrincipal("admin"), *task, nil)
+			if err := res.Steps["call"].Err; !errors.Is(err, tt.wantErr) || (tt.wantErr == nil) != res.Success {
+				t.Errorf("Run() success = %v, call error = %v; want %v", res.Success, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(paths, tt.wantPaths) {
+				t.Errorf("requested paths = %v, want %v", paths, tt.wantPaths)
+			}
+		})
+	}
+}
+
+func TestRunner_RunNotAllowed(t *testing.T) {
+	runner := NewRunner(&config.Config{}, nil, nil, nil)
+	task := config.Task{ID: "t", AllowedRoles: []string{"owner"}, Steps: []config.TaskStep{{ID: "s", Type: "sleep"}}}
+
+	res := runner.Run(context.Background(), testPrincipal("read_only"), task, nil)
+
+	if res.Success || !errors.Is(res.Err, ErrNotAllowed) {
+		t.Errorf("Run() = success %v err %v, want ErrNotAllowed", res.Success, res.Err)
+	}
+	if len(res.Steps) != 0 {
+		t.Errorf("steps ran: %v", res.StepOrder)
+	}
+}
+
+func TestRunner_RunRiskDenied(t *testing.T) {
+	cfg := &config.Config{Env: "prod", RiskPolicy: config.RiskPolicy{Deny: []config.RiskLevel{config.RiskHigh}}}
+	runner := NewRunner(cfg, nil, nil, nil)
+	task := config.Task{ID: "t", AllowedRoles: []string{"owner"}, RiskLevel: config.RiskHigh, Steps: []config.TaskStep{{ID: "s", Type: "sleep"}}}
+
+	res := runner.Run(context.Background(), testPrincipal("owner"), task, nil)
+	if res.Success || !errors.Is(res.Err, ErrRiskDenied) || len(res.Steps) != 0 {
+		t.Errorf("Run() = success %v err %v, steps %v; want ErrRiskDenied", res.Success, res.Err, res.StepOrder)
+	}
+
+	task.RiskLevel = config.RiskMedium
+	if res := runner.Run(context.Background(), testPrincipal("owner"), task, nil); !res.Success {
+		t.Errorf("Run() of medium-risk task error = %v", res.Err)
+	}
+}
+
+func TestRunner_RunRedact(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close() // every request fails, quoting the URL
+
+	httpClients := map[string]*clients.HTTPClient{
+		"backend": clients.NewHTTPClient(server.URL + "/key-hunter22"),
+	}
+	runner := NewRunner(&config.Config{}, nil, httpClients, nil)
+	runner.SetRedact(func(s string) string { return strings.ReplaceAll(s, "hunter22", "[REDACTED]") })
+	task := config.Task{ID: "t", AllowedRoles: []string{"owner"}, Steps: []config.TaskStep{
+		{ID: "call", Type: "http", Resource: "backend", Method: "GET", Path: "/"},
+	}}
+
+	res := runner.Run(context.Background(), testPrincipal("owner"), task, nil)
+	err := res.Steps["call"].Err
+	if res.Success || err == nil {
+		t.Fatalf("Run() succeeded against a closed server")
+	}
+	if strings.Contains(err.Error(), "hunter22") || !strings.Contains(err.Error(), "/key-[REDACTED]") {
+		t.Errorf("step error = %q, want the secret redacted", err)
+	}
+	var uerr *url.Error
+	if !errors.As(err, &uerr) {
+		t.Errorf("redacted step error %T does not unwrap to *url.Error", err)
+	}
+}
+
+func TestRunner_RunRollback(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	runner := NewRunner(&config.Config{}, nil, map[string]*clients.HTTPClient{"backend": clients.NewHTTPClient(server.URL)}, nil)
+
+	call := func(id, path string, rollback ...config.TaskStep) config.TaskStep {
+		return config.TaskStep{ID: id, Type: "http", Resource: "backend", Method: "POST", Path: path, Rollback: rollback}
+	}
+	broken := config.TaskStep{ID: "broken", Type: "http", Resource: "missing"}
+
+	tests := []struct {
+		name             string
+		task             config.Task
+		wantPaths        []string
+		wantRollback     bool
+		wantRollbackOK   bool
+		wantRollbackRuns []string
+	}{
+		{
+			name: "successful task does not roll back",
+			task: config.Task{
+				Steps: []config.TaskStep{call("a", "/a", call("undo_a", "/undo/a"))},
+			},
+			wantPaths: []string{"/a"},
+		},
+		{
+			name: "completed steps roll back in reverse order then task rollback",
+			task: config.Task{
+				Steps: []config.TaskStep{
+					call("a", "/a", call("undo_a", "/undo/a")),
+					call("b", "/b", call("undo_b", "/undo/b")),
+					broken,
+					call("c", "/c", call("undo_c", "/undo/c")),
+				},
+				Rollback: []config.TaskStep{call("notify", "/notify")},
+			},
+			wantPaths:        []string{"/a", "/b", "/undo/b", "/undo/a", "/notify"},
+			wantRollback:     true,
+			wantRollbackOK:   true,
+			wantRollbackRuns: []string{"undo_b", "undo_a", "notify"},
+		},
+		{
+			name: "best_effort rollback continues past failures",
+			task: config.Task{
+				Steps: []config.TaskStep{
+					call("a", "/a", call("undo_a", "/undo/a")),
+					call("b", "/b", config.TaskStep{ID: "undo_b", Type: "http", Resource: "missing", OnError: config.StepOnErrorWarn}),
+					broken,
+				},
+			},
+			wantPaths:        []string{"/a", "/b", "/undo/a"},
+			wantRollback:     true,
+			wantRollbackOK:   false,
+			wantRollbackRuns: []string{"undo_b", "undo_a"},
+		},
+		{
+			name: "fail_fast rollback stops at first failure",
+			task: config.Task{
+				RollbackOnError: config.OnErrorFailFast,
+				Steps: []config.TaskStep{
+					call("a", "/a", call("undo_a", "/undo/a")),
+					call("b", "/b", config.TaskStep{ID: "undo_b", Type: "http", Resource: "missing"}),
+					broken,
+				},
+			},
+			wantPaths:        []string{"/a", "/b"},
+			wantRollback:     true,
+			wantRollbackOK:   false,
+			wantRollbackRuns: []string{"undo_b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths = nil
+			tt.task.ID = "migrate"
+			tt.task.AllowedRoles = []string{"admin"}
+
+			res := runner.Run(context.Background(), testPrincipal("admin"), tt.task, nil)
+
+			if !reflect.DeepEqual(paths, tt.wantPaths) {
+				t.Errorf("requested paths = %v, want %v", paths, tt.wantPaths)
+			}
+			if (res.Rollback != nil) != tt.wantRollback {
+				t.Fatalf("Rollback = %+v, want rollback %v", res.Rollback, tt.wantRollback)
+			}
+			if res.Rollback == nil {
+				return
+			}
+			if res.Rollback.Success != tt.wantRollbackOK {
+				t.Errorf("Rollback.Success = %v, want %v", res.Rollback.Success, tt.wantRollbackOK)
+			}
+			if !reflect.DeepEqual(res.Rollback.StepOrder, tt.wantRollbackRuns) {
+				t.Errorf("Rollback.StepOrder = %v, want %v", res.Rollback.StepOrder, tt.wantRollbackRuns)
+			}
+		})
+	}
+}
+
+func TestRunner_Retire(t *testing.T) {
+	started, finish := make(chan struct{}), make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-finish
+	}))
+	defer server.Close()
+
+	task := config.Task{
+		ID:           "slow",
+		AllowedRoles: []string{"admin"},
+		Steps:        []config.TaskStep{{ID: "call", Type: "http", Resource: "backend", Method: "POST", Path: "/"}},
+	}
+	runner := NewRunner(&config.Config{}, nil, map[string]*clients.HTTPClient{
+		"backend": clients.NewHTTPClient(server.URL),
+	}, nil)
+
+	done := make(chan TaskResult)
+	go func() { done <- runner.Run(context.Background(), testPrincipal("admin"), task, nil) }()
+	<-started
+
+	var released atomic.Bool
+	runner.Retire(func() { released.Store(true) })
+	if released.Load() {
+		t.Fatal("Retire() released the runner while a run was in progress")
+	}
+	close(finish)
+	if res := <-done; !res.Success {
+		t.Errorf("run failed: %+v", res.Steps)
+	}
+	if !released.Load() {
+		t.Error("Retire() did not release the runner after the run finished")
+	}
+
+	// An idle runner is released at once.
+	idle := NewRunner(&config.Config{}, nil, nil, nil)
+	var now bool
+	idle.Retire(func() { now = true })
+	if !now {
+		t.Error("Retire() of an idle runner did not release it")
+	}
+}
@@ -2,7 +2,13 @@ package tasks
 
 import (
 	"bytes"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
 	"text/template"
+
+	"github.com/you/lazyadmin/internal/config"
 )
 
 func executeTemplate(tmpl string, data any) (string, error) {
@@ -18,3 +24,161 @@ func executeTemplate(tmpl string, data any) (string, error) {
 
 	return buf.String(), nil
 }
+
+// resolveInputs merges supplied inputs with the task's declared defaults.
+// Unknown inputs and missing required inputs are errors.
+func resolveInputs(task config.Task, supplied map[string]string) (map[string]string, error) {
+	declared := make(map[string]bool, len(task.Inputs))
+	resolved := make(map[string]string, len(task.Inputs))
+
+	for _, in := range task.Inputs {
+		declared[in.Name] = true
+		if v, ok := supplied[in.Name]; ok && v != "" {
+			resolved[in.Name] = v
+			continue
+		}
+		if in.Required && in.Default == "" {
+			return nil, fmt.Errorf("task %s: missing required input %q", task.ID, in.Name)
+		}
+		resolved[in.Name] = in.Default
+	}
+
+	for name := range supplied {
+		if !declared[name] {
+			return nil, fmt.Errorf("task %s: unknown input %q", task.ID, name)
+		}
+	}
+
+	return resolved, nil
+}
+
+// renderInputs renders a single templated field against task inputs. Missing
+// inputs are errors rather than "<no value>".
+func renderInputs(name, tmpl string, inputs map[string]string) (string, error) {
+	if !strings.Contains(tmpl, "{{") {
+		return tmpl, nil
+	}
+
+	data := struct {
+		Inputs map[string]string
+	}{Inputs: inputs}
+
+	t, err := template.New(name).Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// renderedStep is a step with its templated fields rendered. Inputs in a
+// postgres query are not pasted into the SQL: each becomes a $n placeholder
+// and its value is carried in QueryArgs for the driver to bind.
+type renderedStep struct {
+	config.TaskStep
+	QueryArgs []any
+}
+
+// inputMark brackets an input name in a first rendering pass so the value can
+// be escaped or bound according to where the template placed it.
+const inputMark = "\x00"
+
+var inputRef = regexp.MustCompile(inputMark + `([^` + inputMark + `]*)` + inputMark)
+
+// renderMarked renders tmpl with every input replaced by its marked name.
+func renderMarked(name, tmpl string, inputs map[string]string) (string, error) {
+	marked := make(map[string]string, len(inputs))
+	for k := range inputs {
+		marked[k] = inputMark + k + inputMark
+	}
+	return renderInputs(name, tmpl, marked)
+}
+
+// renderPath renders an http path with input values escaped, so a "/", "?"
+// or "&" in a value cannot add path segments or query parameters.
+func renderPath(tmpl string, inputs map[string]string) (string, error) {
+	out, err := renderMarked("path", tmpl, inputs)
+	if err != nil {
+		return "", err
+	}
+	path, query, hasQuery := strings.Cut(out, "?")
+	path = inputRef.ReplaceAllStringFunc(path, func(m string) string {
+		return url.PathEscape(inputs[m[1:len(m)-1]])
+	})
+	if !hasQuery {
+		return path, nil
+	}
+	query = inputRef.ReplaceAllStringFunc(query, func(m string) string {
+		return url.QueryEscape(inputs[m[1:len(m)-1]])
+	})
+	return path + "?" + query, nil
+}
+
+// renderQuery renders a postgres query with each distinct input replaced by a
+// $n placeholder, returning the values to bind in placeholder order.
+func renderQuery(tmpl string, inputs map[string]string) (string, []any, error) {
+	out, err := renderMarked("query", tmpl, inputs)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var args []any
+	index := make(map[string]int)
+	var quoted []string
+	for _, loc := range inputRef.FindAllStringIndex(out, -1) {
+		if loc[0] > 0 && strings.ContainsRune(`'"`, rune(out[loc[0]-1])) {
+			quoted = append(quoted, out[loc[0]+1:loc[1]-1])
+		}
+	}
+	if len(quoted) > 0 {
+		return "", nil, fmt.Errorf("inputs %s are quoted; inputs are bound as parameters and must not be quoted", strings.Join(quoted, ", "))
+	}
+	out = inputRef.ReplaceAllStringFunc(out, func(m string) string {
+		name := m[1 : len(m)-1]
+		n, ok := index[name]
+		if !ok {
+			args = append(args, inputs[name])
+			n = len(args)
+			index[name] = n
+		}
+		return fmt.Sprintf("$%d", n)
+	})
+	return out, args, nil
+}
+
+// renderStep returns a copy of step with its templated fields (path, query,
+// command and nested task inputs) rendered against inputs.
+func renderStep(step config.TaskStep, inputs map[string]string) (renderedStep, error) {
+	render := func(field, tmpl string) (string, error) {
+		out, err := renderInputs(field, tmpl, inputs)
+		if err != nil {
+			return "", fmt.Errorf("step %s %s: %w", step.ID, field, err)
+		}
+		return out, nil
+	}
+
+	var err error
+	out := renderedStep{TaskStep: step}
+	if out.Path, err = renderPath(step.Path, inputs); err != nil {
+		return out, fmt.Errorf("step %s path: %w", step.ID, err)
+	}
+	if out.Query, out.QueryArgs, err = renderQuery(step.Query, inputs); err != nil {
+		return out, fmt.Errorf("step %s query: %w", step.ID, err)
+	}
+	if out.Command, err = render("command", step.Command); err != nil {
+		return out, err
+	}
+	if len(step.Inputs) > 0 {
+		out.Inputs = make(map[string]string, len(step.Inputs))
+		for k, v := range step.Inputs {
+			if out.Inputs[k], err = render("inputs."+k, v); err != nil {
+				return out, err
+			}
+		}
+	}
+
+	return out, nil
+}
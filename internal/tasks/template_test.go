@@ -198,3 +198,61 @@ type testError struct {
 func (e *testError) Error() string {
 	return e.msg
 }
+
+func TestRenderSummary_Rollback(t *testing.T) {
+	task := config.Task{
+		ID:              "migrate",
+		SummaryTemplate: "{{if .Rollback.Attempted}}rolled back: {{.Rollback.Success}} ({{.Rollback.Steps.undo.Output}}){{else}}no rollback{{end}}",
+	}
+
+	got, err := RenderSummary(task, TaskResult{Steps: map[string]StepResult{}})
+	if err != nil || got != "no rollback" {
+		t.Errorf("RenderSummary() = %q, %v; want %q", got, err, "no rollback")
+	}
+
+	tr := TaskResult{
+		Steps: map[string]StepResult{},
+		Rollback: &RollbackResult{
+			Success: true,
+			Steps:   map[string]StepResult{"undo": {OK: true, Output: "HTTP 200 OK"}},
+		},
+	}
+	got, err = RenderSummary(task, tr)
+	if err != nil || got != "rolled back: true (HTTP 200 OK)" {
+		t.Errorf("RenderSummary() = %q, %v; want %q", got, err, "rolled back: true (HTTP 200 OK)")
+	}
+}
+
+func TestRenderStep_InputsCannotChangeStatementOrPath(t *testing.T) {
+	inputs := map[string]string{
+		"name":    "x'; DROP TABLE users; --",
+		"service": "../admin/users",
+		"filter":  "a&role=owner",
+	}
+
+	step := config.TaskStep{
+		ID:    "lookup",
+		Path:  "/svc/{{ .Inputs.service }}/status?q={{ .Inputs.filter }}",
+		Query: "SELECT count(*) FROM users WHERE name = {{ .Inputs.name }} OR alias = {{ .Inputs.name }} AND svc = {{ .Inputs.service }}",
+	}
+	got, err := renderStep(step, inputs)
+	if err != nil {
+		t.Fatalf("renderStep() error = %v", err)
+	}
+
+	if want := "/svc/..%2Fadmin%2Fusers/status?q=a%26role%3Downer"; got.Path != want {
+		t.Errorf("Path = %q, want %q", got.Path, want)
+	}
+	if want := "SELECT count(*) FROM users WHERE name = $1 OR alias = $1 AND svc = $2"; got.Query != want {
+		t.Errorf("Query = %q, want %q", got.Query, want)
+	}
+	if len(got.QueryArgs) != 2 || got.QueryArgs[0] != inputs["name"] || got.QueryArgs[1] != inputs["service"] {
+		t.Errorf("QueryArgs = %q, want the name and service values", got.QueryArgs)
+	}
+
+	// A quoted input would be bound as the literal text "$1", so it is refused.
+	step.Query = "SELECT 1 WHERE name = '{{ .Inputs.name }}'"
+	if _, err := renderStep(step, inputs); err == nil {
+		t.Error("renderStep() with a quoted input in the query: want an error")
+	}
+}
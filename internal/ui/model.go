@@ -2,18 +2,25 @@ package ui
 
 import (
 	"context"
-	"crypto/rand"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/charmbracelet/bubbles/list"
 	"github.com/charmbracelet/bubbles/table"
+	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
 
 	"github.com/you/lazyadmin/internal/auth"
 	"github.com/you/lazyadmin/internal/clients"
 	"github.com/you/lazyadmin/internal/config"
+	"github.com/you/lazyadmin/internal/cron"
+	"github.com/you/lazyadmin/internal/locks"
 	"github.com/you/lazyadmin/internal/logging"
+	"github.com/you/lazyadmin/internal/runs"
+	"github.com/you/lazyadmin/internal/scheduler"
 	"github.com/you/lazyadmin/internal/tasks"
 	"github.com/you/lazyadmin/internal/users"
 )
@@ -25,15 +32,42 @@ const (
 	modeLogs
 	modeHelp
 	modeUsers
+	modePlan
+	modeRuns
+	modeLocks
+	modeSchedules
+	modeEnvironments
 )
 
-type filterType int
+// taggedItem is an operation or task list item that can be grouped by tag.
+type taggedItem interface {
+	list.Item
+	tags() []string
+}
 
-const (
-	filterAll filterType = iota
-	filterHTTP
-	filterPostgres
-)
+// untaggedGroup collects items without tags once any item has one.
+const untaggedGroup = "untagged"
+
+// groupItem is the header of a tag group in the operations or tasks list.
+type groupItem struct {
+	tag       string
+	count     int
+	collapsed bool
+}
+
+func (i groupItem) Title() string {
+	if i.collapsed {
+		return fmt.Sprintf("▸ %s (%d)", i.tag, i.count)
+	}
+	return fmt.Sprintf("▾ %s (%d)", i.tag, i.count)
+}
+func (i groupItem) Description() string {
+	if i.collapsed {
+		return "tag – enter to expand"
+	}
+	return "tag – enter to collapse"
+}
+func (i groupItem) FilterValue() string { return i.tag }
 
 type operationItem struct {
 	op config.Operation
@@ -42,6 +76,7 @@ type operationItem struct {
 func (i operationItem) Title() string       { return i.op.Label }
 func (i operationItem) Description() string { return fmt.Sprintf("%s (%s)", i.op.ID, i.op.Type) }
 func (i operationItem) FilterValue() string { return i.op.Label }
+func (i operationItem) tags() []string      { return i.op.Tags }
 
 type operationResultMsg struct {
 	op     config.Operation
@@ -55,6 +90,25 @@ type taskResultMsg struct {
 	summary string
 }
 
+type planResultMsg struct {
+	task config.Task
+	plan *tasks.Plan
+	err  error
+}
+
+type runResumeMsg struct {
+	task    config.Task
+	result  *tasks.TaskResult
+	summary string
+	err     error
+}
+
+type forceUnlockMsg struct {
+	name   string
+	holder *locks.Holder
+	err    error
+}
+
 type taskItem struct {
 	task config.Task
 }
@@ -64,9 +118,12 @@ func (i taskItem) Description() string {
 	return fmt.Sprintf("task:%s (risk:%s)", i.task.ID, i.task.RiskLevel)
 }
 func (i taskItem) FilterValue() string { return i.task.Label }
+func (i taskItem) tags() []string      { return i.task.Tags }
 
 type userItem struct {
-	user *users.User
+	user        *users.User
+	grants      []*users.Grant // active elevation grants
+	lockedUntil time.Time      // zero unless locked out after failed sign-ins
 }
 
 func (i userItem) Title() string {
@@ -74,15 +131,26 @@ func (i userItem) Title() string {
 }
 
 func (i userItem) Description() string {
-	return fmt.Sprintf("SSH: %v | Roles: %v", i.user.SSHUsers, i.user.Roles)
+	s := fmt.Sprintf("SSH: %v | Roles: %v", i.user.SSHUsers, i.user.Roles)
+	if i.user.Disabled {
+		s = "DISABLED | " + s
+	}
+	if !i.lockedUntil.IsZero() {
+		s = fmt.Sprintf("LOCKED until %s | %s", i.lockedUntil.Local().Format("15:04"), s)
+	}
+	for _, g := range i.grants {
+		s += fmt.Sprintf(" | Elevated: %s until %s", g.Role, g.ExpiresAt.Local().Format("15:04"))
+	}
+	return s
 }
 
 func (i userItem) FilterValue() string {
 	return i.user.ID
 }
 
-type userRegistrationMsg struct {
-	userID string
+// userActionMsg reports the outcome of a Users view action.
+type userActionMsg struct {
+	status string // shown on success
 	err    error
 }
 
@@ -91,6 +159,112 @@ type userListMsg struct {
 	err   error
 }
 
+// sessionCheckInterval is how often the TUI checks the session timeouts.
+const sessionCheckInterval = 5 * time.Second
+
+type sessionTickMsg time.Time
+
+type sessionUnlockMsg struct {
+	err error
+}
+
+// userForm creates or edits a store user in the Users view.
+type userForm struct {
+	editing string // ID of the user being edited; empty when creating
+	inputs  []textinput.Model
+	focus   int
+	err     string
+}
+
+// inputForm collects a task's inputs before it is planned or run.
+type inputForm struct {
+	task   config.Task
+	plan   bool // plan the task on submit instead of running it
+	inputs []textinput.Model
+	focus  int
+	err    string
+}
+
+// Fields of userForm.inputs.
+const (
+	fieldUserID = iota
+	fieldSSHUsers
+	fieldRoles
+)
+
+// confirmation is a destructive Users view action waiting for y/n.
+type confirmation struct {
+	prompt string
+	run    tea.Cmd
+}
+
+// Environment is everything the TUI swaps when switching environments.
+type Environment struct {
+	Config      *config.Config
+	Principal   *auth.Principal
+	Logger      *logging.AuditLogger
+	HTTPClients map[string]*clients.HTTPClient
+	PGClients   map[string]*clients.PostgresClient
+	Runner      *tasks.Runner
+	Warnings    []string // e.g. resources whose clients could not be created
+}
+
+// EnvironmentLoader prepares environment name for the switcher.
+type EnvironmentLoader func(name string) (*Environment, error)
+
+type envItem struct {
+	name       string
+	production bool
+	current    bool
+}
+
+func (i envItem) Title() string {
+	if i.current {
+		return i.name + " (current)"
+	}
+	return i.name
+}
+func (i envItem) Description() string {
+	if i.production {
+		return "production"
+	}
+	return ""
+}
+func (i envItem) FilterValue() string { return i.name }
+
+type envSwitchMsg struct {
+	name string
+	env  *Environment
+	err  error
+}
+
+// Reloader reloads the configuration for the current environment cur.
+type Reloader func(cur *Environment) (*Environment, error)
+
+// Reload triggers, shown in the status line and the config.reload audit
+// entry.
+const (
+	ReloadKey    = "key"
+	ReloadSIGHUP = "SIGHUP"
+	ReloadWatch  = "file change"
+)
+
+// reloadTriggerMsg asks for a reload from outside the TUI.
+type reloadTriggerMsg string
+
+type reloadMsg struct {
+	trigger string
+	env     *Environment
+	err     error
+}
+
+// prodBanner marks the main view while in a production environment.
+var prodBanner = lipgloss.NewStyle().
+	Bold(true).
+	Foreground(lipgloss.Color("15")).
+	Background(lipgloss.Color("1")).
+	Padding(0, 1)
+
 type Model struct {
 	cfg         *config.Config
 	principal   *auth.Principal
@@ -99,10 +273,28 @@ type Model struct {
 	httpClients map[string]*clients.HTTPClient
 	pgClients   map[string]*clients.PostgresClient
 	taskRunner  *tasks.Runner
+	runStore    *runs.Store
+	schedStore  *scheduler.Store
+	lockout     *auth.Lockout
+
+	// Environment switcher fields
+	loadEnv    EnvironmentLoader
+	envs       map[string]*Environment // loaded environments, by name
+	envConfirm string                  // production environment waiting for y/n
+	envStatus  string
+
+	// Config reload fields
+	reload       Reloader
+	reloads      <-chan string // SIGHUP and file change triggers
+	reloading    bool
+	reloadStatus string
+
+	redact func(string) string // removes resolved secrets from the screen
 
 	mode       mode
-	filter     filterType
+	mainTitle  string
 	viewTasks  bool
+	collapsed  map[string]bool // collapsed tag groups, shared by both views
 	list       list.Model
 	lastOp     *config.Operation
 	lastOutput string
@@ -113,13 +305,50 @@ type Model struct {
 	lastTaskResult *tasks.TaskResult
 	lastSummary    string
 
+	// Plan fields
+	planTask   *config.Task
+	planInputs map[string]string
+	plan       *tasks.Plan
+	planErr    error
+	planning   bool
+
+	inputForm *inputForm // task inputs being entered in the main view
+
+	// Runs fields
+	runList   []*runs.Run
+	runTable  table.Model
+	runStatus string
+	resuming  bool
+
+	// Locks fields
+	lockList   []locks.Holder
+	lockTable  table.Model
+	lockStatus string
+
+	// Schedules fields
+	schedTable  table.Model
+	schedStatus string
+
 	// User management fields
 	userList        []*users.User
-	registeringUser bool
+	grantList       []*users.Grant
+	failureList     []*users.AuthFailures // users with failed sign-ins
+	userForm        *userForm
+	credUser        *users.User // user whose credentials are shown
+	credList        []*users.Credential
+	credTable       table.Model
+	confirm         *confirmation
+	registeringUser bool // waiting for a YubiKey touch
 	registerStatus  string
 
 	logTable table.Model
 	logRows  []table.Row
+
+	// Session lock fields
+	session       auth.Session
+	locked        auth.LockReason // non-empty while the session is locked
+	unlocking     bool
+	sessionStatus string
 }
 
 func NewModel(
@@ -130,17 +359,18 @@ func NewModel(
 	httpClients map[string]*clients.HTTPClient,
 	pgClients map[string]*clients.PostgresClient,
 	runner *tasks.Runner,
+	runStore *runs.Store,
+	schedStore *scheduler.Store,
 ) Model {
-	items := operationsToItems(cfg, principal, filterAll)
+	collapsed := make(map[string]bool)
+	items := operationsToItems(cfg, principal, collapsed)
 
 	l := list.New(items, list.NewDefaultDelegate(), 0, 0)
-	l.Title = fmt.Sprintf(
-		"lazyadmin – project=%s env=%s – user=%s roles=%v",
-		cfg.Project,
-		cfg.Env,
-		principal.SSHUser,
-		principal.ConfigUser.Roles,
-	)
+	l.Title = mainTitle(cfg, principal)
+	session := auth.NewSession(cfg, time.Now())
+	if principal.BreakGlass != nil {
+		session.BreakGlassUntil = principal.BreakGlass.ExpiresAt
+	}
 
 	l.SetShowStatusBar(false)
 	l.SetFilteringEnabled(true)
@@ -149,6 +379,7 @@ func NewModel(
 	// Log table (empty initially; populated on entering logs mode)
 	columns := []table.Column{
 		{Title: "Time", Width: 24},
+		{Title: "Env", Width: 8},
 		{Title: "User", Width: 10},
 		{Title: "Op", Width: 20},
 		{Title: "OK", Width: 3},
@@ -160,31 +391,141 @@ func NewModel(
 		table.WithFocused(true),
 	)
 
+	rt := table.New(
+		table.WithColumns([]table.Column{
+			{Title: "Started", Width: 19},
+			{Title: "Task", Width: 20},
+			{Title: "User", Width: 10},
+			{Title: "Status", Width: 11},
+			{Title: "Run", Width: 16},
+		}),
+		table.WithRows([]table.Row{}),
+		table.WithFocused(true),
+	)
+
+	lt := table.New(
+		table.WithColumns([]table.Column{
+			{Title: "Lock", Width: 30},
+			{Title: "User", Width: 10},
+			{Title: "SSH", Width: 10},
+			{Title: "Host", Width: 16},
+			{Title: "Since", Width: 8},
+			{Title: "Expires", Width: 8},
+		}),
+		table.WithRows([]table.Row{}),
+		table.WithFocused(true),
+	)
+
+	ct := table.New(
+		table.WithColumns([]table.Column{
+			{Title: "ID", Width: 6},
+			{Title: "Name", Width: 18},
+			{Title: "RP ID", Width: 20},
+			{Title: "Credential", Width: 24},
+			{Title: "Added", Width: 16},
+		}),
+		table.WithRows([]table.Row{}),
+		table.WithFocused(true),
+	)
+
+	st := table.New(
+		table.WithColumns([]table.Column{
+			{Title: "Schedule", Width: 16},
+			{Title: "Task", Width: 16},
+			{Title: "Cron", Width: 14},
+			{Title: "Run as", Width: 10},
+			{Title: "Next", Width: 16},
+			{Title: "Last run", Width: 16},
+			{Title: "Result", Width: 24},
+		}),
+		table.WithRows([]table.Row{}),
+		table.WithFocused(true),
+	)
+
 	return Model{
 		cfg:         cfg,
 		principal:   principal,
 		logger:      logger,
 		userStore:   userStore,
+		lockout:     auth.NewLockout(cfg, userStore, logger),
 		httpClients: ensureHTTPMap(httpClients),
 		pgClients:   pgClients,
 		taskRunner:  runner,
+		runStore:    runStore,
+		schedStore:  schedStore,
 		mode:        modeMain,
-		filter:      filterAll,
+		mainTitle:   l.Title,
 		viewTasks:   false,
+		collapsed:   collapsed,
 		list:        l,
 		logTable:    t,
+		runTable:    rt,
+		lockTable:   lt,
+		schedTable:  st,
+		credTable:   ct,
+		session:     session,
 	}
 }
 
-func tasksToItems(cfg *config.Config, principal *auth.Principal) []list.Item {
-	items := []list.Item{}
+// mainTitle describes the project, environment and signed-in user.
+func mainTitle(cfg *config.Config, principal *auth.Principal) string {
+	title := fmt.Sprintf(
+		"lazyadmin – project=%s env=%s – user=%s roles=%v",
+		cfg.Project,
+		cfg.Env,
+		principal.SSHUser,
+		principal.ConfigUser.Roles,
+	)
+	for _, g := range principal.Grants {
+		title += fmt.Sprintf(" +%s until %s", g.Role, g.ExpiresAt.Local().Format("15:04"))
+	}
+	if principal.BreakGlass != nil {
+		title = "BREAK-GLASS – " + title
+	}
+	return title
+}
+
+// WithEnvironments enables the environment switcher, loading environments
+// with load. Without it the TUI stays in the environment it started in.
+func (m Model) WithEnvironments(load EnvironmentLoader) Model {
+	m.loadEnv = load
+	m.envs = map[string]*Environment{
+		m.cfg.Env: {
+			Config:      m.cfg,
+			Principal:   m.principal,
+			Logger:      m.logger,
+			HTTPClients: m.httpClients,
+			PGClients:   m.pgClients,
+			Runner:      m.taskRunner,
+		},
+	}
+	return m
+}
+
+// WithReload enables reloading the configuration with reload, on the R key
+// and whenever triggers delivers a trigger such as ReloadSIGHUP.
+func (m Model) WithReload(reload Reloader, triggers <-chan string) Model {
+	m.reload = reload
+	m.reloads = triggers
+	return m
+}
+
+// WithRedaction passes everything the TUI draws through redact, so resolved
+// secrets echoed in output or errors are never shown.
+func (m Model) WithRedaction(redact func(string) string) Model {
+	m.redact = redact
+	return m
+}
+
+func tasksToItems(cfg *config.Config, principal *auth.Principal, collapsed map[string]bool) []list.Item {
+	var items []taggedItem
 	for _, t := range cfg.Tasks {
-		if !principal.HasAnyRole(t.AllowedRoles) {
+		if !principal.CanRun(t.AllowedRoles, t.Permissions, t.Tags) {
 			continue
 		}
 		items = append(items, taskItem{task: t})
 	}
-	return items
+	return groupByTag(items, collapsed)
 }
 
 func ensureHTTPMap(m map[string]*clients.HTTPClient) map[string]*clients.HTTPClient {
@@ -194,31 +535,128 @@ func ensureHTTPMap(m map[string]*clients.HTTPClient) map[string]*clients.HTTPCli
 	return m
 }
 
-func operationsToItems(cfg *config.Config, principal *auth.Principal, f filterType) []list.Item {
-	items := []list.Item{}
+func operationsToItems(cfg *config.Config, principal *auth.Principal, collapsed map[string]bool) []list.Item {
+	var items []taggedItem
 	for _, op := range cfg.Operations {
-		if !principal.HasAnyRole(op.AllowedRoles) {
+		if !principal.CanRun(op.AllowedRoles, op.Permissions, op.Tags) {
 			continue
 		}
+		items = append(items, operationItem{op: op})
+	}
+	return groupByTag(items, collapsed)
+}
 
-		if f == filterHTTP && op.Type != "http" {
-			continue
+// groupByTag puts each item under a header for its first tag. Groups are
+// sorted by tag, with untagged items last; the items of collapsed groups are
+// left out. If no item has a tag the list is returned flat.
+func groupByTag(items []taggedItem, collapsed map[string]bool) []list.Item {
+	groups := make(map[string][]list.Item)
+	var names []string
+	for _, it := range items {
+		name := untaggedGroup
+		if tags := it.tags(); len(tags) > 0 {
+			name = tags[0]
+		}
+		if _, ok := groups[name]; !ok {
+			names = append(names, name)
 		}
+		groups[name] = append(groups[name], it)
+	}
 
-		if f == filterPostgres && op.Type != "postgres" {
-			continue
+	out := []list.Item{}
+	if len(names) == 1 && names[0] == untaggedGroup {
+		return append(out, groups[untaggedGroup]...)
+	}
+
+	slices.SortFunc(names, func(a, b string) int {
+		switch {
+		case a == untaggedGroup:
+			return 1
+		case b == untaggedGroup:
+			return -1
 		}
+		return strings.Compare(a, b)
+	})
+	for _, name := range names {
+		out = append(out, groupItem{tag: name, count: len(groups[name]), collapsed: collapsed[name]})
+		if !collapsed[name] {
+			out = append(out, groups[name]...)
+		}
+	}
+	return out
+}
 
-		items = append(items, operationItem{op: op})
+// withItems reloads the operations or tasks list, keeping the cursor position.
+func (m Model) withItems() Model {
+	if m.viewTasks {
+		m.list.SetItems(tasksToItems(m.cfg, m.principal, m.collapsed))
+	} else {
+		m.list.SetItems(operationsToItems(m.cfg, m.principal, m.collapsed))
 	}
-	return items
+	return m
+}
+
+// setGroupsCollapsed collapses or expands every group in the current list.
+func (m Model) setGroupsCollapsed(collapsed bool) Model {
+	for _, it := range m.list.Items() {
+		if g, ok := it.(groupItem); ok {
+			if collapsed {
+				m.collapsed[g.tag] = true
+			} else {
+				delete(m.collapsed, g.tag)
+			}
+		}
+	}
+	return m.withItems()
 }
 
 func (m Model) Init() tea.Cmd {
-	return nil
+	var cmds []tea.Cmd
+	if m.session.Enabled() {
+		cmds = append(cmds, sessionTick())
+	}
+	if m.reloads != nil {
+		cmds = append(cmds, waitReload(m.reloads))
+	}
+	return tea.Batch(cmds...)
 }
 
 func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case sessionTickMsg:
+		m = m.checkSession(time.Time(msg))
+		return m, sessionTick()
+	case sessionUnlockMsg:
+		m.unlocking = false
+		if msg.err != nil {
+			m.sessionStatus = fmt.Sprintf("Re-authentication failed: %v", msg.err)
+			return m, nil
+		}
+		m.locked = ""
+		m.sessionStatus = ""
+		m.session.Renew(time.Now())
+		return m, nil
+	case envSwitchMsg:
+		if msg.err != nil {
+			m.envStatus = fmt.Sprintf("Switch to %s failed: %v", msg.name, msg.err)
+			m.audit(fmt.Sprintf("env:switch %s -> %s", m.cfg.Env, msg.name), msg.err)
+			return m, nil
+		}
+		return m.switchEnvironment(msg.env), nil
+	case reloadTriggerMsg:
+		m, cmd := m.reloadConfig(string(msg))
+		return m, tea.Batch(cmd, waitReload(m.reloads))
+	case reloadMsg:
+		return m.applyReload(msg), nil
+	case tea.KeyMsg:
+		// A key arriving between ticks must not revive an expired session.
+		now := time.Now()
+		if m = m.checkSession(now); m.locked != "" {
+			return m.updateLocked(msg)
+		}
+		m.session.Touch(now)
+	}
+
 	switch m.mode {
 	case modeMain:
 		return m.updateMain(msg)
@@ -228,12 +666,33 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m.updateHelp(msg)
 	case modeUsers:
 		return m.updateUsers(msg)
+	case modePlan:
+		return m.updatePlan(msg)
+	case modeRuns:
+		return m.updateRuns(msg)
+	case modeLocks:
+		return m.updateLocks(msg)
+	case modeSchedules:
+		return m.updateSchedules(msg)
+	case modeEnvironments:
+		return m.updateEnvironments(msg)
 	default:
 		return m, nil
 	}
 }
 
 func (m Model) View() string {
+	if m.redact != nil {
+		return m.redact(m.view())
+	}
+	return m.view()
+}
+
+func (m Model) view() string {
+	if m.locked != "" {
+		return m.viewLocked()
+	}
+
 	switch m.mode {
 	case modeMain:
 		return m.viewMain()
@@ -243,11 +702,119 @@ func (m Model) View() string {
 		return m.viewHelp()
 	case modeUsers:
 		return m.viewUsers()
+	case modePlan:
+		return m.viewPlan()
+	case modeRuns:
+		return m.viewRuns()
+	case modeLocks:
+		return m.viewLocks()
+	case modeSchedules:
+		return m.viewSchedules()
+	case modeEnvironments:
+		return m.viewEnvironments()
 	default:
 		return "unknown mode"
 	}
 }
 
+// === SESSION LOCK ===
+
+func sessionTick() tea.Cmd {
+	return tea.Tick(sessionCheckInterval, func(t time.Time) tea.Msg {
+		return sessionTickMsg(t)
+	})
+}
+
+// checkSession locks the session if it has expired at now.
+func (m Model) checkSession(now time.Time) Model {
+	if m.locked != "" {
+		return m
+	}
+	reason := m.session.Expired(now)
+	if reason == "" {
+		return m
+	}
+	m.locked = reason
+	m.sessionStatus = ""
+	m.audit(fmt.Sprintf("session:lock %s", reason), nil)
+	if reason == auth.LockBreakGlass {
+		m.audit(fmt.Sprintf("break-glass:end #%d", m.principal.BreakGlass.ID), nil)
+	}
+	return m
+}
+
+// updateLocked handles keys while the session is locked. Nothing can be run
+// until the user re-authenticates; results of work started before the lock
+// still arrive through the normal mode handlers.
+func (m Model) updateLocked(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c", "q":
+		return m, tea.Quit
+	case "enter":
+		if m.unlocking {
+			return m, nil
+		}
+		m.unlocking = true
+		m.sessionStatus = ""
+		return m, m.unlockSession()
+	}
+	return m, nil
+}
+
+// unlockSession asks for a fresh FIDO2 assertion. Failures count toward
+// auth.lockout like failed sign-ins, and a locked-out user cannot unlock.
+func (m Model) unlockSession() tea.Cmd {
+	return func() tea.Msg {
+		ctx := context.Background()
+		if err := m.lockout.Check(ctx, m.principal, "session:unlock"); err != nil {
+			return sessionUnlockMsg{err: err}
+		}
+		err := auth.Reauthenticate(m.principal)
+		return sessionUnlockMsg{err: m.lockout.Record(ctx, m.principal, "session:unlock", err)}
+	}
+}
+
+// audit records an action of the current principal in the audit log.
+func (m Model) audit(opID string, err error) {
+	if m.logger == nil {
+		return
+	}
+
+	entry := logging.AuditEntry{
+		Time:        time.Now(),
+		UserID:      m.principal.ConfigUser.ID,
+		SSHUser:     m.principal.SSHUser,
+		OperationID: opID,
+		Success:     err == nil,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	_ = m.logger.Log(context.Background(), entry)
+}
+
+func (m Model) viewLocked() string {
+	s := "Session locked"
+	switch m.locked {
+	case auth.LockIdle:
+		s += fmt.Sprintf(" after %s without input", m.session.IdleTimeout)
+	case auth.LockMaxAge:
+		s += fmt.Sprintf(": re-authentication is required every %s", m.session.MaxAge)
+	case auth.LockBreakGlass:
+		s += fmt.Sprintf(": the break-glass window ended at %s", m.session.BreakGlassUntil.Local().Format("15:04"))
+	}
+	s += "\n\n"
+
+	if m.unlocking {
+		s += "Please touch your YubiKey to unlock...\n\n"
+	} else if m.sessionStatus != "" {
+		s += m.sessionStatus + "\n\n"
+	}
+
+	s += "[enter:unlock with YubiKey] [q:quit]\n"
+	return s
+}
+
 // === MAIN MODE ===
 
 func (m Model) updateMain(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -265,46 +832,80 @@ func (m Model) updateMain(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.lastSummary = msg.summary
 		return m, nil
 	case tea.KeyMsg:
+		if m.inputForm != nil {
+			return m.updateInputForm(msg)
+		}
 		switch msg.String() {
 		case "q", "ctrl+c":
 			return m, tea.Quit
 		case "enter":
+			if g, ok := m.list.SelectedItem().(groupItem); ok {
+				if g.collapsed {
+					delete(m.collapsed, g.tag)
+				} else {
+					m.collapsed[g.tag] = true
+				}
+				return m.withItems(), nil
+			}
 			if m.viewTasks {
 				if it, ok := m.list.SelectedItem().(taskItem); ok {
-					return m, m.runTask(it.task)
+					if len(it.task.Inputs) > 0 {
+						m.inputForm = newInputForm(it.task, false)
+						return m, textinput.Blink
+					}
+					return m, m.runTask(it.task, nil)
 				}
 			} else {
 				if it, ok := m.list.SelectedItem().(operationItem); ok {
 					return m, m.runOperation(it.op)
 				}
 			}
-		case "t":
-			m.viewTasks = !m.viewTasks
+		case "d":
 			if m.viewTasks {
-				m.list.SetItems(tasksToItems(m.cfg, m.principal))
-			} else {
-				m.list.SetItems(operationsToItems(m.cfg, m.principal, m.filter))
+				if it, ok := m.list.SelectedItem().(taskItem); ok {
+					if len(it.task.Inputs) > 0 {
+						m.inputForm = newInputForm(it.task, true)
+						return m, textinput.Blink
+					}
+					return m.startPlan(it.task, nil)
+				}
 			}
-		case "a":
-			if !m.viewTasks {
-				m.filter = filterAll
-				m.list.SetItems(operationsToItems(m.cfg, m.principal, m.filter))
+		case "t":
+			m.viewTasks = !m.viewTasks
+			return m.withItems(), nil
+		case "c":
+			return m.setGroupsCollapsed(true), nil
+		case "e":
+			return m.setGroupsCollapsed(false), nil
+		case "l":
+			if m.principal.HasPermission(config.PermAuditRead) {
+				m.mode = modeLogs
+				return m.withLoadedLogs(), nil
 			}
-		case "h":
-			if !m.viewTasks {
-				m.filter = filterHTTP
-				m.list.SetItems(operationsToItems(m.cfg, m.principal, m.filter))
+			return m, nil
+		case "r":
+			m.mode = modeRuns
+			m.runStatus = ""
+			return m.withLoadedRuns(), nil
+		case "L":
+			m.mode = modeLocks
+			m.lockStatus = ""
+			return m.withLoadedLocks(), nil
+		case "s":
+			m.mode = modeSchedules
+			return m.withLoadedSchedules(), nil
+		case "R":
+			if m.reload != nil {
+				return m.reloadConfig(ReloadKey)
 			}
-		case "p":
-			if !m.viewTasks {
-				m.filter = filterPostgres
-				m.list.SetItems(operationsToItems(m.cfg, m.principal, m.filter))
+		case "E":
+			if m.loadEnv != nil {
+				m.mode = modeEnvironments
+				m.envStatus = ""
+				return m.withEnvironmentItems(), nil
 			}
-		case "l":
-			m.mode = modeLogs
-			return m.withLoadedLogs(), nil
 		case "u":
-			if m.principal.IsAdmin() {
+			if m.principal.HasPermission(config.PermUsersManage) {
 				m.mode = modeUsers
 				return m.withLoadedUsers(), nil
 			}
@@ -324,30 +925,41 @@ func (m Model) viewMain() string {
 		viewLabel = "Tasks"
 	}
 
-	filterLabel := "N/A"
-	if !m.viewTasks {
-		filterLabel = "All"
-		switch m.filter {
-		case filterHTTP:
-			filterLabel = "HTTP"
-		case filterPostgres:
-			filterLabel = "Postgres"
-		}
-	}
-
 	status := fmt.Sprintf(
-		"[View: %s] [Filter: %s]  [t:toggle view] [a/h/p:filter ops] [enter:run] [l:logs]%s [?:help] [q:quit]",
+		"[View: %s]  [t:toggle view] [enter:run/toggle group] [c/e:collapse/expand groups] [d:plan task] [l:logs] [r:runs] [L:locks] [s:schedules]%s%s [?:help] [q:quit]",
 		viewLabel,
-		filterLabel,
 		func() string {
-			if m.principal.IsAdmin() {
+			s := ""
+			if m.loadEnv != nil {
+				s += " [E:environment]"
+			}
+			if m.reload != nil {
+				s += " [R:reload config]"
+			}
+			return s
+		}(),
+		func() string {
+			if m.principal.HasPermission(config.PermUsersManage) {
 				return " [u:users]"
 			}
 			return ""
 		}(),
 	)
 
-	s := m.list.View() + "\n"
+	s := ""
+	if m.cfg.Production() {
+		s += prodBanner.Render(fmt.Sprintf("PRODUCTION – %s", m.cfg.Env)) + "\n"
+	}
+	if m.envStatus != "" {
+		s += m.envStatus + "\n"
+	}
+	if m.reloadStatus != "" {
+		s += m.reloadStatus + "\n"
+	}
+	if m.inputForm != nil {
+		return s + m.viewInputForm()
+	}
+	s += m.list.View() + "\n"
 	s += status + "\n"
 
 	s += "\nDetails:\n"
@@ -356,6 +968,14 @@ func (m Model) viewMain() string {
 			s += fmt.Sprintf("  Last task: %s (risk:%s)\n", m.lastTask.ID, m.lastTask.RiskLevel)
 			if m.lastTaskResult != nil {
 				s += fmt.Sprintf("  Success: %v\n", m.lastTaskResult.Success)
+				if m.lastTaskResult.Err != nil {
+					s += fmt.Sprintf("  Error: %v\n", m.lastTaskResult.Err)
+				}
+				if rb := m.lastTaskResult.Rollback; rb != nil && rb.Skipped != nil {
+					s += fmt.Sprintf("  Rollback: %v\n", rb.Skipped)
+				} else if rb != nil {
+					s += fmt.Sprintf("  Rollback: %d steps, success: %v\n", len(rb.StepOrder), rb.Success)
+				}
 			}
 			if m.lastSummary != "" {
 				s += "  Summary:\n"
@@ -418,10 +1038,16 @@ func (m Model) withLoadedLogs() Model {
 			ok = "✓"
 		}
 
+		op := r.OperationID
+		if r.Mark != "" {
+			op = "[" + r.Mark + "] " + op
+		}
+
 		tRows = append(tRows, table.Row{
 			r.OccurredAt.Format("2006-01-02 15:04:05"),
+			r.Env,
 			r.UserID,
-			r.OperationID,
+			op,
 			ok,
 		})
 	}
@@ -478,18 +1104,34 @@ lazyadmin keybindings:
 
     ↑/↓ or j/k   Move selection
 
-    enter        Run selected operation
+    enter        Run selected operation or task
+
+    d            Plan (dry-run) selected task
 
-    a            Filter: all operations
+    enter        On a tag group: collapse or expand it
 
-    h            Filter: HTTP operations only
+    c            Collapse all tag groups
 
-    p            Filter: Postgres operations only
+    e            Expand all tag groups
 
-    l            View recent audit logs`
-	if m.principal.IsAdmin() {
+    l            View recent audit logs (audit.read)
+
+    r            View task runs and resume failed or interrupted ones
+
+    L            View held task and operation locks
+
+    s            View schedules with next and last run times`
+	if m.loadEnv != nil {
+		help += `
+    E            Switch environment (production asks for confirmation)`
+	}
+	if m.reload != nil {
+		help += `
+    R            Reload the configuration (also on SIGHUP and file changes)`
+	}
+	if m.principal.HasPermission(config.PermUsersManage) {
 		help += `
-    u            Manage users (admin only)`
+    u            Manage users (users.manage)`
 	}
 	help += `
     ?            Show this help
@@ -500,9 +1142,42 @@ lazyadmin keybindings:
 
     q / esc      Return to main
 
-  Users mode (admin only):
+  Plan mode:
+
+    r            Re-plan, executing read-only steps as a preview
+    enter        Run the task for real
+    q / esc      Return to main
+
+  Runs mode:
+
+    enter        Resume selected run, retrying the failed step
+    s            Resume selected run, skipping the failed step
+    q / esc      Return to main
+
+  Locks mode:
+
+    f            Force-unlock selected lock (locks.force_unlock, audited)
+    q / esc      Return to main
+
+  Schedules mode:
 
-    n            Register new user with YubiKey
+    R            Refresh
+    q / esc      Return to main
+
+  Environments mode:
+
+    enter        Switch to selected environment (audited)
+    q / esc      Return to main
+
+  Users mode (users.manage, all actions audited):
+
+    n            New user: enter ID, SSH users and roles, then touch the YubiKey
+    e            Edit SSH users and roles of selected user
+    a            Register another YubiKey for selected user
+    c            Show credentials of selected user (x: revoke)
+    d            Disable or enable selected user
+    u            Unlock selected user after failed sign-ins (auth.lockout)
+    X            Delete selected user
     q / esc      Return to main
 
 (Press any key to return)
@@ -513,52 +1188,134 @@ lazyadmin keybindings:
 
 func (m Model) runOperation(op config.Operation) tea.Cmd {
 	return func() tea.Msg {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-
-		var out string
-		var err error
-
-		switch op.Type {
-		case "http":
-			client, ok := m.httpClients[op.Target]
-			if !ok {
-				err = fmt.Errorf("no http resource named %q", op.Target)
-			} else {
-				out, err = client.Request(ctx, op.Method, op.Path)
-			}
-		case "postgres":
-			client, ok := m.pgClients[op.Target]
-			if !ok {
-				err = fmt.Errorf("no postgres resource named %q", op.Target)
-			} else {
-				out, err = client.RunScalarQuery(ctx, op.Query)
-			}
-		default:
-			err = fmt.Errorf("unsupported op type: %s", op.Type)
+		if m.taskRunner == nil {
+			return operationResultMsg{op: op, errMsg: "task runner not configured"}
 		}
 
-		entry := logging.AuditEntry{
-			Time:        time.Now(),
-			UserID:      m.principal.ConfigUser.ID,
-			SSHUser:     m.principal.SSHUser,
-			OperationID: op.ID,
-			Success:     err == nil,
-		}
+		out, err := m.taskRunner.RunOperation(context.Background(), m.principal, op, nil)
 		if err != nil {
-			entry.Error = err.Error()
+			return operationResultMsg{op: op, errMsg: err.Error()}
 		}
+		return operationResultMsg{op: op, output: out}
+	}
+}
+
+// startPlan switches to the plan view and plans task with inputs.
+func (m Model) startPlan(task config.Task, inputs map[string]string) (tea.Model, tea.Cmd) {
+	m.mode = modePlan
+	m.planTask = &task
+	m.planInputs = inputs
+	m.plan = nil
+	m.planning = true
+	return m, m.planTaskCmd(task, inputs, false)
+}
+
+func newInputForm(task config.Task, plan bool) *inputForm {
+	f := &inputForm{task: task, plan: plan}
+	for _, ti := range task.Inputs {
+		in := textinput.New()
+		in.Placeholder = ti.Default
+		in.CharLimit = 256
+		f.inputs = append(f.inputs, in)
+	}
+	f.inputs[0].Focus()
+	return f
+}
+
+// move focuses the next (delta 1) or previous (delta -1) field, wrapping.
+func (f *inputForm) move(delta int) {
+	f.inputs[f.focus].Blur()
+	f.focus = (f.focus + delta + len(f.inputs)) % len(f.inputs)
+	f.inputs[f.focus].Focus()
+}
 
-		_ = m.logger.Log(ctx, entry)
+// values returns the entered inputs. Empty fields are left out so their
+// defaults apply.
+func (f *inputForm) values() (map[string]string, error) {
+	values := make(map[string]string)
+	for i, ti := range f.task.Inputs {
+		v := strings.TrimSpace(f.inputs[i].Value())
+		if v == "" {
+			if ti.Required && ti.Default == "" {
+				return nil, fmt.Errorf("%s is required", inputLabel(ti))
+			}
+			continue
+		}
+		values[ti.Name] = v
+	}
+	return values, nil
+}
 
+func inputLabel(ti config.TaskInput) string {
+	if ti.Label != "" {
+		return ti.Label
+	}
+	return ti.Name
+}
+
+func (m Model) updateInputForm(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	f := m.inputForm
+	switch msg.String() {
+	case "esc":
+		m.inputForm = nil
+		return m, nil
+	case "tab", "down":
+		f.move(1)
+		return m, nil
+	case "shift+tab", "up":
+		f.move(-1)
+		return m, nil
+	case "enter":
+		if f.focus < len(f.inputs)-1 {
+			f.move(1)
+			return m, nil
+		}
+		values, err := f.values()
 		if err != nil {
-			return operationResultMsg{op: op, errMsg: err.Error()}
+			f.err = err.Error()
+			return m, nil
 		}
-		return operationResultMsg{op: op, output: out}
+		m.inputForm = nil
+		if f.plan {
+			return m.startPlan(f.task, values)
+		}
+		return m, m.runTask(f.task, values)
 	}
+
+	var cmd tea.Cmd
+	f.inputs[f.focus], cmd = f.inputs[f.focus].Update(msg)
+	return m, cmd
 }
 
-func (m Model) runTask(task config.Task) tea.Cmd {
+func (m Model) viewInputForm() string {
+	f := m.inputForm
+	verb := "Run"
+	if f.plan {
+		verb = "Plan"
+	}
+	s := fmt.Sprintf("%s task %s\n\n", verb, f.task.ID)
+
+	width := 0
+	for _, ti := range f.task.Inputs {
+		width = max(width, len(inputLabel(ti)))
+	}
+	for i, ti := range f.task.Inputs {
+		label := inputLabel(ti)
+		if ti.Required && ti.Default == "" {
+			label += "*"
+		}
+		s += fmt.Sprintf("%-*s %s\n", width+1, label, f.inputs[i].View())
+	}
+
+	s += "\n* required; empty fields take their default.\n"
+	if f.err != "" {
+		s += "\nError: " + f.err + "\n"
+	}
+	s += "\n[tab/↑/↓:move] [enter:next field / " + strings.ToLower(verb) + "] [esc:cancel]\n"
+	return s
+}
+
+func (m Model) runTask(task config.Task, inputs map[string]string) tea.Cmd {
 	return func() tea.Msg {
 		if m.taskRunner == nil {
 			return taskResultMsg{
@@ -568,10 +1325,14 @@ func (m Model) runTask(task config.Task) tea.Cmd {
 			}
 		}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-		defer cancel()
+		if err := auth.RequireTaskYubiKey(m.principal, task); err != nil {
+			return taskResultMsg{
+				task:    task,
+				summary: fmt.Sprintf("step-up authentication failed: %v", err),
+			}
+		}
 
-		tr := m.taskRunner.Run(ctx, m.principal.ConfigUser.ID, m.principal.SSHUser, task)
+		tr := m.taskRunner.Run(context.Background(), m.principal, task, inputs)
 
 		summary, err := tasks.RenderSummary(task, tr)
 		if err != nil {
@@ -586,6 +1347,587 @@ func (m Model) runTask(task config.Task) tea.Cmd {
 	}
 }
 
+// === PLAN MODE ===
+
+func (m Model) planTaskCmd(task config.Task, inputs map[string]string, preview bool) tea.Cmd {
+	return func() tea.Msg {
+		if m.taskRunner == nil {
+			return planResultMsg{task: task}
+		}
+		if !m.principal.CanRun(task.AllowedRoles, task.Permissions, task.Tags) {
+			return planResultMsg{task: task, err: fmt.Errorf("task %s: %w", task.ID, tasks.ErrNotAllowed)}
+		}
+		plan := m.taskRunner.Plan(context.Background(), m.principal, task, inputs, tasks.PlanOptions{ExecuteReadOnly: preview})
+		return planResultMsg{task: task, plan: plan}
+	}
+}
+
+func (m Model) updatePlan(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case planResultMsg:
+		m.planning = false
+		m.plan = msg.plan
+		m.planErr = msg.err
+		return m, nil
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "q", "esc":
+			m.mode = modeMain
+			return m, nil
+		case "r":
+			if m.planTask != nil && !m.planning {
+				m.planning = true
+				return m, m.planTaskCmd(*m.planTask, m.planInputs, true)
+			}
+		case "enter":
+			if m.planTask != nil && !m.planning {
+				m.mode = modeMain
+				return m, m.runTask(*m.planTask, m.planInputs)
+			}
+		}
+	}
+
+	return m, nil
+}
+
+func (m Model) viewPlan() string {
+	s := "Task plan (dry-run)\n\n"
+
+	switch {
+	case m.planning:
+		s += "Planning...\n"
+	case m.planErr != nil:
+		s += fmt.Sprintf("Error: %v\n", m.planErr)
+	case m.plan == nil:
+		s += "task runner not configured\n"
+	default:
+		s += m.plan.String()
+	}
+
+	s += "\n[r:re-plan with read-only preview] [enter:run task] [q/esc:return to main]\n"
+	return s
+}
+
+// === RUNS MODE ===
+
+func (m Model) withLoadedRuns() Model {
+	m.runList = nil
+	if m.runStore == nil {
+		m.runStatus = "run persistence not configured"
+		m.runTable.SetRows([]table.Row{})
+		return m
+	}
+
+	runList, err := m.runStore.ListRuns(context.Background(), 50)
+	if err != nil {
+		m.runStatus = fmt.Sprintf("Error loading runs: %v", err)
+	}
+	m.runList = runList
+
+	rows := []table.Row{}
+	for _, r := range runList {
+		rows = append(rows, table.Row{
+			r.StartedAt.Local().Format("2006-01-02 15:04:05"),
+			r.TaskID,
+			r.UserID,
+			string(r.Status),
+			r.ID,
+		})
+	}
+	m.runTable.SetRows(rows)
+	return m
+}
+
+func (m Model) selectedRun() *runs.Run {
+	i := m.runTable.Cursor()
+	if i < 0 || i >= len(m.runList) {
+		return nil
+	}
+	return m.runList[i]
+}
+
+// resumeRun re-authenticates the operator and resumes run. The runner checks
+// that the principal owns the run or is an admin.
+func (m Model) resumeRun(run *runs.Run, mode tasks.ResumeMode) tea.Cmd {
+	return func() tea.Msg {
+		if m.taskRunner == nil {
+			return runResumeMsg{err: fmt.Errorf("task runner not configured")}
+		}
+
+		if err := auth.RequireYubiKeyIfConfigured(m.cfg, m.principal); err != nil {
+			return runResumeMsg{err: fmt.Errorf("re-authentication failed: %w", err)}
+		}
+		if task, ok := m.cfg.FindTask(run.TaskID); ok {
+			if err := auth.RequireTaskYubiKey(m.principal, *task); err != nil {
+				return runResumeMsg{err: fmt.Errorf("step-up authentication failed: %w", err)}
+			}
+		}
+
+		tr, err := m.taskRunner.Resume(context.Background(), m.principal, run.ID, mode)
+		if err != nil {
+			return runResumeMsg{err: err}
+		}
+
+		summary, err := tasks.RenderSummary(tr.Task, tr)
+		if err != nil {
+			summary = fmt.Sprintf("error rendering summary: %v", err)
+		}
+
+		return runResumeMsg{task: tr.Task, result: &tr, summary: summary}
+	}
+}
+
+func (m Model) updateRuns(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.runTable.SetWidth(msg.Width)
+		m.runTable.SetHeight(msg.Height - 6)
+	case runResumeMsg:
+		m.resuming = false
+		if msg.err != nil {
+			m.runStatus = fmt.Sprintf("Resume failed: %v", msg.err)
+			return m.withLoadedRuns(), nil
+		}
+		m.lastTask = &msg.task
+		m.lastTaskResult = msg.result
+		m.lastSummary = msg.summary
+		m.runStatus = fmt.Sprintf("Resumed as run %s, success: %v", msg.result.RunID, msg.result.Success)
+		return m.withLoadedRuns(), nil
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "q", "esc":
+			m.mode = modeMain
+			return m, nil
+		case "enter", "s":
+			run := m.selectedRun()
+			if run == nil || m.resuming {
+				return m, nil
+			}
+			if !run.Status.Resumable() {
+				m.runStatus = fmt.Sprintf("Run %s is %s and cannot be resumed", run.ID, run.Status)
+				return m, nil
+			}
+			mode := tasks.ResumeRetryFailed
+			if msg.String() == "s" {
+				mode = tasks.ResumeSkipFailed
+			}
+			m.resuming = true
+			m.runStatus = fmt.Sprintf("Resuming run %s...", run.ID)
+			return m, m.resumeRun(run, mode)
+		}
+	}
+
+	var cmd tea.Cmd
+	m.runTable, cmd = m.runTable.Update(msg)
+	return m, cmd
+}
+
+func (m Model) viewRuns() string {
+	s := "Task runs\n\n"
+
+	if m.resuming && m.cfg.Auth.RequireYubiKey {
+		s += "Please touch your YubiKey to re-authenticate...\n\n"
+	}
+	if m.runStatus != "" {
+		s += m.runStatus + "\n\n"
+	}
+
+	s += m.runTable.View() + "\n"
+	s += "[enter:resume, retry failed step] [s:resume, skip failed step] [q/esc:return to main]\n"
+	return s
+}
+
+// === LOCKS MODE ===
+
+func (m Model) withLoadedLocks() Model {
+	m.lockList = nil
+	rows := []table.Row{}
+
+	if m.taskRunner == nil {
+		m.lockStatus = "task runner not configured"
+		m.lockTable.SetRows(rows)
+		return m
+	}
+
+	lockList, err := m.taskRunner.Locks(context.Background())
+	if err != nil {
+		m.lockStatus = fmt.Sprintf("Error loading locks: %v", err)
+	}
+	m.lockList = lockList
+
+	for _, h := range lockList {
+		rows = append(rows, table.Row{
+			h.Name,
+			h.UserID,
+			h.SSHUser,
+			h.Host,
+			h.AcquiredAt.Local().Format("15:04:05"),
+			h.ExpiresAt.Local().Format("15:04:05"),
+		})
+	}
+	m.lockTable.SetRows(rows)
+	return m
+}
+
+func (m Model) forceUnlock(name string) tea.Cmd {
+	return func() tea.Msg {
+		holder, err := m.taskRunner.ForceUnlock(context.Background(), m.principal, name)
+		return forceUnlockMsg{name: name, holder: holder, err: err}
+	}
+}
+
+func (m Model) updateLocks(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.lockTable.SetWidth(msg.Width)
+		m.lockTable.SetHeight(msg.Height - 6)
+	case forceUnlockMsg:
+		if msg.err != nil {
+			m.lockStatus = fmt.Sprintf("Force-unlock failed: %v", msg.err)
+		} else {
+			m.lockStatus = fmt.Sprintf("Released lock %s held by %s", msg.name, msg.holder.UserID)
+		}
+		return m.withLoadedLocks(), nil
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "q", "esc":
+			m.mode = modeMain
+			return m, nil
+		case "f":
+			if !m.principal.HasPermission(config.PermLocksForceUnlock) {
+				m.lockStatus = "Force-unlock requires the locks.force_unlock permission"
+				return m, nil
+			}
+			i := m.lockTable.Cursor()
+			if m.taskRunner != nil && i >= 0 && i < len(m.lockList) {
+				return m, m.forceUnlock(m.lockList[i].Name)
+			}
+		}
+	}
+
+	var cmd tea.Cmd
+	m.lockTable, cmd = m.lockTable.Update(msg)
+	return m, cmd
+}
+
+func (m Model) viewLocks() string {
+	s := "Held locks\n\n"
+
+	if m.lockStatus != "" {
+		s += m.lockStatus + "\n\n"
+	}
+
+	s += m.lockTable.View() + "\n"
+	if m.principal.HasPermission(config.PermLocksForceUnlock) {
+		s += "[f:force-unlock] "
+	}
+	s += "[q/esc:return to main]\n"
+	return s
+}
+
+// === SCHEDULES MODE ===
+
+func (m Model) withLoadedSchedules() Model {
+	m.schedStatus = ""
+	var states map[string]scheduler.State
+	if m.schedStore != nil {
+		var err error
+		states, err = m.schedStore.List(context.Background())
+		if err != nil {
+			m.schedStatus = fmt.Sprintf("Error loading schedule state: %v", err)
+		}
+	}
+
+	now := time.Now()
+	rows := []table.Row{}
+	for _, sc := range m.cfg.Schedules {
+		next := "-"
+		if c, err := cron.Parse(sc.Cron); err == nil {
+			if t := c.Next(now); !t.IsZero() {
+				next = t.Format("2006-01-02 15:04")
+			}
+		}
+
+		last, result := "never", ""
+		if st, ok := states[sc.ID]; ok {
+			last = st.LastStartedAt.Local().Format("2006-01-02 15:04")
+			switch {
+			case st.Running:
+				result = "running"
+			case st.LastSuccess:
+				result = "ok"
+			default:
+				result = "failed: " + st.LastError
+			}
+		}
+
+		rows = append(rows, table.Row{sc.ID, sc.Task, sc.Cron, sc.RunAs, next, last, result})
+	}
+	m.schedTable.SetRows(rows)
+	return m
+}
+
+func (m Model) updateSchedules(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.schedTable.SetWidth(msg.Width)
+		m.schedTable.SetHeight(msg.Height - 6)
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "q", "esc":
+			m.mode = modeMain
+			return m, nil
+		case "R":
+			return m.withLoadedSchedules(), nil
+		}
+	}
+
+	var cmd tea.Cmd
+	m.schedTable, cmd = m.schedTable.Update(msg)
+	return m, cmd
+}
+
+func (m Model) viewSchedules() string {
+	s := "Schedules (run by `lazyadmin scheduler`)\n\n"
+
+	if m.schedStatus != "" {
+		s += m.schedStatus + "\n\n"
+	}
+	if len(m.cfg.Schedules) == 0 {
+		s += "No schedules configured.\n\n"
+	}
+
+	s += m.schedTable.View() + "\n"
+	s += "[R:refresh] [q/esc:return to main]\n"
+	return s
+}
+
+// === ENVIRONMENTS MODE ===
+
+func (m Model) withEnvironmentItems() Model {
+	items := []list.Item{}
+	for _, name := range m.cfg.EnvironmentNames() {
+		env := m.cfg.Environments[name]
+		items = append(items, envItem{name: name, production: env.Production, current: name == m.cfg.Env})
+	}
+	m.list.SetItems(items)
+	m.list.Title = "Environments"
+	return m
+}
+
+func (m Model) updateEnvironments(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		if m.envConfirm != "" {
+			name := m.envConfirm
+			m.envConfirm = ""
+			if msg.String() != "y" {
+				m.envStatus = "Cancelled"
+				return m, nil
+			}
+			return m, m.loadEnvironment(name)
+		}
+		if m.list.FilterState() == list.Filtering {
+			break
+		}
+		switch msg.String() {
+		case "q", "esc":
+			m.mode = modeMain
+			m.list.Title = m.mainTitle
+			return m.withItems(), nil
+		case "enter":
+			it, ok := m.list.SelectedItem().(envItem)
+			if !ok || it.current {
+				return m, nil
+			}
+			m.envStatus = ""
+			if it.production {
+				m.envConfirm = it.name
+				return m, nil
+			}
+			return m, m.loadEnvironment(it.name)
+		}
+	}
+
+	var cmd tea.Cmd
+	m.list, cmd = m.list.Update(msg)
+	return m, cmd
+}
+
+// loadEnvironment loads environment name, reusing it if it was loaded
+// before so switching back and forth does not pile up clients.
+func (m Model) loadEnvironment(name string) tea.Cmd {
+	if env, ok := m.envs[name]; ok {
+		return func() tea.Msg { return envSwitchMsg{name: name, env: env} }
+	}
+	load := m.loadEnv
+	return func() tea.Msg {
+		env, err := load(name)
+		return envSwitchMsg{name: name, env: env, err: err}
+	}
+}
+
+// switchEnvironment makes env current and returns to the main view. Runs
+// already started keep the runner, and environment, they started with.
+func (m Model) switchEnvironment(env *Environment) Model {
+	from := m.cfg.Env
+	m.envs[env.Config.Env] = env
+
+	m = m.useEnvironment(env)
+	m.audit(fmt.Sprintf("env:switch %s -> %s", from, env.Config.Env), nil)
+
+	m.lastOp, m.lastOutput, m.lastError = nil, "", ""
+	m.lastTask, m.lastTaskResult, m.lastSummary = nil, nil, ""
+	m.envStatus = fmt.Sprintf("Switched to environment %s", env.Config.Env)
+	for _, w := range env.Warnings {
+		m.envStatus += "\nwarning: " + w
+	}
+
+	m.mode = modeMain
+	m.mainTitle = mainTitle(m.cfg, m.principal)
+	m.list.Title = m.mainTitle
+	return m.withItems()
+}
+
+// useEnvironment makes env's configuration, principal and components the
+// ones new actions use.
+func (m Model) useEnvironment(env *Environment) Model {
+	m.cfg = env.Config
+	m.principal = env.Principal
+	m.logger = env.Logger
+	m.httpClients = ensureHTTPMap(env.HTTPClients)
+	m.pgClients = env.PGClients
+	m.taskRunner = env.Runner
+	m.lockout = auth.NewLockout(env.Config, m.userStore, env.Logger)
+	return m
+}
+
+func (m Model) viewEnvironments() string {
+	s := m.list.View() + "\n"
+	if m.envStatus != "" {
+		s += m.envStatus + "\n"
+	}
+	if m.envConfirm != "" {
+		return s + prodBanner.Render(fmt.Sprintf("Switch to PRODUCTION environment %s?", m.envConfirm)) + " [y/N]\n"
+	}
+	return s + "[enter:switch] [q/esc:return to main]\n"
+}
+
+// === CONFIG RELOAD ===
+
+// waitReload waits for the next reload trigger from outside the TUI.
+func waitReload(triggers <-chan string) tea.Cmd {
+	return func() tea.Msg {
+		return reloadTriggerMsg(<-triggers)
+	}
+}
+
+// reloadConfig reloads the configuration in the background. A trigger
+// arriving while a reload is running is dropped; the running one reads the
+// files afresh anyway.
+func (m Model) reloadConfig(trigger string) (Model, tea.Cmd) {
+	if m.reload == nil || m.reloading {
+		return m, nil
+	}
+	m.reloading = true
+	if trigger != ReloadWatch {
+		m.reloadStatus = "Reloading configuration..."
+	}
+	reload := m.reload
+	cur := m.currentEnvironment()
+	return m, func() tea.Msg {
+		env, err := reload(cur)
+		return reloadMsg{trigger: trigger, env: env, err: err}
+	}
+}
+
+// currentEnvironment returns the environment the TUI is using.
+func (m Model) currentEnvironment() *Environment {
+	return &Environment{
+		Config:      m.cfg,
+		Principal:   m.principal,
+		Logger:      m.logger,
+		HTTPClients: m.httpClients,
+		PGClients:   m.pgClients,
+		Runner:      m.taskRunner,
+	}
+}
+
+// applyReload swaps in a reloaded configuration, in one step as far as the
+// TUI is concerned. Runs already started keep the runner, and
+// configuration, they started with; the postgres clients they use are closed
+// after they finish if the new configuration no longer uses them. Other
+// environments are loaded afresh on the next switch. A failed reload keeps
+// the configuration in use.
+func (m Model) applyReload(msg reloadMsg) Model {
+	m.reloading = false
+	old := shortHash(m.cfg.Hash())
+	if msg.err != nil {
+		m.reloadStatus = fmt.Sprintf("Config reload (%s) failed, keeping the current configuration: %v", msg.trigger, msg.err)
+		m.audit(fmt.Sprintf("config.reload %s (%s)", old, msg.trigger), msg.err)
+		return m
+	}
+	// Touching a file, or an editor's swap file, changes nothing.
+	cur := m.currentEnvironment()
+	if msg.trigger == ReloadWatch && msg.env.Config.Hash() == m.cfg.Hash() {
+		retire(msg.env, cur)
+		return m
+	}
+
+	m = m.useEnvironment(msg.env)
+	retire(cur, msg.env)
+	if m.envs != nil {
+		for _, env := range m.envs {
+			if env.Runner != cur.Runner {
+				retire(env, msg.env)
+			}
+		}
+		m.envs = map[string]*Environment{msg.env.Config.Env: msg.env}
+	}
+	m.audit(fmt.Sprintf("config.reload %s -> %s (%s)", old, shortHash(msg.env.Config.Hash()), msg.trigger), nil)
+
+	m.reloadStatus = fmt.Sprintf("Configuration reloaded (%s): %d operations, %d tasks",
+		msg.trigger, len(m.cfg.Operations), len(m.cfg.Tasks))
+	for _, w := range msg.env.Warnings {
+		m.reloadStatus += "\nwarning: " + w
+	}
+	m.mainTitle = mainTitle(m.cfg, m.principal)
+	if m.mode == modeUsers || m.mode == modeEnvironments {
+		return m // the main list is rebuilt on leaving these views
+	}
+	m.list.Title = m.mainTitle
+	return m.withItems()
+}
+
+// retire closes the postgres clients of env that keep does not use, once no
+// call started on env's runner is in progress.
+func retire(env, keep *Environment) {
+	var unused []*clients.PostgresClient
+	for name, c := range env.PGClients {
+		if keep.PGClients[name] != c {
+			unused = append(unused, c)
+		}
+	}
+	release := func() {
+		for _, c := range unused {
+			_ = c.Close()
+		}
+	}
+	if env.Runner == nil {
+		release()
+		return
+	}
+	env.Runner.Retire(release)
+}
+
+// shortHash abbreviates a configuration hash for display and audit.
+func shortHash(h string) string {
+	if h == "" {
+		return "none"
+	}
+	return h[:min(12, len(h))]
+}
+
 // === USERS MODE ===
 
 func (m Model) withLoadedUsers() Model {
@@ -602,56 +1944,149 @@ func (m Model) withLoadedUsers() Model {
 		return m
 	}
 
-	m.userList = userList
-	items := []list.Item{}
-	for _, u := range userList {
-		items = append(items, userItem{user: u})
+	grants, err := m.userStore.ListGrants(ctx, 50)
+	if err != nil {
+		m.registerStatus = fmt.Sprintf("Error loading elevation grants: %v", err)
 	}
 
-	m.list.SetItems(items)
-	m.list.Title = "Users (admin only)"
+	failures, err := m.userStore.ListAuthFailures(ctx)
+	if err != nil {
+		m.registerStatus = fmt.Sprintf("Error loading failed sign-ins: %v", err)
+	}
+
+	m.userList = userList
+	m.grantList = grants
+	m.failureList = failures
+	m.list.SetItems(m.userItems())
+	m.list.Title = "Users"
 	return m
 }
 
+// userItems pairs each user with their elevation grants in force and any
+// sign-in lockout.
+func (m Model) userItems() []list.Item {
+	now := time.Now()
+	items := []list.Item{}
+	for _, u := range m.userList {
+		item := userItem{user: u}
+		for _, g := range m.grantList {
+			if g.UserID == u.ID && g.InForce(now) {
+				item.grants = append(item.grants, g)
+			}
+		}
+		for _, f := range m.failureList {
+			if f.UserID == u.ID && f.Locked(now) {
+				item.lockedUntil = f.LockedUntil
+			}
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
 func (m Model) updateUsers(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.WindowSizeMsg:
 		m.list.SetSize(msg.Width, msg.Height-7)
+		m.credTable.SetWidth(msg.Width)
+		m.credTable.SetHeight(msg.Height - 8)
 	case userListMsg:
 		if msg.err != nil {
 			m.registerStatus = fmt.Sprintf("Error: %v", msg.err)
 		} else {
 			m.userList = msg.users
-			items := []list.Item{}
-			for _, u := range msg.users {
-				items = append(items, userItem{user: u})
-			}
-			m.list.SetItems(items)
+			m.list.SetItems(m.userItems())
 		}
 		return m, nil
-	case userRegistrationMsg:
+	case userActionMsg:
 		m.registeringUser = false
 		if msg.err != nil {
-			m.registerStatus = fmt.Sprintf("Registration failed: %v", msg.err)
-		} else {
-			m.registerStatus = fmt.Sprintf("User %s registered successfully!", msg.userID)
-			// Reload user list
-			return m.withLoadedUsers(), nil
+			m.registerStatus = fmt.Sprintf("Error: %v", msg.err)
+			return m, nil
+		}
+		m.registerStatus = msg.status
+		m = m.withLoadedUsers()
+		if m.credUser != nil {
+			m = m.withLoadedCredentials(m.credUser)
 		}
 		return m, nil
 	case tea.KeyMsg:
-		switch msg.String() {
-		case "q", "esc":
-			m.mode = modeMain
-			m.registerStatus = ""
+		switch {
+		case m.registeringUser:
 			return m, nil
-		case "n":
-			if !m.registeringUser {
-				m.registeringUser = true
-				m.registerStatus = "Starting registration..."
-				return m, m.registerNewUser()
+		case m.confirm != nil:
+			return m.updateConfirm(msg)
+		case m.userForm != nil:
+			return m.updateUserForm(msg)
+		case m.credUser != nil:
+			return m.updateCredentials(msg)
+		case m.list.FilterState() == list.Filtering:
+			break
+		default:
+			return m.updateUserKeys(msg)
+		}
+	}
+
+	var cmd tea.Cmd
+	m.list, cmd = m.list.Update(msg)
+	return m, cmd
+}
+
+// updateUserKeys handles keys on the user list.
+func (m Model) updateUserKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	var u *users.User
+	if item, ok := m.list.SelectedItem().(userItem); ok {
+		u = item.user
+	}
+
+	switch msg.String() {
+	case "q", "esc":
+		m.mode = modeMain
+		m.registerStatus = ""
+		m.list.Title = m.mainTitle
+		return m.withItems(), nil
+	case "n":
+		m.registerStatus = ""
+		m.userForm = newUserForm(nil)
+		return m, textinput.Blink
+	case "e":
+		if u != nil {
+			m.registerStatus = ""
+			m.userForm = newUserForm(u)
+			return m, textinput.Blink
+		}
+		return m, nil
+	case "a":
+		if u != nil {
+			m.registeringUser = true
+			m.registerStatus = fmt.Sprintf("Touch the new YubiKey for %s...", u.ID)
+			return m, m.addCredential(u)
+		}
+		return m, nil
+	case "c":
+		if u != nil {
+			m.registerStatus = ""
+			return m.withLoadedCredentials(u), nil
+		}
+		return m, nil
+	case "d":
+		if u != nil {
+			return m, m.setUserDisabled(u, !u.Disabled)
+		}
+		return m, nil
+	case "u":
+		if u != nil {
+			return m, m.unlockUser(u)
+		}
+		return m, nil
+	case "X":
+		if u != nil {
+			m.confirm = &confirmation{
+				prompt: fmt.Sprintf("Delete user %s with their credentials and SSH keys?", u.ID),
+				run:    m.deleteUser(u),
 			}
 		}
+		return m, nil
 	}
 
 	var cmd tea.Cmd
@@ -659,11 +2094,247 @@ func (m Model) updateUsers(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, cmd
 }
 
+func (m Model) updateConfirm(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	run := m.confirm.run
+	m.confirm = nil
+	if msg.String() != "y" {
+		m.registerStatus = "Cancelled"
+		return m, nil
+	}
+	m.registerStatus = ""
+	return m, run
+}
+
+func newUserForm(u *users.User) *userForm {
+	f := &userForm{}
+	for _, placeholder := range []string{"alice", "alice, alice-admin", "operator"} {
+		in := textinput.New()
+		in.Placeholder = placeholder
+		in.CharLimit = 256
+		f.inputs = append(f.inputs, in)
+	}
+	if u != nil {
+		f.editing = u.ID
+		f.inputs[fieldUserID].SetValue(u.ID)
+		f.inputs[fieldSSHUsers].SetValue(strings.Join(u.SSHUsers, ", "))
+		f.inputs[fieldRoles].SetValue(strings.Join(u.Roles, ", "))
+		f.focus = fieldSSHUsers // the ID cannot change
+	}
+	f.inputs[f.focus].Focus()
+	return f
+}
+
+// move focuses the next (delta 1) or previous (delta -1) field, wrapping.
+func (f *userForm) move(delta int) {
+	first := fieldUserID
+	if f.editing != "" {
+		first = fieldSSHUsers
+	}
+	f.inputs[f.focus].Blur()
+	f.focus += delta
+	if f.focus < first {
+		f.focus = len(f.inputs) - 1
+	} else if f.focus >= len(f.inputs) {
+		f.focus = first
+	}
+	f.inputs[f.focus].Focus()
+}
+
+// user returns the user the form describes, checked against the config, the
+// other users in the store and the roles editor may hand out.
+func (f *userForm) user(cfg *config.Config, existing []*users.User, editor *auth.Principal) (*users.User, error) {
+	u := &users.User{
+		ID:       strings.TrimSpace(f.inputs[fieldUserID].Value()),
+		SSHUsers: splitList(f.inputs[fieldSSHUsers].Value()),
+		Roles:    splitList(f.inputs[fieldRoles].Value()),
+	}
+	switch {
+	case u.ID == "" || strings.ContainsAny(u.ID, " ,\"\\"):
+		return nil, fmt.Errorf("an ID without spaces, commas or quotes is required")
+	case len(u.SSHUsers) == 0:
+		return nil, fmt.Errorf("at least one SSH user is required")
+	case len(u.Roles) == 0:
+		return nil, fmt.Errorf("at least one role is required")
+	}
+
+	if f.editing == "" {
+		_, inConfig := cfg.FindUser(u.ID)
+		if inConfig || slices.ContainsFunc(existing, func(e *users.User) bool { return e.ID == u.ID }) {
+			return nil, fmt.Errorf("user %s already exists", u.ID)
+		}
+	}
+	roles := cfg.EffectiveRoles()
+	for _, name := range u.Roles {
+		if !slices.ContainsFunc(roles, func(r config.Role) bool { return r.Name == name }) {
+			return nil, fmt.Errorf("unknown role %q", name)
+		}
+	}
+	if err := checkRoleChange(editor, existing, f.editing, u); err != nil {
+		return nil, err
+	}
+	for _, su := range u.SSHUsers {
+		if owner := sshUserOwner(cfg, existing, su); owner != "" && owner != u.ID {
+			return nil, fmt.Errorf("SSH user %s already belongs to %s", su, owner)
+		}
+	}
+	return u, nil
+}
+
+// checkRoleChange refuses role changes editor could use to raise privileges:
+// changing their own roles, or granting or removing a role they do not hold
+// themselves. Roles the user already has may stay as they are.
+func checkRoleChange(editor *auth.Principal, existing []*users.User, editing string, u *users.User) error {
+	var before []string
+	if i := slices.IndexFunc(existing, func(e *users.User) bool { return e.ID == editing }); editing != "" && i >= 0 {
+		before = existing[i].Roles
+	}
+	var changed []string
+	for _, r := range u.Roles {
+		if !slices.Contains(before, r) {
+			changed = append(changed, r)
+		}
+	}
+	for _, r := range before {
+		if !slices.Contains(u.Roles, r) {
+			changed = append(changed, r)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	if editor == nil || editor.ConfigUser == nil {
+		return fmt.Errorf("cannot change roles without a signed-in user")
+	}
+	if u.ID == editor.ConfigUser.ID {
+		return fmt.Errorf("cannot change your own roles")
+	}
+	for _, r := range changed {
+		if !editor.HasRole(r) {
+			return fmt.Errorf("cannot grant or remove role %q, which you do not hold", r)
+		}
+	}
+	return nil
+}
+
+// splitList splits comma- or space-separated form input.
+func splitList(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' || r == '"' || r == '\\' })
+}
+
+// sshUserOwner returns the ID of the config or store user sshUser maps to.
+func sshUserOwner(cfg *config.Config, existing []*users.User, sshUser string) string {
+	for _, u := range cfg.Users {
+		if slices.Contains(u.SSHUsers, sshUser) {
+			return u.ID
+		}
+	}
+	for _, u := range existing {
+		if slices.Contains(u.SSHUsers, sshUser) {
+			return u.ID
+		}
+	}
+	return ""
+}
+
+func (m Model) updateUserForm(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	f := m.userForm
+	switch msg.String() {
+	case "esc":
+		m.userForm = nil
+		return m, nil
+	case "tab", "down":
+		f.move(1)
+		return m, nil
+	case "shift+tab", "up":
+		f.move(-1)
+		return m, nil
+	case "enter":
+		if f.focus < len(f.inputs)-1 {
+			f.move(1)
+			return m, nil
+		}
+		u, err := f.user(m.cfg, m.userList, m.principal)
+		if err != nil {
+			f.err = err.Error()
+			return m, nil
+		}
+		m.userForm = nil
+		if f.editing != "" {
+			return m, m.updateUser(u)
+		}
+		m.registeringUser = true
+		m.registerStatus = fmt.Sprintf("Touch the YubiKey for %s to register it...", u.ID)
+		return m, m.createUser(u)
+	}
+
+	var cmd tea.Cmd
+	f.inputs[f.focus], cmd = f.inputs[f.focus].Update(msg)
+	return m, cmd
+}
+
+func (m Model) withLoadedCredentials(u *users.User) Model {
+	m.credUser = u
+	m.credList = nil
+	if m.userStore != nil {
+		creds, err := m.userStore.GetCredentials(context.Background(), u.ID)
+		if err != nil {
+			m.registerStatus = fmt.Sprintf("Error loading credentials: %v", err)
+		}
+		m.credList = creds
+	}
+
+	rows := []table.Row{}
+	for _, c := range m.credList {
+		id := c.CredentialID
+		if len(id) > 20 {
+			id = id[:20] + "…"
+		}
+		rows = append(rows, table.Row{
+			fmt.Sprint(c.ID),
+			c.Name,
+			c.RPID,
+			id,
+			c.CreatedAt.Local().Format("2006-01-02 15:04"),
+		})
+	}
+	m.credTable.SetRows(rows)
+	return m
+}
+
+func (m Model) updateCredentials(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "q", "esc":
+		m.credUser = nil
+		m.registerStatus = ""
+		return m, nil
+	case "a":
+		m.registeringUser = true
+		m.registerStatus = fmt.Sprintf("Touch the new YubiKey for %s...", m.credUser.ID)
+		return m, m.addCredential(m.credUser)
+	case "x":
+		i := m.credTable.Cursor()
+		if i < 0 || i >= len(m.credList) {
+			return m, nil
+		}
+		c := m.credList[i]
+		prompt := fmt.Sprintf("Revoke credential #%d of %s?", c.ID, m.credUser.ID)
+		if len(m.credList) == 1 {
+			prompt += " It is their last one: YubiKey checks will fail until another is added."
+		}
+		m.confirm = &confirmation{prompt: prompt, run: m.revokeCredential(m.credUser, c)}
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.credTable, cmd = m.credTable.Update(msg)
+	return m, cmd
+}
+
 func (m Model) viewUsers() string {
-	s := "User Management (admin only)\n\n"
+	s := "User Management\n\n"
 
 	if m.registeringUser {
-		s += "Registering new user...\n"
 		s += "Please touch your YubiKey...\n\n"
 	}
 
@@ -671,70 +2342,197 @@ func (m Model) viewUsers() string {
 		s += m.registerStatus + "\n\n"
 	}
 
+	switch {
+	case m.userForm != nil:
+		return s + m.viewUserForm()
+	case m.credUser != nil:
+		s += fmt.Sprintf("Credentials of %s:\n\n", m.credUser.ID)
+		s += m.credTable.View() + "\n"
+		return s + m.userFooter("[a:add YubiKey] [x:revoke] [q/esc:back to users]")
+	}
+
 	s += m.list.View() + "\n"
-	s += "[n:register new user] [q/esc:return to main]\n"
+	s += m.viewGrants()
+	return s + m.userFooter("[n:new] [e:edit] [a:add YubiKey] [c:credentials] [d:disable/enable] [u:unlock] [X:delete] [q/esc:return to main]")
+}
+
+// userFooter shows the pending confirmation, or keys otherwise.
+func (m Model) userFooter(keys string) string {
+	if m.confirm != nil {
+		return m.confirm.prompt + " [y/N]\n"
+	}
+	return keys + "\n"
+}
+
+func (m Model) viewUserForm() string {
+	f := m.userForm
+	s := "New user (a YubiKey is registered on save)\n\n"
+	if f.editing != "" {
+		s = fmt.Sprintf("Edit user %s\n\n", f.editing)
+	}
+
+	labels := []string{"ID", "SSH users", "Roles"}
+	for i, in := range f.inputs {
+		if i == fieldUserID && f.editing != "" {
+			continue
+		}
+		s += fmt.Sprintf("%-10s %s\n", labels[i], in.View())
+	}
 
+	var roles []string
+	for _, r := range m.cfg.EffectiveRoles() {
+		roles = append(roles, r.Name)
+	}
+	s += fmt.Sprintf("\nSeparate values with commas. Roles: %s\n", strings.Join(roles, ", "))
+	if f.err != "" {
+		s += "\nError: " + f.err + "\n"
+	}
+	s += "\n[tab/↑/↓:move] [enter:next field / save] [esc:cancel]\n"
 	return s
 }
 
-func (m Model) registerNewUser() tea.Cmd {
-	return func() tea.Msg {
-		if m.userStore == nil {
-			return userRegistrationMsg{err: fmt.Errorf("user store not available")}
+// viewGrants lists pending and in-force elevation grants, including those of
+// config users, which the user list does not show.
+func (m Model) viewGrants() string {
+	now := time.Now()
+	s := ""
+	for _, g := range m.grantList {
+		switch {
+		case g.Status == users.GrantPending:
+			s += fmt.Sprintf("  #%d %s requests %s for %s: %q\n", g.ID, g.UserID, g.Role, g.Duration, g.Reason)
+		case g.InForce(now):
+			s += fmt.Sprintf("  #%d %s holds %s until %s (approved by %s): %q\n",
+				g.ID, g.UserID, g.Role, g.ExpiresAt.Local().Format(time.DateTime), g.DecidedBy, g.Reason)
 		}
+	}
+	if s == "" {
+		return ""
+	}
+	return "Elevation grants (lazyadmin elevate approve|deny|revoke <id>):\n" + s + "\n"
+}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-		defer cancel()
+// registrationRPID is the relying party of credentials registered from the
+// TUI, the default of lazyadmin-register.
+const registrationRPID = "lazyadmin.local"
 
-		// For now, we'll use a simple registration flow
-		// In a full implementation, you'd prompt for user ID, SSH users, and roles
-		// For this implementation, we'll use defaults and register the YubiKey
+// errUsersManage refuses user management without the users.manage permission.
+var errUsersManage = fmt.Errorf("user management requires the %s permission", config.PermUsersManage)
 
-		// Generate a temporary user ID (in real implementation, prompt for this)
-		userIDBytes := make([]byte, 8)
-		if _, err := rand.Read(userIDBytes); err != nil {
-			return userRegistrationMsg{err: fmt.Errorf("generate user ID: %w", err)}
+// userAction runs fn as a user management action: it re-checks
+// users.manage, audits opID with the outcome and reports status on success.
+func (m Model) userAction(opID, status string, fn func(ctx context.Context) error) tea.Cmd {
+	return func() tea.Msg {
+		var err error
+		switch {
+		case !m.principal.HasPermission(config.PermUsersManage):
+			err = errUsersManage
+		case m.userStore == nil:
+			err = fmt.Errorf("user store not available")
+		default:
+			ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+			defer cancel()
+			err = fn(ctx)
 		}
+		m.audit(opID, err)
+		return userActionMsg{status: status, err: err}
+	}
+}
 
-		// Use default RP ID from config
-		rpID := "lazyadmin.local"
-		if m.cfg.Auth.YubiKeyMode != "" {
-			// Could be configured per environment
-		}
+// registerCredential asks for a YubiKey touch and returns the new credential.
+// handle is the user's existing WebAuthn user handle; a new one is generated
+// when it is empty.
+func registerCredential(ctx context.Context, userID, handle string) (*users.Credential, error) {
+	handleBytes, handle, err := auth.UserHandle(handle)
+	if err != nil {
+		return nil, err
+	}
 
-		// Register the credential
-		result, err := auth.RegisterFIDO2Credential(ctx, rpID, "lazyadmin", "newuser", userIDBytes)
+	result, err := auth.RegisterFIDO2Credential(ctx, registrationRPID, "lazyadmin", userID, handleBytes)
+	if err != nil {
+		return nil, fmt.Errorf("register credential: %w", err)
+	}
+	return &users.Credential{
+		RPID:         registrationRPID,
+		CredentialID: result.CredentialID,
+		PublicKey:    result.PublicKey,
+		UserHandle:   handle,
+	}, nil
+}
+
+// createUser registers a YubiKey for u, then stores u with the credential.
+func (m Model) createUser(u *users.User) tea.Cmd {
+	opID := fmt.Sprintf("user:create %s ssh:%v roles:%v", u.ID, u.SSHUsers, u.Roles)
+	return m.userAction(opID, fmt.Sprintf("User %s registered", u.ID), func(ctx context.Context) error {
+		cred, err := registerCredential(ctx, u.ID, "")
 		if err != nil {
-			return userRegistrationMsg{err: fmt.Errorf("register credential: %w", err)}
+			return err
 		}
-
-		// Create user with default values (in production, prompt for these)
-		// For now, use a placeholder user ID
-		newUserID := fmt.Sprintf("user_%d", time.Now().Unix())
-		newUser := &users.User{
-			ID:       newUserID,
-			SSHUsers: []string{newUserID},   // In production, prompt for SSH username
-			Roles:    []string{"read_only"}, // Default role, admin can change later
+		if err := m.userStore.CreateUser(ctx, u); err != nil {
+			return fmt.Errorf("create user: %w", err)
 		}
-
-		// Create user in database
-		if err := m.userStore.CreateUser(ctx, newUser); err != nil {
-			return userRegistrationMsg{err: fmt.Errorf("create user: %w", err)}
+		if err := m.userStore.AddCredential(ctx, u.ID, cred); err != nil {
+			_ = m.userStore.DeleteUser(ctx, u.ID)
+			return fmt.Errorf("add credential: %w", err)
 		}
+		return nil
+	})
+}
 
-		// Add credential
-		cred := &users.Credential{
-			RPID:         rpID,
-			CredentialID: result.CredentialID,
-			PublicKey:    result.PublicKey,
-		}
+func (m Model) updateUser(u *users.User) tea.Cmd {
+	opID := fmt.Sprintf("user:update %s ssh:%v roles:%v", u.ID, u.SSHUsers, u.Roles)
+	return m.userAction(opID, fmt.Sprintf("User %s updated", u.ID), func(ctx context.Context) error {
+		return m.userStore.UpdateUser(ctx, u)
+	})
+}
 
-		if err := m.userStore.AddCredential(ctx, newUserID, cred); err != nil {
-			// Try to clean up user if credential add fails
-			_ = m.userStore.DeleteUser(ctx, newUserID)
-			return userRegistrationMsg{err: fmt.Errorf("add credential: %w", err)}
+func (m Model) addCredential(u *users.User) tea.Cmd {
+	opID := fmt.Sprintf("user:credential-add %s", u.ID)
+	return m.userAction(opID, fmt.Sprintf("YubiKey added to %s", u.ID), func(ctx context.Context) error {
+		handle, err := m.userStore.UserHandle(ctx, u.ID)
+		if err != nil {
+			return err
 		}
+		cred, err := registerCredential(ctx, u.ID, handle)
+		if err != nil {
+			return err
+		}
+		return m.userStore.AddCredential(ctx, u.ID, cred)
+	})
+}
 
-		return userRegistrationMsg{userID: newUserID}
+func (m Model) revokeCredential(u *users.User, c *users.Credential) tea.Cmd {
+	opID := fmt.Sprintf("user:credential-revoke %s #%d", u.ID, c.ID)
+	return m.userAction(opID, fmt.Sprintf("Credential #%d of %s revoked", c.ID, u.ID), func(ctx context.Context) error {
+		return m.userStore.RemoveCredential(ctx, u.ID, c.ID)
+	})
+}
+
+func (m Model) setUserDisabled(u *users.User, disabled bool) tea.Cmd {
+	action, status := "enable", fmt.Sprintf("User %s enabled", u.ID)
+	if disabled {
+		action, status = "disable", fmt.Sprintf("User %s disabled", u.ID)
 	}
+	return m.userAction(fmt.Sprintf("user:%s %s", action, u.ID), status, func(ctx context.Context) error {
+		if u.ID == m.principal.ConfigUser.ID {
+			return fmt.Errorf("cannot %s your own account", action)
+		}
+		return m.userStore.SetDisabled(ctx, u.ID, disabled)
+	})
+}
+
+// unlockUser lifts a sign-in lockout and clears the user's failed sign-ins.
+func (m Model) unlockUser(u *users.User) tea.Cmd {
+	return m.userAction(fmt.Sprintf("user:unlock %s", u.ID), fmt.Sprintf("User %s unlocked", u.ID), func(ctx context.Context) error {
+		_, err := m.userStore.ResetAuthFailures(ctx, u.ID)
+		return err
+	})
+}
+
+func (m Model) deleteUser(u *users.User) tea.Cmd {
+	return m.userAction(fmt.Sprintf("user:delete %s", u.ID), fmt.Sprintf("User %s deleted", u.ID), func(ctx context.Context) error {
+		if u.ID == m.principal.ConfigUser.ID {
+			return fmt.Errorf("cannot delete your own account")
+		}
+		return m.userStore.DeleteUser(ctx, u.ID)
+	})
 }
@@ -0,0 +1,162 @@
+package ui
+
+import (
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/you/lazyadmin/internal/auth"
+	"github.com/you/lazyadmin/internal/config"
+	"github.com/you/lazyadmin/internal/logging"
+	"github.com/you/lazyadmin/internal/users"
+)
+
+func TestModel_KeyAfterIdleTimeoutLocks(t *testing.T) {
+	logger, err := logging.NewAuditLogger(t.TempDir() + "/audit.db")
+	if err != nil {
+		t.Fatalf("NewAuditLogger() error = %v", err)
+	}
+	defer logger.Close()
+
+	cfg := &config.Config{
+		Auth:  config.AuthConfig{SessionIdleTimeout: 15 * time.Minute},
+		Users: []config.User{{ID: "alice", SSHUsers: []string{"alice"}, Roles: []string{"owner"}}},
+		Tasks: []config.Task{{ID: "restart", Label: "Restart", AllowedRoles: []string{"owner"}}},
+	}
+	alice := &auth.Principal{ConfigUser: &cfg.Users[0], SSHUser: "alice", RBAC: auth.NewRBAC(cfg)}
+	plan := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("d")}
+
+	m := NewModel(cfg, alice, logger, nil, nil, nil, nil, nil, nil)
+	m.viewTasks = true
+	m = m.withItems()
+
+	// Before the timeout the key plans the selected task.
+	next, _ := m.Update(plan)
+	if got := next.(Model); got.mode != modePlan || got.locked != "" {
+		t.Fatalf("Update() on a live session: mode = %v, locked = %q; want plan mode", got.mode, got.locked)
+	}
+
+	// After it, the first key locks the session instead of reaching the view,
+	// even though no tick has run yet.
+	m.session = auth.NewSession(cfg, time.Now().Add(-time.Hour))
+	next, _ = m.Update(plan)
+	got := next.(Model)
+	if got.locked != auth.LockIdle {
+		t.Errorf("Update() after idle timeout locked = %q, want %q", got.locked, auth.LockIdle)
+	}
+	if got.mode != modeMain || got.planTask != nil {
+		t.Errorf("Update() after idle timeout: mode = %v, planTask = %v; want nothing started", got.mode, got.planTask)
+	}
+
+	rows, err := logging.ReadRecent(logger, 10)
+	if err != nil {
+		t.Fatalf("ReadRecent() error = %v", err)
+	}
+	if len(rows) != 1 || rows[0].OperationID != "session:lock idle" {
+		t.Errorf("audit log = %+v, want one session:lock idle entry", rows)
+	}
+}
+
+func TestModel_TaskInputForm(t *testing.T) {
+	cfg := &config.Config{
+		Users: []config.User{{ID: "alice", SSHUsers: []string{"alice"}, Roles: []string{"owner"}}},
+		Tasks: []config.Task{{
+			ID:           "deploy",
+			AllowedRoles: []string{"owner"},
+			Inputs: []config.TaskInput{
+				{Name: "version", Label: "Version", Required: true},
+				{Name: "region", Default: "eu"},
+			},
+		}},
+	}
+	alice := &auth.Principal{ConfigUser: &cfg.Users[0], SSHUser: "alice", RBAC: auth.NewRBAC(cfg)}
+	key := func(s string) tea.KeyMsg {
+		switch s {
+		case "enter":
+			return tea.KeyMsg{Type: tea.KeyEnter}
+		case "tab":
+			return tea.KeyMsg{Type: tea.KeyTab}
+		}
+		return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(s)}
+	}
+	send := func(m Model, keys ...string) Model {
+		for _, k := range keys {
+			next, _ := m.Update(key(k))
+			m = next.(Model)
+		}
+		return m
+	}
+
+	m := NewModel(cfg, alice, nil, nil, nil, nil, nil, nil, nil)
+	m.viewTasks = true
+	m = m.withItems()
+
+	// Enter asks for the inputs instead of running the task without them.
+	m = send(m, "enter")
+	if m.inputForm == nil || m.inputForm.plan {
+		t.Fatalf("enter on a task with inputs: inputForm = %+v, want a run form", m.inputForm)
+	}
+
+	// A required input without a default must be filled in.
+	m = send(m, "tab", "enter")
+	if m.inputForm == nil || m.inputForm.err == "" {
+		t.Fatalf("submit without version: inputForm = %+v, want an error", m.inputForm)
+	}
+
+	m = send(m, "tab", "1.2", "tab")
+	values, err := m.inputForm.values()
+	if err != nil || len(values) != 1 || values["version"] != "1.2" {
+		t.Errorf("values() = %v, %v; want version only, region left to its default", values, err)
+	}
+	m = send(m, "enter")
+	if m.inputForm != nil {
+		t.Errorf("submit with version: form still open, err = %q", m.inputForm.err)
+	}
+
+	// d plans with the entered inputs.
+	m = send(m, "d", "2.0", "tab", "us", "enter")
+	if m.mode != modePlan || m.planInputs["version"] != "2.0" || m.planInputs["region"] != "us" {
+		t.Errorf("plan from form: mode = %v, planInputs = %v", m.mode, m.planInputs)
+	}
+}
+
+func TestUserForm_RoleChanges(t *testing.T) {
+	cfg := &config.Config{}
+	existing := []*users.User{
+		{ID: "erin", SSHUsers: []string{"erin"}, Roles: []string{"admin"}},
+		{ID: "carol", SSHUsers: []string{"carol"}, Roles: []string{"owner"}},
+		{ID: "bob", SSHUsers: []string{"bob"}, Roles: []string{"read_only"}},
+	}
+	erin := &auth.Principal{ConfigUser: &config.User{ID: "erin", Roles: []string{"admin"}}, SSHUser: "erin", RBAC: auth.NewRBAC(cfg)}
+	form := func(u *users.User, id, sshUsers, roles string) *userForm {
+		f := newUserForm(u)
+		f.inputs[fieldUserID].SetValue(id)
+		f.inputs[fieldSSHUsers].SetValue(sshUsers)
+		f.inputs[fieldRoles].SetValue(roles)
+		return f
+	}
+
+	tests := []struct {
+		name    string
+		form    *userForm
+		wantErr bool
+	}{
+		{"create with a held role", form(nil, "dave", "dave", "operator"), false},
+		{"create with a role not held", form(nil, "dave", "dave", "owner"), true},
+		{"promote to a held role", form(existing[2], "bob", "bob", "admin"), false},
+		{"promote to a role not held", form(existing[2], "bob", "bob", "owner"), true},
+		{"edit a higher user without touching roles", form(existing[1], "carol", "carol, carol2", "owner"), false},
+		{"demote a higher user", form(existing[1], "carol", "carol", "operator"), true},
+		{"change own roles", form(existing[0], "erin", "erin", "admin, operator"), true},
+		{"edit own SSH users", form(existing[0], "erin", "erin, erin2", "admin"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.form.user(cfg, existing, erin)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("user() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
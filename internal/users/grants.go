@@ -0,0 +1,259 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrGrantNotFound = errors.New("grant not found")
+	ErrGrantState    = errors.New("grant is not in a state that allows this")
+)
+
+// GrantStatus is the lifecycle state of a role elevation grant.
+type GrantStatus string
+
+const (
+	GrantPending GrantStatus = "pending" // requested, waiting for an approver
+	GrantActive  GrantStatus = "active"  // approved; in force until ExpiresAt
+	GrantDenied  GrantStatus = "denied"
+	GrantRevoked GrantStatus = "revoked"
+	GrantExpired GrantStatus = "expired"
+)
+
+// Grant is a time-bound role elevation for a user.
+type Grant struct {
+	ID          int64
+	UserID      string
+	Role        string
+	Reason      string
+	Duration    time.Duration // requested; the grant expires this long after approval
+	Status      GrantStatus
+	RequestedAt time.Time
+	DecidedBy   string // approver or denier; "auto" for auto-approved grants
+	DecidedAt   time.Time
+	ExpiresAt   time.Time
+	RevokedBy   string
+	RevokedAt   time.Time
+}
+
+// InForce reports whether the grant confers its role at t.
+func (g Grant) InForce(t time.Time) bool {
+	return g.Status == GrantActive && t.Before(g.ExpiresAt)
+}
+
+const grantColumns = `id, user_id, role, reason, duration_ms, status, requested_at,
+	decided_by, decided_at, expires_at, revoked_by, revoked_at`
+
+// CreateGrant records a new elevation request. g.ID and g.RequestedAt are set.
+func (s *Store) CreateGrant(ctx context.Context, g *Grant) error {
+	if g.RequestedAt.IsZero() {
+		g.RequestedAt = time.Now()
+	}
+	if g.Status == "" {
+		g.Status = GrantPending
+	}
+
+	res, err := s.db.ExecContext(ctx,
+		`INSERT INTO role_grants (user_id, role, reason, duration_ms, status, requested_at,
+		   decided_by, decided_at, expires_at, revoked_by, revoked_at)
+		 VALUES (?, ?, ?, ?, ?, ?, '', '', '', '', '')`,
+		g.UserID, g.Role, g.Reason, g.Duration.Milliseconds(), string(g.Status), formatTime(g.RequestedAt),
+	)
+	if err != nil {
+		return fmt.Errorf("create grant: %w", err)
+	}
+	g.ID, err = res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("create grant: %w", err)
+	}
+	return nil
+}
+
+// GetGrant retrieves a grant by ID.
+func (s *Store) GetGrant(ctx context.Context, id int64) (*Grant, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+grantColumns+` FROM role_grants WHERE id = ?`, id)
+	g, err := scanGrant(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrGrantNotFound
+		}
+		return nil, fmt.Errorf("get grant: %w", err)
+	}
+	return g, nil
+}
+
+// ListGrants returns pending and active grants, followed by the most recent
+// others, newest first, up to limit in total.
+func (s *Store) ListGrants(ctx context.Context, limit int) ([]*Grant, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+grantColumns+` FROM role_grants
+		 ORDER BY status NOT IN ('pending', 'active'), id DESC
+		 LIMIT ?`,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list grants: %w", err)
+	}
+	defer rows.Close()
+	return scanGrants(rows)
+}
+
+// ActiveGrants returns the user's grants that are in force at t.
+func (s *Store) ActiveGrants(ctx context.Context, userID string, t time.Time) ([]Grant, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+grantColumns+` FROM role_grants WHERE user_id = ? AND status = ? ORDER BY id`,
+		userID, string(GrantActive),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("active grants: %w", err)
+	}
+	defer rows.Close()
+
+	grants, err := scanGrants(rows)
+	if err != nil {
+		return nil, err
+	}
+	var out []Grant
+	for _, g := range grants {
+		if g.InForce(t) {
+			out = append(out, *g)
+		}
+	}
+	return out, nil
+}
+
+// ApproveGrant activates a pending grant, starting its duration at t.
+func (s *Store) ApproveGrant(ctx context.Context, id int64, approver string, t time.Time) (*Grant, error) {
+	g, err := s.GetGrant(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	expires := t.Add(g.Duration)
+	if err := s.transitionGrant(ctx, id, GrantPending,
+		`status = ?, decided_by = ?, decided_at = ?, expires_at = ?`,
+		string(GrantActive), approver, formatTime(t), formatTime(expires),
+	); err != nil {
+		return nil, err
+	}
+	return s.GetGrant(ctx, id)
+}
+
+// DenyGrant rejects a pending grant.
+func (s *Store) DenyGrant(ctx context.Context, id int64, approver string, t time.Time) (*Grant, error) {
+	if err := s.transitionGrant(ctx, id, GrantPending,
+		`status = ?, decided_by = ?, decided_at = ?`,
+		string(GrantDenied), approver, formatTime(t),
+	); err != nil {
+		return nil, err
+	}
+	return s.GetGrant(ctx, id)
+}
+
+// RevokeGrant ends an active grant before it expires.
+func (s *Store) RevokeGrant(ctx context.Context, id int64, by string, t time.Time) (*Grant, error) {
+	if err := s.transitionGrant(ctx, id, GrantActive,
+		`status = ?, revoked_by = ?, revoked_at = ?`,
+		string(GrantRevoked), by, formatTime(t),
+	); err != nil {
+		return nil, err
+	}
+	return s.GetGrant(ctx, id)
+}
+
+// ExpireGrants marks active grants whose expiry is at or before t as expired
+// and returns them.
+func (s *Store) ExpireGrants(ctx context.Context, t time.Time) ([]*Grant, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+grantColumns+` FROM role_grants WHERE status = ? ORDER BY id`,
+		string(GrantActive),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("expire grants: %w", err)
+	}
+	grants, err := scanGrants(rows)
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []*Grant
+	for _, g := range grants {
+		if g.InForce(t) {
+			continue
+		}
+		err := s.transitionGrant(ctx, g.ID, GrantActive, `status = ?`, string(GrantExpired))
+		if errors.Is(err, ErrGrantState) {
+			continue // revoked or expired concurrently
+		}
+		if err != nil {
+			return expired, err
+		}
+		g.Status = GrantExpired
+		expired = append(expired, g)
+	}
+	return expired, nil
+}
+
+// transitionGrant applies set to grant id if it is still in state from.
+func (s *Store) transitionGrant(ctx context.Context, id int64, from GrantStatus, set string, args ...any) error {
+	args = append(args, id, string(from))
+	res, err := s.db.ExecContext(ctx, `UPDATE role_grants SET `+set+` WHERE id = ? AND status = ?`, args...)
+	if err != nil {
+		return fmt.Errorf("update grant: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("update grant: %w", err)
+	}
+	if n == 0 {
+		if _, err := s.GetGrant(ctx, id); err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: grant %d is not %s", ErrGrantState, id, from)
+	}
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanGrant(row rowScanner) (*Grant, error) {
+	var (
+		g                                            Grant
+		durationMS                                   int64
+		status                                       string
+		requestedAt, decidedAt, expiresAt, revokedAt string
+	)
+	if err := row.Scan(&g.ID, &g.UserID, &g.Role, &g.Reason, &durationMS, &status, &requestedAt,
+		&g.DecidedBy, &decidedAt, &expiresAt, &g.RevokedBy, &revokedAt); err != nil {
+		return nil, err
+	}
+	g.Duration = time.Duration(durationMS) * time.Millisecond
+	g.Status = GrantStatus(status)
+	g.RequestedAt, _ = time.Parse(time.RFC3339Nano, requestedAt)
+	g.DecidedAt, _ = time.Parse(time.RFC3339Nano, decidedAt)
+	g.ExpiresAt, _ = time.Parse(time.RFC3339Nano, expiresAt)
+	g.RevokedAt, _ = time.Parse(time.RFC3339Nano, revokedAt)
+	return &g, nil
+}
+
+func scanGrants(rows *sql.Rows) ([]*Grant, error) {
+	var grants []*Grant
+	for rows.Next() {
+		g, err := scanGrant(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan grant: %w", err)
+		}
+		grants = append(grants, g)
+	}
+	return grants, rows.Err()
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,127 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrLockedOut = errors.New("account locked after repeated failed sign-ins")
+
+// AuthFailures is a user's run of consecutive failed sign-ins.
+type AuthFailures struct {
+	UserID        string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   time.Time // zero unless a lockout was imposed
+}
+
+// Locked reports whether the user is locked out at t.
+func (f AuthFailures) Locked(t time.Time) bool {
+	return t.Before(f.LockedUntil)
+}
+
+const authFailureColumns = `user_id, failures, last_failure_at, locked_until`
+
+// RecordAuthFailure counts a failed sign-in of userID at t. When the run
+// reaches maxFailures (if positive), the user is locked out until t+lockout;
+// each further failure extends the lockout until the count is reset.
+func (s *Store) RecordAuthFailure(ctx context.Context, userID string, t time.Time, maxFailures int, lockout time.Duration) (*AuthFailures, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("record auth failure: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO auth_failures (user_id, failures, last_failure_at, locked_until)
+		 VALUES (?, 1, ?, '')
+		 ON CONFLICT(user_id) DO UPDATE SET failures = failures + 1, last_failure_at = excluded.last_failure_at`,
+		userID, formatTime(t),
+	); err != nil {
+		return nil, fmt.Errorf("record auth failure: %w", err)
+	}
+	f, err := scanAuthFailures(tx.QueryRowContext(ctx,
+		`SELECT `+authFailureColumns+` FROM auth_failures WHERE user_id = ?`, userID))
+	if err != nil {
+		return nil, fmt.Errorf("record auth failure: %w", err)
+	}
+	if maxFailures > 0 && f.Failures >= maxFailures {
+		f.LockedUntil = t.Add(lockout)
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE auth_failures SET locked_until = ? WHERE user_id = ?`,
+			formatTime(f.LockedUntil), userID,
+		); err != nil {
+			return nil, fmt.Errorf("record auth failure: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("record auth failure: %w", err)
+	}
+	return f, nil
+}
+
+// GetAuthFailures returns the user's current run of failed sign-ins, with
+// zero failures if there is none.
+func (s *Store) GetAuthFailures(ctx context.Context, userID string) (*AuthFailures, error) {
+	f, err := scanAuthFailures(s.db.QueryRowContext(ctx,
+		`SELECT `+authFailureColumns+` FROM auth_failures WHERE user_id = ?`, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return &AuthFailures{UserID: userID}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get auth failures: %w", err)
+	}
+	return f, nil
+}
+
+// ListAuthFailures returns every user with failed sign-ins since their last
+// success or unlock.
+func (s *Store) ListAuthFailures(ctx context.Context) ([]*AuthFailures, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+authFailureColumns+` FROM auth_failures ORDER BY user_id`)
+	if err != nil {
+		return nil, fmt.Errorf("list auth failures: %w", err)
+	}
+	defer rows.Close()
+
+	var list []*AuthFailures
+	for rows.Next() {
+		f, err := scanAuthFailures(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan auth failures: %w", err)
+		}
+		list = append(list, f)
+	}
+	return list, rows.Err()
+}
+
+// ResetAuthFailures clears the user's failure count and any lockout, after
+// a successful sign-in or an administrator's unlock. It reports whether
+// there was anything to clear.
+func (s *Store) ResetAuthFailures(ctx context.Context, userID string) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM auth_failures WHERE user_id = ?`, userID)
+	if err != nil {
+		return false, fmt.Errorf("reset auth failures: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("reset auth failures: %w", err)
+	}
+	return n > 0, nil
+}
+
+func scanAuthFailures(row rowScanner) (*AuthFailures, error) {
+	var (
+		f                        AuthFailures
+		lastFailure, lockedUntil string
+	)
+	if err := row.Scan(&f.UserID, &f.Failures, &lastFailure, &lockedUntil); err != nil {
+		return nil, err
+	}
+	f.LastFailureAt, _ = time.Parse(time.RFC3339Nano, lastFailure)
+	f.LockedUntil, _ = time.Parse(time.RFC3339Nano, lockedUntil)
+	return &f, nil
+}
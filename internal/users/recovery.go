@@ -0,0 +1,118 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrRecoveryCodeInvalid = errors.New("invalid or already used recovery code")
+
+// hashRecoveryCode returns the stored form of a break-glass recovery code:
+// the hex SHA-256 of salt and the normalized code. Case, spaces and dashes
+// are ignored so codes can be typed as printed.
+func hashRecoveryCode(salt, code string) string {
+	normalized := strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(code))
+	sum := sha256.Sum256([]byte(salt + normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// ReplaceRecoveryCodes discards the user's recovery codes, used or not, and
+// stores the given codes in their place, each hashed with its own random
+// salt.
+func (s *Store) ReplaceRecoveryCodes(ctx context.Context, userID string, codes []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("replace recovery codes: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("replace recovery codes: %w", err)
+	}
+	now := formatTime(time.Now())
+	buf := make([]byte, 16)
+	for _, c := range codes {
+		if _, err := rand.Read(buf); err != nil {
+			return fmt.Errorf("replace recovery codes: %w", err)
+		}
+		salt := hex.EncodeToString(buf)
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO recovery_codes (hash, salt, user_id, created_at, used_at) VALUES (?, ?, ?, ?, '')`,
+			hashRecoveryCode(salt, c), salt, userID, now,
+		); err != nil {
+			return fmt.Errorf("replace recovery codes: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// UseRecoveryCode marks the user's unused recovery code matching code as
+// used at t. Each code works once.
+func (s *Store) UseRecoveryCode(ctx context.Context, userID, code string, t time.Time) error {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT hash, salt FROM recovery_codes WHERE user_id = ? AND used_at = ''`, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("use recovery code: %w", err)
+	}
+	var match string
+	for rows.Next() {
+		var hash, salt string
+		if err := rows.Scan(&hash, &salt); err != nil {
+			rows.Close()
+			return fmt.Errorf("use recovery code: %w", err)
+		}
+		if subtle.ConstantTimeCompare([]byte(hashRecoveryCode(salt, code)), []byte(hash)) == 1 {
+			match = hash
+		}
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return fmt.Errorf("use recovery code: %w", err)
+	}
+	if match == "" {
+		return ErrRecoveryCodeInvalid
+	}
+
+	// A concurrent use of the same code leaves nothing to update.
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE recovery_codes SET used_at = ? WHERE hash = ? AND user_id = ? AND used_at = ''`,
+		formatTime(t), match, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("use recovery code: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("use recovery code: %w", err)
+	}
+	if n == 0 {
+		return ErrRecoveryCodeInvalid
+	}
+	return nil
+}
+
+// UnusedRecoveryCodes returns how many of the user's recovery codes are left.
+func (s *Store) UnusedRecoveryCodes(ctx context.Context, userID string) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at = ''`, userID,
+	).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("count recovery codes: %w", err)
+	}
+	return n, nil
+}
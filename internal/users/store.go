@@ -8,11 +8,15 @@ import (
 	"time"
 
 	_ "github.com/glebarez/sqlite"
+
+	"github.com/you/lazyadmin/internal/migrate"
 )
 
 var (
-	ErrUserNotFound = errors.New("user not found")
-	ErrUserExists   = errors.New("user already exists")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrUserExists         = errors.New("user already exists")
+	ErrCredentialNotFound = errors.New("credential not found")
+	ErrCredentialExists   = errors.New("credential already registered")
 )
 
 // User represents a user stored in SQLite.
@@ -20,6 +24,7 @@ type User struct {
 	ID        string
 	SSHUsers  []string
 	Roles     []string
+	Disabled  bool // disabled users cannot sign in
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
@@ -31,6 +36,8 @@ type Credential struct {
 	RPID         string
 	CredentialID string // Base64URL-encoded
 	PublicKey    string // Base64URL-encoded SPKI
+	Name         string // e.g. "blue backup key"; unique per user when set
+	UserHandle   string // Base64URL-encoded WebAuthn user handle used at registration
 	CreatedAt    time.Time
 }
 
@@ -47,42 +54,12 @@ func NewStore(sqlitePath string) (*Store, error) {
 		return nil, fmt.Errorf("open sqlite: %w", err)
 	}
 
-	store := &Store{db: db}
-	if err := store.initSchema(); err != nil {
+	if _, err := migrate.Up(context.Background(), db, sqlitePath); err != nil {
 		db.Close()
 		return nil, fmt.Errorf("init schema: %w", err)
 	}
 
-	return store, nil
-}
-
-func (s *Store) initSchema() error {
-	schema := `
-CREATE TABLE IF NOT EXISTS users (
-  id TEXT PRIMARY KEY,
-  ssh_users TEXT NOT NULL, -- JSON array
-  roles TEXT NOT NULL,     -- JSON array
-  created_at TEXT NOT NULL,
-  updated_at TEXT NOT NULL
-);
-
-CREATE TABLE IF NOT EXISTS credentials (
-  id INTEGER PRIMARY KEY AUTOINCREMENT,
-  user_id TEXT NOT NULL,
-  rp_id TEXT NOT NULL,
-  credential_id TEXT NOT NULL,
-  public_key TEXT NOT NULL,
-  created_at TEXT NOT NULL,
-  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
-  UNIQUE(user_id, rp_id, credential_id)
-);
-
-CREATE INDEX IF NOT EXISTS idx_credentials_user_id ON credentials(user_id);
-CREATE INDEX IF NOT EXISTS idx_credentials_rp_id ON credentials(rp_id);
-`
-
-	_, err := s.db.Exec(schema)
-	return err
+	return &Store{db: db}, nil
 }
 
 // Close closes the database connection.
@@ -118,77 +95,50 @@ func (s *Store) CreateUser(ctx context.Context, user *User) error {
 	return nil
 }
 
-// GetUser retrieves a user by ID.
-func (s *Store) GetUser(ctx context.Context, userID string) (*User, error) {
+const userColumns = `id, ssh_users, roles, disabled, created_at, updated_at`
+
+func scanUser(row rowScanner) (*User, error) {
 	var (
-		id        string
-		sshUsers  string
-		roles     string
-		createdAt string
-		updatedAt string
+		u                    User
+		sshUsers, roles      string
+		disabled             int
+		createdAt, updatedAt string
 	)
+	if err := row.Scan(&u.ID, &sshUsers, &roles, &disabled, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+	u.SSHUsers = unmarshalStringArray(sshUsers)
+	u.Roles = unmarshalStringArray(roles)
+	u.Disabled = disabled != 0
+	u.CreatedAt, _ = time.Parse(time.RFC3339Nano, createdAt)
+	u.UpdatedAt, _ = time.Parse(time.RFC3339Nano, updatedAt)
+	return &u, nil
+}
 
-	err := s.db.QueryRowContext(ctx,
-		`SELECT id, ssh_users, roles, created_at, updated_at
-		 FROM users WHERE id = ?`,
-		userID,
-	).Scan(&id, &sshUsers, &roles, &createdAt, &updatedAt)
+// GetUser retrieves a user by ID.
+func (s *Store) GetUser(ctx context.Context, userID string) (*User, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE id = ?`, userID)
+	u, err := scanUser(row)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("get user: %w", err)
 	}
-
-	createdAtTime, _ := time.Parse(time.RFC3339Nano, createdAt)
-	updatedAtTime, _ := time.Parse(time.RFC3339Nano, updatedAt)
-
-	return &User{
-		ID:        id,
-		SSHUsers:  unmarshalStringArray(sshUsers),
-		Roles:     unmarshalStringArray(roles),
-		CreatedAt: createdAtTime,
-		UpdatedAt: updatedAtTime,
-	}, nil
+	return u, nil
 }
 
 // FindUserBySSHUser finds a user by SSH username.
 func (s *Store) FindUserBySSHUser(ctx context.Context, sshUser string) (*User, error) {
-	rows, err := s.db.QueryContext(ctx,
-		`SELECT id, ssh_users, roles, created_at, updated_at
-		 FROM users`,
-	)
+	users, err := s.ListUsers(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("query users: %w", err)
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var (
-			id        string
-			sshUsers  string
-			roles     string
-			createdAt string
-			updatedAt string
-		)
 
-		if err := rows.Scan(&id, &sshUsers, &roles, &createdAt, &updatedAt); err != nil {
-			continue
-		}
-
-		userSSHUsers := unmarshalStringArray(sshUsers)
-		for _, su := range userSSHUsers {
+	for _, u := range users {
+		for _, su := range u.SSHUsers {
 			if su == sshUser {
-				createdAtTime, _ := time.Parse(time.RFC3339Nano, createdAt)
-				updatedAtTime, _ := time.Parse(time.RFC3339Nano, updatedAt)
-
-				return &User{
-					ID:        id,
-					SSHUsers:  userSSHUsers,
-					Roles:     unmarshalStringArray(roles),
-					CreatedAt: createdAtTime,
-					UpdatedAt: updatedAtTime,
-				}, nil
+				return u, nil
 			}
 		}
 	}
@@ -198,10 +148,7 @@ func (s *Store) FindUserBySSHUser(ctx context.Context, sshUser string) (*User, e
 
 // ListUsers returns all users.
 func (s *Store) ListUsers(ctx context.Context) ([]*User, error) {
-	rows, err := s.db.QueryContext(ctx,
-		`SELECT id, ssh_users, roles, created_at, updated_at
-		 FROM users ORDER BY id`,
-	)
+	rows, err := s.db.QueryContext(ctx, `SELECT `+userColumns+` FROM users ORDER BY id`)
 	if err != nil {
 		return nil, fmt.Errorf("list users: %w", err)
 	}
@@ -209,58 +156,135 @@ func (s *Store) ListUsers(ctx context.Context) ([]*User, error) {
 
 	var users []*User
 	for rows.Next() {
-		var (
-			id        string
-			sshUsers  string
-			roles     string
-			createdAt string
-			updatedAt string
-		)
-
-		if err := rows.Scan(&id, &sshUsers, &roles, &createdAt, &updatedAt); err != nil {
+		u, err := scanUser(rows)
+		if err != nil {
 			continue
 		}
-
-		createdAtTime, _ := time.Parse(time.RFC3339Nano, createdAt)
-		updatedAtTime, _ := time.Parse(time.RFC3339Nano, updatedAt)
-
-		users = append(users, &User{
-			ID:        id,
-			SSHUsers:  unmarshalStringArray(sshUsers),
-			Roles:     unmarshalStringArray(roles),
-			CreatedAt: createdAtTime,
-			UpdatedAt: updatedAtTime,
-		})
+		users = append(users, u)
 	}
 
 	return users, nil
 }
 
-// AddCredential adds a FIDO2 credential to a user.
+// UpdateUser replaces a user's SSH usernames and roles.
+func (s *Store) UpdateUser(ctx context.Context, user *User) error {
+	user.UpdatedAt = time.Now().UTC()
+
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE users SET ssh_users = ?, roles = ?, updated_at = ? WHERE id = ?`,
+		marshalStringArray(user.SSHUsers), marshalStringArray(user.Roles),
+		user.UpdatedAt.Format(time.RFC3339Nano), user.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("update user: %w", err)
+	}
+	return requireRow(res, ErrUserNotFound)
+}
+
+// SetDisabled disables or re-enables a user. Credentials, SSH keys and roles
+// are kept.
+func (s *Store) SetDisabled(ctx context.Context, userID string, disabled bool) error {
+	flag := 0
+	if disabled {
+		flag = 1
+	}
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE users SET disabled = ?, updated_at = ? WHERE id = ?`,
+		flag, time.Now().UTC().Format(time.RFC3339Nano), userID,
+	)
+	if err != nil {
+		return fmt.Errorf("set disabled: %w", err)
+	}
+	return requireRow(res, ErrUserNotFound)
+}
+
+// requireRow returns notFound if res affected no rows.
+func requireRow(res sql.Result, notFound error) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notFound
+	}
+	return nil
+}
+
+// AddCredential adds a FIDO2 credential to a user. It returns
+// ErrCredentialExists if the credential is registered to any user, or if
+// the user already has a credential with the same name.
 func (s *Store) AddCredential(ctx context.Context, userID string, cred *Credential) error {
+	if err := s.CheckCredential(ctx, userID, cred); err != nil {
+		return err
+	}
+
 	now := time.Now().UTC()
 	cred.UserID = userID
 	cred.CreatedAt = now
 
-	_, err := s.db.ExecContext(ctx,
-		`INSERT INTO credentials (user_id, rp_id, credential_id, public_key, created_at)
-		 VALUES (?, ?, ?, ?, ?)`,
-		cred.UserID, cred.RPID, cred.CredentialID, cred.PublicKey,
+	res, err := s.db.ExecContext(ctx,
+		`INSERT INTO credentials (user_id, rp_id, credential_id, public_key, name, user_handle, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		cred.UserID, cred.RPID, cred.CredentialID, cred.PublicKey, cred.Name, cred.UserHandle,
 		cred.CreatedAt.Format(time.RFC3339Nano),
 	)
 	if err != nil {
 		if isUniqueConstraintError(err) {
-			return fmt.Errorf("credential already exists")
+			return ErrCredentialExists
 		}
 		return fmt.Errorf("add credential: %w", err)
 	}
+	cred.ID, _ = res.LastInsertId()
 	return nil
 }
 
+// CheckCredential returns ErrCredentialExists if cred could not be added to
+// the user: its credential ID is registered to anyone, or its name is taken
+// by another of the user's credentials. Only the name is checked when the
+// credential ID is empty, so the check can run before a YubiKey touch.
+func (s *Store) CheckCredential(ctx context.Context, userID string, cred *Credential) error {
+	var owner string
+	err := s.db.QueryRowContext(ctx,
+		`SELECT user_id FROM credentials
+		 WHERE (rp_id = ? AND credential_id = ? AND credential_id != '')
+		    OR (user_id = ? AND name = ? AND name != '')
+		 LIMIT 1`,
+		cred.RPID, cred.CredentialID, userID, cred.Name,
+	).Scan(&owner)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil
+	case err != nil:
+		return fmt.Errorf("check credential: %w", err)
+	case owner == userID && cred.Name != "":
+		return fmt.Errorf("%w: %s already has this credential or one named %q", ErrCredentialExists, userID, cred.Name)
+	default:
+		return fmt.Errorf("%w to %s", ErrCredentialExists, owner)
+	}
+}
+
+// UserHandle returns the WebAuthn user handle of the user's existing
+// credentials, so new registrations for the same user reuse it, or "" if
+// none was recorded.
+func (s *Store) UserHandle(ctx context.Context, userID string) (string, error) {
+	var handle string
+	err := s.db.QueryRowContext(ctx,
+		`SELECT user_handle FROM credentials WHERE user_id = ? AND user_handle != '' ORDER BY id LIMIT 1`,
+		userID,
+	).Scan(&handle)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("user handle: %w", err)
+	}
+	return handle, nil
+}
+
 // GetCredentials returns all credentials for a user.
 func (s *Store) GetCredentials(ctx context.Context, userID string) ([]*Credential, error) {
 	rows, err := s.db.QueryContext(ctx,
-		`SELECT id, user_id, rp_id, credential_id, public_key, created_at
+		`SELECT id, user_id, rp_id, credential_id, public_key, name, user_handle, created_at
 		 FROM credentials WHERE user_id = ? ORDER BY created_at`,
 		userID,
 	)
@@ -277,10 +301,12 @@ func (s *Store) GetCredentials(ctx context.Context, userID string) ([]*Credentia
 			rpID         string
 			credentialID string
 			publicKey    string
+			name         string
+			userHandle   string
 			createdAt    string
 		)
 
-		if err := rows.Scan(&id, &uid, &rpID, &credentialID, &publicKey, &createdAt); err != nil {
+		if err := rows.Scan(&id, &uid, &rpID, &credentialID, &publicKey, &name, &userHandle, &createdAt); err != nil {
 			continue
 		}
 
@@ -292,6 +318,8 @@ func (s *Store) GetCredentials(ctx context.Context, userID string) ([]*Credentia
 			RPID:         rpID,
 			CredentialID: credentialID,
 			PublicKey:    publicKey,
+			Name:         name,
+			UserHandle:   userHandle,
 			CreatedAt:    createdAtTime,
 		})
 	}
@@ -299,13 +327,92 @@ func (s *Store) GetCredentials(ctx context.Context, userID string) ([]*Credentia
 	return creds, nil
 }
 
-// DeleteUser deletes a user and all their credentials.
+// RemoveCredential deletes one of a user's FIDO2 credentials by its ID.
+func (s *Store) RemoveCredential(ctx context.Context, userID string, id int64) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM credentials WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return fmt.Errorf("remove credential: %w", err)
+	}
+	return requireRow(res, ErrCredentialNotFound)
+}
+
+// AddSSHKey maps an SSH public key fingerprint to a user for bastion mode.
+// A fingerprint belongs to at most one user.
+func (s *Store) AddSSHKey(ctx context.Context, userID, fingerprint string) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO ssh_keys (fingerprint, user_id, created_at) VALUES (?, ?, ?)`,
+		fingerprint, userID, time.Now().UTC().Format(time.RFC3339Nano),
+	)
+	if err != nil {
+		if isUniqueConstraintError(err) {
+			return fmt.Errorf("ssh key %s already registered", fingerprint)
+		}
+		return fmt.Errorf("add ssh key: %w", err)
+	}
+	return nil
+}
+
+// GetSSHKeys returns the SSH key fingerprints mapped to a user.
+func (s *Store) GetSSHKeys(ctx context.Context, userID string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT fingerprint FROM ssh_keys WHERE user_id = ? ORDER BY created_at`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get ssh keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var fp string
+		if err := rows.Scan(&fp); err != nil {
+			continue
+		}
+		keys = append(keys, fp)
+	}
+	return keys, nil
+}
+
+// FindUserBySSHKey finds the user an SSH public key fingerprint is mapped to.
+func (s *Store) FindUserBySSHKey(ctx context.Context, fingerprint string) (*User, error) {
+	var userID string
+	err := s.db.QueryRowContext(ctx,
+		`SELECT user_id FROM ssh_keys WHERE fingerprint = ?`,
+		fingerprint,
+	).Scan(&userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("find user by ssh key: %w", err)
+	}
+	return s.GetUser(ctx, userID)
+}
+
+// DeleteUser deletes a user with their credentials, SSH keys and recovery
+// codes. Elevation grants are kept as history. Foreign keys are not enforced
+// by SQLite unless enabled, so dependent rows are deleted explicitly.
 func (s *Store) DeleteUser(ctx context.Context, userID string) error {
-	_, err := s.db.ExecContext(ctx, `DELETE FROM users WHERE id = ?`, userID)
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("delete user: %w", err)
 	}
-	return nil
+	defer tx.Rollback()
+
+	for _, table := range []string{"credentials", "ssh_keys", "recovery_codes"} {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE user_id = ?`, userID); err != nil {
+			return fmt.Errorf("delete user: %w", err)
+		}
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = ?`, userID)
+	if err != nil {
+		return fmt.Errorf("delete user: %w", err)
+	}
+	if err := requireRow(res, ErrUserNotFound); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // Helper functions for JSON array marshaling (simple implementation)
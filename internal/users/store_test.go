@@ -0,0 +1,286 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	store, err := NewStore(t.TempDir() + "/users.db")
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestStore_UserLifecycle(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+
+	if err := store.CreateUser(ctx, &User{ID: "erin", SSHUsers: []string{"erin"}, Roles: []string{"operator"}}); err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+	if err := store.CreateUser(ctx, &User{ID: "erin"}); !errors.Is(err, ErrUserExists) {
+		t.Errorf("CreateUser(duplicate) error = %v, want ErrUserExists", err)
+	}
+
+	if err := store.UpdateUser(ctx, &User{ID: "erin", SSHUsers: []string{"erin", "erin-adm"}, Roles: []string{"admin"}}); err != nil {
+		t.Fatalf("UpdateUser() error = %v", err)
+	}
+	if err := store.SetDisabled(ctx, "erin", true); err != nil {
+		t.Fatalf("SetDisabled() error = %v", err)
+	}
+	got, err := store.FindUserBySSHUser(ctx, "erin-adm")
+	if err != nil {
+		t.Fatalf("FindUserBySSHUser() error = %v", err)
+	}
+	if got.ID != "erin" || !got.Disabled || len(got.Roles) != 1 || got.Roles[0] != "admin" {
+		t.Errorf("FindUserBySSHUser() = %+v, want disabled admin erin", got)
+	}
+
+	if err := store.AddCredential(ctx, "erin", &Credential{RPID: "lazyadmin.local", CredentialID: "c1", Name: "blue"}); err != nil {
+		t.Fatalf("AddCredential() error = %v", err)
+	}
+	if err := store.DeleteUser(ctx, "erin"); err != nil {
+		t.Fatalf("DeleteUser() error = %v", err)
+	}
+	if _, err := store.GetUser(ctx, "erin"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("GetUser() after delete error = %v, want ErrUserNotFound", err)
+	}
+	if creds, _ := store.GetCredentials(ctx, "erin"); len(creds) != 0 {
+		t.Errorf("credentials left after delete: %+v", creds)
+	}
+
+	for name, err := range map[string]error{
+		"UpdateUser":  store.UpdateUser(ctx, &User{ID: "ghost"}),
+		"SetDisabled": store.SetDisabled(ctx, "ghost", true),
+		"DeleteUser":  store.DeleteUser(ctx, "ghost"),
+	} {
+		if !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("%s(missing) error = %v, want ErrUserNotFound", name, err)
+		}
+	}
+}
+
+func TestStore_Credentials(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	for _, id := range []string{"will", "erin"} {
+		if err := store.CreateUser(ctx, &User{ID: id, SSHUsers: []string{id}, Roles: []string{"operator"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	primary := &Credential{RPID: "lazyadmin.local", CredentialID: "c1", Name: "primary", UserHandle: "h-will"}
+	if err := store.AddCredential(ctx, "will", primary); err != nil {
+		t.Fatalf("AddCredential() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		user string
+		cred Credential
+	}{
+		{name: "credential ID of another user", user: "erin", cred: Credential{RPID: "lazyadmin.local", CredentialID: "c1"}},
+		{name: "name taken by the same user", user: "will", cred: Credential{RPID: "lazyadmin.local", CredentialID: "c2", Name: "primary"}},
+		{name: "name check before touch", user: "will", cred: Credential{Name: "primary"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := store.CheckCredential(ctx, tt.user, &tt.cred); !errors.Is(err, ErrCredentialExists) {
+				t.Errorf("CheckCredential() error = %v, want ErrCredentialExists", err)
+			}
+		})
+	}
+	if err := store.CheckCredential(ctx, "erin", &Credential{Name: "primary"}); err != nil {
+		t.Errorf("CheckCredential() of another user's name error = %v", err)
+	}
+
+	if err := store.AddCredential(ctx, "will", &Credential{RPID: "lazyadmin.local", CredentialID: "c2", Name: "backup"}); err != nil {
+		t.Fatalf("AddCredential(backup) error = %v", err)
+	}
+	creds, err := store.GetCredentials(ctx, "will")
+	if err != nil || len(creds) != 2 || creds[0].Name != "primary" || creds[1].Name != "backup" {
+		t.Fatalf("GetCredentials() = %+v, %v; want primary and backup", creds, err)
+	}
+	if h, err := store.UserHandle(ctx, "will"); h != "h-will" || err != nil {
+		t.Errorf("UserHandle() = %q, %v; want h-will", h, err)
+	}
+
+	if err := store.RemoveCredential(ctx, "erin", primary.ID); !errors.Is(err, ErrCredentialNotFound) {
+		t.Errorf("RemoveCredential() by another user error = %v, want ErrCredentialNotFound", err)
+	}
+	if err := store.RemoveCredential(ctx, "will", primary.ID); err != nil {
+		t.Fatalf("RemoveCredential() error = %v", err)
+	}
+	if creds, _ := store.GetCredentials(ctx, "will"); len(creds) != 1 || creds[0].Name != "backup" {
+		t.Errorf("GetCredentials() after remove = %+v, want backup only", creds)
+	}
+}
+
+func TestStore_Grants(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	request := func(role string, d time.Duration) *Grant {
+		t.Helper()
+		g := &Grant{UserID: "erin", Role: role, Reason: "incident", Duration: d, RequestedAt: now}
+		if err := store.CreateGrant(ctx, g); err != nil {
+			t.Fatalf("CreateGrant() error = %v", err)
+		}
+		return g
+	}
+
+	short := request("admin", time.Hour)
+	long := request("owner", 4*time.Hour)
+	denied := request("owner", time.Hour)
+
+	g, err := store.ApproveGrant(ctx, short.ID, "will", now)
+	if err != nil {
+		t.Fatalf("ApproveGrant() error = %v", err)
+	}
+	if g.Status != GrantActive || g.DecidedBy != "will" || !g.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("ApproveGrant() = %+v, want active until %s", g, now.Add(time.Hour))
+	}
+	if _, err := store.ApproveGrant(ctx, long.ID, "will", now); err != nil {
+		t.Fatalf("ApproveGrant(long) error = %v", err)
+	}
+	if _, err := store.DenyGrant(ctx, denied.ID, "will", now); err != nil {
+		t.Fatalf("DenyGrant() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		fn   func() (*Grant, error)
+		want error
+	}{
+		{"approve an active grant", func() (*Grant, error) { return store.ApproveGrant(ctx, short.ID, "will", now) }, ErrGrantState},
+		{"approve a denied grant", func() (*Grant, error) { return store.ApproveGrant(ctx, denied.ID, "will", now) }, ErrGrantState},
+		{"deny an active grant", func() (*Grant, error) { return store.DenyGrant(ctx, short.ID, "will", now) }, ErrGrantState},
+		{"revoke a denied grant", func() (*Grant, error) { return store.RevokeGrant(ctx, denied.ID, "will", now) }, ErrGrantState},
+		{"approve a missing grant", func() (*Grant, error) { return store.ApproveGrant(ctx, 999, "will", now) }, ErrGrantNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.fn(); !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	later := now.Add(2 * time.Hour)
+	active, err := store.ActiveGrants(ctx, "erin", later)
+	if err != nil || len(active) != 1 || active[0].ID != long.ID {
+		t.Errorf("ActiveGrants() two hours later = %+v, %v; want only the 4h grant", active, err)
+	}
+
+	expired, err := store.ExpireGrants(ctx, later)
+	if err != nil || len(expired) != 1 || expired[0].ID != short.ID {
+		t.Fatalf("ExpireGrants() = %+v, %v; want the 1h grant", expired, err)
+	}
+	if _, err := store.RevokeGrant(ctx, short.ID, "will", later); !errors.Is(err, ErrGrantState) {
+		t.Errorf("RevokeGrant() of an expired grant error = %v, want ErrGrantState", err)
+	}
+	g, err = store.RevokeGrant(ctx, long.ID, "will", later)
+	if err != nil || g.Status != GrantRevoked || g.RevokedBy != "will" {
+		t.Errorf("RevokeGrant() = %+v, %v; want revoked by will", g, err)
+	}
+	if active, _ := store.ActiveGrants(ctx, "erin", later); len(active) != 0 {
+		t.Errorf("ActiveGrants() after revoke = %+v, want none", active)
+	}
+}
+
+func TestStore_AuthFailures(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	var f *AuthFailures
+	for i := range 3 {
+		var err error
+		if f, err = store.RecordAuthFailure(ctx, "erin", now.Add(time.Duration(i)*time.Second), 3, 15*time.Minute); err != nil {
+			t.Fatalf("RecordAuthFailure() error = %v", err)
+		}
+		if locked := f.Locked(now.Add(time.Duration(i) * time.Second)); locked != (i == 2) {
+			t.Errorf("after %d failures Locked() = %v", i+1, locked)
+		}
+	}
+	if f.Failures != 3 || !f.LockedUntil.Equal(now.Add(2*time.Second+15*time.Minute)) {
+		t.Errorf("RecordAuthFailure() = %+v, want 3 failures locked for 15m from the last", f)
+	}
+	if f.Locked(f.LockedUntil) {
+		t.Error("Locked() at LockedUntil = true, want the lockout over")
+	}
+	if _, err := store.RecordAuthFailure(ctx, "will", now, 3, 15*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := store.ListAuthFailures(ctx)
+	if err != nil || len(list) != 2 || list[0].UserID != "erin" || list[1].UserID != "will" {
+		t.Errorf("ListAuthFailures() = %+v, %v; want erin and will", list, err)
+	}
+
+	// An unlock, or a successful sign-in, resets the count.
+	if cleared, err := store.ResetAuthFailures(ctx, "erin"); !cleared || err != nil {
+		t.Errorf("ResetAuthFailures() = %v, %v; want true", cleared, err)
+	}
+	if cleared, err := store.ResetAuthFailures(ctx, "erin"); cleared || err != nil {
+		t.Errorf("second ResetAuthFailures() = %v, %v; want false", cleared, err)
+	}
+	got, err := store.GetAuthFailures(ctx, "erin")
+	if err != nil || got.Failures != 0 || got.Locked(now) {
+		t.Errorf("GetAuthFailures() after reset = %+v, %v; want none", got, err)
+	}
+	if f, _ := store.RecordAuthFailure(ctx, "erin", now, 3, 15*time.Minute); f.Failures != 1 || f.Locked(now) {
+		t.Errorf("first failure after reset = %+v, want a fresh count", f)
+	}
+}
+
+func TestStore_RecoveryCodes(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, id := range []string{"erin", "will"} {
+		if err := store.ReplaceRecoveryCodes(ctx, id, []string{"ABCD-EFGH-JKMN", "PQRS-TUVW-XYZ2"}); err != nil {
+			t.Fatalf("ReplaceRecoveryCodes() error = %v", err)
+		}
+	}
+	// Each code has its own salt, so equal codes are stored differently.
+	var hashes int
+	if err := store.db.QueryRowContext(ctx, `SELECT COUNT(DISTINCT hash) FROM recovery_codes WHERE salt != ''`).Scan(&hashes); err != nil || hashes != 4 {
+		t.Errorf("distinct salted hashes = %d, %v; want 4", hashes, err)
+	}
+
+	if err := store.UseRecoveryCode(ctx, "erin", "abcd efgh jkmn", now); err != nil {
+		t.Fatalf("UseRecoveryCode() error = %v", err)
+	}
+	if err := store.UseRecoveryCode(ctx, "erin", "ABCD-EFGH-JKMN", now); !errors.Is(err, ErrRecoveryCodeInvalid) {
+		t.Errorf("UseRecoveryCode() of a used code error = %v, want ErrRecoveryCodeInvalid", err)
+	}
+	if err := store.UseRecoveryCode(ctx, "erin", "AAAA-BBBB-CCCC", now); !errors.Is(err, ErrRecoveryCodeInvalid) {
+		t.Errorf("UseRecoveryCode() of an unknown code error = %v, want ErrRecoveryCodeInvalid", err)
+	}
+	if n, _ := store.UnusedRecoveryCodes(ctx, "erin"); n != 1 {
+		t.Errorf("UnusedRecoveryCodes(erin) = %d, want 1", n)
+	}
+	if n, _ := store.UnusedRecoveryCodes(ctx, "will"); n != 2 {
+		t.Errorf("UnusedRecoveryCodes(will) = %d, want 2", n)
+	}
+
+	// Codes stored before salting have an empty salt and keep working.
+	if _, err := store.db.ExecContext(ctx,
+		`INSERT INTO recovery_codes (hash, user_id, created_at, used_at) VALUES (?, 'erin', ?, '')`,
+		hashRecoveryCode("", "LEGA-CYCO-DE23"), formatTime(now),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.UseRecoveryCode(ctx, "erin", "LEGA-CYCO-DE23", now); err != nil {
+		t.Errorf("UseRecoveryCode() of an unsalted code error = %v", err)
+	}
+}